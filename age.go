@@ -0,0 +1,284 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CalendarAge is an age counted on the calendar, in whole years, then months, then days
+type CalendarAge struct {
+	Years  int
+	Months int
+	Days   int
+}
+
+// CalendarAgeBetween counts the calendar age from one date to a later one. A month is complete
+// when the same day of the month comes round, or the last day of the month for one which is
+// shorter, so that someone born on the 29th of February has their birthday on the 28th in
+// other years. Times of day are ignored.
+func CalendarAgeBetween(from, to time.Time) CalendarAge {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	months := (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+	if addMonths(from, months).After(to) {
+		months--
+	}
+	days := int(to.Sub(addMonths(from, months)).Hours() / 24)
+	return CalendarAge{Years: months / 12, Months: months % 12, Days: days}
+}
+
+// addMonths adds whole months to a date, keeping to the last day of a shorter month
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// CalendarAge returns the person's age at death counted on the calendar
+func (rec Person) CalendarAge() (CalendarAge, error) {
+	birth, _, err := ParsePartialDate(rec.BirthDate)
+	if err != nil {
+		return CalendarAge{}, fmt.Errorf("unparseable birth date: %v", err)
+	}
+	death, _, err := ParsePartialDate(rec.DeathDate)
+	if err != nil {
+		return CalendarAge{}, fmt.Errorf("unparseable death date: %v", err)
+	}
+	return CalendarAgeBetween(birth, death), nil
+}
+
+// String formats the age, e.g. "27 years, 9 months and 22 days"
+func (a CalendarAge) String() string {
+	return fmt.Sprintf("%d %s, %d %s and %d %s", a.Years, plural(a.Years, "year"), a.Months, plural(a.Months, "month"), a.Days, plural(a.Days, "day"))
+}
+
+// FormatCalendarAge formats the age padded to a fixed width, for aligning in columns
+func FormatCalendarAge(a CalendarAge) string {
+	return fmt.Sprintf("%3d years, %2d months and %2d days", a.Years, a.Months, a.Days)
+}
+
+// FormatPreciseAge formats the result's age at death counted on the calendar, or roughly if it
+// is approximate
+func (res Result) FormatPreciseAge() string {
+	if res.Approximate() {
+		return FormatApproximateAge(res.Days)
+	}
+	a, err := res.CalendarAge()
+	if err != nil {
+		return res.FormatAge()
+	}
+	return FormatCalendarAge(a)
+}
+
+// AgeInUnits is an age given in several units at once, each counted on the calendar
+type AgeInUnits struct {
+	Days     int
+	Weeks    int
+	Months   int
+	Years    int
+	YearDays int // the days since the last birthday, beside the whole years
+}
+
+// AgeInUnitsBetween counts the age from one date to a later one in each unit, the months and
+// years as CalendarAgeBetween does
+func AgeInUnitsBetween(from, to time.Time) AgeInUnits {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	days := int(to.Sub(from).Hours() / 24)
+	a := CalendarAgeBetween(from, to)
+	return AgeInUnits{
+		Days:     days,
+		Weeks:    days / 7,
+		Months:   a.Years*12 + a.Months,
+		Years:    a.Years,
+		YearDays: int(to.Sub(addMonths(from, a.Years*12)).Hours() / 24),
+	}
+}
+
+// String formats the age, e.g. "12,775 days / 1,825 weeks / 419 months / 34 years and 362 days"
+func (a AgeInUnits) String() string {
+	return fmt.Sprintf("%s %s / %s %s / %s %s / %d %s and %d %s", groupDigits(a.Days), plural(a.Days, "day"),
+		groupDigits(a.Weeks), plural(a.Weeks, "week"), groupDigits(a.Months), plural(a.Months, "month"),
+		a.Years, plural(a.Years, "year"), a.YearDays, plural(a.YearDays, "day"))
+}
+
+// FormatAgeInUnits formats the age padded to a fixed width, for aligning in columns
+func FormatAgeInUnits(a AgeInUnits) string {
+	return fmt.Sprintf("%6s days / %5s weeks / %5s months / %3d years and %3d days",
+		groupDigits(a.Days), groupDigits(a.Weeks), groupDigits(a.Months), a.Years, a.YearDays)
+}
+
+// FormatAgeInUnits formats the result's age at death in each unit, or roughly if it is
+// approximate
+func (res Result) FormatAgeInUnits() string {
+	if res.Approximate() {
+		return FormatApproximateAge(res.Days)
+	}
+	birth, _, err := ParsePartialDate(res.BirthDate)
+	if err != nil {
+		return res.FormatAge()
+	}
+	death, _, err := ParsePartialDate(res.DeathDate)
+	if err != nil {
+		return res.FormatAge()
+	}
+	return FormatAgeInUnits(AgeInUnitsBetween(birth, death))
+}
+
+// groupDigits formats a number with its digits grouped in thousands, e.g. "12,775"
+func groupDigits(n int) string {
+	if n < 0 {
+		return "-" + groupDigits(-n)
+	}
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
+
+// ageExprPattern matches an age such as '27y', '27y6m', '18m' or '400d', or '27' for years
+var ageExprPattern = regexp.MustCompile(`^(?:([0-9]+)y)?(?:([0-9]+)m)?(?:([0-9]+)d)?$|^([0-9]+)$`)
+
+// ageExpr is an age given in years, months and days, which stands for every age from it until
+// one more of its smallest unit: '27y' is anyone aged 27, and '27y6m' anyone aged 27 and a half
+// but not yet 27 and seven months
+type ageExpr struct {
+	months int
+	days   int
+	inDays bool // whether the age is given in days alone, and compared with ages in days
+	unit   byte // the smallest unit given: 'y', 'm' or 'd'
+}
+
+func parseAgeExpr(s string) (ageExpr, error) {
+	m := ageExprPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil || m[0] == "" {
+		return ageExpr{}, fmt.Errorf("invalid age '%s': expected years, months and days such as '27y', '27y6m' or '400d'", s)
+	}
+	n := func(i int) int {
+		v, _ := strconv.Atoi(m[i])
+		return v
+	}
+	var e ageExpr
+	switch {
+	case m[4] != "":
+		e.months, e.unit = n(4)*12, 'y'
+	default:
+		e.months, e.days = n(1)*12+n(2), n(3)
+		for i, unit := range []byte{'y', 'm', 'd'} {
+			if m[i+1] != "" {
+				e.unit = unit
+			}
+		}
+		e.inDays = m[1] == "" && m[2] == ""
+	}
+	return e, nil
+}
+
+// next returns the age one of the smallest unit later, where the ages the expression stands
+// for end
+func (e ageExpr) next() ageExpr {
+	switch e.unit {
+	case 'y':
+		e.months += 12
+	case 'm':
+		e.months++
+	default:
+		e.days++
+	}
+	return e
+}
+
+// minDays and maxDays bound the age in days, for any dates of birth
+func (e ageExpr) minDays() int {
+	return e.months/12*365 + e.months%12*28 + e.days
+}
+
+func (e ageExpr) maxDays() int {
+	return e.months/12*366 + e.months%12*31 + e.days
+}
+
+// before reports whether the result's age at death is younger than the expression
+func (e ageExpr) before(res Result) bool {
+	if e.inDays {
+		return res.Days < e.days
+	}
+	a, err := res.CalendarAge()
+	if err != nil {
+		return res.Days < e.minDays()
+	}
+	months := a.Years*12 + a.Months
+	return months < e.months || (months == e.months && a.Days < e.days)
+}
+
+// AgeRange is a range of ages at death given in years, months and days, e.g. to find who died
+// aged 27. Ages are counted on the calendar, from each person's date of birth.
+type AgeRange struct {
+	from ageExpr
+	to   ageExpr // the first age after the range
+}
+
+// ParseAgeRange parses the ages from and to, such as '27y' and '28y', giving the range of ages
+// from the start of the first until the end of the second; with to empty, that of from alone
+func ParseAgeRange(from, to string) (AgeRange, error) {
+	f, err := parseAgeExpr(from)
+	if err != nil {
+		return AgeRange{}, err
+	}
+	t := f
+	if to != "" {
+		if t, err = parseAgeExpr(to); err != nil {
+			return AgeRange{}, err
+		}
+	}
+	if t.minDays() < f.minDays() {
+		return AgeRange{}, fmt.Errorf("invalid ages: %s is younger than %s", to, from)
+	}
+	return AgeRange{from: f, to: t.next()}, nil
+}
+
+// Days returns the range of ages at death in days holding every age in the range, whatever
+// the dates of birth, along with others which Contains rules out
+func (r AgeRange) Days() (int, int) {
+	return r.from.minDays(), r.to.maxDays() - 1
+}
+
+// Contains reports whether the result's age at death lies within the range
+func (r AgeRange) Contains(res Result) bool {
+	return !r.from.before(res) && r.to.before(res)
+}
+
+// QueryByAge returns the records from the datasets whose age at death lies within the range
+// and which match the filter, sorted and paged as by Query, along with the total number of
+// such records
+func QueryByAge(store Store, r AgeRange, opts QueryOptions) ([]Result, int, error) {
+	min, max := r.Days()
+	results, err := queryDatasets(store, opts, min, max)
+	if err != nil {
+		return nil, 0, err
+	}
+	within := results[:0]
+	for _, res := range results {
+		if r.Contains(res) {
+			within = append(within, res)
+		}
+	}
+	SortResults(within, opts.Sort, opts.Desc)
+	return paginate(within, opts.Offset, opts.Limit), len(within), nil
+}
@@ -0,0 +1,102 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoAPIKey is returned when an API key does not exist
+var ErrNoAPIKey = errors.New("no such API key")
+
+// ErrInvalidAPIKey is returned when a key presented to the API is unknown, wrong or revoked
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// API_KEY_PREFIX begins every API key, so that keys can be recognised, e.g. by secret scanners
+const API_KEY_PREFIX = "ol_"
+
+// APIKey is a key granting access to the API served by 'outlived serve'. Only a hash of the
+// secret part is kept, so a lost key must be replaced rather than recovered.
+type APIKey struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name"` // of whoever the key was given to
+	Hash    string     `json:"hash"` // SHA-256 of the secret, hex encoded
+	Created time.Time  `json:"created"`
+	Revoked *time.Time `json:"revoked,omitempty"`
+}
+
+// APIKeyStore holds API keys
+type APIKeyStore interface {
+	// APIKeys returns all of the keys, revoked keys included, the oldest first
+	APIKeys() ([]APIKey, error)
+	// APIKey returns the key with the ID, or ErrNoAPIKey if there is none
+	APIKey(id string) (APIKey, error)
+	// SaveAPIKey adds the key, replacing any with the same ID
+	SaveAPIKey(k APIKey) error
+}
+
+// NewAPIKey generates a key for the named client, returning it along with the token to give
+// them, of the form 'ol_<id>_<secret>'. The token cannot be recovered from the key.
+func NewAPIKey(name string, now time.Time) (APIKey, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return APIKey{}, "", errors.New("an API key must be given a name")
+	}
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	for _, b := range [][]byte{id, secret} {
+		if _, err := rand.Read(b); err != nil {
+			return APIKey{}, "", err
+		}
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	k := APIKey{ID: hex.EncodeToString(id), Name: name, Hash: hashSecret(encoded), Created: now.UTC()}
+	return k, API_KEY_PREFIX + k.ID + "_" + encoded, nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckAPIKey returns the key a token was issued for, or ErrInvalidAPIKey if it was not issued
+// or has been revoked
+func CheckAPIKey(ks APIKeyStore, token string) (APIKey, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(token, API_KEY_PREFIX), "_")
+	if !ok || !strings.HasPrefix(token, API_KEY_PREFIX) {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	k, err := ks.APIKey(id)
+	if errors.Is(err, ErrNoAPIKey) {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(k.Hash)) != 1 || k.Revoked != nil {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	return k, nil
+}
+
+// RevokeAPIKey revokes the key with the ID, so that it is no longer accepted. The key is kept,
+// so that it is still listed.
+func RevokeAPIKey(ks APIKeyStore, id string, now time.Time) error {
+	k, err := ks.APIKey(id)
+	if err != nil {
+		return err
+	}
+	if k.Revoked != nil {
+		return fmt.Errorf("API key '%s' was already revoked on %s", id, k.Revoked.Format(DATE_FMT))
+	}
+	revoked := now.UTC()
+	k.Revoked = &revoked
+	return ks.SaveAPIKey(k)
+}
@@ -0,0 +1,45 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"math"
+	"sort"
+)
+
+// Summary is where someone born on a date stands among the people in the datasets
+type Summary struct {
+	BirthDate string
+	UserAge   int // the age in days as of opts.Now
+	Ranking   Ranking
+	Last      *Milestone // the person most recently outlived, if any
+	Next      *Milestone // the next person to be outlived, if any
+}
+
+// Summarize returns a Summary for each of the dates of birth, as of opts.Now. The filtered
+// records of the datasets are read once for them all, so that many dates can be summarised
+// for little more than the cost of one.
+func Summarize(store Store, dates []string, opts QueryOptions) ([]Summary, error) {
+	results, err := queryDatasets(store, opts, math.MinInt32, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]Summary, len(dates))
+	for i, dateStr := range dates {
+		birth, userAge, err := userBirthAndAge(dateStr, opts.Now)
+		if err != nil {
+			return nil, err
+		}
+		// the people outlived are those who died younger than the user is now
+		n := sort.Search(len(results), func(i int) bool { return results[i].Days >= userAge })
+		s := Summary{BirthDate: dateStr, UserAge: userAge, Ranking: Ranking{Outlived: n, Total: len(results)}}
+		if n > 0 {
+			s.Last = &milestones(birth, results[n-1:n])[0]
+		}
+		if n < len(results) {
+			s.Next = &milestones(birth, results[n:n+1])[0]
+		}
+		summaries[i] = s
+	}
+	return summaries, nil
+}
@@ -0,0 +1,406 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// STAGING_SUFFIX ends the keys beside a dataset's sets into which an import writes their
+// new contents, before they replace them
+const STAGING_SUFFIX = ":importing"
+
+// errStagedGone is returned by swapStaged when the sets an import wrote beside the dataset are
+// no longer there, having been swapped in already or deleted by another import
+var errStagedGone = errors.New("the sets staged by the import are gone")
+
+// stagingKey returns the key into which an import writes the set held under key
+func stagingKey(key string) string {
+	return key + STAGING_SUFFIX
+}
+
+// stagedPersonKey returns the key into which an import writes a person's hash, which is kept
+// apart from the dataset's hashes so that a search index covering those doesn't find it, e.g.
+// 'outlived:{actors}:importing:person:9f86d081884c7d65'
+func stagedPersonKey(dataset, id string) string {
+	return DatasetKey(dataset) + STAGING_SUFFIX + ":person:" + id
+}
+
+// datasetSets returns the keys of the dataset's sets, which an import replaces: its sorted
+// sets of the dead and the living, and its indexes (see personIndex) other than the sets by
+// occupation and tag, whose keys depend on the values it holds (see valueSets)
+func datasetSets(dataset string) []string {
+	keys := []string{DatasetKey(dataset), LivingKey(dataset), NamesKey(dataset), DeathYearsKey(dataset), BirthYearsKey(dataset)}
+	for _, vi := range valueIndexes {
+		keys = append(keys, vi.registry(dataset))
+	}
+	for _, k := range dayKeys(dataset) {
+		keys = append(keys, k.(string))
+	}
+	return keys
+}
+
+// Import replaces the contents of the dataset with the given records, written in batches with
+// the default BatchOptions (see ImportBatched)
+func (s *RedisStore) Import(dataset string, records []Person) error {
+	return s.ImportBatched(dataset, records, BatchOptions{})
+}
+
+// ImportBatched replaces the contents of the dataset with the given records, first creating its
+// search index if the RediSearch module is loaded. Upsert leaves unindexed datasets as they are.
+//
+// The new sets and person hashes are written beside the dataset's, under keys containing
+// STAGING_SUFFIX, each batch of opts.Size people in one pipeline with many members to each
+// command; opts.Workers batches are written at once, each on its own connection. They then
+// replace the old in one transaction, which deletes the hashes of those no longer in the
+// dataset, so that queries and searches see either the old dataset or the new. Should the
+// import fail, or the store's context end, before they are swapped in, what it wrote beside
+// the dataset is deleted, leaving it as it was. The swap is made once, not retried, and the
+// dataset is listed only once it has been.
+func (s *RedisStore) ImportBatched(dataset string, records []Person, opts BatchOptions) error {
+	if err := ValidateDatasetName(dataset); err != nil {
+		return err
+	}
+	dead, living := SplitLiving(dedupePeople(records))
+	results, err := NewResults(dead)
+	if err != nil {
+		return err
+	}
+	if opts.Size <= 0 {
+		opts.Size = DEFAULT_BATCH_SIZE
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DEFAULT_BATCH_WORKERS
+	}
+	sets := datasetSets(dataset)
+	staged := make([]interface{}, len(sets))
+	for i, k := range sets {
+		staged[i] = stagingKey(k)
+	}
+
+	err = s.do(func(c redis.Conn) error {
+		if err := s.createIndex(c, dataset); err != nil {
+			return err
+		}
+		// left by an import which didn't finish
+		left, err := stagedPeople(c, dataset)
+		if err != nil {
+			return err
+		}
+		values, err := stagedValueSets(c, dataset)
+		if err != nil {
+			return err
+		}
+		return pipeline(c, deleteCmds(append(append(left, values...), staged...), opts.Size))
+	})
+	if err != nil {
+		return err
+	}
+
+	ids, err := s.writeStaged(dataset, results, living, opts)
+	if err == nil {
+		err = s.commitStaged(dataset, ids, opts.Size)
+	}
+	if err != nil {
+		s.abandonStaged(dataset, staged, ids, opts.Size)
+		return err
+	}
+	err = s.do(func(c redis.Conn) error { return recordImport(c, dataset) })
+	if err != nil {
+		return fmt.Errorf("dataset '%s' was imported, but not listed: %v", dataset, err)
+	}
+	return nil
+}
+
+// commitStaged swaps in the sets written beside the dataset. It is tried only once, rather than
+// by do, as a transaction whose reply was lost may have committed. Should the connection break,
+// the sets staged having gone shows that it did.
+func (s *RedisStore) commitStaged(dataset string, ids []string, size int) error {
+	broken, err := s.try(func(c redis.Conn) error { return swapStaged(c, dataset, ids, size) })
+	if err == nil || !broken {
+		return err
+	}
+	var gone bool
+	if s.do(func(c redis.Conn) error {
+		var err error
+		gone, err = stagedGone(c, dataset, ids)
+		return err
+	}) == nil && gone {
+		return nil
+	}
+	return err
+}
+
+// stagedGone reports whether the sorted sets an import wrote beside the dataset are missing,
+// although it staged people in them
+func stagedGone(c redis.Conn, dataset string, ids []string) (bool, error) {
+	if len(ids) == 0 {
+		return false, nil // an empty dataset stages nothing
+	}
+	n, err := redis.Int(c.Do("EXISTS", stagingKey(DatasetKey(dataset)), stagingKey(LivingKey(dataset))))
+	return n == 0, err
+}
+
+// writeStaged writes the people's hashes and the sets of the new dataset beside the old, in
+// batches made and written by opts.Workers at once. It returns the IDs of the dead and then
+// the living, those of any batch not reached being left empty. The first batch to fail stops
+// the rest.
+func (s *RedisStore) writeStaged(dataset string, results []Result, living []Person, opts BatchOptions) ([]string, error) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	work := s.WithContext(ctx).(*RedisStore)
+	ids := make([]string, len(results)+len(living))
+	starts := make(chan int, opts.Workers) // of batches, indexing ids
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		written  int
+	)
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range starts {
+				end := min(start+opts.Size, len(ids))
+				var cmds []redisCmd
+				if start < len(results) {
+					cmds = deadBatch(dataset, results[start:end], ids[start:end])
+				} else {
+					cmds = livingBatch(dataset, living[start-len(results):end-len(results)], ids[start:end])
+				}
+				err := work.do(func(c redis.Conn) error { return pipeline(c, cmds) })
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				} else if err == nil && opts.Progress != nil {
+					written += end - start
+					opts.Progress(written, len(ids))
+				}
+				mu.Unlock()
+				if err != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+	// batches don't straddle the dead and the living, so that each is one or the other
+	send := func(from, to int) {
+		for start := from; start < to && ctx.Err() == nil; start += opts.Size {
+			select {
+			case starts <- start:
+			case <-ctx.Done():
+			}
+		}
+	}
+	send(0, len(results))
+	send(len(results), len(ids))
+	close(starts)
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = s.ctx.Err()
+	}
+	return ids, firstErr
+}
+
+// deadBatch returns the commands writing a batch of those who have died, filling in their IDs:
+// their entries in the new sets, with one command adding all of the batch to each set, and
+// their staged hashes. They are added to the staged sorted set first, so that it holds the ID
+// of every staged hash, even should the batch be cut short.
+func deadBatch(dataset string, results []Result, ids []string) []redisCmd {
+	zadd := []interface{}{stagingKey(DatasetKey(dataset))}
+	people := make([]Person, len(results))
+	hashes := make([]redisCmd, 0, len(results))
+	for i, res := range results {
+		id := res.ID()
+		ids[i] = id
+		people[i] = res.Person
+		hashes = append(hashes, redisCmd{"HMSET", personArgs(stagedPersonKey(dataset, id), res.Person, res.Days)})
+		zadd = append(zadd, res.Days, id)
+	}
+	cmds := append([]redisCmd{{"ZADD", zadd}}, hashes...)
+	return append(cmds, stagedIndexCmds(dataset, people, ids)...)
+}
+
+// livingBatch returns the commands writing a batch of the living, as deadBatch does
+func livingBatch(dataset string, living []Person, ids []string) []redisCmd {
+	zadd := []interface{}{stagingKey(LivingKey(dataset))}
+	hashes := make([]redisCmd, 0, len(living))
+	for i, rec := range living {
+		id := rec.ID()
+		ids[i] = id
+		hashes = append(hashes, redisCmd{"HMSET", personArgs(stagedPersonKey(dataset, id), rec, 0)})
+		zadd = append(zadd, birthDay(rec), id)
+	}
+	cmds := append([]redisCmd{{"ZADD", zadd}}, hashes...)
+	return append(cmds, stagedIndexCmds(dataset, living, ids)...)
+}
+
+// swapStaged replaces the dataset's sets and the hashes of the people whose IDs are given with
+// those written beside them, deletes the hashes of anyone no longer in it, and records
+// SCHEMA_VERSION, in a transaction watching the dataset and its staged sets so that it is
+// repeated if another import changes them meanwhile. It returns errStagedGone, changing
+// nothing, if the staged sets are missing, as swapping in their absence would delete the
+// dataset's.
+func swapStaged(c redis.Conn, dataset string, ids []string, size int) error {
+	keep := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		keep[id] = true
+	}
+	sets := datasetSets(dataset)
+	watched := []interface{}{DatasetKey(dataset), LivingKey(dataset), stagingKey(DatasetKey(dataset)), stagingKey(LivingKey(dataset))}
+	return watchedTransaction(c, watched, func() ([]redisCmd, error) {
+		if gone, err := stagedGone(c, dataset, ids); err != nil || gone {
+			if err == nil {
+				err = errStagedGone
+			}
+			return nil, err
+		}
+		current, err := datasetIDs(c, dataset)
+		if err != nil {
+			return nil, err
+		}
+		// the sets by occupation and tag of the old dataset and of the new, each either replaced
+		// or deleted
+		keys := sets[:len(sets):len(sets)]
+		seen := map[string]bool{}
+		for _, staged := range []bool{false, true} {
+			values, err := valueSets(c, dataset, staged)
+			if err != nil {
+				return nil, err
+			}
+			for _, k := range values {
+				if !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+		}
+		for _, k := range keys {
+			c.Send("EXISTS", stagingKey(k))
+		}
+		if err := c.Flush(); err != nil {
+			return nil, err
+		}
+		cmds := make([]redisCmd, 0, len(keys))
+		for _, k := range keys {
+			if n, err := redis.Int(c.Receive()); err != nil {
+				return nil, err
+			} else if n > 0 {
+				cmds = append(cmds, redisCmd{"RENAME", []interface{}{stagingKey(k), k}})
+			} else {
+				cmds = append(cmds, redisCmd{"DEL", []interface{}{k}}) // the new dataset has none
+			}
+		}
+		for _, id := range ids {
+			cmds = append(cmds, redisCmd{"RENAME", []interface{}{stagedPersonKey(dataset, id), PersonKey(dataset, id)}})
+		}
+		var stale []interface{}
+		for id := range current {
+			if !keep[id] {
+				stale = append(stale, PersonKey(dataset, id))
+			}
+		}
+		cmds = append(cmds, redisCmd{"SET", []interface{}{SchemaKey(dataset), SCHEMA_VERSION}})
+		return append(cmds, deleteCmds(stale, size)...), nil
+	})
+}
+
+// abandonStaged deletes what an import which failed wrote beside the dataset, the sets and the
+// hashes of the people whose IDs are given, leaving the dataset as it was. It goes ahead even if
+// the store's context has ended, as it is cleaning up after that.
+func (s *RedisStore) abandonStaged(dataset string, staged []interface{}, ids []string, size int) {
+	var hashes []interface{}
+	for _, id := range ids {
+		if id != "" {
+			hashes = append(hashes, stagedPersonKey(dataset, id))
+		}
+	}
+	cleanup := s.WithContext(context.WithoutCancel(s.ctx)).(*RedisStore)
+	cleanup.do(func(c redis.Conn) error {
+		values, err := stagedValueSets(c, dataset)
+		if err != nil {
+			return err
+		}
+		return pipeline(c, append([]redisCmd{{"DEL", append(values, staged...)}}, deleteCmds(hashes, size)...))
+	})
+}
+
+// stagedPeople returns the keys of the hashes written beside the dataset by an import, whose
+// IDs its staged sorted sets hold
+func stagedPeople(c redis.Conn, dataset string) ([]interface{}, error) {
+	var keys []interface{}
+	for _, key := range []string{DatasetKey(dataset), LivingKey(dataset)} {
+		ids, err := redis.Strings(c.Do("ZRANGE", stagingKey(key), 0, -1))
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			keys = append(keys, stagedPersonKey(dataset, id))
+		}
+	}
+	return keys, nil
+}
+
+// stagedValueSets returns the keys of the sets by occupation and tag written beside the
+// dataset's by an import
+func stagedValueSets(c redis.Conn, dataset string) ([]interface{}, error) {
+	values, err := valueSets(c, dataset, true)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]interface{}, len(values))
+	for i, k := range values {
+		keys[i] = stagingKey(k)
+	}
+	return keys, nil
+}
+
+// datasetIDs returns the IDs of everyone in the dataset, dead or living
+func datasetIDs(c redis.Conn, dataset string) (map[string]bool, error) {
+	ids := map[string]bool{}
+	for _, key := range []string{DatasetKey(dataset), LivingKey(dataset)} {
+		members, err := redis.Strings(c.Do("ZRANGE", key, 0, -1))
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range members {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
+
+// deleteCmds returns DEL commands deleting the keys, up to size of them at a time
+func deleteCmds(keys []interface{}, size int) []redisCmd {
+	var cmds []redisCmd
+	for start := 0; start < len(keys); start += size {
+		cmds = append(cmds, redisCmd{"DEL", keys[start:min(start+size, len(keys))]})
+	}
+	return cmds
+}
+
+// pipeline sends the commands together and waits for all of their replies, returning the
+// first error among them
+func pipeline(c redis.Conn, cmds []redisCmd) error {
+	for _, cmd := range cmds {
+		if err := c.Send(cmd.name, cmd.args...); err != nil {
+			return err
+		}
+	}
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	var firstErr error
+	for range cmds {
+		if _, err := c.Receive(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,446 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	clusterSlots        = 16384
+	clusterMaxRedirects = 5
+)
+
+var errClusterClosed = errors.New("cluster: connection closed")
+
+// redisCmd is a command held back to be sent later
+type redisCmd struct {
+	name string
+	args []interface{}
+}
+
+// clusterConn is a redis.Conn which routes each command to the node of a Redis Cluster
+// serving its key, following MOVED and ASK redirects and refreshing the slot map when a
+// node becomes unreachable (for example during a failover).
+//
+// Commands passed to Send are buffered until Flush, Receive or Do is called. Runs of
+// ordinary commands are pipelined to each node that serves them, while a MULTI ... EXEC
+// block is sent as a whole to the node serving the first key within it, so all of the keys
+// in a transaction must share a hash slot.
+type clusterConn struct {
+	cfg     RedisConfig
+	seed    string
+	slots   [clusterSlots]string
+	nodes   map[string]redis.Conn
+	pending []redisCmd
+	replies []interface{} // replies to flushed commands not yet received; errors are stored as values
+	err     error
+}
+
+func newClusterConn(cfg RedisConfig, seed redis.Conn) (*clusterConn, error) {
+	c := &clusterConn{cfg: cfg, seed: cfg.Addr, nodes: map[string]redis.Conn{cfg.Addr: seed}}
+	if err := c.refreshSlots(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *clusterConn) Close() error {
+	for addr, conn := range c.nodes {
+		conn.Close()
+		delete(c.nodes, addr)
+	}
+	c.err = errClusterClosed
+	return nil
+}
+
+func (c *clusterConn) Err() error {
+	return c.err
+}
+
+func (c *clusterConn) Send(cmd string, args ...interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.pending = append(c.pending, redisCmd{cmd, args})
+	return nil
+}
+
+func (c *clusterConn) Flush() error {
+	if c.err != nil {
+		return c.err
+	}
+	c.replies = append(c.replies, c.exec(c.pending)...)
+	c.pending = nil
+	return nil
+}
+
+func (c *clusterConn) Receive() (interface{}, error) {
+	if len(c.replies) == 0 {
+		if err := c.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.replies) == 0 {
+		return nil, errors.New("cluster: no pending replies")
+	}
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	if err, ok := reply.(error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Do follows the semantics of the redigo connection: all pending replies are received, and
+// the reply to the given command returned along with the first error encountered
+func (c *clusterConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "" {
+		c.Send(cmd, args...)
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	replies := c.replies
+	c.replies = nil
+
+	if cmd == "" {
+		if len(replies) == 0 {
+			return nil, nil
+		}
+		return replies, nil
+	}
+	var err error
+	for _, r := range replies {
+		if e, ok := r.(error); ok && err == nil {
+			err = e
+		}
+	}
+	var reply = replies[len(replies)-1]
+	if e, ok := reply.(error); ok {
+		return nil, e
+	}
+	return reply, err
+}
+
+// exec runs the commands, returning a reply for each
+func (c *clusterConn) exec(cmds []redisCmd) []interface{} {
+	replies := make([]interface{}, 0, len(cmds))
+	for i := 0; i < len(cmds); {
+		j := i
+		if isCommand(cmds[i], "MULTI") {
+			for j < len(cmds) && !isCommand(cmds[j], "EXEC") && !isCommand(cmds[j], "DISCARD") {
+				j++
+			}
+			if j == len(cmds) {
+				err := errors.New("cluster: MULTI without EXEC in the same flush")
+				for range cmds[i:] {
+					replies = append(replies, err)
+				}
+				break
+			}
+			replies = append(replies, c.transaction(cmds[i:j+1])...)
+			i = j + 1
+			continue
+		}
+		for j < len(cmds) && !isCommand(cmds[j], "MULTI") {
+			j++
+		}
+		replies = append(replies, c.pipeline(cmds[i:j])...)
+		i = j
+	}
+	return replies
+}
+
+// pipeline sends the commands to the nodes serving them, retrying any which are
+// redirected or whose node could not be reached
+func (c *clusterConn) pipeline(cmds []redisCmd) []interface{} {
+	replies := make([]interface{}, len(cmds))
+	byAddr := make(map[string][]int)
+	for i, cmd := range cmds {
+		addr := c.addrFor(cmd)
+		byAddr[addr] = append(byAddr[addr], i)
+	}
+	for addr, idx := range byAddr {
+		for n, r := range c.send(addr, cmdsAt(cmds, idx)) {
+			replies[idx[n]] = r
+		}
+	}
+
+	refreshed := false
+	for i, r := range replies {
+		switch e := r.(type) {
+		case redis.Error:
+			replies[i] = c.follow(cmds[i], e)
+		case error: // the node could not be reached, so the slot map may be stale
+			if !refreshed {
+				c.refreshSlots()
+				refreshed = true
+			}
+			replies[i] = c.doOne(cmds[i])
+		}
+	}
+	return replies
+}
+
+// transaction sends a MULTI ... EXEC block to a single node, retrying it on the correct
+// node if it is redirected
+func (c *clusterConn) transaction(cmds []redisCmd) []interface{} {
+	var replies []interface{}
+	for attempt := 0; attempt < clusterMaxRedirects; attempt++ {
+		addr := c.seed
+		for _, cmd := range cmds {
+			if _, ok := clusterKey(cmd); ok {
+				addr = c.addrFor(cmd)
+				break
+			}
+		}
+		replies = c.send(addr, cmds)
+		retry := false
+		for _, r := range replies {
+			if e, ok := r.(error); ok {
+				if _, isRedis := e.(redis.Error); !isRedis || isRedirect(e) {
+					retry = true
+				}
+			}
+		}
+		if !retry {
+			break
+		}
+		c.refreshSlots()
+	}
+	return replies
+}
+
+// doOne runs a single command, following any redirects
+func (c *clusterConn) doOne(cmd redisCmd) interface{} {
+	r := c.send(c.addrFor(cmd), []redisCmd{cmd})[0]
+	if e, ok := r.(redis.Error); ok {
+		return c.follow(cmd, e)
+	}
+	return r
+}
+
+// follow retries a command redirected by a MOVED or ASK error
+func (c *clusterConn) follow(cmd redisCmd, err redis.Error) interface{} {
+	for n := 0; n < clusterMaxRedirects; n++ {
+		kind, slot, addr, ok := parseRedirect(err)
+		if !ok {
+			return err
+		}
+		cmds := []redisCmd{cmd}
+		if kind == "ASK" {
+			cmds = []redisCmd{{name: "ASKING"}, cmd}
+		} else {
+			c.slots[slot] = addr
+		}
+		replies := c.send(addr, cmds)
+		r := replies[len(replies)-1]
+		e, isErr := r.(redis.Error)
+		if !isErr {
+			return r
+		}
+		err = e
+	}
+	return err
+}
+
+// send pipelines the commands to a single node and returns the replies
+func (c *clusterConn) send(addr string, cmds []redisCmd) []interface{} {
+	replies := make([]interface{}, len(cmds))
+	fail := func(from int, err error) []interface{} {
+		c.dropNode(addr)
+		for i := from; i < len(replies); i++ {
+			replies[i] = err
+		}
+		return replies
+	}
+	conn, err := c.node(addr)
+	if err != nil {
+		return fail(0, err)
+	}
+	for _, cmd := range cmds {
+		conn.Send(cmd.name, cmd.args...)
+	}
+	if err := conn.Flush(); err != nil {
+		return fail(0, err)
+	}
+	for i := range cmds {
+		r, err := conn.Receive()
+		if e, ok := err.(redis.Error); ok {
+			r = e
+		} else if err != nil {
+			return fail(i, err)
+		}
+		replies[i] = r
+	}
+	return replies
+}
+
+// node returns an open connection to the given node, dialling it if necessary
+func (c *clusterConn) node(addr string) (redis.Conn, error) {
+	if conn, ok := c.nodes[addr]; ok && conn.Err() == nil {
+		return conn, nil
+	}
+	c.dropNode(addr)
+	conn, err := c.cfg.dialAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	c.nodes[addr] = conn
+	return conn, nil
+}
+
+func (c *clusterConn) dropNode(addr string) {
+	if conn, ok := c.nodes[addr]; ok {
+		conn.Close()
+		delete(c.nodes, addr)
+	}
+}
+
+// addrFor returns the address of the node serving the command's key, or the seed node
+// for commands without a key
+func (c *clusterConn) addrFor(cmd redisCmd) string {
+	if key, ok := clusterKey(cmd); ok {
+		if addr := c.slots[hashSlot(key)]; addr != "" {
+			return addr
+		}
+	}
+	return c.seed
+}
+
+// refreshSlots rebuilds the slot map using CLUSTER SLOTS, asking each known node in turn
+func (c *clusterConn) refreshSlots() error {
+	candidates := []string{c.seed}
+	for addr := range c.nodes {
+		if addr != c.seed {
+			candidates = append(candidates, addr)
+		}
+	}
+	var lastErr error
+	for _, addr := range candidates {
+		conn, err := c.node(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		host, _, _ := net.SplitHostPort(addr)
+		for _, r := range ranges {
+			// each entry is [start, end, [host, port, id], replicas...]
+			fields, err := redis.Values(r, nil)
+			if err != nil || len(fields) < 3 {
+				continue
+			}
+			start, _ := redis.Int(fields[0], nil)
+			end, _ := redis.Int(fields[1], nil)
+			master, err := redis.Values(fields[2], nil)
+			if err != nil || len(master) < 2 {
+				continue
+			}
+			masterHost, _ := redis.String(master[0], nil)
+			if masterHost == "" { // an empty host means the node that answered
+				masterHost = host
+			}
+			port, _ := redis.Int(master[1], nil)
+			masterAddr := net.JoinHostPort(masterHost, strconv.Itoa(port))
+			for s := start; s <= end && s < clusterSlots; s++ {
+				c.slots[s] = masterAddr
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("cluster: unable to load slot map: %v", lastErr)
+}
+
+func cmdsAt(cmds []redisCmd, idx []int) []redisCmd {
+	sub := make([]redisCmd, len(idx))
+	for n, i := range idx {
+		sub[n] = cmds[i]
+	}
+	return sub
+}
+
+func isCommand(cmd redisCmd, name string) bool {
+	return strings.EqualFold(cmd.name, name)
+}
+
+// clusterKey returns the key used to route the command, if it has one
+func clusterKey(cmd redisCmd) (string, bool) {
+	switch strings.ToUpper(cmd.name) {
+	case "MULTI", "EXEC", "DISCARD", "ASKING", "PING", "INFO", "ROLE", "CLUSTER",
+		"SCRIPT", "SCAN", "KEYS", "DBSIZE", "FLUSHDB", "FLUSHALL", "MODULE":
+		return "", false
+	case "EVAL", "EVALSHA":
+		// EVAL script numkeys key [key ...]
+		if len(cmd.args) < 3 {
+			return "", false
+		}
+		if n, err := redis.Int(cmd.args[1], nil); err != nil || n == 0 {
+			return "", false
+		}
+		return fmt.Sprint(cmd.args[2]), true
+	}
+	if len(cmd.args) == 0 {
+		return "", false
+	}
+	if b, ok := cmd.args[0].([]byte); ok {
+		return string(b), true
+	}
+	return fmt.Sprint(cmd.args[0]), true
+}
+
+// hashSlot returns the cluster hash slot of the key, honouring {hash tags}
+func hashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+// crc16 implements the CRC16-CCITT (XMODEM) checksum used by Redis Cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for b := 0; b < 8; b++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func isRedirect(err error) bool {
+	_, _, _, ok := parseRedirect(err)
+	return ok
+}
+
+// parseRedirect parses errors of the form "MOVED 3999 127.0.0.1:6381"
+func parseRedirect(err error) (kind string, slot int, addr string, ok bool) {
+	fields := strings.Fields(err.Error())
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", 0, "", false
+	}
+	slot, convErr := strconv.Atoi(fields[1])
+	if convErr != nil || slot < 0 || slot >= clusterSlots {
+		return "", 0, "", false
+	}
+	return fields[0], slot, fields[2], true
+}
@@ -0,0 +1,269 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var addOpts struct {
+	store  *storeFlags
+	person outlived.Person
+}
+
+var addCommand = &command{
+	name:    "add",
+	summary: "Add one person to a dataset, or correct their record, without importing the whole file again",
+	flags: func(fs *flag.FlagSet) {
+		addOpts.store = addStoreFlags(fs)
+		fs.StringVar(&addOpts.person.Name, "name", "", "The person's name")
+		fs.StringVar(&addOpts.person.BirthDate, "born", "", "Date of birth, e.g. '1940-01-01' or '1 January 1940'")
+		fs.StringVar(&addOpts.person.DeathDate, "died", "", "Date of death (default none, for a living person)")
+		fs.StringVar(&addOpts.person.Occupation, "occupation", "", "Occupation, e.g. 'guitarist'")
+		fs.StringVar(&addOpts.person.Nationality, "nationality", "", "Nationality, e.g. 'GB'")
+		fs.StringVar(&addOpts.person.CauseOfDeath, "cause-of-death", "", "Cause of death")
+		fs.StringVar(&addOpts.person.Genre, "genre", "", "Genres, separated by ';'")
+		fs.StringVar(&addOpts.person.Aliases, "aliases", "", "Other names the person is known by, separated by ';'")
+	},
+	run: runAdd,
+}
+
+func runAdd(fs *flag.FlagSet, args []string) error {
+	if len(args) > 0 {
+		fs.Usage()
+		return errors.New("add: the person is given by -name, -born and -died")
+	}
+	rec := addOpts.person
+	if err := checkPerson(&rec); err != nil {
+		return fmt.Errorf("add: %v", err)
+	}
+	dataset := addOpts.store.dataset
+	store, err := addOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	store = outlived.StoreWithContext(addOpts.store.context(), store)
+
+	stats, err := store.Upsert(dataset, []outlived.Person{rec})
+	if err != nil {
+		return err
+	}
+	switch {
+	case stats.Inserted > 0:
+		fmt.Printf("Added %s to dataset '%s'\n", rec, dataset)
+	case stats.Updated > 0:
+		fmt.Printf("Updated %s in dataset '%s'\n", rec, dataset)
+	default:
+		fmt.Printf("Dataset '%s' already holds %s as given; nothing was changed\n", dataset, rec)
+		return nil
+	}
+	return outlived.MarkEdited(store, dataset)
+}
+
+var removeOpts struct {
+	store *storeFlags
+	born  string
+}
+
+var removeCommand = &command{
+	name:    "remove",
+	args:    "NAME",
+	summary: "Remove one person, named in full, from a dataset without importing the whole file again",
+	flags: func(fs *flag.FlagSet) {
+		removeOpts.store = addStoreFlags(fs)
+		fs.StringVar(&removeOpts.born, "born", "", "Date of birth of the person to remove, choosing between namesakes")
+	},
+	run: runRemove,
+}
+
+func runRemove(fs *flag.FlagSet, args []string) error {
+	name := strings.Join(args, " ")
+	if strings.TrimSpace(name) == "" {
+		fs.Usage()
+		return errors.New("remove: a name must be given")
+	}
+	dataset := removeOpts.store.dataset
+	store, err := removeOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	store = outlived.StoreWithContext(removeOpts.store.context(), store)
+	remover, ok := store.(outlived.Remover)
+	if !ok {
+		return fmt.Errorf("remove: the %s backend can't remove people", removeOpts.store.backend)
+	}
+
+	rec, err := namedPerson(store, "remove", dataset, name, removeOpts.born)
+	if err != nil {
+		return err
+	}
+	n, err := remover.Remove(dataset, []outlived.Person{rec})
+	if err != nil {
+		return err
+	}
+	if n == 0 { // removed meanwhile
+		return noMatch{fmt.Sprintf("remove: no one in dataset '%s' is named '%s'", dataset, name)}
+	}
+	fmt.Printf("Removed %s from dataset '%s'\n", rec, dataset)
+	return outlived.MarkEdited(store, dataset)
+}
+
+var editOpts struct {
+	store  *storeFlags
+	born   string
+	fields map[string]*string // by field name
+}
+
+// editFlags are the flags of edit setting each field. As -born chooses between namesakes, a
+// corrected date of birth is given by -set-born.
+var editFlags = []struct{ flag, field, usage string }{
+	{"name", outlived.FIELD_NAME, "Rename the person"},
+	{"set-born", outlived.FIELD_BIRTH, "Correct the date of birth"},
+	{"died", outlived.FIELD_DEATH, "Correct the date of death, or with '' mark the person as living"},
+	{"occupation", outlived.FIELD_OCCUPATION, "Set the occupation"},
+	{"nationality", outlived.FIELD_NATIONALITY, "Set the nationality"},
+	{"cause-of-death", outlived.FIELD_CAUSE_OF_DEATH, "Set the cause of death"},
+	{"genre", outlived.FIELD_GENRE, "Set the genres, separated by ';'"},
+	{"aliases", outlived.FIELD_ALIASES, "Set the other names the person is known by, separated by ';'"},
+	{"url", outlived.FIELD_URL, "Set the link to an article about the person"},
+	{"summary", outlived.FIELD_SUMMARY, "Set the summary"},
+	{"image-url", outlived.FIELD_IMAGE_URL, "Set the image URL"},
+	{"tags", outlived.FIELD_TAGS, "Set the tags, separated by ';'"},
+}
+
+var editCommand = &command{
+	name:    "edit",
+	args:    "NAME",
+	summary: "Correct fields of one person's record, named in full, rescoring and reindexing them at once",
+	flags: func(fs *flag.FlagSet) {
+		editOpts.store = addStoreFlags(fs)
+		fs.StringVar(&editOpts.born, "born", "", "Date of birth of the person to edit, choosing between namesakes")
+		editOpts.fields = map[string]*string{}
+		for _, f := range editFlags {
+			editOpts.fields[f.field] = fs.String(f.flag, "", f.usage)
+		}
+	},
+	run: runEdit,
+}
+
+func runEdit(fs *flag.FlagSet, args []string) error {
+	name := strings.Join(args, " ")
+	if strings.TrimSpace(name) == "" {
+		fs.Usage()
+		return errors.New("edit: a name must be given")
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	dataset := editOpts.store.dataset
+	store, err := editOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	store = outlived.StoreWithContext(editOpts.store.context(), store)
+	editor, ok := store.(outlived.Editor)
+	if !ok {
+		return fmt.Errorf("edit: the %s backend can't edit people", editOpts.store.backend)
+	}
+
+	old, err := namedPerson(store, "edit", dataset, name, editOpts.born)
+	if err != nil {
+		return err
+	}
+	edited := old
+	for _, f := range editFlags {
+		if set[f.flag] {
+			edited.SetField(f.field, *editOpts.fields[f.field])
+		}
+	}
+	if err := checkPerson(&edited); err != nil {
+		return fmt.Errorf("edit: %v", err)
+	}
+	change := outlived.RecordChange{Old: old, New: edited}
+	if len(change.Fields()) == 0 {
+		fmt.Printf("Dataset '%s' already holds %s as given; nothing was changed\n", dataset, old)
+		return nil
+	}
+	if err := editor.Edit(dataset, old, edited); err != nil {
+		return fmt.Errorf("edit: %v", err)
+	}
+	fmt.Printf("Edited %s in dataset '%s'\n", edited, dataset)
+	for _, field := range change.Fields() {
+		fmt.Printf("    %s: %s -> %s\n", field, quoteField(old.Field(field)), quoteField(edited.Field(field)))
+	}
+	return outlived.MarkEdited(store, dataset)
+}
+
+// checkPerson tidies the record of a person given by flags, reading its dates as import does,
+// and validates it
+func checkPerson(rec *outlived.Person) error {
+	rec.Name = strings.TrimSpace(rec.Name)
+	for _, date := range []*string{&rec.BirthDate, &rec.DeathDate} {
+		if *date == "" {
+			continue
+		}
+		d, err := readDate(*date)
+		if err != nil {
+			return err
+		}
+		*date = d
+	}
+	return outlived.ValidateLivingPerson(*rec)
+}
+
+// readDate reads a date given by a flag as import does, e.g. '1 January 1940' as '1940-01-01'
+func readDate(date string) (string, error) {
+	return outlived.ParseDate(date, []string{outlived.DATE_FORMAT_AUTO}, outlived.CALENDAR_GREGORIAN)
+}
+
+// namedPerson returns the one person in the dataset named in full, or by one of their
+// aliases, and if born is given born then, never the closest match to the name. Born is read
+// as the dates of the person are by checkPerson, so that it matches however it is written.
+func namedPerson(store outlived.Store, cmd, dataset, name, born string) (outlived.Person, error) {
+	if born != "" {
+		d, err := readDate(born)
+		if err != nil {
+			return outlived.Person{}, fmt.Errorf("%s: invalid -born: %v", cmd, err)
+		}
+		born = d
+	}
+	matches, err := outlived.Find(store, name, outlived.MATCH_EXACT, outlived.QueryOptions{Datasets: []string{dataset}, Now: time.Now()})
+	if err != nil {
+		return outlived.Person{}, err
+	}
+	var named []outlived.Person
+	var names []string
+	for _, m := range matches {
+		if !namedAs(m.Person, name) || (born != "" && m.BirthDate != born) {
+			continue
+		}
+		named = append(named, m.Person)
+		names = append(names, fmt.Sprintf("%s (%s)", m.Name, m.BirthDate))
+	}
+	switch {
+	case len(named) == 0:
+		return outlived.Person{}, noMatch{fmt.Sprintf("%s: no one in dataset '%s' is named '%s'", cmd, dataset, name)}
+	case len(named) > 1:
+		return outlived.Person{}, fmt.Errorf("%s: '%s' could be any of %s; give -born to choose", cmd, name, strings.Join(names, ", "))
+	}
+	return named[0], nil
+}
+
+// namedAs reports whether the name, or one of the aliases, of the person is the one given,
+// ignoring case and accents
+func namedAs(rec outlived.Person, name string) bool {
+	for _, n := range rec.Names() {
+		if outlived.FoldName(n) == outlived.FoldName(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var apikeyOpts struct {
+	store *storeFlags
+}
+
+var apikeyCommand = &command{
+	name:    "apikey",
+	args:    "create NAME | list | revoke ID",
+	summary: "Create, list or revoke the API keys accepted by 'serve -api-keys', which are kept in Redis",
+	flags: func(fs *flag.FlagSet) {
+		apikeyOpts.store = addStoreFlags(fs)
+	},
+	run: runAPIKey,
+}
+
+func runAPIKey(fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("apikey: a subcommand must be given")
+	}
+	ks, err := outlived.NewRedisStore(apikeyOpts.store.redisConfig())
+	if err != nil {
+		return err
+	}
+	defer ks.Close()
+
+	switch {
+	case args[0] == "create" && len(args) == 2:
+		k, token, err := outlived.NewAPIKey(args[1], time.Now())
+		if err != nil {
+			return err
+		}
+		if err := ks.SaveAPIKey(k); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Created API key %s for '%s'. It is shown only once, so keep it safe:\n", k.ID, k.Name)
+		fmt.Println(token)
+		return nil
+	case args[0] == "list" && len(args) == 1:
+		keys, err := ks.APIKeys()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tCREATED\tSTATUS")
+		for _, k := range keys {
+			status := "active"
+			if k.Revoked != nil {
+				status = "revoked " + k.Revoked.Local().Format(outlived.DATE_FMT)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", k.ID, k.Name, k.Created.Local().Format(outlived.DATE_FMT), status)
+		}
+		return tw.Flush()
+	case args[0] == "revoke" && len(args) == 2:
+		id := strings.TrimPrefix(args[1], outlived.API_KEY_PREFIX)
+		if i := strings.Index(id, "_"); i >= 0 {
+			id = id[:i] // given the whole token
+		}
+		if err := outlived.RevokeAPIKey(ks, id, time.Now()); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked API key %s\n", id)
+		return nil
+	}
+	fs.Usage()
+	return fmt.Errorf("apikey: unknown subcommand '%s' or wrong number of arguments", args[0])
+}
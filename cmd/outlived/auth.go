@@ -0,0 +1,170 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// ENV_JWT_SECRET holds the secret with which JWT bearer tokens are signed, so that it need not
+// be given on the command line
+const ENV_JWT_SECRET = "OUTLIVED_JWT_SECRET"
+
+// authenticator checks the credentials presented with API requests: keys created with
+// 'apikey create', and if a secret is configured JWT bearer tokens signed with it
+type authenticator struct {
+	mu        sync.Mutex // serialises use of the key store, which is not safe for concurrent use
+	keys      outlived.APIKeyStore
+	jwtSecret []byte
+	jwtIssuer string // the issuer tokens must name, if any
+}
+
+// clientKey is the context key under which the client a request was authenticated as is held
+type clientKey struct{}
+
+// requestClient returns who the request was authenticated as, e.g. 'key:1f2e3d4c5b6a7988' or
+// 'jwt:alice', or "" if the API does not require authentication
+func requestClient(r *http.Request) string {
+	client, _ := r.Context().Value(clientKey{}).(string)
+	return client
+}
+
+// wrap rejects requests to the handler which lack valid credentials. A nil authenticator
+// accepts every request.
+func (a *authenticator) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, err := a.check(r)
+		if err != nil {
+			var he httpError
+			if errors.As(err, &he) && he.status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="outlived"`)
+			}
+			writeError(w, err)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, client)))
+	}
+}
+
+// check returns who the request's credentials identify. They are given as a bearer token in the
+// Authorization header, in an X-API-Key header, or as the 'api_key' parameter for clients
+// such as calendars which cannot set headers.
+func (a *authenticator) check(r *http.Request) (string, error) {
+	token := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, t, _ := strings.Cut(auth, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return "", unauthorized("only Bearer authorization is supported")
+		}
+		token = strings.TrimSpace(t)
+	}
+	if token == "" {
+		token = r.URL.Query().Get("api_key")
+	}
+	switch {
+	case token == "":
+		return "", unauthorized("an API key is required")
+	case strings.HasPrefix(token, outlived.API_KEY_PREFIX) && a.keys != nil:
+		a.mu.Lock()
+		k, err := outlived.CheckAPIKey(a.keys, token)
+		a.mu.Unlock()
+		if errors.Is(err, outlived.ErrInvalidAPIKey) {
+			return "", unauthorized("invalid API key")
+		}
+		if err != nil {
+			return "", err
+		}
+		return "key:" + k.ID, nil
+	case strings.Count(token, ".") == 2 && a.jwtSecret != nil:
+		sub, err := a.checkJWT(token, time.Now())
+		if err != nil {
+			return "", unauthorized("invalid token: %v", err)
+		}
+		return "jwt:" + sub, nil
+	}
+	return "", unauthorized("invalid API key")
+}
+
+func unauthorized(format string, args ...interface{}) error {
+	return httpError{http.StatusUnauthorized, fmt.Sprintf(format, args...)}
+}
+
+// checkJWT verifies a JWT signed with HS256, returning its subject. Tokens which have expired,
+// are not yet valid, or name another issuer than that configured are rejected.
+func (a *authenticator) checkJWT(token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "HS256" {
+		return "", errors.New("tokens must be signed with HS256")
+	}
+	mac := hmac.New(sha256.New, a.jwtSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	sig, err := base64.RawURLEncoding.Strict().DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", errors.New("bad signature")
+	}
+	var claims struct {
+		Sub string   `json:"sub"`
+		Iss string   `json:"iss"`
+		Exp *float64 `json:"exp"`
+		Nbf *float64 `json:"nbf"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", err
+	}
+	switch {
+	case claims.Exp != nil && now.Unix() >= int64(*claims.Exp):
+		return "", errors.New("expired")
+	case claims.Nbf != nil && now.Unix() < int64(*claims.Nbf):
+		return "", errors.New("not yet valid")
+	case a.jwtIssuer != "" && claims.Iss != a.jwtIssuer:
+		return "", errors.New("wrong issuer")
+	}
+	return claims.Sub, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// securitySchemes returns the OpenAPI security schemes of the credentials accepted
+func (a *authenticator) securitySchemes() map[string]interface{} {
+	if a == nil {
+		return nil
+	}
+	schemes := map[string]interface{}{}
+	if a.keys != nil {
+		schemes["apiKey"] = map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"}
+	}
+	if a.jwtSecret != nil {
+		schemes["bearer"] = map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}
+	}
+	return schemes
+}
@@ -0,0 +1,147 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var backupOpts struct {
+	store *storeFlags
+	out   string
+}
+
+var backupCommand = &command{
+	name:    "backup",
+	summary: "Write whole datasets to a snapshot file, from which restore can load them into any store",
+	flags: func(fs *flag.FlagSet) {
+		backupOpts.store = addStoreFlags(fs)
+		fs.StringVar(&backupOpts.out, "out", "", "File to write the snapshot to (default stdout)")
+	},
+	run: runBackup,
+}
+
+func runBackup(fs *flag.FlagSet, args []string) error {
+	store, err := backupOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	datasets, err := outlived.ResolveDatasets(store, backupOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	snap, err := outlived.TakeSnapshot(store, datasets)
+	if err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+
+	if backupOpts.out == "" {
+		return outlived.WriteSnapshot(os.Stdout, snap)
+	}
+	f, err := os.Create(backupOpts.out)
+	if err != nil {
+		return err
+	}
+	if err := outlived.WriteSnapshot(f, snap); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	for _, ds := range snap.Datasets {
+		fmt.Fprintf(os.Stderr, "Backed up %d records from dataset '%s' to '%s'\n", ds.Count+ds.Living, ds.Name, backupOpts.out)
+	}
+	return nil
+}
+
+var restoreOpts struct {
+	store *storeFlags
+}
+
+var restoreCommand = &command{
+	name:    "restore",
+	args:    "FILE [DATASET...]",
+	summary: "Load the datasets in a snapshot written by backup, or those named, replacing any of the same name",
+	flags: func(fs *flag.FlagSet) {
+		restoreOpts.store = addStoreFlags(fs)
+	},
+	run: runRestore,
+}
+
+func runRestore(fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		fs.Usage()
+		return fmt.Errorf("restore: no snapshot file given")
+	}
+	snap, err := readSnapshotFile(args[0])
+	if err != nil {
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+	datasets := snap.Datasets
+	if len(args) > 1 {
+		datasets = nil
+		for _, name := range args[1:] {
+			ds, ok := findSnapshot(snap, name)
+			if !ok {
+				return fmt.Errorf("restore: '%s' holds no dataset '%s'", args[0], name)
+			}
+			datasets = append(datasets, ds)
+		}
+	}
+
+	store, err := restoreOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx, stop := interruptible(restoreOpts.store.context())
+	defer stop()
+	store = outlived.StoreWithContext(ctx, store)
+	fmt.Printf("Restoring from a snapshot taken %s\n", snap.Created.Local().Format(time.RFC1123))
+	for _, ds := range datasets {
+		if err := store.Import(ds.Name, ds.People); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("restore: stopped (%v); dataset '%s' was left as it was", ctx.Err(), ds.Name)
+			}
+			return fmt.Errorf("restore: dataset '%s': %v", ds.Name, err)
+		}
+		if err := outlived.SaveCopiedProvenance(store, ds.Name, ds.Provenance, "snapshot "+args[0], ds.People); err != nil {
+			return fmt.Errorf("restore: dataset '%s' was restored, but its provenance could not be recorded: %v", ds.Name, err)
+		}
+		fmt.Printf("Restored %d records into dataset '%s'\n", ds.Count+ds.Living, ds.Name)
+	}
+	return nil
+}
+
+// readSnapshotFile reads the snapshot in the file, or on stdin if the path is '-'
+func readSnapshotFile(path string) (outlived.Snapshot, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return outlived.Snapshot{}, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return outlived.ReadSnapshot(bufio.NewReader(r))
+}
+
+// findSnapshot returns the dataset of the name held in the snapshot
+func findSnapshot(snap outlived.Snapshot, name string) (outlived.DatasetSnapshot, bool) {
+	for _, ds := range snap.Datasets {
+		if ds.Name == name {
+			return ds, true
+		}
+	}
+	return outlived.DatasetSnapshot{}, false
+}
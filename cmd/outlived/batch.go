@@ -0,0 +1,260 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// batchEntry is a date of birth read from a -batch file, with its label if it has one
+type batchEntry struct {
+	label string
+	dob   string
+	line  int
+}
+
+// runBatch summarises where each of the dates of birth in the -batch file stands among the
+// datasets, reading the datasets once for them all
+func runBatch(fs *flag.FlagSet, args []string) error {
+	if len(args) > 1 || (len(args) == 1) != queryOpts.noDB {
+		fs.Usage()
+		return errors.New("query: no date can be given with -batch, only a file when using -no-db")
+	}
+	if queryOpts.diedAt != "" || queryOpts.diedBetween != "" || queryOpts.count || queryOpts.format != "" || queryOpts.nearest > 0 || queryOpts.minResults > 0 {
+		return errors.New("query: -batch can't be used with -died-at, -died-between, -count, -format, -nearest or -min-results")
+	}
+	entries, err := readBatchFile(queryOpts.batch)
+	if err != nil {
+		return err
+	}
+	store, dataset, err := openQueryStore(args)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, dataset)
+	if err != nil {
+		return err
+	}
+	now, err := queryOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	dates := make([]string, len(entries))
+	for i, e := range entries {
+		if e.dob > now.Format(outlived.DATE_FMT) {
+			return fmt.Errorf("query: %s: line %d: the date of birth %s is in the future", batchName(queryOpts.batch), e.line, e.dob)
+		}
+		dates[i] = e.dob
+	}
+	summaries, err := outlived.Summarize(store, dates, outlived.QueryOptions{Datasets: datasets, Filter: queryOpts.filter, Now: now})
+	if err != nil {
+		return err
+	}
+	return writeBatch(os.Stdout, queryOpts.output, entries, summaries, now, len(datasets) > 1)
+}
+
+// readBatchFile reads the dates of birth from a CSV file, or stdin if path is '-'
+func readBatchFile(path string) ([]batchEntry, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+	entries, err := readBatch(f)
+	if err != nil {
+		return nil, fmt.Errorf("query: %s: %v", batchName(path), err)
+	}
+	return entries, nil
+}
+
+// batchName names the -batch file in errors
+func batchName(path string) string {
+	if path == "-" {
+		return "stdin"
+	}
+	return path
+}
+
+// readBatch reads a date of birth (YYYY-MM-DD) from each row of CSV data, labelled by the
+// first other field which isn't blank, e.g. 'Grandma,1931-02-03'. Blank rows, rows starting
+// with '#' and a header row are skipped.
+func readBatch(r io.Reader) ([]batchEntry, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	var entries []batchEntry
+	for n := 0; ; n++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		e := batchEntry{line: line}
+		for _, field := range row {
+			field = strings.TrimSpace(field)
+			switch {
+			case e.dob == "" && outlived.ValidateDate(field) == nil:
+				if _, err := time.Parse(outlived.DATE_FMT, field); err != nil {
+					return nil, fmt.Errorf("line %d: invalid date '%s'", line, field)
+				}
+				e.dob = field
+			case e.label == "":
+				e.label = field
+			}
+		}
+		switch {
+		case e.dob != "":
+			entries = append(entries, e)
+		case e.label == "":
+			// a blank row
+		case n > 0:
+			return nil, fmt.Errorf("line %d: no date of birth (YYYY-MM-DD) in '%s'", line, strings.Join(row, ","))
+		}
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no dates of birth found")
+	}
+	return entries, nil
+}
+
+func writeBatch(w io.Writer, format string, entries []batchEntry, summaries []outlived.Summary, now time.Time, labelled bool) error {
+	switch format {
+	case OUTPUT_TEXT:
+		return writeBatchText(w, entries, summaries, now, labelled)
+	case OUTPUT_JSON:
+		return writeBatchJSON(w, entries, summaries)
+	case OUTPUT_CSV:
+		return writeBatchCSV(w, entries, summaries, ',')
+	case OUTPUT_TSV:
+		return writeBatchCSV(w, entries, summaries, '\t')
+	}
+	return fmt.Errorf("unknown output format '%s'", format)
+}
+
+// writeBatchText writes a table of the summaries, giving each date's age, the proportion of
+// the datasets outlived, and who is next to be outlived and when
+func writeBatchText(w io.Writer, entries []batchEntry, summaries []outlived.Summary, now time.Time, labelled bool) error {
+	today, _ := time.Parse(outlived.DATE_FMT, now.Format(outlived.DATE_FMT))
+	withLabels := false
+	for _, e := range entries {
+		withLabels = withLabels || e.label != ""
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if withLabels {
+		fmt.Fprint(tw, "LABEL\t")
+	}
+	fmt.Fprintln(tw, "BORN\tAGE\tOUTLIVED\tNEXT TO OUTLIVE")
+	for i, s := range summaries {
+		age := formatAge(s.UserAge)
+		birth, _ := time.Parse(outlived.DATE_FMT, s.BirthDate)
+		switch {
+		case queryOpts.units:
+			age = outlived.AgeInUnitsBetween(birth, now).String()
+		case queryOpts.precise:
+			age = outlived.CalendarAgeBetween(birth, now).String()
+		}
+		next := "no one"
+		if m := s.Next; m != nil {
+			name := m.Name
+			if labelled {
+				name += " [" + m.Dataset + "]"
+			}
+			next = fmt.Sprintf("%s on %s, %s", name, m.Date.Format(outlived.DATE_FMT), relativeDays(int(m.Date.Sub(today).Hours()/24)))
+		}
+		if withLabels {
+			fmt.Fprintf(tw, "%s\t", entries[i].label)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.0f%% (%d of %d)\t%s\n", s.BirthDate, age,
+			s.Ranking.Percentile(), s.Ranking.Outlived, s.Ranking.Total, next)
+	}
+	return tw.Flush()
+}
+
+type jsonSummary struct {
+	Label     string         `json:"label,omitempty"`
+	BirthDate string         `json:"birth_date"`
+	AgeDays   int            `json:"age_days"`
+	Age       string         `json:"age"`
+	Ranking   jsonRanking    `json:"ranking"`
+	Last      *jsonMilestone `json:"last_outlived"`
+	Next      *jsonMilestone `json:"next_to_outlive"`
+}
+
+type jsonMilestone struct {
+	Name    string `json:"name"`
+	Dataset string `json:"dataset"`
+	AgeDays int    `json:"age_days"`
+	Date    string `json:"date"` // the first day on which they are outlived
+}
+
+func newJSONMilestone(m *outlived.Milestone) *jsonMilestone {
+	if m == nil {
+		return nil
+	}
+	return &jsonMilestone{Name: m.Name, Dataset: m.Dataset, AgeDays: m.Days, Date: m.Date.Format(outlived.DATE_FMT)}
+}
+
+func writeBatchJSON(w io.Writer, entries []batchEntry, summaries []outlived.Summary) error {
+	docs := make([]jsonSummary, len(summaries))
+	for i, s := range summaries {
+		docs[i] = jsonSummary{
+			Label:     entries[i].label,
+			BirthDate: s.BirthDate,
+			AgeDays:   s.UserAge,
+			Age:       formatAge(s.UserAge),
+			Ranking: jsonRanking{
+				Outlived:   s.Ranking.Outlived,
+				Total:      s.Ranking.Total,
+				Percentile: s.Ranking.Percentile(),
+				Rank:       s.Ranking.Rank(),
+			},
+			Last: newJSONMilestone(s.Last),
+			Next: newJSONMilestone(s.Next),
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(docs)
+}
+
+// writeBatchCSV writes a row for each summary, with the people last and next outlived
+func writeBatchCSV(w io.Writer, entries []batchEntry, summaries []outlived.Summary, delimiter rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = delimiter
+	cw.Write([]string{"label", "birth_date", "age_days", "age", "outlived", "total", "percentile",
+		"last_outlived", "last_outlived_on", "next_to_outlive", "next_outlived_on"})
+	milestone := func(m *outlived.Milestone) []string {
+		if m == nil {
+			return []string{"", ""}
+		}
+		return []string{m.Name, m.Date.Format(outlived.DATE_FMT)}
+	}
+	for i, s := range summaries {
+		row := []string{entries[i].label, s.BirthDate, strconv.Itoa(s.UserAge), formatAge(s.UserAge),
+			strconv.Itoa(s.Ranking.Outlived), strconv.Itoa(s.Ranking.Total), strconv.FormatFloat(s.Ranking.Percentile(), 'f', 1, 64)}
+		row = append(row, milestone(s.Last)...)
+		cw.Write(append(row, milestone(s.Next)...))
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,164 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// BENCH_DATASET is the dataset into which bench imports its synthetic people, unless -dataset
+// is given
+const BENCH_DATASET = "bench"
+
+// benchSeed seeds the synthetic people and the dates queried, so that runs can be compared
+const benchSeed = 1
+
+var benchOpts struct {
+	store   *storeFlags
+	rows    int
+	queries int
+	days    int
+	force   bool
+	batch   outlived.BatchOptions
+}
+
+var benchCommand = &command{
+	name:    "bench",
+	summary: "Import synthetic people into a dataset and report the import's throughput and the latency of queries against it",
+	flags: func(fs *flag.FlagSet) {
+		benchOpts.store = addStoreFlags(fs)
+		benchOpts.store.dataset = BENCH_DATASET
+		fs.Lookup("dataset").DefValue = BENCH_DATASET
+		fs.IntVar(&benchOpts.rows, "rows", 100000, "Number of synthetic people to import")
+		fs.IntVar(&benchOpts.queries, "queries", 1000, "Number of queries to time, each for a random date of birth")
+		fs.IntVar(&benchOpts.days, "days", 365, "Window either side of each query's age, in days")
+		fs.IntVar(&benchOpts.batch.Size, "batch-size", outlived.DEFAULT_BATCH_SIZE, "Records written to Redis in each pipelined batch")
+		fs.IntVar(&benchOpts.batch.Workers, "workers", outlived.DEFAULT_BATCH_WORKERS, "Batches written to Redis at once, each on its own connection")
+		fs.BoolVar(&benchOpts.force, "force", false, "Replace the dataset even if it holds people not imported by bench")
+	},
+	run: runBench,
+}
+
+func runBench(fs *flag.FlagSet, args []string) error {
+	if len(args) > 0 {
+		fs.Usage()
+		return errors.New("bench: no arguments are expected")
+	}
+	if benchOpts.rows <= 0 || benchOpts.queries < 0 || benchOpts.days < 0 {
+		return errors.New("bench: -rows must be positive, and -queries and -days can't be negative")
+	}
+	dataset := benchOpts.store.dataset
+	if err := outlived.ValidateDatasetName(dataset); err != nil {
+		return err
+	}
+	store, err := benchOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if !benchOpts.force {
+		if err := checkBenchDataset(store, dataset); err != nil {
+			return err
+		}
+	}
+
+	rng := rand.New(rand.NewSource(benchSeed))
+	now := time.Now()
+	start := time.Now()
+	people := outlived.SyntheticPeople(benchOpts.rows, rng, now)
+	fmt.Printf("Generated %d synthetic people in %s\n", len(people), time.Since(start).Round(time.Millisecond))
+
+	start = time.Now()
+	if bi, ok := store.(outlived.BatchImporter); ok {
+		err = bi.ImportBatched(dataset, people, benchOpts.batch)
+	} else {
+		err = store.Import(dataset, people)
+	}
+	if err != nil {
+		return fmt.Errorf("bench: %v", err)
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("Imported them into dataset '%s' (%s) in %s: %.0f records/sec\n",
+		dataset, benchOpts.store.backend, elapsed.Round(time.Millisecond), float64(len(people))/elapsed.Seconds())
+	if ps, ok := store.(outlived.ProvenanceStore); ok {
+		if err := ps.SaveProvenance(dataset, outlived.NewProvenance(outlived.SOURCE_SYNTHETIC, people, outlived.Progress{})); err != nil {
+			return err
+		}
+	}
+	if benchOpts.queries == 0 {
+		return nil
+	}
+
+	latencies := make([]time.Duration, benchOpts.queries)
+	found := 0
+	opts := outlived.QueryOptions{Datasets: []string{dataset}, Days: benchOpts.days, Now: now}
+	for i := range latencies {
+		// ages within those at which the synthetic people died
+		dob := now.AddDate(0, 0, -(15*365 + rng.Intn(80*365))).Format(outlived.DATE_FMT)
+		start := time.Now()
+		_, results, _, err := outlived.Query(store, dob, opts)
+		latencies[i] = time.Since(start)
+		if err != nil {
+			return fmt.Errorf("bench: query for %s: %v", dob, err)
+		}
+		found += len(results)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, d := range latencies {
+		total += d
+	}
+	fmt.Printf("Ran %d queries of %d days either side, finding %.0f people each on average\n",
+		len(latencies), benchOpts.days, float64(found)/float64(len(latencies)))
+	fmt.Printf("Latency: mean %s, p50 %s, p90 %s, p99 %s, max %s\n",
+		roundLatency(total/time.Duration(len(latencies))), roundLatency(percentile(latencies, 50)),
+		roundLatency(percentile(latencies, 90)), roundLatency(percentile(latencies, 99)), roundLatency(latencies[len(latencies)-1]))
+	return nil
+}
+
+// checkBenchDataset refuses to replace a dataset holding people who weren't imported by bench,
+// as a slip of -dataset would otherwise lose a real one
+func checkBenchDataset(store outlived.Store, dataset string) error {
+	datasets, err := store.Datasets()
+	if err != nil {
+		return err
+	}
+	for _, ds := range datasets {
+		if ds.Name != dataset || ds.Count+ds.Living == 0 {
+			continue
+		}
+		if ps, ok := store.(outlived.ProvenanceStore); ok {
+			if p, err := ps.Provenance(dataset); err == nil && p.Source == outlived.SOURCE_SYNTHETIC {
+				return nil
+			}
+		}
+		return fmt.Errorf("bench: dataset '%s' already holds %d people, who would be replaced; give -force to go ahead", dataset, ds.Count+ds.Living)
+	}
+	return nil
+}
+
+// percentile returns the latency below which p percent of the sorted latencies lie, by the
+// nearest rank
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// roundLatency rounds a latency to a precision suited to its size
+func roundLatency(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
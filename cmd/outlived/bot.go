@@ -0,0 +1,311 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// ENV_TELEGRAM_TOKEN supplies the token of the Telegram bot, should -token not be given
+const ENV_TELEGRAM_TOKEN = "OUTLIVED_TELEGRAM_TOKEN"
+
+// ENV_FEDIVERSE_TOKEN supplies the access token of the fediverse account, should -token not be
+// given
+const ENV_FEDIVERSE_TOKEN = "OUTLIVED_FEDIVERSE_TOKEN"
+
+// the services on which bot can run, naming their subscriptions in the store
+const (
+	BOT_TELEGRAM  = "telegram"
+	BOT_FEDIVERSE = "fediverse"
+)
+
+// TELEGRAM_POLL is how long each request for updates waits for a message to arrive
+const TELEGRAM_POLL = 30 * time.Second
+
+// BOT_RETRY is how long a bot waits before asking again for the messages it failed to read
+const BOT_RETRY = 5 * time.Second
+
+var botOpts struct {
+	store *storeFlags
+	clock *clockFlags
+	token string
+	at    string
+
+	account    string
+	template   string
+	count      int
+	visibility string
+	dryRun     bool
+	date       string
+}
+
+var botCommand = &command{
+	name:    "bot",
+	args:    "telegram|fediverse",
+	summary: "Run a chat bot answering a date of birth with how it compares and sending subscribers their milestones, or a fediverse account posting who died on this day",
+	flags: func(fs *flag.FlagSet) {
+		botOpts.store = addStoreFlags(fs)
+		botOpts.clock = addClockFlags(fs, false)
+		// not defaulted from the environment, so that the token is not shown in the usage text
+		fs.StringVar(&botOpts.token, "token", "", "Token of the Telegram bot, as given by BotFather (env "+ENV_TELEGRAM_TOKEN+"), or access token of the fediverse account, with the write:statuses scope (env "+ENV_FEDIVERSE_TOKEN+")")
+		fs.StringVar(&botOpts.at, "at", "09:00", "Time of day (HH:MM, in -timezone) at which to send subscribed chats their milestones, or to post")
+		fs.StringVar(&botOpts.account, "account", "", "Fediverse account to post as, e.g. '@outlived@mastodon.social'")
+		fs.StringVar(&botOpts.template, "template", DEFAULT_POST_TEMPLATE, "Go template of each fediverse post, given .Name, .Year, .YearsAgo, .AgeYears, .Age, .URL and the person's other fields")
+		fs.IntVar(&botOpts.count, "count", 1, "Number of people who died on the day to post about each day")
+		fs.StringVar(&botOpts.visibility, "visibility", "public", "Visibility of fediverse posts: "+strings.Join(outlived.FEDIVERSE_VISIBILITIES, ", "))
+		fs.BoolVar(&botOpts.dryRun, "dry-run", false, "Print the day's fediverse posts rather than posting them, and exit")
+		fs.StringVar(&botOpts.date, "date", "", "Day whose posts -dry-run prints (YYYY-MM-DD), by default today")
+	},
+	run: runBot,
+}
+
+func runBot(fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		fs.Usage()
+		return errors.New("bot: a service must be given: 'telegram' or 'fediverse'")
+	}
+	switch args[0] {
+	case BOT_TELEGRAM:
+		return runTelegramBot()
+	case BOT_FEDIVERSE:
+		return runFediverseBot()
+	}
+	return fmt.Errorf("bot: unknown service '%s': expected 'telegram' or 'fediverse'", args[0])
+}
+
+// botToken returns the token given by -token, or else by the environment variable
+func botToken(env string) (string, error) {
+	token := botOpts.token
+	if token == "" {
+		token = os.Getenv(env)
+	}
+	if token == "" {
+		return "", fmt.Errorf("bot: the token must be given with -token or %s", env)
+	}
+	return token, nil
+}
+
+func runTelegramBot() error {
+	token, err := botToken(ENV_TELEGRAM_TOKEN)
+	if err != nil {
+		return err
+	}
+	at, err := time.Parse("15:04", botOpts.at)
+	if err != nil {
+		return fmt.Errorf("bot: invalid time '%s', expected HH:MM", botOpts.at)
+	}
+	loc, err := botOpts.clock.location()
+	if err != nil {
+		return err
+	}
+	store, err := botOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	subs, ok := store.(outlived.SubscriptionStore)
+	if !ok {
+		return fmt.Errorf("bot: the %s backend can't hold subscriptions; use redis", botOpts.store.backend)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	tg := outlived.TelegramBot{Token: token, Client: &http.Client{Timeout: TELEGRAM_POLL + 30*time.Second}}
+	b := &chatBot{
+		service: BOT_TELEGRAM,
+		store:   store,
+		subs:    subs,
+		loc:     loc,
+		send: func(chat, text string) error {
+			id, err := strconv.ParseInt(chat, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid chat '%s'", chat)
+			}
+			return tg.Send(ctx, id, text)
+		},
+	}
+	go b.schedule(ctx, at)
+	log.Printf("bot: answering messages to the Telegram bot, and sending milestones at %s", botOpts.at)
+
+	var offset int64
+	for ctx.Err() == nil {
+		updates, err := tg.Updates(ctx, offset, TELEGRAM_POLL)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Print(err)
+				sleepCtx(ctx, BOT_RETRY)
+			}
+			continue
+		}
+		for _, u := range updates {
+			offset = u.ID + 1
+			if u.Message == nil || strings.TrimSpace(u.Message.Text) == "" {
+				continue
+			}
+			chat := strconv.FormatInt(u.Message.Chat.ID, 10)
+			if err := b.send(chat, b.reply(chat, u.Message.Text)); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+	return nil
+}
+
+// sleepCtx waits for d, or until ctx ends
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// chatBot answers the messages sent to a bot on one service, and sends the chats subscribed to
+// it their milestones
+type chatBot struct {
+	mu      sync.Mutex // serialises use of the store, which is not safe for concurrent use
+	service string
+	store   outlived.Store
+	subs    outlived.SubscriptionStore
+	loc     *time.Location
+	send    func(chat, text string) error
+}
+
+// botHelp is the reply to '/start' and '/help', and to messages the bot can't read
+const botHelp = `Send me a date of birth, such as 1990-09-25 or 25 Sep 1990, and I'll tell you how many of the people I know of you have outlived, and who is next.
+
+/subscribe DATE - send me a message each time someone born on DATE outlives someone
+/unsubscribe - stop sending them`
+
+// reply returns the answer to a message sent in the chat
+func (b *chatBot) reply(chat, text string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now().In(b.loc)
+	word, rest, _ := strings.Cut(strings.TrimSpace(text), " ")
+	// in a group, commands may be addressed to the bot as '/subscribe@name'
+	command, _, _ := strings.Cut(strings.ToLower(word), "@")
+	switch command {
+	case "/start", "/help":
+		return botHelp
+	case "/subscribe":
+		dob, err := outlived.ParseUserDate(strings.TrimSpace(rest), now)
+		if err != nil {
+			return "I can't read that date of birth: " + err.Error() + ". Try /subscribe 1990-09-25"
+		}
+		sub, err := outlived.NewSubscription(chat, dob, now)
+		if err == nil {
+			err = b.subs.SaveSubscription(b.service, sub)
+		}
+		if err != nil {
+			log.Printf("bot: subscribing chat %s: %v", chat, err)
+			return "Sorry, I couldn't subscribe this chat. Please try again later."
+		}
+		return fmt.Sprintf("Subscribed: I'll send a message each time someone born on %s outlives someone, at %s each day.\n\n%s",
+			dob, botOpts.at, b.summary(dob, now))
+	case "/unsubscribe":
+		err := b.subs.RemoveSubscription(b.service, chat)
+		if errors.Is(err, outlived.ErrNoSubscription) {
+			return "This chat isn't subscribed."
+		}
+		if err != nil {
+			log.Printf("bot: unsubscribing chat %s: %v", chat, err)
+			return "Sorry, I couldn't unsubscribe this chat. Please try again later."
+		}
+		return "Unsubscribed: I won't send any more milestones."
+	}
+	if strings.HasPrefix(command, "/") {
+		text = rest // another command, such as '/outlived 1990-09-25'
+	}
+	dob, err := outlived.ParseUserDate(strings.TrimSpace(text), now)
+	if err != nil {
+		return botHelp
+	}
+	return b.summary(dob, now)
+}
+
+// summary returns how someone born on dob compares with the datasets, or an apology
+func (b *chatBot) summary(dob string, now time.Time) string {
+	datasets, err := outlived.ResolveDatasets(b.store, botOpts.store.dataset)
+	if err == nil {
+		var o outlived.Overview
+		if o, err = outlived.OverviewOf(b.store, dob, outlived.QueryOptions{Datasets: datasets, Now: now}); err == nil {
+			return o.Text()
+		}
+	}
+	log.Printf("bot: %s: %v", dob, err)
+	return "Sorry, I couldn't look that up. Please try again later."
+}
+
+// schedule sends the subscribed chats their milestones each day at the time of day given by
+// at, until ctx ends
+func (b *chatBot) schedule(ctx context.Context, at time.Time) {
+	for {
+		sleepCtx(ctx, time.Until(nextCheck(time.Now().In(b.loc), at)))
+		if ctx.Err() != nil {
+			return
+		}
+		if err := b.pushMilestones(time.Now().In(b.loc)); err != nil {
+			log.Printf("bot: %v", err) // tried again at the next check
+		}
+	}
+}
+
+// pushMilestones sends each subscribed chat a message for each milestone passed since it was
+// last sent them, carrying on past chats which fail. A chat's record of what it was sent only
+// moves on once all of its messages are sent, so that a failure is made good the next time.
+// Messages are answered once it is done, so that no chat unsubscribes meanwhile.
+func (b *chatBot) pushMilestones(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs, err := b.subs.Subscriptions(b.service)
+	if err != nil {
+		return err
+	}
+	datasets, err := outlived.ResolveDatasets(b.store, botOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	today := now.Format(outlived.DATE_FMT)
+	var failures []string
+	for _, sub := range subs {
+		if err := b.pushTo(sub, datasets, now, today); err != nil {
+			failures = append(failures, fmt.Sprintf("chat %s: %v", sub.Chat, err))
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// pushTo sends the subscriber their milestones due as of now
+func (b *chatBot) pushTo(sub outlived.Subscription, datasets []string, now time.Time, today string) error {
+	due, err := outlived.DueMilestones(b.store, sub, outlived.QueryOptions{Datasets: datasets, Now: now})
+	if err != nil {
+		return err
+	}
+	for _, m := range due {
+		n := outlived.Notification{BirthDate: sub.BirthDate, Milestone: m}
+		if err := b.send(sub.Chat, n.Text()); err != nil {
+			return err
+		}
+	}
+	sub.Notified = today
+	return b.subs.SaveSubscription(b.service, sub)
+}
@@ -0,0 +1,225 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// CACHE_MAX_ENTRIES limits the number of responses held by a responseCache
+const CACHE_MAX_ENTRIES = 1000
+
+// where responses are cached, given by -cache-store
+const (
+	CACHE_MEMORY = "memory"
+	CACHE_REDIS  = "redis"
+)
+
+// cachedResponse is a generated response body
+type cachedResponse struct {
+	contentType string
+	header      map[string]string // any other headers to send with it
+	body        []byte
+	etag        string
+	expires     time.Time
+}
+
+// sharedResponse is a cachedResponse as held in Redis
+type sharedResponse struct {
+	ContentType string            `json:"content_type"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body"`
+	Expires     time.Time         `json:"expires"`
+}
+
+// responseCache holds generated responses in memory for a fixed time, keyed by request URL,
+// and if shared is set also in Redis, where other servers can find them
+type responseCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	entries    map[string]*cachedResponse
+	generation int64 // of the store, when the entries were generated
+
+	shared *outlived.RedisStore // used by requests at once, each on a connection from its pool
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{ttl: ttl, entries: map[string]*cachedResponse{}}
+}
+
+// observe empties the cache once the store has changed, since its entries are then stale
+func (c *responseCache) observe(generation int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		c.entries = map[string]*cachedResponse{}
+		c.generation = generation
+	}
+}
+
+func (c *responseCache) get(ctx context.Context, key string, now time.Time) *cachedResponse {
+	c.mu.Lock()
+	e := c.entries[key]
+	c.mu.Unlock()
+	if e != nil && now.Before(e.expires) {
+		return e
+	}
+	if c.shared == nil {
+		return nil
+	}
+	v, err := c.sharedStore(ctx).CachedResponse(key)
+	var sr sharedResponse
+	if err == nil && v != nil {
+		err = json.Unmarshal(v, &sr)
+	}
+	if err != nil {
+		log.Printf("cache: %v", err)
+	}
+	if err != nil || v == nil || !now.Before(sr.Expires) {
+		return nil
+	}
+	e = &cachedResponse{contentType: sr.ContentType, header: sr.Header, body: sr.Body, etag: etag(sr.Body), expires: sr.Expires}
+	c.putLocal(key, e, now)
+	return e
+}
+
+// put caches the response until the TTL passes, or the day ends in the zone loc, when the ages
+// it was generated from change
+func (c *responseCache) put(ctx context.Context, key string, e *cachedResponse, now time.Time, loc *time.Location) {
+	local := now.In(loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+	e.expires = now.Add(c.ttl)
+	if midnight.Before(e.expires) {
+		e.expires = midnight
+	}
+	e.etag = etag(e.body)
+	c.putLocal(key, e, now)
+	if c.shared == nil {
+		return
+	}
+	v, err := json.Marshal(sharedResponse{ContentType: e.contentType, Header: e.header, Body: e.body, Expires: e.expires})
+	if err == nil {
+		err = c.sharedStore(ctx).CacheResponse(key, v, e.expires.Sub(now))
+	}
+	if err != nil {
+		log.Printf("cache: %v", err)
+	}
+}
+
+// sharedStore returns a view of shared tracing its commands as part of ctx
+func (c *responseCache) sharedStore(ctx context.Context) *outlived.RedisStore {
+	return c.shared.WithContext(ctx).(*outlived.RedisStore)
+}
+
+func (c *responseCache) putLocal(key string, e *cachedResponse, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= CACHE_MAX_ENTRIES {
+		for k, old := range c.entries {
+			if now.After(old.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= CACHE_MAX_ENTRIES {
+			c.entries = map[string]*cachedResponse{}
+		}
+	}
+	c.entries[key] = e
+}
+
+// etag returns a strong entity tag for the body
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// cached wraps a handler generating a response so that its responses are cached, and answered
+// with 304 Not Modified when the client already has them. The key includes the store's
+// generation, so that imports invalidate what was cached before, and the current date, since
+// the milestones passed change from one day to the next.
+func (s *server) cached(generate func(r *http.Request) (*cachedResponse, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		ctx, span := outlived.Tracer().Start(r.Context(), "cache lookup")
+		generation, err := s.generation(ctx)
+		if err != nil {
+			span.End()
+			writeError(w, err)
+			return
+		}
+		s.cache.observe(generation)
+		key := fmt.Sprintf("%d %s %s", generation, now.In(s.loc).Format(outlived.DATE_FMT), cacheKey(r))
+		e := s.cache.get(ctx, key, now)
+		span.SetAttributes(attribute.Bool("outlived.cache.hit", e != nil))
+		span.End()
+		if e == nil {
+			ctx, span := outlived.Tracer().Start(r.Context(), "generate response")
+			if e, err = generate(r.WithContext(ctx)); err == nil {
+				s.cache.put(ctx, key, e, now, s.loc)
+			}
+			span.End()
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		for k, v := range e.header {
+			w.Header().Set(k, v)
+		}
+		w.Header().Set("Content-Type", e.contentType)
+		w.Header().Set("ETag", e.etag)
+		cacheControl := "max-age=" + strconv.Itoa(int(e.expires.Sub(now).Seconds()))
+		if s.auth != nil {
+			cacheControl = "private, " + cacheControl // not to be shared with those without a key
+		}
+		w.Header().Set("Cache-Control", cacheControl)
+		if etagMatches(r.Header.Get("If-None-Match"), e.etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(e.body)
+	}
+}
+
+// generation returns that of the store, or zero if it does not count imports
+func (s *server) generation(ctx context.Context) (int64, error) {
+	vs, ok := s.storeFor(ctx).(outlived.VersionedStore)
+	if !ok {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return vs.Generation()
+}
+
+// cacheKey identifies the response to the request: its path and parameters in a fixed order,
+// leaving out the API key, which does not change the response
+func cacheKey(r *http.Request) string {
+	q := r.URL.Query()
+	q.Del("api_key")
+	return r.URL.Path + "?" + q.Encode()
+}
+
+// etagMatches reports whether an If-None-Match header lists the entity tag
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == etag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+var cardOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	dob      string
+	out      string
+}
+
+var cardCommand = &command{
+	name:    "card",
+	summary: "Render a PNG image of the age of someone born on -dob, how much of the dataset they have outlived and who they outlived last, for sharing",
+	flags: func(fs *flag.FlagSet) {
+		cardOpts.store = addStoreFlags(fs)
+		cardOpts.clock = addClockFlags(fs, true)
+		cardOpts.profiles = addProfileFlags(fs, true)
+		fs.StringVar(&cardOpts.dob, "dob", cfg.DOB, "Date of birth (YYYY-MM-DD)")
+		fs.StringVar(&cardOpts.out, "out", "card.png", "File to write the image to, or '-' for stdout")
+	},
+	run: runCard,
+}
+
+// The size of the card, that of the preview images shown by most social networks
+const (
+	CARD_WIDTH  = 1200
+	CARD_HEIGHT = 630
+	CARD_MARGIN = 60
+)
+
+// Colours of the card
+var (
+	CARD_BACKGROUND = color.RGBA{0x1e, 0x2a, 0x38, 0xff}
+	CARD_TEXT       = color.RGBA{0xf4, 0xf4, 0xf4, 0xff}
+	CARD_DIM        = color.RGBA{0x9a, 0xa8, 0xb8, 0xff}
+	CARD_ACCENT     = color.RGBA{0xe0, 0xb0, 0x40, 0xff}
+	CARD_BAR        = color.RGBA{0x33, 0x44, 0x58, 0xff}
+)
+
+// card is what the card says about the user
+type card struct {
+	age      string
+	datasets string
+	ranking  outlived.Ranking
+	last     string // who the user outlived last, and when
+	next     string // who the user will outlive next, and when
+}
+
+func runCard(fs *flag.FlagSet, args []string) error {
+	dob, err := resolveDOB(cardOpts.dob, cardOpts.profiles, cardOpts.store)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 || dob == "" {
+		fs.Usage()
+		return errors.New("card: a date of birth must be given with -dob or -profile")
+	}
+	now, err := cardOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	store, err := cardOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, cardOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	opts := outlived.QueryOptions{Datasets: datasets, Now: now}
+	c, err := newCard(store, dob, opts)
+	if err != nil {
+		return err
+	}
+	if cardOpts.out == "-" {
+		return writeCard(os.Stdout, c)
+	}
+	f, err := os.Create(cardOpts.out)
+	if err != nil {
+		return err
+	}
+	if err := writeCard(f, c); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote the card to '%s'\n", cardOpts.out)
+	return nil
+}
+
+// newCard works out what the card says about someone born on dob
+func newCard(store outlived.Store, dob string, opts outlived.QueryOptions) (card, error) {
+	today, _ := time.Parse(outlived.DATE_FMT, opts.Now.Format(outlived.DATE_FMT))
+	userAge, err := outlived.AgeInDays(dob, today.Format(outlived.DATE_FMT))
+	if err != nil {
+		return card{}, err
+	}
+	c := card{age: formatAge(userAge), datasets: strings.Join(opts.Datasets, " and ")}
+	if c.ranking, err = outlived.Rank(store, userAge, opts); err != nil {
+		return c, err
+	}
+	recent, err := outlived.Recent(store, dob, 1, opts)
+	if err != nil {
+		return c, err
+	}
+	next, err := outlived.Next(store, dob, 1, opts)
+	if err != nil {
+		return c, err
+	}
+	c.last = "You have yet to outlive anyone in " + c.datasets
+	if len(recent) > 0 {
+		m := recent[0]
+		c.last = fmt.Sprintf("You outlived %s %s", m.Name, relativeDays(int(m.Date.Sub(today).Hours()/24)))
+	}
+	if len(next) > 0 {
+		m := next[0]
+		c.next = fmt.Sprintf("Next: %s, on %s", m.Name, m.Date.Format(outlived.DATE_FMT))
+	}
+	return c, nil
+}
+
+// writeCard renders the card as a PNG image
+func writeCard(w io.Writer, c card) error {
+	regular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return err
+	}
+	bold, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return err
+	}
+	face := func(f *opentype.Font, size float64) (font.Face, error) {
+		return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	}
+	img := image.NewRGBA(image.Rect(0, 0, CARD_WIDTH, CARD_HEIGHT))
+	draw.Draw(img, img.Bounds(), image.NewUniform(CARD_BACKGROUND), image.Point{}, draw.Src)
+
+	lines := []struct {
+		font  *opentype.Font
+		size  float64
+		color color.Color
+		y     int // of the baseline
+		text  string
+	}{
+		{regular, 36, CARD_ACCENT, 100, "outlived"},
+		{bold, 76, CARD_TEXT, 220, c.age},
+		{regular, 40, CARD_TEXT, 300, fmt.Sprintf("You have outlived %.0f%% of %s", c.ranking.Percentile(), c.datasets)},
+		{regular, 36, CARD_TEXT, 460, c.last},
+		{regular, 32, CARD_DIM, 530, c.next},
+	}
+	for _, l := range lines {
+		f, err := face(l.font, l.size)
+		if err != nil {
+			return err
+		}
+		d := &font.Drawer{Dst: img, Src: image.NewUniform(l.color), Face: f}
+		d.Dot = fixed.P(CARD_MARGIN, l.y)
+		d.DrawString(fitText(f, l.text, CARD_WIDTH-2*CARD_MARGIN))
+		f.Close()
+	}
+
+	// a bar filled in proportion to the share of the datasets outlived
+	bar := image.Rect(CARD_MARGIN, 340, CARD_WIDTH-CARD_MARGIN, 370)
+	draw.Draw(img, bar, image.NewUniform(CARD_BAR), image.Point{}, draw.Src)
+	bar.Max.X = bar.Min.X + int(float64(bar.Dx())*c.ranking.Percentile()/100)
+	draw.Draw(img, bar, image.NewUniform(CARD_ACCENT), image.Point{}, draw.Src)
+
+	return png.Encode(w, img)
+}
+
+// fitText shortens the text with an ellipsis, if need be, to fit within width pixels
+func fitText(f font.Face, text string, width int) string {
+	if font.MeasureString(f, text).Ceil() <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		s := strings.TrimSpace(string(runes)) + "…"
+		if font.MeasureString(f, s).Ceil() <= width {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// clockFlags are the options giving the date and time zone in which ages are calculated
+type clockFlags struct {
+	asOf     string
+	timezone string
+}
+
+// addClockFlags adds -timezone, and -as-of if asOf is set, to a command's flags
+func addClockFlags(fs *flag.FlagSet, asOf bool) *clockFlags {
+	f := &clockFlags{}
+	if asOf {
+		fs.StringVar(&f.asOf, "as-of", "", "Calculate ages as of this date (YYYY-MM-DD), rather than today")
+	}
+	fs.StringVar(&f.timezone, "timezone", cfg.Timezone, "Time zone in which today's date is taken, e.g. 'Europe/London' (defaults to the local zone)")
+	return f
+}
+
+// location returns the time zone given by -timezone
+func (f *clockFlags) location() (*time.Location, error) {
+	if f.timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(f.timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone '%s'", f.timezone)
+	}
+	return loc, nil
+}
+
+// now returns the current time in the zone given by -timezone, or the start of the day given
+// by -as-of
+func (f *clockFlags) now() (time.Time, error) {
+	loc, err := f.location()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if f.asOf == "" {
+		return time.Now().In(loc), nil
+	}
+	t, err := time.ParseInLocation(outlived.DATE_FMT, f.asOf, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -as-of date '%s', expected YYYY-MM-DD", f.asOf)
+	}
+	return t, nil
+}
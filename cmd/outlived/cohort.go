@@ -0,0 +1,127 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var cohortOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	dob      string
+	decade   bool
+}
+
+var cohortCommand = &command{
+	name:    "cohort",
+	summary: "Show the people born in the same year as someone born on -dob, or the same decade, and how many of them have died",
+	flags: func(fs *flag.FlagSet) {
+		cohortOpts.store = addStoreFlags(fs)
+		cohortOpts.clock = addClockFlags(fs, true)
+		cohortOpts.profiles = addProfileFlags(fs, true)
+		fs.StringVar(&cohortOpts.dob, "dob", cfg.DOB, "Date of birth (e.g. 1990-09-25 or '25 Sep 1990')")
+		fs.BoolVar(&cohortOpts.decade, "decade", false, "Show those born in the same decade, rather than the same year")
+	},
+	run: runCohort,
+}
+
+func runCohort(fs *flag.FlagSet, args []string) error {
+	if len(args) > 0 {
+		fs.Usage()
+		return errors.New("cohort: no arguments are expected")
+	}
+	dob, err := resolveDOB(cohortOpts.dob, cohortOpts.profiles, cohortOpts.store)
+	if err != nil {
+		return err
+	}
+	if dob == "" {
+		return errors.New("cohort: a date of birth must be given with -dob or -profile")
+	}
+	now, err := cohortOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	if dob, err = outlived.ParseUserDate(dob, now); err != nil {
+		return fmt.Errorf("cohort: %v", err)
+	}
+	birth, _ := time.Parse(outlived.DATE_FMT, dob)
+	from, to := birth.Year(), birth.Year()
+	if cohortOpts.decade {
+		from = from - from%10
+		to = from + 9
+	}
+	store, err := cohortOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, cohortOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	cohort, err := outlived.QueryCohort(store, datasets, from, to, now)
+	if err != nil {
+		return err
+	}
+	if cohort.Size() == 0 {
+		return noMatch{fmt.Sprintf("cohort: no one in %s was born in %s", strings.Join(datasets, ", "), cohortYears(cohort))}
+	}
+	userAge, err := outlived.AgeInDays(dob, now.Format(outlived.DATE_FMT))
+	if err != nil {
+		return err
+	}
+	return writeCohort(os.Stdout, cohort, userAge, datasets, now)
+}
+
+// cohortYears describes the years in which the cohort was born, e.g. '1990' or '1990 to 1999'
+func cohortYears(c outlived.Cohort) string {
+	if c.From == c.To {
+		return fmt.Sprint(c.From)
+	}
+	return fmt.Sprintf("%d to %d", c.From, c.To)
+}
+
+// writeCohort writes how many of the cohort have died, and how many of them were younger than
+// the user now, followed by the dead and then the living with their ages
+func writeCohort(w io.Writer, c outlived.Cohort, userAge int, datasets []string, now time.Time) error {
+	people := "people"
+	if c.Size() == 1 {
+		people = "person"
+	}
+	fmt.Fprintf(w, "Born in %s: %d %s in %s, of whom %d (%.0f%%) have died\n", cohortYears(c), c.Size(), people,
+		strings.Join(datasets, " and "), len(c.Dead), float64(len(c.Dead))*100/float64(c.Size()))
+	younger := 0
+	for _, res := range c.Dead {
+		if res.Days < userAge {
+			younger++
+		}
+	}
+	if len(c.Dead) > 0 {
+		fmt.Fprintf(w, "%d of them died younger than you are now (%s)\n", younger, formatAge(userAge))
+	}
+	labelled := len(datasets) > 1
+	for _, group := range []struct {
+		title   string
+		results []outlived.Result
+	}{{"Died", c.Dead}, {"Living", c.Living}} {
+		if len(group.results) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s:\n", group.title)
+		if err := writeFound(w, group.results, "", now, labelled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
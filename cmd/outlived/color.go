@@ -0,0 +1,30 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import "os"
+
+// ANSI escape sequences used to highlight text output on a terminal
+const (
+	ANSI_HIGHLIGHT = "\x1b[1;33m" // bold yellow
+	ANSI_RESET     = "\x1b[0m"
+)
+
+// useColor reports whether text output to f should be coloured: only on a terminal, and
+// unless -no-color is given or the NO_COLOR (see https://no-color.org) or TERM=dumb
+// environment variables say otherwise
+func useColor(f *os.File, noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps the text in the escape sequence, if color is true
+func colorize(s, seq string, color bool) string {
+	if !color {
+		return s
+	}
+	return seq + s + ANSI_RESET
+}
@@ -0,0 +1,120 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var compareOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	names    []string
+}
+
+var compareCommand = &command{
+	name:    "compare",
+	summary: "Compare the ages, percentiles and milestones of several profiles side by side",
+	flags: func(fs *flag.FlagSet) {
+		compareOpts.store = addStoreFlags(fs)
+		compareOpts.clock = addClockFlags(fs, true)
+		compareOpts.profiles = addProfileFlags(fs, false)
+		fs.Var(listFlag{&compareOpts.names}, "profiles", "Comma separated profiles to compare, or 'all'")
+	},
+	run: runCompare,
+}
+
+// standing summarises where one profile stands
+type standing struct {
+	profile outlived.Profile
+	age     int
+	ranking outlived.Ranking
+	last    *outlived.Milestone // the person most recently outlived
+	next    *outlived.Milestone // the next person to be outlived
+}
+
+func runCompare(fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 || len(compareOpts.names) == 0 {
+		fs.Usage()
+		return errors.New("compare: profiles must be given with -profiles")
+	}
+	profiles, err := selectProfiles(compareOpts.profiles, compareOpts.store, compareOpts.names)
+	if err != nil {
+		return err
+	}
+	store, err := compareOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, compareOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	now, err := compareOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	opts := outlived.QueryOptions{Datasets: datasets, Now: now}
+	standings := make([]standing, 0, len(profiles))
+	for _, p := range profiles {
+		st, err := profileStanding(store, p, opts)
+		if err != nil {
+			return fmt.Errorf("compare: %s: %v", p.Name, err)
+		}
+		standings = append(standings, st)
+	}
+	return writeComparison(os.Stdout, standings)
+}
+
+func profileStanding(store outlived.Store, p outlived.Profile, opts outlived.QueryOptions) (standing, error) {
+	st := standing{profile: p}
+	var err error
+	if st.age, err = outlived.AgeInDays(p.BirthDate, opts.Now.Format(outlived.DATE_FMT)); err != nil {
+		return st, err
+	}
+	if st.ranking, err = outlived.Rank(store, st.age, opts); err != nil {
+		return st, err
+	}
+	recent, err := outlived.Recent(store, p.BirthDate, 1, opts)
+	if err != nil {
+		return st, err
+	}
+	if len(recent) > 0 {
+		st.last = &recent[0]
+	}
+	next, err := outlived.Next(store, p.BirthDate, 1, opts)
+	if err != nil {
+		return st, err
+	}
+	if len(next) > 0 {
+		st.next = &next[0]
+	}
+	return st, nil
+}
+
+func writeComparison(w io.Writer, standings []standing) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROFILE\tBORN\tAGE\tOUTLIVED\tLAST OUTLIVED\tNEXT TO OUTLIVE")
+	for _, st := range standings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.0f%%\t%s\t%s\n", st.profile.Name, st.profile.BirthDate, formatAge(st.age),
+			st.ranking.Percentile(), milestoneCell(st.last), milestoneCell(st.next))
+	}
+	return tw.Flush()
+}
+
+func milestoneCell(m *outlived.Milestone) string {
+	if m == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%s (%s)", m.Name, m.Date.Format(outlived.DATE_FMT))
+}
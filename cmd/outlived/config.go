@@ -0,0 +1,140 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// ENV_CONFIG names the config file to read in place of the default
+const ENV_CONFIG = "OUTLIVED_CONFIG"
+
+// config holds defaults read from the config file, which are overridden by the environment
+// and by command line flags:
+//
+//	dob: 1990-09-25
+//	dataset: musicians
+//	days: 365
+//	output: text
+//	timezone: Europe/London
+//	backend: redis
+//	redis:
+//	  addr: 127.0.0.1:6379
+//	  password: secret
+//	notifiers:
+//	  - type: slack
+//	    url: https://hooks.slack.com/services/T000/B000/XXXX
+type config struct {
+	DOB     string `yaml:"dob"`
+	Dataset string `yaml:"dataset"`
+	Days    int    `yaml:"days"`
+	Output  string `yaml:"output"`
+	Backend string `yaml:"backend"`
+	DB      string `yaml:"db"` // path of the sqlite database
+
+	Timezone string `yaml:"timezone"` // the zone in which today's date is taken
+
+	ProfileStore string `yaml:"profile_store"` // 'file' or 'redis'
+	Redis        struct {
+		Addr      string   `yaml:"addr"`
+		DB        int      `yaml:"db"`
+		Password  string   `yaml:"password"`
+		TLS       bool     `yaml:"tls"`
+		Master    string   `yaml:"master"`
+		Sentinels []string `yaml:"sentinels"`
+
+		PoolSize     int           `yaml:"pool_size"`
+		Retries      int           `yaml:"retries"`
+		RetryBackoff time.Duration `yaml:"retry_backoff"`
+	} `yaml:"redis"`
+	Notifiers []outlived.NotifierConfig `yaml:"notifiers"`
+}
+
+// cfg is the configuration read from the config file, if there is one
+var cfg config
+
+// defaultConfigPath returns ~/.config/outlived/config.yaml, or its equivalent on this platform
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "outlived", "config.yaml")
+}
+
+// configPath returns the config file named by a -config flag among the arguments, by the
+// environment, or else the default. The flags have not been parsed yet, since the file
+// supplies their defaults.
+func configPath(args []string) (path string, explicit bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		switch {
+		case arg == name:
+		case name == "config" && i+1 < len(args):
+			return args[i+1], true
+		case strings.HasPrefix(name, "config="):
+			return strings.TrimPrefix(name, "config="), true
+		}
+	}
+	if v := os.Getenv(ENV_CONFIG); v != "" {
+		return v, true
+	}
+	return defaultConfigPath(), false
+}
+
+// loadConfig reads the config file. A missing file is only an error when it was named explicitly.
+func loadConfig(path string, explicit bool) (config, error) {
+	var c config
+	if path == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return c, nil
+	}
+	if err != nil {
+		return c, fmt.Errorf("config: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("config: %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// redisConfig returns the Redis settings from the config file, overridden by the environment
+func (c config) redisConfig() outlived.RedisConfig {
+	r := outlived.RedisConfig{
+		Addr:          c.Redis.Addr,
+		DB:            c.Redis.DB,
+		Password:      c.Redis.Password,
+		TLS:           c.Redis.TLS,
+		MasterName:    c.Redis.Master,
+		SentinelAddrs: c.Redis.Sentinels,
+		PoolSize:      c.Redis.PoolSize,
+		Retries:       c.Redis.Retries,
+		RetryBackoff:  c.Redis.RetryBackoff,
+	}
+	if r.Addr == "" {
+		r.Addr = outlived.DB_ADDR
+	}
+	return r.WithEnv()
+}
+
+// orDefault returns s, or def if s is empty
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var copyOpts struct {
+	store *storeFlags
+	from  string
+	to    string
+}
+
+var copyCommand = &command{
+	name:    "copy",
+	summary: "Copy datasets from one Redis instance to another, checking the copies against the originals",
+	flags: func(fs *flag.FlagSet) {
+		copyOpts.store = addStoreFlags(fs)
+		fs.StringVar(&copyOpts.from, "from", "", "URL of the Redis instance to copy from, e.g. 'redis://:PASSWORD@HOST:6379/0' (default the one given by -redis-addr)")
+		fs.StringVar(&copyOpts.to, "to", "", "URL of the Redis instance to copy to, or 'rediss://...' over TLS")
+	},
+	run: runCopy,
+}
+
+func runCopy(fs *flag.FlagSet, args []string) error {
+	if copyOpts.to == "" {
+		return fmt.Errorf("copy: no -to instance given")
+	}
+	base := copyOpts.store.redisConfig()
+	fromCfg := base
+	if copyOpts.from != "" {
+		var err error
+		if fromCfg, err = base.WithURL(copyOpts.from); err != nil {
+			return fmt.Errorf("copy: -from: %v", err)
+		}
+	}
+	toCfg, err := base.WithURL(copyOpts.to)
+	if err != nil {
+		return fmt.Errorf("copy: -to: %v", err)
+	}
+	if fromCfg.Addr == toCfg.Addr && fromCfg.DB == toCfg.DB {
+		return fmt.Errorf("copy: -from and -to are the same instance")
+	}
+
+	ctx := copyOpts.store.context()
+	from, err := outlived.NewRedisStore(fromCfg)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := outlived.NewRedisStore(toCfg)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+	datasets, err := outlived.ResolveDatasets(from, copyOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	for _, dataset := range datasets {
+		start := time.Now()
+		stats, err := outlived.CopyDataset(outlived.StoreWithContext(ctx, from), outlived.StoreWithContext(ctx, to), dataset)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("copy: stopped (%v) while copying dataset '%s'", ctx.Err(), dataset)
+			}
+			return fmt.Errorf("copy: %v", err)
+		}
+		fmt.Printf("Copied %d records in dataset '%s' from %s to %s in %s, checksum %s\n",
+			stats.People, dataset, fromCfg.Addr, toCfg.Addr, time.Since(start).Round(time.Millisecond), stats.Checksum)
+	}
+	return nil
+}
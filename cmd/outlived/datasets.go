@@ -0,0 +1,40 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var datasetsStore *storeFlags
+
+var datasetsCommand = &command{
+	name:    "datasets",
+	summary: "List the datasets which have been imported, with the number of records in each",
+	flags: func(fs *flag.FlagSet) {
+		datasetsStore = addStoreFlags(fs)
+	},
+	run: runDatasets,
+}
+
+func runDatasets(fs *flag.FlagSet, args []string) error {
+	store, err := datasetsStore.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := store.Datasets()
+	if err != nil {
+		return err
+	}
+	for _, ds := range datasets {
+		if ds.Living > 0 {
+			fmt.Printf("%-30s %6d records, %d living\n", ds.Name, ds.Count+ds.Living, ds.Living)
+		} else {
+			fmt.Printf("%-30s %6d records\n", ds.Name, ds.Count)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var diffOpts struct {
+	store  *storeFlags
+	upsert bool
+}
+
+var diffCommand = &command{
+	name:    "diff",
+	args:    "[FILE|URL]",
+	summary: "Show which people importing a file would add, remove or change in the dataset, without changing it",
+	flags: func(fs *flag.FlagSet) {
+		diffOpts.store = addStoreFlags(fs)
+		addSourceFlags(fs)
+		fs.BoolVar(&diffOpts.upsert, "upsert", false, "Compare as for an import with -upsert, which removes nobody")
+	},
+	run: runDiff,
+}
+
+func runDiff(fs *flag.FlagSet, args []string) error {
+	if (len(args) == 1) != (sourceOpts.source == SOURCE_FILE) {
+		fs.Usage()
+		return errors.New("diff: a single file or URL must be supplied, unless reading from another -source")
+	}
+	opts, err := sourceReadOptions()
+	if err != nil {
+		return err
+	}
+	store, err := diffOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := interruptible(diffOpts.store.context())
+	defer stop()
+	opts.Context = ctx
+	records, _, rejected, err := readRecords(ctx, args, opts)
+	if err != nil {
+		return diffAbandoned(ctx, err)
+	}
+	for _, r := range rejected {
+		fmt.Fprintf(os.Stderr, "line %d: %s\n", r.Line, r.Reason)
+	}
+	existing, err := outlived.AllPeople(outlived.StoreWithContext(ctx, store), diffOpts.store.dataset)
+	if err != nil {
+		return diffAbandoned(ctx, err)
+	}
+	if records, _, err = resolveDuplicates(existing, records); err != nil {
+		return fmt.Errorf("diff: %v", err)
+	}
+	writeDiff(os.Stdout, outlived.PlanImport(existing, records, !diffOpts.upsert))
+	if len(rejected) > 0 {
+		fmt.Printf("%d rows of '%s' were rejected and are not compared\n", len(rejected), sourceName(args))
+	}
+	return nil
+}
+
+// diffAbandoned explains an error caused by the context ending
+func diffAbandoned(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.Canceled:
+		return errors.New("diff: interrupted")
+	case context.DeadlineExceeded:
+		return fmt.Errorf("diff: timed out after %v", diffOpts.store.timeout)
+	}
+	return err
+}
+
+// writeDiff writes a line for each person the plan would add (+), remove (-) or change (~),
+// the last followed by the fields changed, then a summary of the counts
+func writeDiff(w io.Writer, plan outlived.ImportPlan) {
+	for _, rec := range plan.Insert {
+		fmt.Fprintf(w, "+ %s\n", rec)
+	}
+	for _, rec := range plan.Remove {
+		fmt.Fprintf(w, "- %s\n", rec)
+	}
+	for _, change := range plan.Update {
+		fmt.Fprintf(w, "~ %s\n", change.Old)
+		for _, field := range change.Fields() {
+			fmt.Fprintf(w, "    %s: %s -> %s\n", field, quoteField(change.Old.Field(field)), quoteField(change.New.Field(field)))
+		}
+	}
+	fmt.Fprintf(w, "%d to add, %d to remove, %d to change, %d unchanged\n",
+		len(plan.Insert), len(plan.Remove), len(plan.Update), plan.Unchanged)
+}
+
+// quoteField quotes a field's value for writeDiff, abbreviating long ones such as summaries
+func quoteField(value string) string {
+	if runes := []rune(value); len(runes) > 60 {
+		value = strings.TrimSpace(string(runes[:57])) + "..."
+	}
+	return fmt.Sprintf("%q", value)
+}
@@ -0,0 +1,169 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// ENRICH_BATCH_SIZE is the number of looked up people saved to the store at a time
+const ENRICH_BATCH_SIZE = 100
+
+var enrichOpts struct {
+	store      *storeFlags
+	checkpoint string
+	force      bool
+	lookup     outlived.EnrichOptions
+}
+
+var enrichCommand = &command{
+	name:    "enrich",
+	summary: "Look each person in a dataset up on Wikipedia, adding a summary, image and link to their record",
+	flags: func(fs *flag.FlagSet) {
+		enrichOpts.store = addStoreFlags(fs)
+		fs.StringVar(&enrichOpts.lookup.Endpoint, "wikipedia", outlived.WIKIPEDIA_SUMMARY_ENDPOINT, "Page summary API to look people up in, e.g. that of another language's Wikipedia")
+		fs.IntVar(&enrichOpts.lookup.Concurrency, "concurrency", 4, "Number of lookups to make at once")
+		fs.DurationVar(&enrichOpts.lookup.Timeout, "timeout", outlived.DEFAULT_FETCH_TIMEOUT, "Time allowed for each lookup")
+		fs.StringVar(&enrichOpts.checkpoint, "checkpoint", "", "File recording who has been looked up, so that an interrupted run carries on where it stopped (default under the user cache directory)")
+		fs.BoolVar(&enrichOpts.force, "force", false, "Look up everyone again, including people already enriched or recorded in the checkpoint")
+	},
+	run: runEnrich,
+}
+
+func runEnrich(fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		fs.Usage()
+		return errors.New("enrich: no arguments are accepted")
+	}
+	dataset := enrichOpts.store.dataset
+	checkpoint := enrichOpts.checkpoint
+	if checkpoint == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return fmt.Errorf("enrich: no cache directory for the checkpoint, give -checkpoint: %v", err)
+		}
+		checkpoint = filepath.Join(dir, "outlived", "enrich-"+dataset+".txt")
+	}
+	done := map[string]bool{}
+	if !enrichOpts.force {
+		var err error
+		if done, err = readEnrichCheckpoint(checkpoint); err != nil {
+			return err
+		}
+	}
+
+	store, err := enrichOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	all, err := outlived.AllPeople(store, dataset)
+	if err != nil {
+		return err
+	}
+	var todo []outlived.Person
+	for _, rec := range all {
+		if enrichOpts.force || (rec.URL == "" && !done[rec.ID()]) {
+			todo = append(todo, rec)
+		}
+	}
+	fmt.Printf("Looking up %d of %d people in dataset '%s'\n", len(todo), len(all), dataset)
+	if len(todo) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(checkpoint), 0755); err != nil {
+		return err
+	}
+	mode := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if enrichOpts.force {
+		mode |= os.O_TRUNC
+	}
+	cp, err := os.OpenFile(checkpoint, mode, 0644)
+	if err != nil {
+		return err
+	}
+	defer cp.Close()
+
+	start := time.Now()
+	var batch, lookedUp []outlived.Person
+	n, found, failed, saveErr := 0, 0, 0, error(nil)
+	// save the batch of enriched people, then record everyone looked up since the last save
+	save := func() {
+		if saveErr != nil {
+			return
+		}
+		if len(batch) > 0 {
+			if _, saveErr = store.Upsert(dataset, batch); saveErr != nil {
+				return
+			}
+		}
+		for _, rec := range lookedUp {
+			if _, saveErr = fmt.Fprintln(cp, rec.ID()); saveErr != nil {
+				return
+			}
+		}
+		batch, lookedUp = batch[:0], lookedUp[:0]
+	}
+	progress := isTerminal(os.Stderr)
+	outlived.Enrich(todo, enrichOpts.lookup, func(res outlived.EnrichResult) {
+		n++
+		switch {
+		case res.Err != nil:
+			// not recorded in the checkpoint, so that it is tried again next time
+			failed++
+			fmt.Fprintln(os.Stderr, res.Err)
+		case res.Found:
+			found++
+			batch = append(batch, res.Person)
+			lookedUp = append(lookedUp, res.Person)
+		default:
+			lookedUp = append(lookedUp, res.Person)
+		}
+		if len(lookedUp) >= ENRICH_BATCH_SIZE {
+			save()
+		}
+		if progress {
+			fmt.Fprintf(os.Stderr, "\r%8d of %d looked up, %d found\033[K", n, len(todo), found)
+		}
+	})
+	if progress {
+		fmt.Fprintln(os.Stderr)
+	}
+	save()
+	if saveErr != nil {
+		return saveErr
+	}
+	fmt.Printf("Enriched %d of %d people in %s, %d not found, %d failed\n",
+		found, len(todo), time.Since(start).Round(time.Millisecond), len(todo)-found-failed, failed)
+	return nil
+}
+
+// readEnrichCheckpoint returns the IDs of the people recorded as already looked up
+func readEnrichCheckpoint(filename string) (map[string]bool, error) {
+	done := map[string]bool{}
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			done[id] = true
+		}
+	}
+	return done, scanner.Err()
+}
@@ -0,0 +1,209 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SSE_HEARTBEAT is how often a comment is sent down an idle event stream, so that proxies and
+// browsers don't take it to have died
+const SSE_HEARTBEAT = 30 * time.Second
+
+// SSE_RETRY is how long clients are asked to wait before reconnecting, in milliseconds
+const SSE_RETRY = 10000
+
+// milestoneEvent is a milestone as sent down an event stream. Its ID is the date on which the
+// person is outlived followed by their dataset and ID, so that IDs sort in the order of the
+// milestones and are the same whichever server sends them.
+type milestoneEvent struct {
+	id   string
+	data []byte
+}
+
+// subscription is an open event stream, to which the scheduler publishes the milestones passed
+type subscription struct {
+	dob      string
+	datasets []string
+	events   chan []milestoneEvent
+}
+
+// eventHub holds the open event streams
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[*subscription]bool
+	closed chan struct{} // closed when the server shuts down, ending the streams
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: map[*subscription]bool{}, closed: make(chan struct{})}
+}
+
+// close ends every stream, open or yet to be opened
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-h.closed:
+	default:
+		close(h.closed)
+	}
+}
+
+func (h *eventHub) subscribe(dob string, datasets []string) *subscription {
+	sub := &subscription{dob: dob, datasets: datasets, events: make(chan []milestoneEvent, 4)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = true
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+// scheduleEvents publishes the milestones passed to the open event streams at each midnight in
+// the server's zone, when everyone's ages move on. It never returns.
+func (s *server) scheduleEvents() {
+	midnight := time.Date(0, 1, 1, 0, 0, 0, 0, s.loc)
+	for {
+		time.Sleep(time.Until(nextCheck(time.Now().In(s.loc), midnight)))
+		s.publishMilestones(time.Now().In(s.loc))
+	}
+}
+
+// publishMilestones sends each open stream the milestones passed today, evaluating them once
+// for all of the streams of the same date of birth and datasets
+func (s *server) publishMilestones(now time.Time) {
+	groups := map[string][]*subscription{}
+	s.events.mu.Lock()
+	streams := len(s.events.subs)
+	for sub := range s.events.subs {
+		key := sub.dob + " " + strings.Join(sub.datasets, ",")
+		groups[key] = append(groups[key], sub)
+	}
+	s.events.mu.Unlock()
+	ctx, span := outlived.Tracer().Start(context.Background(), "publish milestones",
+		trace.WithAttributes(attribute.Int("outlived.streams", streams)))
+	defer span.End()
+	for _, subs := range groups {
+		// every ID of a milestone passed today sorts after today's date alone
+		events, err := s.milestoneEvents(ctx, subs[0].dob, subs[0].datasets, now, now.Format(outlived.DATE_FMT))
+		if err != nil {
+			log.Printf("events: %v", err)
+			continue
+		}
+		if len(events) == 0 {
+			continue
+		}
+		for _, sub := range subs {
+			select {
+			case sub.events <- events:
+			default:
+				log.Printf("events: dropped milestones for a stream which isn't reading them")
+			}
+		}
+	}
+}
+
+// milestoneEvents returns the milestones passed as of now by someone born on dob whose event
+// IDs sort after the one given, in the order of their IDs. Those of the milestones of a day
+// are sorted by dataset and ID rather than as passed, so that a client which missed only some
+// of them, being sent those whose IDs sort after its last, is sent all of those it missed.
+func (s *server) milestoneEvents(ctx context.Context, dob string, datasets []string, now time.Time, after string) ([]milestoneEvent, error) {
+	s.mu.Lock()
+	ms, err := outlived.Recent(s.storeFor(ctx), dob, math.MaxInt32, outlived.QueryOptions{Datasets: datasets, Now: now})
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var events []milestoneEvent
+	for i := len(ms) - 1; i >= 0; i-- {
+		id := ms[i].Date.Format(outlived.DATE_FMT) + "/" + ms[i].Dataset + "/" + ms[i].ID()
+		if id <= after {
+			continue
+		}
+		data, err := json.Marshal(newJSONMilestone(&ms[i]))
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, milestoneEvent{id: id, data: data})
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].id < events[j].id })
+	return events, nil
+}
+
+// handleEvents answers '/events?dob=YYYY-MM-DD' with a stream of server-sent events, an
+// 'outlived' event being sent as each person is outlived. A client reconnecting with the
+// Last-Event-ID header is first sent the milestones it missed while it was away.
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, fmt.Errorf("events: streaming is not supported by the connection"))
+		return
+	}
+	s.mu.Lock()
+	p, err := s.parseQueryParams(r, 0)
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// subscribed before the missed milestones are found, so that none published meanwhile are lost
+	sub := s.events.subscribe(p.dob, p.opts.Datasets)
+	defer s.events.unsubscribe(sub)
+	last := r.Header.Get("Last-Event-ID")
+	var missed []milestoneEvent
+	if last != "" {
+		if missed, err = s.milestoneEvents(r.Context(), p.dob, p.opts.Datasets, p.opts.Now, last); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // stops nginx holding the events back
+	fmt.Fprintf(w, "retry: %d\n\n", SSE_RETRY)
+	send := func(events []milestoneEvent) {
+		for _, e := range events {
+			if e.id > last {
+				fmt.Fprintf(w, "id: %s\nevent: outlived\ndata: %s\n\n", e.id, e.data)
+				last = e.id
+			}
+		}
+	}
+	send(missed)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(SSE_HEARTBEAT)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.events.closed:
+			return
+		case events := <-sub.events:
+			send(events)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		flusher.Flush()
+	}
+}
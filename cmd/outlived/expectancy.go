@@ -0,0 +1,136 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var expectancyOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	dob      string
+	country  string
+	sex      string
+	table    string
+}
+
+var expectancyCommand = &command{
+	name:    "expectancy",
+	summary: "Compare someone born on -dob with the life expectancy of their country and sex, and with the people in a dataset",
+	flags: func(fs *flag.FlagSet) {
+		expectancyOpts.store = addStoreFlags(fs)
+		expectancyOpts.clock = addClockFlags(fs, true)
+		expectancyOpts.profiles = addProfileFlags(fs, true)
+		fs.StringVar(&expectancyOpts.dob, "dob", cfg.DOB, "Date of birth (e.g. 1990-09-25 or '25 Sep 1990')")
+		fs.StringVar(&expectancyOpts.country, "country", "", "Country whose life table to use, as a two-letter code such as GB, US or JP")
+		fs.StringVar(&expectancyOpts.sex, "sex", "", "Sex whose life table to use: 'm' or 'f'")
+		fs.StringVar(&expectancyOpts.table, "table", "", "CSV file of life tables to use in place of those built in, with the columns country, sex, age and remaining")
+	},
+	run: runExpectancy,
+}
+
+func runExpectancy(fs *flag.FlagSet, args []string) error {
+	if len(args) > 0 {
+		fs.Usage()
+		return errors.New("expectancy: no arguments are expected")
+	}
+	if expectancyOpts.country == "" || expectancyOpts.sex == "" {
+		return errors.New("expectancy: -country and -sex must be given")
+	}
+	sex, err := outlived.ParseSex(expectancyOpts.sex)
+	if err != nil {
+		return fmt.Errorf("expectancy: %v", err)
+	}
+	tables := outlived.BuiltinLifeTables()
+	if expectancyOpts.table != "" {
+		f, err := os.Open(expectancyOpts.table)
+		if err != nil {
+			return err
+		}
+		tables, err = outlived.ReadLifeTables(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("expectancy: %s: %v", expectancyOpts.table, err)
+		}
+	}
+	table, err := outlived.FindLifeTable(tables, expectancyOpts.country, sex)
+	if err != nil {
+		return fmt.Errorf("expectancy: %v", err)
+	}
+	dob, err := resolveDOB(expectancyOpts.dob, expectancyOpts.profiles, expectancyOpts.store)
+	if err != nil {
+		return err
+	}
+	if dob == "" {
+		return errors.New("expectancy: a date of birth must be given with -dob or -profile")
+	}
+	now, err := expectancyOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	if dob, err = outlived.ParseUserDate(dob, now); err != nil {
+		return fmt.Errorf("expectancy: %v", err)
+	}
+	birth, _ := time.Parse(outlived.DATE_FMT, dob)
+	if birth.After(now) {
+		return fmt.Errorf("expectancy: %s is in the future", dob)
+	}
+	e := table.ExpectancyOf(birth, now)
+
+	store, err := expectancyOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	datasets, err := outlived.ResolveDatasets(store, expectancyOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	stats, err := outlived.DatasetStats(store, datasets, outlived.Filter{})
+	if err != nil {
+		return err
+	}
+	ranking, err := outlived.Rank(store, e.Days(), outlived.QueryOptions{Datasets: datasets, Now: now})
+	if err != nil {
+		return err
+	}
+	writeExpectancy(os.Stdout, table, e, dob, stats, ranking, datasets)
+	return nil
+}
+
+// writeExpectancy writes what the life table expects of someone born on dob, and how that
+// compares with the ages at death in the datasets
+func writeExpectancy(w io.Writer, table outlived.LifeTable, e outlived.Expectancy, dob string, stats outlived.Stats, ranking outlived.Ranking, datasets []string) {
+	who := "men"
+	if table.Sex == outlived.SEX_FEMALE {
+		who = "women"
+	}
+	fmt.Fprintf(w, "Born %s, by the life table for %s in %s:\n\n", dob, who, table.Country)
+	fmt.Fprintf(w, "Age now:            %.1f years\n", e.Age)
+	fmt.Fprintf(w, "Expected remaining: %.1f years\n", e.Remaining)
+	fmt.Fprintf(w, "Expected lifespan:  %.1f years, reached on %s\n", e.Expected, e.Death.Format(outlived.DATE_FMT))
+	of := strings.Join(datasets, " and ")
+	if stats.Count == 0 {
+		fmt.Fprintf(w, "\nThere is no one in %s to compare with\n", of)
+		return
+	}
+	median := stats.Median / 365.25
+	fmt.Fprintf(w, "\nThe median age at death in %s is %.1f years, ", of, median)
+	if e.Expected >= median {
+		fmt.Fprintf(w, "%.1f years less than you can expect\n", e.Expected-median)
+	} else {
+		fmt.Fprintf(w, "%.1f years more than you can expect\n", median-e.Expected)
+	}
+	fmt.Fprintf(w, "Living as long as expected, you would outlive %.0f%% of them (%d of %d)\n",
+		ranking.Percentile(), ranking.Outlived, ranking.Total)
+}
@@ -0,0 +1,75 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// export formats accepted by the -format flag
+const (
+	EXPORT_CSV  = "csv"
+	EXPORT_JSON = "json"
+)
+
+var exportOpts struct {
+	store  *storeFlags
+	format string
+	out    string
+}
+
+var exportCommand = &command{
+	name:    "export",
+	summary: "Write the records of a dataset out as CSV or JSON",
+	flags: func(fs *flag.FlagSet) {
+		exportOpts.store = addStoreFlags(fs)
+		fs.StringVar(&exportOpts.format, "format", EXPORT_CSV, "Export format: 'csv' or 'json'")
+		fs.StringVar(&exportOpts.out, "out", "", "File to write to (default stdout)")
+	},
+	run: runExport,
+}
+
+func runExport(fs *flag.FlagSet, args []string) error {
+	var write func(io.Writer, []outlived.Person) error
+	switch exportOpts.format {
+	case EXPORT_CSV:
+		write = outlived.WriteCSV
+	case EXPORT_JSON:
+		write = outlived.WriteJSON
+	default:
+		return fmt.Errorf("unknown export format '%s'", exportOpts.format)
+	}
+
+	store, err := exportOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	records, err := outlived.AllPeople(store, exportOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+
+	if exportOpts.out == "" {
+		return write(os.Stdout, records)
+	}
+	f, err := os.Create(exportOpts.out)
+	if err != nil {
+		return err
+	}
+	if err := write(f, records); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d records from dataset '%s' to '%s'\n", len(records), exportOpts.store.dataset, exportOpts.out)
+	return nil
+}
@@ -0,0 +1,213 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// DEFAULT_POST_TEMPLATE is the template of each fediverse post unless -template is given
+const DEFAULT_POST_TEMPLATE = "On this day in {{.Year}}, {{.Name}} died aged {{.AgeYears}}.{{if .URL}} {{.URL}}{{end}}"
+
+// postData is the data available to the -template of each fediverse post
+type postData struct {
+	outlived.Person
+	Dataset  string
+	Year     postYear // in which they died
+	YearsAgo int
+	AgeDays  int
+	AgeYears int
+	Age      string
+}
+
+// postYear is a year numbered astronomically, rendered as it is commonly written, e.g. '44 BCE'
+type postYear int
+
+func (y postYear) String() string {
+	return outlived.FormatYear(int(y))
+}
+
+// fediversePost is a post to be made, with the key by which it is made only once
+type fediversePost struct {
+	key  string
+	text string
+}
+
+// fediverseBot posts who died on this day each day, as an account on the fediverse
+type fediverseBot struct {
+	store   outlived.Store
+	client  outlived.FediverseClient
+	account string
+	tmpl    *template.Template
+}
+
+func runFediverseBot() error {
+	if botOpts.account == "" && !botOpts.dryRun {
+		return errors.New("bot: the account to post as must be given with -account")
+	}
+	if botOpts.count < 1 {
+		return errors.New("bot: -count must be at least 1")
+	}
+	if !slices.Contains(outlived.FEDIVERSE_VISIBILITIES, botOpts.visibility) {
+		return fmt.Errorf("bot: unknown visibility '%s': expected one of %s", botOpts.visibility, strings.Join(outlived.FEDIVERSE_VISIBILITIES, ", "))
+	}
+	tmpl, err := parseTemplate(botOpts.template)
+	if err != nil {
+		return fmt.Errorf("bot: invalid -template: %v", err)
+	}
+	at, err := time.Parse("15:04", botOpts.at)
+	if err != nil {
+		return fmt.Errorf("bot: invalid time '%s', expected HH:MM", botOpts.at)
+	}
+	loc, err := botOpts.clock.location()
+	if err != nil {
+		return err
+	}
+	store, err := botOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	b := &fediverseBot{store: store, account: botOpts.account, tmpl: tmpl}
+
+	if botOpts.dryRun {
+		now := time.Now().In(loc)
+		if botOpts.date != "" {
+			if now, err = time.ParseInLocation(outlived.DATE_FMT, botOpts.date, loc); err != nil {
+				return fmt.Errorf("bot: invalid -date '%s', expected YYYY-MM-DD", botOpts.date)
+			}
+		}
+		return b.preview(now)
+	}
+
+	name, server, err := outlived.ParseFediverseAccount(botOpts.account)
+	if err != nil {
+		return err
+	}
+	token, err := botToken(ENV_FEDIVERSE_TOKEN)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	b.client = outlived.FediverseClient{Server: server, Token: token, Client: &http.Client{Timeout: 30 * time.Second}}
+	// a token of another account would post there without complaint
+	who, err := b.client.Account(ctx)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(who, name) {
+		return fmt.Errorf("bot: the token is of @%s@%s, not %s", who, server, botOpts.account)
+	}
+	log.Printf("bot: posting who died on this day as %s at %s each day", botOpts.account, botOpts.at)
+
+	for {
+		sleepCtx(ctx, time.Until(nextCheck(time.Now().In(loc), at)))
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := b.postDay(ctx, time.Now().In(loc)); err != nil {
+			log.Printf("bot: %v", err)
+		}
+	}
+}
+
+// preview prints the posts of the day on which now falls, noting any too long to be posted
+func (b *fediverseBot) preview(now time.Time) error {
+	posts, err := b.posts(now)
+	if err != nil {
+		return err
+	}
+	if len(posts) == 0 {
+		fmt.Printf("No one in the datasets died on %s, so there is nothing to post\n", now.Format("2 January"))
+	}
+	for i, p := range posts {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(p.text)
+		if n := utf8.RuneCountInString(p.text); n > outlived.FEDIVERSE_MAX_CHARS {
+			fmt.Printf("(%d characters, over the %d which many servers allow)\n", n, outlived.FEDIVERSE_MAX_CHARS)
+		}
+	}
+	return nil
+}
+
+// postDay makes the posts of the day on which now falls, carrying on past those which fail.
+// Where bots share a store, each post is claimed by one of them, which makes it.
+func (b *fediverseBot) postDay(ctx context.Context, now time.Time) error {
+	posts, err := b.posts(now)
+	if err != nil {
+		return err
+	}
+	var failures []string
+	for _, p := range posts {
+		if c, ok := b.store.(outlived.Claimer); ok {
+			claimed, err := c.Claim("fediverse:"+strings.ToLower(b.account)+":"+p.key, 36*time.Hour)
+			if err != nil {
+				failures = append(failures, err.Error())
+				continue
+			}
+			if !claimed {
+				continue
+			}
+		}
+		if err := b.client.Post(ctx, p.text, botOpts.visibility, p.key); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// posts returns the posts of the day on which now falls: -count of those who died on that day
+// of the year, chosen afresh each day, earliest first
+func (b *fediverseBot) posts(now time.Time) ([]fediversePost, error) {
+	datasets, err := outlived.ResolveDatasets(b.store, botOpts.store.dataset)
+	if err != nil {
+		return nil, err
+	}
+	day := now.Format(outlived.DATE_FMT)
+	results, err := outlived.OnThisDay(b.store, outlived.DayOf(day), outlived.DAY_DIED, outlived.QueryOptions{Datasets: datasets})
+	if err != nil {
+		return nil, err
+	}
+	var posts []fediversePost
+	for _, res := range outlived.ChooseOfTheDay(results, botOpts.count, now) {
+		died, _, err := outlived.ParsePartialDate(res.DeathDate)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", res.Name, err)
+		}
+		var text strings.Builder
+		err = b.tmpl.Execute(&text, postData{
+			Person:   res.Person,
+			Dataset:  res.Dataset,
+			Year:     postYear(died.Year()),
+			YearsAgo: now.Year() - died.Year(),
+			AgeDays:  res.Days,
+			AgeYears: outlived.AgeInYears(res.Days),
+			Age:      outlived.Unpadded(res.FormatAge()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, fediversePost{key: day + ":" + res.Dataset + ":" + res.Name, text: strings.TrimSpace(text.String())})
+	}
+	return posts, nil
+}
@@ -0,0 +1,144 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var findOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	dob      string
+	match    string
+	filter   outlived.Filter
+}
+
+var findCommand = &command{
+	name:    "find",
+	args:    "NAME",
+	summary: "Find people by name, ignoring case and accents, and show how someone born on -dob compares",
+	flags: func(fs *flag.FlagSet) {
+		findOpts.store = addStoreFlags(fs)
+		findOpts.clock = addClockFlags(fs, true)
+		findOpts.profiles = addProfileFlags(fs, true)
+		fs.StringVar(&findOpts.dob, "dob", cfg.DOB, "Date of birth (YYYY-MM-DD) to compare with each person found")
+		fs.StringVar(&findOpts.match, "match", outlived.MATCH_FUZZY, "How closely names must match: 'exact' (containing NAME), 'fuzzy' (allowing for typos) or 'phonetic' (also names which sound alike)")
+		fs.StringVar(&findOpts.filter.Occupation, "occupation", "", "Only show people with this occupation, e.g. 'guitarist'")
+		fs.StringVar(&findOpts.filter.Nationality, "nationality", "", "Only show people of this nationality, e.g. 'GB'")
+		fs.StringVar(&findOpts.filter.Genre, "genre", "", "Only show people with this genre, e.g. 'rock'")
+		fs.StringVar(&findOpts.filter.Tag, "tag", "", "Only show people with this tag, e.g. 'grammy-winner'")
+	},
+	run: runFind,
+}
+
+func runFind(fs *flag.FlagSet, args []string) error {
+	name := strings.Join(args, " ")
+	if strings.TrimSpace(name) == "" {
+		fs.Usage()
+		return errors.New("find: a name must be given")
+	}
+	switch findOpts.match {
+	case outlived.MATCH_EXACT, outlived.MATCH_FUZZY, outlived.MATCH_PHONETIC:
+	default:
+		return fmt.Errorf("find: unknown match '%s'", findOpts.match)
+	}
+	dob, err := resolveDOB(findOpts.dob, findOpts.profiles, findOpts.store)
+	if err != nil {
+		return err
+	}
+	if dob != "" {
+		if err := outlived.ValidateDate(dob); err != nil {
+			return err
+		}
+	}
+	now, err := findOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	store, err := findOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, findOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	matches, err := outlived.Find(store, name, findOpts.match, outlived.QueryOptions{Datasets: datasets, Filter: findOpts.filter, Now: now})
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return noMatch{fmt.Sprintf("find: no one found named '%s'", name)}
+	}
+	results := make([]outlived.Result, len(matches))
+	for i, m := range matches {
+		results[i] = m.Result
+	}
+	return writeFound(os.Stdout, results, dob, now, len(datasets) > 1)
+}
+
+// writeFound lists each person found with their dates and age, followed by how someone born
+// on dob compares, if given
+func writeFound(w io.Writer, results []outlived.Result, dob string, now time.Time, labelled bool) error {
+	for _, res := range results {
+		name := res.Name
+		if labelled {
+			name = fmt.Sprintf("%-30s %-15s", res.Name, "["+res.Dataset+"]")
+		}
+		if res.Living() {
+			fmt.Fprintf(w, "%-30s %-24s (living, aged %s)%s\n", name, res.BirthDate, res.FormatAge(), details(res.Person))
+		} else {
+			fmt.Fprintf(w, "%-30s %-24s (died aged %s)%s\n", name, res.BirthDate+" to "+res.DeathDate, res.FormatAge(), details(res.Person))
+		}
+		if dob == "" {
+			continue
+		}
+		s, err := comparison(res, dob, now)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "    %s\n", s)
+	}
+	return nil
+}
+
+// comparison describes how someone born on dob compares with a person: when they outlive(d)
+// them, or for a living person how much older or younger they are
+func comparison(res outlived.Result, dob string, now time.Time) (string, error) {
+	userAge, err := outlived.AgeInDays(dob, now.Format(outlived.DATE_FMT))
+	if err != nil {
+		return "", err
+	}
+	if res.Living() {
+		switch diff := res.Days - userAge; {
+		case diff > 0:
+			return fmt.Sprintf("They are %s older than you", formatAge(diff)), nil
+		case diff < 0:
+			return fmt.Sprintf("They are %s younger than you", formatAge(-diff)), nil
+		}
+		return "They are the same age as you", nil
+	}
+	birth, err := time.Parse(outlived.DATE_FMT, dob)
+	if err != nil {
+		return "", err
+	}
+	today, _ := time.Parse(outlived.DATE_FMT, now.Format(outlived.DATE_FMT))
+	date := outlived.OutlivedOn(birth, res.Days)
+	when := relativeDays(int(date.Sub(today).Hours() / 24))
+	if userAge > res.Days {
+		return fmt.Sprintf("You outlived them on %s, %s", date.Format(outlived.DATE_FMT), when), nil
+	}
+	return fmt.Sprintf("You will outlive them on %s, %s", date.Format(outlived.DATE_FMT), when), nil
+}
@@ -0,0 +1,84 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var generateOpts struct {
+	rows   int
+	out    string
+	format string
+	seed   int64
+	clock  *clockFlags
+}
+
+var generateCommand = &command{
+	name:    "generate",
+	summary: "Write a file of synthetic people with realistic names and lifespans, for load testing and demos",
+	flags: func(fs *flag.FlagSet) {
+		fs.IntVar(&generateOpts.rows, "rows", 1000, "Number of people to generate")
+		fs.StringVar(&generateOpts.out, "out", "", "File to write to (default stdout)")
+		fs.StringVar(&generateOpts.format, "format", EXPORT_CSV, "Output format: 'csv' or 'json'")
+		fs.Int64Var(&generateOpts.seed, "seed", 0, "Seed for the random choices, so that the same people can be generated again as of the same -as-of date (default a random seed, which is reported)")
+		generateOpts.clock = addClockFlags(fs, true)
+	},
+	run: runGenerate,
+}
+
+func runGenerate(fs *flag.FlagSet, args []string) error {
+	if len(args) > 0 {
+		fs.Usage()
+		return errors.New("generate: no arguments are expected")
+	}
+	if generateOpts.rows <= 0 {
+		return errors.New("generate: -rows must be positive")
+	}
+	var write func(io.Writer, []outlived.Person) error
+	switch generateOpts.format {
+	case EXPORT_CSV:
+		write = outlived.WriteCSV
+	case EXPORT_JSON:
+		write = outlived.WriteJSON
+	default:
+		return fmt.Errorf("unknown output format '%s'", generateOpts.format)
+	}
+	now, err := generateOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	seed := generateOpts.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	people := outlived.SyntheticPeople(generateOpts.rows, rand.New(rand.NewSource(seed)), now)
+
+	if generateOpts.out == "" {
+		if err := write(os.Stdout, people); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Create(generateOpts.out)
+		if err != nil {
+			return err
+		}
+		if err := write(f, people); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Generated %d synthetic people with -seed %d -as-of %s\n", len(people), seed, now.Format(outlived.DATE_FMT))
+	return nil
+}
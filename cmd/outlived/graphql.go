@@ -0,0 +1,492 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/matthewhegarty/outlived"
+)
+
+// GRAPHQL_SCHEMA is the schema served at /graphql. Lists of people are paged as connections,
+// with opaque cursors, forwards from 'after'.
+const GRAPHQL_SCHEMA = `
+schema {
+	query: Query
+}
+
+type Query {
+	# the datasets which have been imported
+	datasets: [Dataset!]!
+	# everyone in the datasets who has died, ordered by age at death, or with a name the
+	# people whose names match it, the living included, closest first
+	people(dataset: String, name: String, first: Int = 20, after: String): PersonConnection!
+	stats(dataset: String, bin: Int = 5): Stats!
+	# where someone born on dob stands, and who died within days of their age
+	outlived(dob: String!, dataset: String, days: Int = 365, first: Int = 20, after: String): Outlived!
+}
+
+type Dataset {
+	name: String!
+	count: Int!
+	living: Int!
+	stats(bin: Int = 5): Stats!
+}
+
+type Person {
+	name: String!
+	birthDate: String!
+	deathDate: String
+	living: Boolean!
+	# the age at death, or for the living their age today
+	ageDays: Int!
+	age: String!
+	approximate: Boolean!
+	dataset: String!
+	occupation: String
+	nationality: String
+	causeOfDeath: String
+	genres: [String!]!
+	# added by enrich, if it found an article
+	enrichment: Enrichment
+}
+
+type Enrichment {
+	url: String
+	summary: String
+	imageUrl: String
+}
+
+type PersonConnection {
+	totalCount: Int!
+	edges: [PersonEdge!]!
+	pageInfo: PageInfo!
+}
+
+type PersonEdge {
+	cursor: String!
+	node: Person!
+}
+
+type PageInfo {
+	hasNextPage: Boolean!
+	endCursor: String
+}
+
+type Stats {
+	count: Int!
+	minDays: Int!
+	maxDays: Int!
+	meanDays: Float!
+	medianDays: Float!
+	stdDevYears: Float!
+	buckets: [Bucket!]!
+}
+
+type Bucket {
+	# in years, from inclusive and to exclusive
+	from: Int!
+	to: Int!
+	count: Int!
+}
+
+type Outlived {
+	birthDate: String!
+	ageDays: Int!
+	age: String!
+	ranking: Ranking!
+	people: PersonConnection!
+	next(count: Int = 5): [Milestone!]!
+	recent(count: Int = 5): [Milestone!]!
+}
+
+type Ranking {
+	outlived: Int!
+	total: Int!
+	percentile: Float!
+	rank: Int!
+}
+
+type Milestone {
+	# the first day on which the person is outlived
+	date: String!
+	person: Person!
+}
+`
+
+// GRAPHQL_MAX_PAGE is the most people returned by one page of a connection
+const GRAPHQL_MAX_PAGE = 1000
+
+// newGraphQLSchema parses the schema, resolving it from the server's store. Fields are
+// resolved one at a time, as the store is not safe for concurrent use.
+func (s *server) newGraphQLSchema() *graphql.Schema {
+	return graphql.MustParseSchema(GRAPHQL_SCHEMA, &gqlQuery{s}, graphql.MaxParallelism(1))
+}
+
+// graphqlRequest is a query POSTed to /graphql
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// handleGraphQL answers GraphQL queries, POSTed as JSON or given as the 'query' parameter
+func (s *server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, badRequest("invalid request: %v", err))
+			return
+		}
+	} else {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeError(w, badRequest("variables: %v", err))
+				return
+			}
+		}
+	}
+	s.mu.Lock()
+	resp := s.schema.Exec(r.Context(), req.Query, req.OperationName, req.Variables)
+	s.mu.Unlock()
+	writeJSONResponse(w, resp)
+}
+
+// gqlQuery resolves the Query type. Its resolvers run with s.mu held by handleGraphQL.
+type gqlQuery struct {
+	s *server
+}
+
+// datasets resolves the datasets named by an optional argument, or those served by default
+func (q *gqlQuery) datasets(ctx context.Context, dataset *string) ([]string, error) {
+	spec := q.s.dataset
+	if dataset != nil {
+		spec = *dataset
+	}
+	return outlived.ResolveDatasets(q.s.storeFor(ctx), spec)
+}
+
+func (q *gqlQuery) now() time.Time {
+	return time.Now().In(q.s.loc)
+}
+
+func (q *gqlQuery) Datasets(ctx context.Context) ([]*gqlDataset, error) {
+	infos, err := q.s.storeFor(ctx).Datasets()
+	if err != nil {
+		return nil, err
+	}
+	datasets := make([]*gqlDataset, len(infos))
+	for i, info := range infos {
+		datasets[i] = &gqlDataset{q, info}
+	}
+	return datasets, nil
+}
+
+// page returns the offset and limit of the page asked for by the 'first' and 'after' arguments
+func page(first int32, after *string) (int, int, error) {
+	if first < 0 || first > GRAPHQL_MAX_PAGE {
+		return 0, 0, fmt.Errorf("first must be between 0 and %d", GRAPHQL_MAX_PAGE)
+	}
+	offset := 0
+	if after != nil {
+		b, err := base64.StdEncoding.DecodeString(*after)
+		if err == nil {
+			offset, err = strconv.Atoi(string(b))
+		}
+		// cursors are offsets into the results, which never reach MaxInt32, so offset++ is safe
+		if err != nil || offset < 0 || offset >= math.MaxInt32 {
+			return 0, 0, errors.New("after is not a cursor returned by this API")
+		}
+		offset++
+	}
+	return offset, int(first), nil
+}
+
+func (q *gqlQuery) People(ctx context.Context, args struct {
+	Dataset *string
+	Name    *string
+	First   int32
+	After   *string
+}) (*gqlConnection, error) {
+	offset, limit, err := page(args.First, args.After)
+	if err != nil {
+		return nil, err
+	}
+	datasets, err := q.datasets(ctx, args.Dataset)
+	if err != nil {
+		return nil, err
+	}
+	var results []outlived.Result
+	if args.Name != nil {
+		matches, err := outlived.Find(q.s.storeFor(ctx), *args.Name, outlived.MATCH_FUZZY, outlived.QueryOptions{Datasets: datasets, Now: q.now()})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			results = append(results, m.Result)
+		}
+	} else {
+		for _, dataset := range datasets {
+			found, err := outlived.AllRecords(q.s.storeFor(ctx), dataset)
+			if err != nil {
+				return nil, err
+			}
+			for i := range found {
+				found[i].Dataset = dataset
+			}
+			results = append(results, found...)
+		}
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Days < results[j].Days })
+	}
+	offset = minInt(offset, len(results))
+	end := offset + minInt(limit, len(results)-offset)
+	return newConnection(results[offset:end], offset, len(results)), nil
+}
+
+func (q *gqlQuery) Stats(ctx context.Context, args struct {
+	Dataset *string
+	Bin     int32
+}) (*gqlStats, error) {
+	datasets, err := q.datasets(ctx, args.Dataset)
+	if err != nil {
+		return nil, err
+	}
+	return newStats(q.s.storeFor(ctx), datasets, args.Bin)
+}
+
+func (q *gqlQuery) Outlived(ctx context.Context, args struct {
+	Dob     string
+	Dataset *string
+	Days    int32
+	First   int32
+	After   *string
+}) (*gqlOutlived, error) {
+	offset, limit, err := page(args.First, args.After)
+	if err != nil {
+		return nil, err
+	}
+	if args.Days < 0 {
+		return nil, errors.New("days must not be negative")
+	}
+	datasets, err := q.datasets(ctx, args.Dataset)
+	if err != nil {
+		return nil, err
+	}
+	opts := outlived.QueryOptions{Datasets: datasets, Days: int(args.Days), Now: q.now(), Offset: offset, Limit: limit}
+	if limit == 0 {
+		opts.Limit = 1 // Query returns all the rest for a limit of zero, rather than none
+	}
+	userAge, results, total, err := outlived.Query(q.s.storeFor(ctx), args.Dob, opts)
+	if err != nil {
+		return nil, err
+	}
+	results = results[:minInt(limit, len(results))]
+	ranking, err := outlived.Rank(q.s.storeFor(ctx), userAge, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &gqlOutlived{q: q, dob: args.Dob, userAge: userAge, ranking: ranking, opts: opts,
+		people: newConnection(results, offset, total)}, nil
+}
+
+type gqlDataset struct {
+	q    *gqlQuery
+	info outlived.DatasetInfo
+}
+
+func (d *gqlDataset) Name() string  { return d.info.Name }
+func (d *gqlDataset) Count() int32  { return int32(d.info.Count) }
+func (d *gqlDataset) Living() int32 { return int32(d.info.Living) }
+
+func (d *gqlDataset) Stats(ctx context.Context, args struct{ Bin int32 }) (*gqlStats, error) {
+	return newStats(d.q.s.storeFor(ctx), []string{d.info.Name}, args.Bin)
+}
+
+type gqlPerson struct {
+	res outlived.Result
+}
+
+func (p *gqlPerson) Name() string      { return p.res.Name }
+func (p *gqlPerson) BirthDate() string { return p.res.BirthDate }
+func (p *gqlPerson) DeathDate() *string {
+	return optional(p.res.DeathDate)
+}
+func (p *gqlPerson) Living() bool      { return p.res.Living() }
+func (p *gqlPerson) AgeDays() int32    { return int32(p.res.Days) }
+func (p *gqlPerson) Age() string       { return outlived.Unpadded(p.res.FormatAge()) }
+func (p *gqlPerson) Approximate() bool { return p.res.Approximate() }
+func (p *gqlPerson) Dataset() string   { return p.res.Dataset }
+func (p *gqlPerson) Occupation() *string {
+	return optional(p.res.Occupation)
+}
+func (p *gqlPerson) Nationality() *string {
+	return optional(p.res.Nationality)
+}
+func (p *gqlPerson) CauseOfDeath() *string {
+	return optional(p.res.CauseOfDeath)
+}
+func (p *gqlPerson) Genres() []string {
+	return append([]string{}, p.res.Genres()...)
+}
+
+func (p *gqlPerson) Enrichment() *gqlEnrichment {
+	if p.res.URL == "" && p.res.Summary == "" && p.res.ImageURL == "" {
+		return nil
+	}
+	return &gqlEnrichment{p.res.Person}
+}
+
+type gqlEnrichment struct {
+	rec outlived.Person
+}
+
+func (e *gqlEnrichment) URL() *string      { return optional(e.rec.URL) }
+func (e *gqlEnrichment) Summary() *string  { return optional(e.rec.Summary) }
+func (e *gqlEnrichment) ImageURL() *string { return optional(e.rec.ImageURL) }
+
+// optional returns nil for an empty string, to be null in the response
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+type gqlConnection struct {
+	edges    []*gqlEdge
+	total    int
+	pageInfo *gqlPageInfo
+}
+
+type gqlEdge struct {
+	cursor string
+	node   *gqlPerson
+}
+
+type gqlPageInfo struct {
+	hasNextPage bool
+	endCursor   *string
+}
+
+// newConnection returns the page of results, which starts offset results into total, with a
+// cursor for each giving its position
+func newConnection(results []outlived.Result, offset, total int) *gqlConnection {
+	c := &gqlConnection{edges: []*gqlEdge{}, total: total, pageInfo: &gqlPageInfo{}}
+	for i, res := range results {
+		cursor := base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(offset + i)))
+		c.edges = append(c.edges, &gqlEdge{cursor, &gqlPerson{res}})
+		c.pageInfo.endCursor = &cursor
+	}
+	c.pageInfo.hasNextPage = offset+len(results) < total
+	return c
+}
+
+func (c *gqlConnection) TotalCount() int32      { return int32(c.total) }
+func (c *gqlConnection) Edges() []*gqlEdge      { return c.edges }
+func (c *gqlConnection) PageInfo() *gqlPageInfo { return c.pageInfo }
+func (e *gqlEdge) Cursor() string               { return e.cursor }
+func (e *gqlEdge) Node() *gqlPerson             { return e.node }
+func (p *gqlPageInfo) HasNextPage() bool        { return p.hasNextPage }
+func (p *gqlPageInfo) EndCursor() *string       { return p.endCursor }
+
+type gqlStats struct {
+	stats   outlived.Stats
+	buckets []*gqlBucket
+}
+
+func newStats(store outlived.Store, datasets []string, bin int32) (*gqlStats, error) {
+	if bin <= 0 {
+		return nil, errors.New("bin must be a positive number of years")
+	}
+	stats, err := outlived.DatasetStats(store, datasets, outlived.Filter{})
+	if err != nil {
+		return nil, err
+	}
+	s := &gqlStats{stats: stats, buckets: []*gqlBucket{}}
+	for _, b := range stats.Buckets(int(bin)) {
+		s.buckets = append(s.buckets, &gqlBucket{b})
+	}
+	return s, nil
+}
+
+func (s *gqlStats) Count() int32          { return int32(s.stats.Count) }
+func (s *gqlStats) MinDays() int32        { return int32(s.stats.Min) }
+func (s *gqlStats) MaxDays() int32        { return int32(s.stats.Max) }
+func (s *gqlStats) MeanDays() float64     { return s.stats.Mean }
+func (s *gqlStats) MedianDays() float64   { return s.stats.Median }
+func (s *gqlStats) StdDevYears() float64  { return s.stats.StdDevYears() }
+func (s *gqlStats) Buckets() []*gqlBucket { return s.buckets }
+
+type gqlBucket struct {
+	b outlived.Bucket
+}
+
+func (b *gqlBucket) From() int32  { return int32(b.b.From) }
+func (b *gqlBucket) To() int32    { return int32(b.b.To) }
+func (b *gqlBucket) Count() int32 { return int32(b.b.Count) }
+
+type gqlOutlived struct {
+	q       *gqlQuery
+	dob     string
+	userAge int
+	ranking outlived.Ranking
+	opts    outlived.QueryOptions
+	people  *gqlConnection
+}
+
+func (o *gqlOutlived) BirthDate() string      { return o.dob }
+func (o *gqlOutlived) AgeDays() int32         { return int32(o.userAge) }
+func (o *gqlOutlived) Age() string            { return formatAge(o.userAge) }
+func (o *gqlOutlived) Ranking() *gqlRanking   { return &gqlRanking{o.ranking} }
+func (o *gqlOutlived) People() *gqlConnection { return o.people }
+
+func (o *gqlOutlived) Next(ctx context.Context, args struct{ Count int32 }) ([]*gqlMilestone, error) {
+	return o.milestones(ctx, outlived.Next, args.Count)
+}
+
+func (o *gqlOutlived) Recent(ctx context.Context, args struct{ Count int32 }) ([]*gqlMilestone, error) {
+	return o.milestones(ctx, outlived.Recent, args.Count)
+}
+
+func (o *gqlOutlived) milestones(ctx context.Context, find func(outlived.Store, string, int, outlived.QueryOptions) ([]outlived.Milestone, error), count int32) ([]*gqlMilestone, error) {
+	if count < 0 || count > GRAPHQL_MAX_PAGE {
+		return nil, fmt.Errorf("count must be between 0 and %d", GRAPHQL_MAX_PAGE)
+	}
+	ms, err := find(o.q.s.storeFor(ctx), o.dob, int(count), outlived.QueryOptions{Datasets: o.opts.Datasets, Now: o.opts.Now})
+	if err != nil {
+		return nil, err
+	}
+	milestones := make([]*gqlMilestone, len(ms))
+	for i, m := range ms {
+		milestones[i] = &gqlMilestone{m}
+	}
+	return milestones, nil
+}
+
+type gqlRanking struct {
+	r outlived.Ranking
+}
+
+func (r *gqlRanking) Outlived() int32     { return int32(r.r.Outlived) }
+func (r *gqlRanking) Total() int32        { return int32(r.r.Total) }
+func (r *gqlRanking) Percentile() float64 { return r.r.Percentile() }
+func (r *gqlRanking) Rank() int32         { return int32(r.r.Rank()) }
+
+type gqlMilestone struct {
+	m outlived.Milestone
+}
+
+func (m *gqlMilestone) Date() string       { return m.m.Date.Format(outlived.DATE_FMT) }
+func (m *gqlMilestone) Person() *gqlPerson { return &gqlPerson{m.m.Result} }
@@ -0,0 +1,95 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// the states reported by /readyz, overall and of each check
+const (
+	HEALTH_OK          = "ok"
+	HEALTH_UNAVAILABLE = "unavailable"
+)
+
+type jsonReadiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"` // the state of each check, or why it failed
+}
+
+// handleHealthz answers '/healthz', so that an orchestrator can tell the process is alive
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, HEALTH_OK)
+}
+
+// handleReadyz answers '/readyz' with whether the server can answer queries: the store must be
+// reachable and hold the datasets served by default, and the server must not be shutting down.
+// It is answered with 503 Service Unavailable when it can't, so that traffic is sent elsewhere.
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	doc := jsonReadiness{Status: HEALTH_OK, Checks: map[string]string{}}
+	fail := func(check, reason string) {
+		doc.Status = HEALTH_UNAVAILABLE
+		doc.Checks[check] = reason
+	}
+	if s.draining.Load() {
+		fail("server", "shutting down")
+	} else {
+		doc.Checks["server"] = HEALTH_OK
+	}
+	s.mu.Lock()
+	infos, err := s.storeFor(r.Context()).Datasets()
+	s.mu.Unlock()
+	if err != nil {
+		fail("store", err.Error())
+		fail("datasets", "unknown, as the store is unreachable")
+	} else {
+		doc.Checks["store"] = HEALTH_OK
+		if reason := missingDatasets(infos, s.dataset); reason != "" {
+			fail("datasets", reason)
+		} else {
+			doc.Checks["datasets"] = HEALTH_OK
+		}
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if doc.Status != HEALTH_OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(body)
+}
+
+// missingDatasets says which of the datasets in the list, or 'all', have not been imported, or
+// returns "" if every one has
+func missingDatasets(infos []outlived.DatasetInfo, spec string) string {
+	if spec == outlived.DATASETS_ALL {
+		if len(infos) == 0 {
+			return "no datasets have been imported"
+		}
+		return ""
+	}
+	imported := map[string]bool{}
+	for _, info := range infos {
+		imported[info.Name] = true
+	}
+	var missing []string
+	for _, name := range outlived.SplitList(spec) {
+		if !imported[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	return "not imported: " + strings.Join(missing, ", ")
+}
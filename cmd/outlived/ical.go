@@ -0,0 +1,86 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var icalOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	dob      string
+	count    int
+	out      string
+}
+
+var icalCommand = &command{
+	name:    "ical",
+	summary: "Write an iCalendar file with an event on each date someone born on -dob will outlive someone",
+	flags: func(fs *flag.FlagSet) {
+		icalOpts.store = addStoreFlags(fs)
+		icalOpts.clock = addClockFlags(fs, true)
+		icalOpts.profiles = addProfileFlags(fs, true)
+		fs.StringVar(&icalOpts.dob, "dob", cfg.DOB, "Date of birth (YYYY-MM-DD)")
+		fs.IntVar(&icalOpts.count, "count", 0, "Only include the next N milestones (default all)")
+		fs.StringVar(&icalOpts.out, "out", "", "File to write, instead of stdout")
+	},
+	run: runICal,
+}
+
+func runICal(fs *flag.FlagSet, args []string) error {
+	dob, err := resolveDOB(icalOpts.dob, icalOpts.profiles, icalOpts.store)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 || dob == "" {
+		fs.Usage()
+		return errors.New("ical: a date of birth must be given with -dob or -profile")
+	}
+	store, err := icalOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, icalOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	count := icalOpts.count
+	if count <= 0 {
+		count = math.MaxInt32
+	}
+	now, err := icalOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	ms, err := outlived.Next(store, dob, count, outlived.QueryOptions{Datasets: datasets, Now: now})
+	if err != nil {
+		return err
+	}
+
+	if icalOpts.out == "" {
+		return outlived.WriteICal(os.Stdout, dob, ms, now)
+	}
+	f, err := os.Create(icalOpts.out)
+	if err != nil {
+		return err
+	}
+	if err := outlived.WriteICal(f, dob, ms, now); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d milestones to '%s'\n", len(ms), icalOpts.out)
+	return nil
+}
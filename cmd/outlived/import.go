@@ -0,0 +1,511 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var importOpts struct {
+	store      *storeFlags
+	upsert     bool
+	dryRun     bool
+	force      bool
+	rejects    string
+	maxRejects string
+	batch      outlived.BatchOptions
+}
+
+// sourceOpts holds the options selecting the source of records and how it is read, shared by
+// import and diff
+var sourceOpts struct {
+	progress    bool
+	timeout     time.Duration
+	noCache     bool
+	source      string
+	inputFormat string
+	fieldMap    string
+	sheet       string
+	delimiter   string
+	quotes      string
+	header      string
+	dateFormats []string
+	calendar    string
+	living      bool
+	onDuplicate string
+	limit       int
+	wikidata    outlived.WikidataOptions
+	musicbrainz outlived.MusicBrainzOptions
+}
+
+// import sources accepted by the -source flag
+const (
+	SOURCE_FILE        = "file"
+	SOURCE_WIKIDATA    = "wikidata"
+	SOURCE_MUSICBRAINZ = "musicbrainz"
+)
+
+var importCommand = &command{
+	name:    "import",
+	args:    "[FILE|URL]",
+	summary: "Import records from a CSV, JSON or Excel file or URL, or from Wikidata or MusicBrainz, replacing any existing data unless -upsert is given",
+	flags: func(fs *flag.FlagSet) {
+		importOpts.store = addStoreFlags(fs)
+		addSourceFlags(fs)
+		fs.BoolVar(&importOpts.upsert, "upsert", false, "Merge the records into the existing dataset, updating people already present (matched by name and date of birth)")
+		fs.BoolVar(&importOpts.upsert, "append", false, "Alias for -upsert")
+		fs.StringVar(&importOpts.rejects, "rejects", "", "Write rows which could not be imported to this CSV file, with their line numbers and reasons")
+		fs.StringVar(&importOpts.maxRejects, "max-rejects", "", "Abort the import if more rows than this are rejected, given as a count or a percentage such as '5%' (default no limit)")
+		fs.IntVar(&importOpts.batch.Size, "batch-size", outlived.DEFAULT_BATCH_SIZE, "Records written to Redis in each pipelined batch")
+		fs.IntVar(&importOpts.batch.Workers, "workers", outlived.DEFAULT_BATCH_WORKERS, "Batches written to Redis at once, each on its own connection")
+		fs.BoolVar(&importOpts.force, "force", false, "Import the records even if the latest import to the dataset was of the same records")
+		fs.BoolVar(&importOpts.dryRun, "dry-run", false, "Validate the file and report what would be inserted, updated or rejected, without changing the dataset")
+	},
+	run: runImport,
+}
+
+// addSourceFlags adds the flags selecting the source of records and how it is read
+func addSourceFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sourceOpts.source, "source", SOURCE_FILE, "Where to import from: 'file' (a file or URL), 'wikidata' or 'musicbrainz'")
+	fs.StringVar(&sourceOpts.inputFormat, "input-format", "", "Format of the file: 'csv', 'json' (an array of objects), 'jsonl' (an object per line) or 'xlsx' (default from the file extension)")
+	fs.StringVar(&sourceOpts.delimiter, "delimiter", ",", "Character separating the fields of a CSV file, e.g. ';', or 'tab'")
+	fs.StringVar(&sourceOpts.quotes, "quotes", outlived.QUOTES_STRICT, "How quoted CSV fields are read: 'strict', 'lazy' (allowing stray quotes) or 'none' (quotes are ordinary characters)")
+	fs.StringVar(&sourceOpts.header, "header", outlived.HEADER_AUTO, "Whether a CSV file starts with a header row: 'yes', 'no' or 'auto' (if no field of the first row is a date)")
+	fs.Var(stringsFlag{&sourceOpts.dateFormats}, "date-format", "Format in which dates are written, such as 'DD/MM/YYYY' or 'D MMMM YYYY', or 'auto' to recognise the common ones; may be repeated (default auto)")
+	fs.BoolVar(&sourceOpts.living, "living", false, "Import records with no date of death as living people, rather than rejecting them")
+	fs.StringVar(&sourceOpts.calendar, "calendar", outlived.CALENDAR_GREGORIAN, "Calendar the dates are written in: 'gregorian', 'julian', or 'auto' (Julian before 1582-10-15); dates marked 'O.S.' or 'N.S.' are read as such")
+	fs.StringVar(&sourceOpts.sheet, "sheet", "", "Name of the worksheet to import from an Excel workbook (default the first)")
+	fs.StringVar(&sourceOpts.fieldMap, "map", "", "Where each field is found: the keys of JSON objects, or the headers or numbers (from 1) of columns, e.g. 'name=full_name,birth=born.date' or 'name=2,birth=5,death=6' "+
+		"(fields: "+strings.Join(outlived.PERSON_FIELDS, ", ")+")")
+	fs.StringVar(&sourceOpts.onDuplicate, "on-duplicate", outlived.ON_DUPLICATE_OVERWRITE, "What to do with a person found more than once, or already in the dataset with other details: "+
+		"'overwrite' (keep the last record), 'skip' (keep the first, the dataset's if there), 'error' (import nothing) or 'merge' (fill the gaps in the first from the others)")
+	fs.IntVar(&sourceOpts.limit, "limit", 0, "Maximum number of people to import from wikidata or musicbrainz (default all)")
+	fs.StringVar(&sourceOpts.wikidata.Occupation, "occupation", "", "Occupation of the people to import from Wikidata, as a label such as 'musician' or an item ID")
+	fs.StringVar(&sourceOpts.musicbrainz.Query, "musicbrainz-query", outlived.MUSICBRAINZ_QUERY, "MusicBrainz artist search selecting the people to import")
+	fs.StringVar(&sourceOpts.musicbrainz.Checkpoint, "checkpoint", "", "File recording the progress of a musicbrainz import, so that if interrupted it can be resumed by running it again")
+	fs.BoolVar(&sourceOpts.progress, "progress", isTerminal(os.Stderr), "Show progress on stderr while reading the file")
+	fs.DurationVar(&sourceOpts.timeout, "timeout", outlived.DEFAULT_FETCH_TIMEOUT, "Time allowed for downloading a URL")
+	fs.BoolVar(&sourceOpts.noCache, "no-cache", false, "Always download a URL in full, rather than reusing an unchanged cached copy")
+}
+
+// import data from the given file into the store
+func runImport(fs *flag.FlagSet, args []string) (err error) {
+	if (len(args) == 1) != (sourceOpts.source == SOURCE_FILE) {
+		fs.Usage()
+		return errors.New("import: a single file or URL must be supplied, unless importing from another -source")
+	}
+	dataset := importOpts.store.dataset
+	maxRejects, err := parseThreshold(importOpts.maxRejects)
+	if err != nil {
+		return err
+	}
+	opts, err := sourceReadOptions()
+	if err != nil {
+		return err
+	}
+
+	store, err := importOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := interruptible(importOpts.store.context())
+	defer stop()
+	ctx, span := outlived.Tracer().Start(ctx, "import", trace.WithAttributes(
+		attribute.String("outlived.dataset", dataset),
+		attribute.String("outlived.source", sourceName(args))))
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	fmt.Printf("Importing records from '%s' into dataset '%s'\n", sourceName(args), dataset)
+	opts.Context = ctx
+	records, summary, rejected, err := readRecords(ctx, args, opts)
+	if err != nil {
+		return abandoned(ctx, err)
+	}
+	fmt.Printf("Parsed %d records from %s, skipped %d rows (%.0f rows/sec)\n",
+		summary.Rows, sourceOpts.source, summary.Skipped, summary.RowsPerSecond())
+	if len(rejected) > 0 {
+		fmt.Printf("Rejected %d rows\n", len(rejected))
+	}
+	if importOpts.rejects != "" {
+		if err := writeRejectsFile(importOpts.rejects, rejected); err != nil {
+			return err
+		}
+		fmt.Printf("Rejected rows written to '%s'\n", importOpts.rejects)
+	}
+	ps, recording := store.(outlived.ProvenanceStore)
+	var existing []outlived.Person
+	if importOpts.dryRun || (recording && !importOpts.upsert) || sourceOpts.onDuplicate != outlived.ON_DUPLICATE_OVERWRITE {
+		// read before the import replaces them, so that duplicates of them can be found and
+		// the import's changes counted
+		if existing, err = outlived.AllPeople(outlived.StoreWithContext(ctx, store), dataset); err != nil {
+			return abandoned(ctx, err)
+		}
+	}
+	records, duplicates, err := resolveDuplicates(existing, records)
+	if err != nil {
+		return fmt.Errorf("import: %v; nothing was imported", err)
+	}
+	if importOpts.dryRun {
+		printPlan(outlived.PlanImport(existing, records, !importOpts.upsert), rejected, duplicates)
+		return nil
+	}
+	if maxRejects.exceeded(len(rejected), len(rejected)+len(records)) {
+		for _, r := range rejected {
+			fmt.Fprintf(os.Stderr, "line %d: %s\n", r.Line, r.Reason)
+		}
+		return fmt.Errorf("import: %d rows rejected, exceeding the limit of %s; nothing was imported", len(rejected), importOpts.maxRejects)
+	}
+	var prov outlived.Provenance
+	if recording {
+		prov = outlived.NewProvenance(provenanceSource(args), records, summary)
+		prov.Upsert, prov.Rejected = importOpts.upsert, len(rejected)
+		if last, err := ps.Provenance(dataset); err == nil && !importOpts.force && unchangedSince(last, prov) {
+			fmt.Printf("Dataset '%s' already holds these records, imported from %s at %s; nothing was imported (use -force to import them again)\n",
+				dataset, last.Source, last.Imported.Local().Format(time.RFC1123))
+			return nil
+		} else if err != nil && !errors.Is(err, outlived.ErrNoProvenance) {
+			return abandoned(ctx, err)
+		}
+	}
+	storeCtx, storeSpan := outlived.Tracer().Start(ctx, "store records", trace.WithAttributes(
+		attribute.Int("outlived.records", len(records)),
+		attribute.Bool("outlived.upsert", importOpts.upsert)))
+	stats, err := storeRecords(outlived.StoreWithContext(storeCtx, store), dataset, records)
+	endSpan(storeSpan, err)
+	if err != nil {
+		return abandoned(ctx, err)
+	}
+	if recording {
+		if importOpts.upsert {
+			prov.Inserted, prov.Updated, prov.Unchanged = stats.Inserted, stats.Updated, stats.Unchanged
+		} else {
+			plan := outlived.PlanImport(existing, records, true)
+			prov.Inserted, prov.Updated, prov.Unchanged, prov.Removed = len(plan.Insert), len(plan.Update), plan.Unchanged, len(plan.Remove)
+		}
+		if err := ps.SaveProvenance(dataset, prov); err != nil {
+			return fmt.Errorf("import: the records were imported, but their provenance could not be recorded: %v", err)
+		}
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("Successfully completed import of %d records in %s (%.0f records/sec)\n",
+		len(records), elapsed.Round(time.Millisecond), float64(len(records))/elapsed.Seconds())
+	return nil
+}
+
+// sourceReadOptions returns the options for reading the source given by the source flags
+func sourceReadOptions() (outlived.ReadOptions, error) {
+	delimiter, err := parseDelimiter(sourceOpts.delimiter)
+	if err != nil {
+		return outlived.ReadOptions{}, err
+	}
+	switch sourceOpts.calendar {
+	case outlived.CALENDAR_GREGORIAN, outlived.CALENDAR_JULIAN, outlived.CALENDAR_AUTO:
+	default:
+		return outlived.ReadOptions{}, fmt.Errorf("unknown calendar '%s'", sourceOpts.calendar)
+	}
+	fields, err := outlived.ParseFieldMap(sourceOpts.fieldMap)
+	if err != nil {
+		return outlived.ReadOptions{}, err
+	}
+	if !slices.Contains(outlived.ON_DUPLICATE_POLICIES, sourceOpts.onDuplicate) {
+		return outlived.ReadOptions{}, fmt.Errorf("unknown duplicate policy '%s', expected one of %s", sourceOpts.onDuplicate, strings.Join(outlived.ON_DUPLICATE_POLICIES, ", "))
+	}
+	opts := outlived.ReadOptions{
+		Fetch:     outlived.FetchOptions{Timeout: sourceOpts.timeout},
+		Format:    sourceOpts.inputFormat,
+		Fields:    fields,
+		Sheet:     sourceOpts.sheet,
+		Delimiter: delimiter,
+		Quotes:    sourceOpts.quotes,
+		Header:    sourceOpts.header,
+		Calendar:  sourceOpts.calendar,
+		Living:    sourceOpts.living,
+	}
+	if opts.DateFormats = sourceOpts.dateFormats; len(opts.DateFormats) == 0 {
+		opts.DateFormats = []string{outlived.DATE_FORMAT_AUTO}
+	}
+	if !sourceOpts.noCache {
+		opts.Fetch.CacheDir = defaultCacheDir()
+	}
+	if sourceOpts.progress {
+		opts.Progress = printProgress
+	}
+	return opts, nil
+}
+
+// readRecords reads the records from the source, returning with them the rows rejected
+func readRecords(ctx context.Context, args []string, opts outlived.ReadOptions) ([]outlived.Person, outlived.Progress, []outlived.Rejection, error) {
+	var rejected []outlived.Rejection
+	opts.Reject = func(r outlived.Rejection) { rejected = append(rejected, r) }
+	_, readSpan := outlived.Tracer().Start(ctx, "read source")
+	records, summary, err := readSource(args, opts)
+	readSpan.SetAttributes(
+		attribute.Int("outlived.rows", summary.Rows),
+		attribute.Int("outlived.skipped", summary.Skipped),
+		attribute.Int("outlived.rejected", len(rejected)))
+	endSpan(readSpan, err)
+	if sourceOpts.progress {
+		fmt.Fprintln(os.Stderr)
+	}
+	return records, summary, rejected, err
+}
+
+// resolveDuplicates applies the -on-duplicate policy to the records, reporting the people
+// found more than once, and returns one record for each person. The dataset's existing records
+// are compared only by the policies which may keep them.
+func resolveDuplicates(existing, records []outlived.Person) ([]outlived.Person, []outlived.Duplicate, error) {
+	if sourceOpts.onDuplicate == outlived.ON_DUPLICATE_OVERWRITE {
+		existing = nil // the source's records replace them, as ever
+	}
+	resolved, duplicates, err := outlived.ResolveDuplicates(existing, records, sourceOpts.onDuplicate)
+	if err != nil {
+		for _, d := range duplicates {
+			printDuplicate(os.Stderr, d)
+		}
+	} else if len(duplicates) > 0 {
+		fmt.Printf("Found %d people more than once, resolved by the '%s' policy\n", len(duplicates), sourceOpts.onDuplicate)
+	}
+	return resolved, duplicates, err
+}
+
+// interruptible returns a context ended by SIGINT or SIGTERM, so that an import stopped with
+// Ctrl-C abandons its transaction rather than dying part way through. A second signal kills
+// the process as usual.
+func interruptible(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx, stop
+}
+
+// abandoned explains an error caused by the context ending, when nothing has been imported
+func abandoned(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.Canceled:
+		return errors.New("import: interrupted; nothing was imported")
+	case context.DeadlineExceeded:
+		return fmt.Errorf("import: timed out after %v; nothing was imported", importOpts.store.timeout)
+	}
+	return err
+}
+
+// storeRecords imports or, with -upsert, merges the records into the dataset, returning what
+// a merge changed
+func storeRecords(store outlived.Store, dataset string, records []outlived.Person) (outlived.UpsertStats, error) {
+	if bi, ok := store.(outlived.BatchImporter); ok && !importOpts.upsert {
+		return outlived.UpsertStats{}, bi.ImportBatched(dataset, records, importOpts.batch)
+	}
+	if !importOpts.upsert {
+		return outlived.UpsertStats{}, store.Import(dataset, records)
+	}
+	stats, err := store.Upsert(dataset, records)
+	if err != nil {
+		return stats, err
+	}
+	fmt.Printf("Merged records: %d inserted, %d updated, %d unchanged\n", stats.Inserted, stats.Updated, stats.Unchanged)
+	return stats, nil
+}
+
+// unchangedSince reports whether importing the records described by the provenance would
+// change nothing, as the latest import to the dataset was of the same records: an upsert of
+// records merged in already, or a full import of those which replaced the dataset, with no one
+// added or removed by hand since
+func unchangedSince(last, prov outlived.Provenance) bool {
+	return last.Checksum == prov.Checksum && (prov.Upsert || !last.Upsert) && last.Edited == nil
+}
+
+// provenanceSource describes the source being imported as its provenance records it, with the
+// full path of a local file
+func provenanceSource(args []string) string {
+	name := sourceName(args)
+	if sourceOpts.source == SOURCE_FILE && !outlived.IsURL(name) {
+		if abs, err := filepath.Abs(name); err == nil {
+			return abs
+		}
+	}
+	return name
+}
+
+// sourceName describes the source being imported
+func sourceName(args []string) string {
+	if sourceOpts.source == SOURCE_FILE {
+		return args[0]
+	}
+	return sourceOpts.source
+}
+
+// readSource reads the records from the source selected by -source
+func readSource(args []string, opts outlived.ReadOptions) ([]outlived.Person, outlived.Progress, error) {
+	switch sourceOpts.source {
+	case SOURCE_FILE:
+		return outlived.ReadFile(args[0], opts)
+	case SOURCE_WIKIDATA:
+		if sourceOpts.wikidata.Occupation == "" {
+			return nil, outlived.Progress{}, errors.New("import: an -occupation must be given when importing from wikidata")
+		}
+		sourceOpts.wikidata.Limit = sourceOpts.limit
+		return outlived.ReadWikidata(sourceOpts.wikidata, opts)
+	case SOURCE_MUSICBRAINZ:
+		sourceOpts.musicbrainz.Limit = sourceOpts.limit
+		return outlived.ReadMusicBrainz(sourceOpts.musicbrainz, opts)
+	}
+	return nil, outlived.Progress{}, fmt.Errorf("unknown import source '%s'", sourceOpts.source)
+}
+
+// defaultCacheDir returns the directory under the user's cache directory holding downloads,
+// or "" if there is none
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "outlived", "http")
+}
+
+// parseDelimiter parses the -delimiter flag, which is a single character or 'tab'
+func parseDelimiter(s string) (rune, error) {
+	switch s {
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 {
+		return 0, fmt.Errorf("invalid delimiter '%s': expected a single character or 'tab'", s)
+	}
+	return r[0], nil
+}
+
+func writeRejectsFile(filename string, rejected []outlived.Rejection) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := outlived.WriteRejects(f, rejected); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// threshold is a limit given either as a count or as a percentage of a total
+type threshold struct {
+	set     bool
+	count   int
+	percent float64
+	isRatio bool
+}
+
+func parseThreshold(s string) (threshold, error) {
+	if s == "" {
+		return threshold{}, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p < 0 {
+			return threshold{}, fmt.Errorf("invalid percentage '%s'", s)
+		}
+		return threshold{set: true, percent: p, isRatio: true}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return threshold{}, fmt.Errorf("invalid limit '%s'", s)
+	}
+	return threshold{set: true, count: n}, nil
+}
+
+// exceeded reports whether n out of total goes over the threshold
+func (t threshold) exceeded(n, total int) bool {
+	switch {
+	case !t.set:
+		return false
+	case t.isRatio:
+		return total > 0 && float64(n)*100/float64(total) > t.percent
+	}
+	return n > t.count
+}
+
+// printPlan prints the validation report for a dry run
+func printPlan(plan outlived.ImportPlan, rejected []outlived.Rejection, duplicates []outlived.Duplicate) {
+	fmt.Println("Dry run: the dataset has not been changed")
+	fmt.Printf("\nRejected %d rows:\n", len(rejected))
+	for _, r := range rejected {
+		fmt.Printf("    line %d: %s: %s\n", r.Line, r.Reason, strings.Join(r.Record, ","))
+	}
+	fmt.Printf("\n%d people found more than once, resolved by the '%s' policy:\n", len(duplicates), sourceOpts.onDuplicate)
+	for _, d := range duplicates {
+		printDuplicate(os.Stdout, d)
+	}
+	fmt.Printf("\nWould insert %d records:\n", len(plan.Insert))
+	for _, rec := range plan.Insert {
+		fmt.Printf("    %s\n", rec)
+	}
+	fmt.Printf("\nWould update %d records:\n", len(plan.Update))
+	for _, change := range plan.Update {
+		fmt.Printf("    %s -> %s\n", change.Old, change.New)
+	}
+	fmt.Printf("\nWould remove %d records:\n", len(plan.Remove))
+	for _, rec := range plan.Remove {
+		fmt.Printf("    %s\n", rec)
+	}
+	fmt.Printf("\n%d records unchanged\n", plan.Unchanged)
+}
+
+// printDuplicate lists the records of a person found more than once, marking the one kept
+func printDuplicate(w io.Writer, d outlived.Duplicate) {
+	fmt.Fprintf(w, "    %s, differing in %s\n", d.Records[0].Name, strings.Join(d.Fields(), ", "))
+	for i, rec := range d.Records {
+		from := "source"
+		if i == 0 && d.Dataset {
+			from = "dataset"
+		}
+		mark := " "
+		if rec == d.Kept {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "      %s %-7s %s\n", mark, from, rec)
+	}
+	if d.Kept != (outlived.Person{}) && !slices.Contains(d.Records, d.Kept) {
+		fmt.Fprintf(w, "      * merged  %s\n", d.Kept)
+	}
+}
+
+// printProgress redraws a single status line on stderr
+func printProgress(p outlived.Progress) {
+	line := fmt.Sprintf("%8d rows  %8.0f rows/sec", p.Rows+p.Skipped, p.RowsPerSecond())
+	if f := p.Fraction(); f >= 0 {
+		line = fmt.Sprintf("%s  %s %3.0f%%", line, progressBar(f, 30), f*100)
+		if eta := p.ETA(); eta >= 0 {
+			line = fmt.Sprintf("%s  ETA %s", line, eta.Round(time.Second))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\r%s\033[K", line)
+}
+
+func progressBar(fraction float64, width int) string {
+	filled := int(fraction * float64(width))
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
+
+// isTerminal reports whether the file is a character device, such as a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,101 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var infoOpts struct {
+	store *storeFlags
+}
+
+var infoCommand = &command{
+	name:    "info",
+	summary: "Show where each dataset was last imported from, when, and what the import changed",
+	flags: func(fs *flag.FlagSet) {
+		infoOpts.store = addStoreFlags(fs)
+	},
+	run: runInfo,
+}
+
+func runInfo(fs *flag.FlagSet, args []string) error {
+	store, err := infoOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ps, ok := store.(outlived.ProvenanceStore)
+	if !ok {
+		return fmt.Errorf("info: the %s backend records no provenance", infoOpts.store.backend)
+	}
+	datasets, err := outlived.ResolveDatasets(store, infoOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	infos, err := store.Datasets()
+	if err != nil {
+		return err
+	}
+	for i, dataset := range datasets {
+		if i > 0 {
+			fmt.Println()
+		}
+		var size *outlived.DatasetInfo
+		for j := range infos {
+			if infos[j].Name == dataset {
+				size = &infos[j]
+			}
+		}
+		if size == nil {
+			return fmt.Errorf("info: dataset '%s' has not been imported", dataset)
+		}
+		p, err := ps.Provenance(dataset)
+		if err != nil && !errors.Is(err, outlived.ErrNoProvenance) {
+			return err
+		}
+		if err := writeInfo(os.Stdout, *size, p, err == nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeInfo writes the dataset's size and, if recorded, the provenance of its latest import
+func writeInfo(w io.Writer, size outlived.DatasetInfo, p outlived.Provenance, recorded bool) error {
+	fmt.Fprintln(w, size.Name)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "    Records:\t%d, %d living\n", size.Count+size.Living, size.Living)
+	if !recorded {
+		fmt.Fprintf(tw, "    Provenance:\tnone recorded\n")
+		return tw.Flush()
+	}
+	how := "replacing the dataset"
+	if p.Upsert {
+		how = "merged into the dataset"
+	}
+	fmt.Fprintf(tw, "    Source:\t%s\n", p.Source)
+	fmt.Fprintf(tw, "    Imported:\t%s, %s\n", p.Imported.Local().Format(time.RFC1123), how)
+	fmt.Fprintf(tw, "    Read:\t%d records, %d rejected, %d rows skipped\n", p.Records, p.Rejected, p.Skipped)
+	fmt.Fprintf(tw, "    Changes:\t%d inserted, %d updated, %d unchanged", p.Inserted, p.Updated, p.Unchanged)
+	if !p.Upsert {
+		fmt.Fprintf(tw, ", %d removed", p.Removed)
+	}
+	fmt.Fprintln(tw)
+	if p.SourceHash != "" {
+		fmt.Fprintf(tw, "    Source hash:\t%s\n", p.SourceHash)
+	}
+	fmt.Fprintf(tw, "    Checksum:\t%s\n", p.Checksum)
+	if p.Edited != nil {
+		fmt.Fprintf(tw, "    Edited:\t%s, by add or remove\n", p.Edited.Local().Format(time.RFC1123))
+	}
+	return tw.Flush()
+}
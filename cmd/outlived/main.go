@@ -0,0 +1,200 @@
+// Copyright © 2016 Matthew R Hegarty
+
+// Imports data from a source text file into a Redis Sorted Set, and allows querying of the data.
+// The source data is a csv containing a list of deceased musicians in the format:
+//
+// FIELD 1: Name (unquoted)
+// FIELD 2: Date of Birth (YYYY-MM-DD)
+// FIELD 3: Date of Death (YYYY-MM-DD)
+// FIELD 4-7: Occupation, Nationality, Cause of Death, Genres separated by ';' (all optional)
+// FIELD 8-10: Article link, Summary, Image URL (all optional, and filled in by 'enrich')
+//
+// The data can be imported and then queried using this script.
+// A date can be passed in (for example, your own date of birth) in order to establish which
+// musicians you've outlived.
+// Use the '-days' flag to widen the search query.
+//
+// Usage:
+//
+//	./outlived COMMAND [OPTIONS] [ARGS]
+//
+// Examples:
+//
+//	Import:  ./outlived import musicians.csv
+//	 Query:  ./outlived query 1990-09-25 -days 365
+//	SQLite:  ./outlived import -backend sqlite -db outlived.db musicians.csv
+//	No DB:   ./outlived query -no-db 1990-09-25 musicians.csv
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// command is a subcommand of the tool, with its own flags and help text
+type command struct {
+	name    string
+	args    string // synopsis of the positional arguments
+	summary string
+	run     func(fs *flag.FlagSet, args []string) error
+	flags   func(fs *flag.FlagSet)
+}
+
+var commands []*command
+
+// Exit statuses, so that scripts can tell a search which found nothing from a failure
+const (
+	EXIT_NO_MATCH = 1 // the command ran, but nothing matched
+	EXIT_ERROR    = 2 // the command failed, or was used incorrectly
+)
+
+// noMatch is returned by a command which found nothing, to exit with EXIT_NO_MATCH. Its
+// message, if any, is logged.
+type noMatch struct {
+	msg string
+}
+
+func (e noMatch) Error() string {
+	return e.msg
+}
+
+func init() {
+	commands = []*command{
+		importCommand,
+		diffCommand,
+		addCommand,
+		removeCommand,
+		editCommand,
+		enrichCommand,
+		queryCommand,
+		datasetsCommand,
+		infoCommand,
+		migrateCommand,
+		exportCommand,
+		backupCommand,
+		restoreCommand,
+		copyCommand,
+		statsCommand,
+		nextCommand,
+		recentCommand,
+		watchCommand,
+		icalCommand,
+		serveCommand,
+		profileCommand,
+		compareCommand,
+		overlapCommand,
+		onThisDayCommand,
+		findCommand,
+		personCommand,
+		vsCommand,
+		tuiCommand,
+		cardCommand,
+		timelineCommand,
+		apikeyCommand,
+		benchCommand,
+		generateCommand,
+		expectancyCommand,
+		cohortCommand,
+		tagCommand,
+		tagsCommand,
+		randomCommand,
+		potdCommand,
+		botCommand,
+	}
+}
+
+var Usage = func() {
+	fmt.Fprintf(os.Stderr, "Usage of %s:\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "    %s COMMAND [OPTIONS] [ARGS]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "    %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s COMMAND -h' for help on a command.\n", os.Args[0])
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("outlived: ")
+
+	if len(os.Args) < 2 {
+		Usage()
+		os.Exit(0)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		Usage()
+		os.Exit(0)
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			err := runCommand(cmd, os.Args[2:])
+			if nm, ok := err.(noMatch); ok {
+				if nm.msg != "" {
+					log.Print(nm.msg)
+				}
+				os.Exit(EXIT_NO_MATCH)
+			}
+			if err != nil {
+				log.Print(err)
+				os.Exit(EXIT_ERROR)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n", name)
+	Usage()
+	os.Exit(EXIT_ERROR)
+}
+
+func runCommand(cmd *command, args []string) error {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s %s:\n\n", os.Args[0], cmd.name)
+		fmt.Fprintf(os.Stderr, "    %s %s [OPTIONS] %s\n\n%s\n\nOptions:\n", os.Args[0], cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	path, explicit := configPath(args)
+	var err error
+	if cfg, err = loadConfig(path, explicit); err != nil {
+		return err
+	}
+	fs.String("config", path, "Config file supplying defaults for the options (env "+ENV_CONFIG+")")
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	flushSpans, err := setupTracing()
+	if err != nil {
+		return err
+	}
+	defer flushSpans()
+	err = cmd.run(fs, positional)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%s: timed out, exceeding -store-timeout", cmd.name)
+	}
+	return err
+}
+
+// parseArgs parses flags which may appear either side of the positional arguments,
+// returning the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var migrateOpts struct {
+	store    *storeFlags
+	dryRun   bool
+	progress bool
+}
+
+var migrateCommand = &command{
+	name:    "migrate",
+	args:    "[DATASET...]",
+	summary: "Upgrade datasets imported by earlier versions to the current storage layout, by default every dataset",
+	flags: func(fs *flag.FlagSet) {
+		migrateOpts.store = addStoreFlags(fs)
+		fs.BoolVar(&migrateOpts.dryRun, "dry-run", false, "Report the layout each dataset is held in, without changing any")
+		fs.BoolVar(&migrateOpts.progress, "progress", isTerminal(os.Stderr), "Show progress on stderr while each dataset is rewritten")
+	},
+	run: runMigrate,
+}
+
+func runMigrate(fs *flag.FlagSet, args []string) error {
+	store, err := migrateOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	m, ok := store.(outlived.Migrator)
+	if !ok {
+		fmt.Printf("The %s backend upgrades its schema as it is opened; nothing to migrate\n", migrateOpts.store.backend)
+		return nil
+	}
+	datasets := args
+	if len(datasets) == 0 {
+		if datasets, err = outlived.ResolveDatasets(store, outlived.DATASETS_ALL); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := interruptible(migrateOpts.store.context())
+	defer stop()
+	m = outlived.StoreWithContext(ctx, store).(outlived.Migrator)
+	for _, dataset := range datasets {
+		if err := outlived.ValidateDatasetName(dataset); err != nil {
+			return err
+		}
+		if migrateOpts.dryRun {
+			version, err := m.SchemaVersion(dataset)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%-30s %s\n", dataset, describeSchema(version))
+			continue
+		}
+		if err := migrateDataset(ctx, m, dataset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateDataset upgrades the dataset, reporting what was done
+func migrateDataset(ctx context.Context, m outlived.Migrator, dataset string) error {
+	start := time.Now()
+	var progress func(written, total int)
+	if migrateOpts.progress {
+		progress = func(written, total int) {
+			f := float64(written) / float64(total)
+			fmt.Fprintf(os.Stderr, "\r%-20s %8d of %d people  %s %3.0f%%\033[K", dataset, written, total, progressBar(f, 30), f*100)
+		}
+	}
+	stats, err := m.Migrate(dataset, progress)
+	if migrateOpts.progress && stats.People > 0 {
+		fmt.Fprintln(os.Stderr)
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("migrate: interrupted; '%s' was left as it was", dataset)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("migrate: timed out after %v; '%s' was left as it was", migrateOpts.store.timeout, dataset)
+	case err != nil:
+		return fmt.Errorf("migrate: %v", err)
+	case stats.From == outlived.SCHEMA_VERSION:
+		fmt.Printf("Dataset '%s' is already held in layout version %d\n", dataset, outlived.SCHEMA_VERSION)
+	default:
+		fmt.Printf("Migrated dataset '%s' from layout version %d to %d: %d people rewritten, %d of them from 'name,dob,dod' members, in %s\n",
+			dataset, stats.From, outlived.SCHEMA_VERSION, stats.People, stats.Legacy, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// describeSchema says what a migration would do to a dataset held in the layout version
+func describeSchema(version int) string {
+	switch {
+	case version == 0:
+		return "holds nobody"
+	case version < outlived.SCHEMA_VERSION:
+		return fmt.Sprintf("layout version %d, to be migrated to %d", version, outlived.SCHEMA_VERSION)
+	case version > outlived.SCHEMA_VERSION:
+		return fmt.Sprintf("layout version %d, newer than this version of outlived knows", version)
+	}
+	return fmt.Sprintf("layout version %d, current", version)
+}
@@ -0,0 +1,107 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var nextOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	dob      string
+	count    int
+	precise  bool
+}
+
+var nextCommand = &command{
+	name:    "next",
+	summary: "List the next people someone born on -dob will outlive, and the date on which they do",
+	flags: func(fs *flag.FlagSet) {
+		nextOpts.store = addStoreFlags(fs)
+		nextOpts.clock = addClockFlags(fs, true)
+		nextOpts.profiles = addProfileFlags(fs, true)
+		fs.StringVar(&nextOpts.dob, "dob", cfg.DOB, "Date of birth (YYYY-MM-DD)")
+		fs.IntVar(&nextOpts.count, "count", 10, "Number of people to list")
+		fs.BoolVar(&nextOpts.precise, "precise", false, "Count ages on the calendar, in years, months and days, rather than in years of 365.25 days")
+	},
+	run: runNext,
+}
+
+func runNext(fs *flag.FlagSet, args []string) error {
+	dob, err := resolveDOB(nextOpts.dob, nextOpts.profiles, nextOpts.store)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 || dob == "" {
+		fs.Usage()
+		return errors.New("next: a date of birth must be given with -dob or -profile")
+	}
+	if nextOpts.count < 1 {
+		return errors.New("next: -count must be at least 1")
+	}
+	store, err := nextOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, nextOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	now, err := nextOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	ms, err := outlived.Next(store, dob, nextOpts.count, outlived.QueryOptions{Datasets: datasets, Now: now})
+	if err != nil {
+		return err
+	}
+	writeMilestones(os.Stdout, ms, now, len(datasets) > 1, nextOpts.precise)
+	return nil
+}
+
+// writeMilestones lists each milestone with its date and how far it is from now
+func writeMilestones(w io.Writer, ms []outlived.Milestone, now time.Time, labelled, precise bool) {
+	today, _ := time.Parse(outlived.DATE_FMT, now.Format(outlived.DATE_FMT))
+	width := 33
+	if precise {
+		width = 45
+	}
+	for _, m := range ms {
+		age := m.FormatAge()
+		if precise {
+			age = m.FormatPreciseAge()
+		}
+		name := m.Name
+		if labelled {
+			name = fmt.Sprintf("%-30s %-15s", m.Name, "["+m.Dataset+"]")
+		}
+		fmt.Fprintf(w, "%s  %-30s %-*s  %s\n", m.Date.Format(outlived.DATE_FMT), name, width,
+			"(died aged "+age+")", relativeDays(int(m.Date.Sub(today).Hours()/24)))
+	}
+}
+
+// relativeDays describes a number of days from today, e.g. "in 3 days" or "12 days ago"
+func relativeDays(days int) string {
+	switch {
+	case days == 0:
+		return "today"
+	case days == 1:
+		return "tomorrow"
+	case days == -1:
+		return "yesterday"
+	case days > 0:
+		return fmt.Sprintf("in %d days", days)
+	}
+	return fmt.Sprintf("%d days ago", -days)
+}
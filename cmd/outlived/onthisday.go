@@ -0,0 +1,91 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var onThisDayOpts struct {
+	store  *storeFlags
+	clock  *clockFlags
+	date   string
+	born   bool
+	filter outlived.Filter
+}
+
+var onThisDayCommand = &command{
+	name:    "onthisday",
+	summary: "List the people who died, or with -born were born, on this day of the year, or on -date",
+	flags: func(fs *flag.FlagSet) {
+		onThisDayOpts.store = addStoreFlags(fs)
+		onThisDayOpts.clock = addClockFlags(fs, false)
+		fs.StringVar(&onThisDayOpts.date, "date", "", "Day of the year (MM-DD, or a date YYYY-MM-DD), by default today")
+		fs.BoolVar(&onThisDayOpts.born, "born", false, "List the people born on the day, rather than those who died on it")
+		fs.StringVar(&onThisDayOpts.filter.Occupation, "occupation", "", "Only show people with this occupation, e.g. 'guitarist'")
+		fs.StringVar(&onThisDayOpts.filter.Nationality, "nationality", "", "Only show people of this nationality, e.g. 'GB'")
+		fs.StringVar(&onThisDayOpts.filter.Genre, "genre", "", "Only show people with this genre, e.g. 'rock'")
+		fs.StringVar(&onThisDayOpts.filter.Tag, "tag", "", "Only show people with this tag, e.g. 'grammy-winner'")
+	},
+	run: runOnThisDay,
+}
+
+func runOnThisDay(fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		fs.Usage()
+		return errors.New("onthisday: unexpected arguments")
+	}
+	date := onThisDayOpts.date
+	if date == "" {
+		now, err := onThisDayOpts.clock.now()
+		if err != nil {
+			return err
+		}
+		date = now.Format(outlived.DATE_FMT)
+	}
+	day, err := outlived.ParseDay(date)
+	if err != nil {
+		return err
+	}
+	which := outlived.DAY_DIED
+	if onThisDayOpts.born {
+		which = outlived.DAY_BORN
+	}
+	store, err := onThisDayOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, onThisDayOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	results, err := outlived.OnThisDay(store, day, which, outlived.QueryOptions{Datasets: datasets, Filter: onThisDayOpts.filter})
+	if err != nil {
+		return err
+	}
+	writeOnThisDay(os.Stdout, results, which, len(datasets) > 1)
+	return nil
+}
+
+// writeOnThisDay lists each person with the date on which they died, or were born
+func writeOnThisDay(w io.Writer, results []outlived.Result, which string, labelled bool) {
+	for _, res := range results {
+		date := res.DeathDate
+		if which == outlived.DAY_BORN {
+			date = res.BirthDate
+		}
+		name := res.Name
+		if labelled {
+			name = fmt.Sprintf("%-30s %-15s", res.Name, "["+res.Dataset+"]")
+		}
+		fmt.Fprintf(w, "%-11s %-30s (died aged %s)%s\n", date, name, res.FormatAge(), details(res.Person))
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// OPENAPI_VERSION is the version of the OpenAPI specification the spec follows
+const OPENAPI_VERSION = "3.0.3"
+
+// newOpenAPISpec documents the endpoints as an OpenAPI spec, encoded as JSON, requiring any of
+// the security schemes given for those which are not public, and if rateLimited saying that
+// they may refuse requests made too often. The schemas of JSON requests and responses are
+// derived from the types which are encoded.
+func newOpenAPISpec(endpoints []endpoint, securitySchemes map[string]interface{}, rateLimited bool) []byte {
+	var names []string
+	for name := range securitySchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var security []interface{}
+	for _, name := range names {
+		security = append(security, map[string][]string{name: {}})
+	}
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{}
+	for _, e := range endpoints {
+		methods := e.methods
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet}
+		}
+		ops := map[string]interface{}{}
+		for _, m := range methods {
+			op := map[string]interface{}{
+				"summary":     e.summary,
+				"operationId": operationID(m, e.path),
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "OK",
+						"content":     map[string]interface{}{e.content: map[string]interface{}{"schema": responseSchema(e, schemas)}},
+					},
+					"400": map[string]interface{}{
+						"description": "The parameters are invalid",
+						"content":     map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]string{"type": "string"}}},
+					},
+				},
+			}
+			if len(security) > 0 && !e.public {
+				op["security"] = security
+				op["responses"].(map[string]interface{})["401"] = map[string]interface{}{"description": "Credentials are missing or invalid"}
+			}
+			if rateLimited && !e.public {
+				op["responses"].(map[string]interface{})["429"] = map[string]interface{}{"description": "Too many requests have been made; retry after the number of seconds in Retry-After"}
+			}
+			if m == http.MethodPost {
+				if e.body != nil {
+					op["requestBody"] = map[string]interface{}{
+						"required": true,
+						"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(e.body), schemas)}},
+					}
+				}
+			} else if len(e.params) > 0 {
+				op["parameters"] = openAPIParams(e.params)
+			}
+			ops[strings.ToLower(m)] = op
+		}
+		paths[e.path] = ops
+	}
+	spec := map[string]interface{}{
+		"openapi": OPENAPI_VERSION,
+		"info": map[string]interface{}{
+			"title":       "outlived",
+			"description": "Who died at the age of someone born on a given date, and who they will outlive next",
+			"version":     "1",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+	if len(securitySchemes) > 0 {
+		spec["components"].(map[string]interface{})["securitySchemes"] = securitySchemes
+	}
+	body, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		panic(err) // the spec is built of maps, strings and bools, so this cannot happen
+	}
+	return body
+}
+
+// handleOpenAPI answers '/openapi.json' with the OpenAPI spec of the API
+func (s *server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s.spec)
+}
+
+func openAPIParams(params []apiParam) []interface{} {
+	var list []interface{}
+	for _, p := range params {
+		schema := map[string]interface{}{"type": p.kind}
+		if len(p.enum) > 0 {
+			schema["enum"] = p.enum
+		}
+		list = append(list, map[string]interface{}{
+			"name":        p.name,
+			"in":          "query",
+			"description": p.desc,
+			"required":    p.required,
+			"schema":      schema,
+		})
+	}
+	return list
+}
+
+func responseSchema(e endpoint, schemas map[string]interface{}) interface{} {
+	switch {
+	case e.response != nil:
+		return jsonSchema(reflect.TypeOf(e.response), schemas)
+	case e.content == "application/json":
+		return map[string]string{"type": "object"}
+	}
+	return map[string]string{"type": "string"}
+}
+
+// operationID names the operation for generated clients, e.g. "getApiQuery" for GET /api/query
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		id += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return id
+}
+
+// jsonSchema returns the schema of values of the type as encoded by encoding/json. Structs
+// are added to schemas, named after their type less any 'json' prefix, and referred to.
+func jsonSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := jsonSchema(t.Elem(), schemas)
+		if _, ok := schema["$ref"]; ok {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := strings.TrimPrefix(t.Name(), "json")
+		name = strings.ToUpper(name[:1]) + name[1:]
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // placeholder, in case the type refers to itself
+			props := map[string]interface{}{}
+			var required []string
+			structProperties(t, props, &required, schemas)
+			schema := map[string]interface{}{"type": "object", "properties": props}
+			if len(required) > 0 {
+				schema["required"] = required
+			}
+			schemas[name] = schema
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{} // any value
+}
+
+// structProperties adds the properties which encoding/json writes for the struct's fields,
+// including those of embedded structs, and lists those not omitted when empty as required
+func structProperties(t reflect.Type, props map[string]interface{}, required *[]string, schemas map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			structProperties(f.Type, props, required, schemas)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = jsonSchema(f.Type, schemas)
+		if !strings.Contains(","+opts+",", ",omitempty,") {
+			*required = append(*required, name)
+		}
+	}
+}
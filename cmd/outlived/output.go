@@ -0,0 +1,367 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// output formats accepted by the -output flag
+const (
+	OUTPUT_TEXT = "text"
+	OUTPUT_JSON = "json"
+	OUTPUT_CSV  = "csv"
+	OUTPUT_TSV  = "tsv"
+)
+
+// queryReport is the outcome of a query, ready to be rendered in one of the output formats
+type queryReport struct {
+	BirthDate string
+	UserAge   int
+	Results   []outlived.Result
+	Total     int  // the number of results, of which Results may be a page
+	Offset    int  // the number of results before the page
+	Paged     bool // whether -limit or -offset was given, so that text output shows the total
+	PrevAge   int  // the age of the result before the page, if any
+	Sort      string
+	Desc      bool
+	Quiet     bool // whether text output shows only the results
+	Color     bool // whether text output highlights the user's line
+	Ranking   outlived.Ranking
+	Datasets  []string
+	Labelled  bool // whether results come from several datasets, and so are labelled with theirs
+	Links     bool // whether text output shows the article link of each result
+	Precise   bool // whether ages are counted on the calendar, in years, months and days
+	Units     bool // whether ages are shown in days, weeks, months and years at once
+	Widened   int  // the window in days to which -min-results widened -days, if it did
+	Now       time.Time
+
+	nameWidth, datasetWidth int // widths of the text output's columns, fitting the longest
+}
+
+// resultAge formats the age at death of a result, padded for aligning in text output
+func (r queryReport) resultAge(res outlived.Result) string {
+	if r.Units {
+		return res.FormatAgeInUnits()
+	}
+	if r.Precise {
+		return res.FormatPreciseAge()
+	}
+	return res.FormatAge()
+}
+
+// userBetween reports whether the user's age falls between two ages, in the order of the results
+func (r queryReport) userBetween(prev, next int) bool {
+	if r.Desc {
+		return r.UserAge <= prev && r.UserAge > next
+	}
+	return r.UserAge >= prev && r.UserAge < next
+}
+
+// userIndex returns the index of the result before which the user is placed, which is
+// len(Results) for after them all, or -1 if the user isn't placed among them. Results ordered
+// by age have the user placed among them. On a page the user is placed after the last result
+// only if there are none beyond, and before the first only if they come after whoever was
+// before it. In other orders the user is placed first, on the first page.
+func (r queryReport) userIndex() int {
+	if r.BirthDate == "" {
+		return -1
+	}
+	if r.Sort != "" && r.Sort != outlived.SORT_AGE {
+		if r.Offset == 0 {
+			return 0
+		}
+		return -1
+	}
+	first, last := math.MinInt32, math.MaxInt32
+	if r.Desc {
+		first, last = last, first
+	}
+	lastAge := first
+	if r.Offset > 0 {
+		lastAge = r.PrevAge
+	}
+	for i, res := range r.Results {
+		if r.userBetween(lastAge, res.Days) {
+			return i
+		}
+		lastAge = res.Days
+	}
+	if r.userBetween(lastAge, last) && r.Offset+len(r.Results) >= r.Total && (len(r.Results) > 0 || !r.Paged) {
+		return len(r.Results)
+	}
+	return -1
+}
+
+// userAge formats the user's age, padded for aligning in text output
+func (r queryReport) userAge() string {
+	if r.Units {
+		if dob, err := time.Parse(outlived.DATE_FMT, r.BirthDate); err == nil {
+			return outlived.FormatAgeInUnits(outlived.AgeInUnitsBetween(dob, r.Now))
+		}
+	}
+	if r.Precise {
+		if dob, err := time.Parse(outlived.DATE_FMT, r.BirthDate); err == nil {
+			return outlived.FormatCalendarAge(outlived.CalendarAgeBetween(dob, r.Now))
+		}
+	}
+	return outlived.FormatAgeInYearsAndDays(r.UserAge)
+}
+
+type jsonReport struct {
+	BirthDate string       `json:"birth_date"`
+	AgeDays   int          `json:"age_days"`
+	Age       string       `json:"age"`
+	Results   []jsonResult `json:"results"`
+	Total     int          `json:"total"`
+	Offset    int          `json:"offset"`
+	Widened   int          `json:"widened_to_days,omitempty"`
+	Ranking   jsonRanking  `json:"ranking"`
+}
+
+type jsonRanking struct {
+	Outlived   int     `json:"outlived"`
+	Total      int     `json:"total"`
+	Percentile float64 `json:"percentile"`
+	Rank       int     `json:"rank"`
+}
+
+type jsonResult struct {
+	outlived.Person
+	AgeDays     int    `json:"age_days"`
+	Age         string `json:"age"`
+	Approximate bool   `json:"approximate,omitempty"` // whether the age comes from partial dates
+	Dataset     string `json:"dataset"`
+}
+
+// templateRow is the data available to a -format template for each result
+type templateRow struct {
+	outlived.Person
+	Dataset      string
+	AgeDays      int
+	AgeYears     int
+	Age          string
+	Approximate  bool // whether the age comes from partial dates
+	UserAgeDays  int
+	UserAgeYears int
+	UserAge      string
+	Outlived     bool    // whether the user is older than the person was when they died
+	Percentile   float64 // the percentage of the datasets the user has outlived
+}
+
+func writeReport(w io.Writer, format string, r queryReport) error {
+	switch format {
+	case OUTPUT_TEXT:
+		writeText(w, r)
+		return nil
+	case OUTPUT_JSON:
+		return writeJSON(w, r)
+	case OUTPUT_CSV:
+		return writeCSV(w, r, ',')
+	case OUTPUT_TSV:
+		return writeCSV(w, r, '\t')
+	}
+	return fmt.Errorf("unknown output format '%s'", format)
+}
+
+func writeText(w io.Writer, r queryReport) {
+	if r.Quiet {
+		r.Paged, r.BirthDate, r.Ranking = false, "", outlived.Ranking{}
+	}
+	r.nameWidth, r.datasetWidth = utf8.RuneCountInString(USER_MARKER), 0
+	for _, res := range r.Results {
+		if n := utf8.RuneCountInString(res.Name); n > r.nameWidth {
+			r.nameWidth = n
+		}
+		if n := utf8.RuneCountInString(res.Dataset) + 2; n > r.datasetWidth {
+			r.datasetWidth = n
+		}
+	}
+	if r.Widened > 0 && !r.Quiet {
+		fmt.Fprintf(w, "Widened the search to %d days either side of your age\n\n", r.Widened)
+	}
+	if r.Paged {
+		if len(r.Results) == 0 {
+			fmt.Fprintf(w, "No results past %d of %d\n\n", r.Offset, r.Total)
+		} else {
+			fmt.Fprintf(w, "Results %d to %d of %d\n\n", r.Offset+1, r.Offset+len(r.Results), r.Total)
+		}
+	}
+	user := r.userIndex()
+	for i, res := range r.Results {
+		if i == user {
+			printUserAge(w, r)
+		}
+		fmt.Fprintf(w, "%s(died aged %s)%s\n", r.nameColumns(res.Name, "["+res.Dataset+"]"), r.resultAge(res), details(res.Person))
+		if r.Links && res.URL != "" {
+			fmt.Fprintf(w, "    %s\n", res.URL)
+		}
+	}
+	if user == len(r.Results) {
+		printUserAge(w, r) // the user comes after everyone in the results
+	}
+	if r.Ranking.Total > 0 {
+		fmt.Fprintf(w, "\nYou have outlived %.0f%% of %s (%d of %d), ranking %s by age at death\n",
+			r.Ranking.Percentile(), strings.Join(r.Datasets, " and "), r.Ranking.Outlived, r.Ranking.Total,
+			ordinal(r.Ranking.Rank()))
+	}
+}
+
+// ordinal formats a positive number as an English ordinal, e.g. "21st"
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// details formats the optional fields of a person for text output, e.g. "  guitarist, US, rock"
+func details(rec outlived.Person) string {
+	var parts []string
+	for _, v := range []string{rec.Occupation, rec.Nationality, strings.Join(rec.Genres(), "/")} {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	if rec.CauseOfDeath != "" {
+		parts = append(parts, "died of "+rec.CauseOfDeath)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "  " + strings.Join(parts, ", ")
+}
+
+// USER_MARKER marks the user's place among the results in text output
+const USER_MARKER = ">>> YOU ARE HERE"
+
+func printUserAge(w io.Writer, r queryReport) {
+	line := fmt.Sprintf("%s(     aged %s)", r.nameColumns(USER_MARKER, ""), r.userAge())
+	fmt.Fprintln(w, colorize(line, ANSI_HIGHLIGHT, r.Color))
+}
+
+// nameColumns formats the name, and the dataset if results are labelled, padded to the widths
+// of their columns
+func (r queryReport) nameColumns(name, dataset string) string {
+	if r.Labelled {
+		return fmt.Sprintf("%-*s %-*s ", r.nameWidth, name, r.datasetWidth, dataset)
+	}
+	return fmt.Sprintf("%-*s ", r.nameWidth, name)
+}
+
+func writeJSON(w io.Writer, r queryReport) error {
+	doc := jsonReport{
+		BirthDate: r.BirthDate,
+		AgeDays:   r.UserAge,
+		Age:       outlived.Unpadded(r.userAge()),
+		Results:   make([]jsonResult, 0, len(r.Results)),
+		Total:     r.Total,
+		Offset:    r.Offset,
+		Widened:   r.Widened,
+		Ranking: jsonRanking{
+			Outlived:   r.Ranking.Outlived,
+			Total:      r.Ranking.Total,
+			Percentile: r.Ranking.Percentile(),
+			Rank:       r.Ranking.Rank(),
+		},
+	}
+	for _, res := range r.Results {
+		doc.Results = append(doc.Results, jsonResult{
+			Person:      res.Person,
+			AgeDays:     res.Days,
+			Age:         outlived.Unpadded(r.resultAge(res)),
+			Approximate: res.Approximate(),
+			Dataset:     res.Dataset,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// CSV_COLUMNS are the columns of CSV output which follow those of a Person, as written by
+// export, so that the output can be imported again
+var CSV_COLUMNS = []string{"dataset", "age_days", "age_years", "age", "approximate", "is_user"}
+
+// writeCSV writes the results as CSV, or TSV with a tab as the delimiter, with a row for the
+// user placed among them as in text output and marked by the is_user column
+func writeCSV(w io.Writer, r queryReport, delimiter rune) error {
+	if r.Quiet {
+		r.BirthDate = ""
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = delimiter
+	cw.Write(append(append([]string{}, outlived.PERSON_FIELDS...), CSV_COLUMNS...))
+	row := func(rec outlived.Person, dataset string, days int, age string, approximate, isUser bool) {
+		cw.Write([]string{rec.Name, rec.BirthDate, rec.DeathDate, rec.Occupation, rec.Nationality, rec.CauseOfDeath,
+			rec.Genre, rec.URL, rec.Summary, rec.ImageURL, rec.Aliases, rec.Tags, dataset, strconv.Itoa(days),
+			strconv.Itoa(outlived.AgeInYears(days)), outlived.Unpadded(age), strconv.FormatBool(approximate),
+			strconv.FormatBool(isUser)})
+	}
+	you := outlived.Person{Name: "You", BirthDate: r.BirthDate}
+	user := r.userIndex()
+	for i, res := range r.Results {
+		if i == user {
+			row(you, "", r.UserAge, r.userAge(), false, true)
+		}
+		row(res.Person, res.Dataset, res.Days, r.resultAge(res), res.Approximate(), false)
+	}
+	if user == len(r.Results) {
+		row(you, "", r.UserAge, r.userAge(), false, true)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatAge formats the age in years and days without the padding used to align text output
+func formatAge(days int) string {
+	return outlived.Unpadded(outlived.FormatAgeInYearsAndDays(days))
+}
+
+// parseTemplate parses a -format template, which is rendered once per result
+func parseTemplate(text string) (*template.Template, error) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return template.New("format").Parse(text)
+}
+
+func writeTemplate(w io.Writer, tmpl *template.Template, r queryReport) error {
+	for _, res := range r.Results {
+		row := templateRow{
+			Person:       res.Person,
+			Dataset:      res.Dataset,
+			AgeDays:      res.Days,
+			AgeYears:     outlived.AgeInYears(res.Days),
+			Age:          outlived.Unpadded(r.resultAge(res)),
+			Approximate:  res.Approximate(),
+			UserAgeDays:  r.UserAge,
+			UserAgeYears: outlived.AgeInYears(r.UserAge),
+			UserAge:      outlived.Unpadded(r.userAge()),
+			Outlived:     r.UserAge > res.Days,
+			Percentile:   r.Ranking.Percentile(),
+		}
+		if err := tmpl.Execute(w, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var overlapOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	dob      string
+	atBirth  bool
+	living   bool
+	count    int
+	filter   outlived.Filter
+}
+
+var overlapCommand = &command{
+	name:    "overlap",
+	summary: "List the people whose lives overlapped that of someone born on -dob, or compare their age with the living",
+	flags: func(fs *flag.FlagSet) {
+		overlapOpts.store = addStoreFlags(fs)
+		overlapOpts.clock = addClockFlags(fs, true)
+		overlapOpts.profiles = addProfileFlags(fs, true)
+		fs.StringVar(&overlapOpts.dob, "dob", cfg.DOB, "Date of birth (YYYY-MM-DD)")
+		fs.BoolVar(&overlapOpts.atBirth, "at-birth", false, "Only list the people who were alive on the day you were born")
+		fs.BoolVar(&overlapOpts.living, "living", false, "Only list living people, closest to your age first, with how much older or younger they are")
+		fs.IntVar(&overlapOpts.count, "count", 0, "Only list the first N people (default all)")
+		fs.StringVar(&overlapOpts.filter.Occupation, "occupation", "", "Only show people with this occupation, e.g. 'guitarist'")
+		fs.StringVar(&overlapOpts.filter.Nationality, "nationality", "", "Only show people of this nationality, e.g. 'GB'")
+		fs.StringVar(&overlapOpts.filter.Genre, "genre", "", "Only show people with this genre, e.g. 'rock'")
+		fs.StringVar(&overlapOpts.filter.Tag, "tag", "", "Only show people with this tag, e.g. 'grammy-winner'")
+	},
+	run: runOverlap,
+}
+
+func runOverlap(fs *flag.FlagSet, args []string) error {
+	dob, err := resolveDOB(overlapOpts.dob, overlapOpts.profiles, overlapOpts.store)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 || dob == "" {
+		fs.Usage()
+		return errors.New("overlap: a date of birth must be given with -dob or -profile")
+	}
+	now, err := overlapOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	store, err := overlapOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, overlapOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	userAge, overlaps, err := outlived.Overlaps(store, dob, outlived.QueryOptions{Datasets: datasets, Filter: overlapOpts.filter, Now: now})
+	if err != nil {
+		return err
+	}
+	selected := overlaps[:0]
+	for _, ov := range overlaps {
+		if (!overlapOpts.atBirth || ov.AtBirth) && (!overlapOpts.living || ov.Living()) {
+			selected = append(selected, ov)
+		}
+	}
+	if overlapOpts.living {
+		sort.SliceStable(selected, func(i, j int) bool {
+			return abs(selected[i].Days-userAge) < abs(selected[j].Days-userAge)
+		})
+	}
+	if overlapOpts.count > 0 && len(selected) > overlapOpts.count {
+		selected = selected[:overlapOpts.count]
+	}
+	if overlapOpts.living {
+		return writeLiving(os.Stdout, selected, userAge, len(datasets) > 1)
+	}
+	return writeOverlaps(os.Stdout, selected, len(datasets) > 1)
+}
+
+// writeOverlaps lists each person with their dates and how much of the user's life they shared
+func writeOverlaps(w io.Writer, overlaps []outlived.Overlap, labelled bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, overlapHeader(labelled, "BORN\tDIED\tSHARED\t"))
+	for _, ov := range overlaps {
+		died := ov.DeathDate
+		if ov.Living() {
+			died = "living"
+		}
+		alive := ""
+		if ov.AtBirth {
+			alive = "alive when you were born"
+		}
+		fmt.Fprintf(tw, "%s%s\t%s\t%s\t%s\n", overlapName(ov, labelled), ov.BirthDate, died, formatAge(ov.Shared), alive)
+	}
+	return tw.Flush()
+}
+
+// writeLiving lists each living person with their age and how it compares with the user's
+func writeLiving(w io.Writer, overlaps []outlived.Overlap, userAge int, labelled bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, overlapHeader(labelled, "BORN\tAGE\tCOMPARED WITH YOU"))
+	for _, ov := range overlaps {
+		compared := "the same age"
+		switch diff := ov.Days - userAge; {
+		case diff > 0:
+			compared = formatAge(diff) + " older"
+		case diff < 0:
+			compared = formatAge(-diff) + " younger"
+		}
+		fmt.Fprintf(tw, "%s%s\t%s\t%s\n", overlapName(ov, labelled), ov.BirthDate, formatAge(ov.Days), compared)
+	}
+	return tw.Flush()
+}
+
+func overlapHeader(labelled bool, columns string) string {
+	if labelled {
+		return "NAME\tDATASET\t" + columns
+	}
+	return "NAME\t" + columns
+}
+
+func overlapName(ov outlived.Overlap, labelled bool) string {
+	if labelled {
+		return ov.Name + "\t" + ov.Dataset + "\t"
+	}
+	return ov.Name + "\t"
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,167 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var personOpts struct {
+	store *storeFlags
+	clock *clockFlags
+}
+
+var personCommand = &command{
+	name:    "person",
+	args:    "NAME",
+	summary: "Show everything known about one person, and how their lifespan compares with the rest of the dataset",
+	flags: func(fs *flag.FlagSet) {
+		personOpts.store = addStoreFlags(fs)
+		personOpts.clock = addClockFlags(fs, true)
+	},
+	run: runPerson,
+}
+
+func runPerson(fs *flag.FlagSet, args []string) error {
+	name := strings.Join(args, " ")
+	if strings.TrimSpace(name) == "" {
+		fs.Usage()
+		return errors.New("person: a name must be given")
+	}
+	now, err := personOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	store, err := personOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, personOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	res, err := findPerson(store, "person", name, outlived.QueryOptions{Datasets: datasets, Now: now})
+	if err != nil {
+		return err
+	}
+	for i, r := range res {
+		if i > 0 {
+			fmt.Println()
+		}
+		standing, err := outlived.PersonStanding(store, r)
+		if err != nil {
+			return err
+		}
+		if err := writePerson(os.Stdout, r, standing, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findPerson returns the person named, or namesakes sharing the name. A name which could be
+// any of several people is an error listing them, so that more of the name can be given.
+func findPerson(store outlived.Store, cmd, name string, opts outlived.QueryOptions) ([]outlived.Result, error) {
+	matches, err := outlived.FindPerson(store, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, noMatch{fmt.Sprintf("%s: no one found named '%s'", cmd, name)}
+	}
+	results := make([]outlived.Result, len(matches))
+	names := make([]string, len(matches))
+	namesakes := true
+	for i, m := range matches {
+		results[i] = m.Result
+		names[i] = fmt.Sprintf("%s (%s)", m.Name, m.BirthDate)
+		namesakes = namesakes && outlived.FoldName(m.Name) == outlived.FoldName(matches[0].Name)
+	}
+	if !namesakes {
+		return nil, fmt.Errorf("%s: '%s' could be any of %s", cmd, name, strings.Join(names, ", "))
+	}
+	return results, nil
+}
+
+// writePerson writes the person's record, with their exact age and how many people in their
+// dataset lived longer or shorter lives
+func writePerson(w io.Writer, res outlived.Result, s outlived.Standing, now time.Time) error {
+	fmt.Fprintf(w, "%s [%s]\n", res.Name, res.Dataset)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if aliases := res.Names()[1:]; len(aliases) > 0 {
+		fmt.Fprintf(tw, "    Also known as:\t%s\n", strings.Join(aliases, ", "))
+	}
+	fmt.Fprintf(tw, "    Born:\t%s\n", res.BirthDate)
+	if res.Living() {
+		fmt.Fprintf(tw, "    Died:\tliving\n")
+		fmt.Fprintf(tw, "    Age:\t%s\n", preciseAge(res, now))
+	} else {
+		fmt.Fprintf(tw, "    Died:\t%s\n", res.DeathDate)
+		fmt.Fprintf(tw, "    Lifespan:\t%s\n", preciseAge(res, now))
+	}
+	fmt.Fprintf(tw, "    Days lived:\t%d\n", res.Days)
+	if s.Total() > 0 {
+		fmt.Fprintf(tw, "    Compared:\t%s\n", describeStanding(res, s))
+	}
+	if d := strings.TrimSpace(details(res.Person)); d != "" {
+		fmt.Fprintf(tw, "    Details:\t%s\n", d)
+	}
+	if tags := res.TagList(); len(tags) > 0 {
+		fmt.Fprintf(tw, "    Tags:\t%s\n", strings.Join(tags, ", "))
+	}
+	if res.URL != "" {
+		fmt.Fprintf(tw, "    Wikipedia:\t%s\n", res.URL)
+	}
+	if res.Summary != "" {
+		fmt.Fprintf(tw, "    Summary:\t%s\n", res.Summary)
+	}
+	return tw.Flush()
+}
+
+// preciseAge formats the person's age at death, or for the living their age now, counted on
+// the calendar where their dates allow
+func preciseAge(res outlived.Result, now time.Time) string {
+	if !res.Approximate() {
+		if !res.Living() {
+			if a, err := res.CalendarAge(); err == nil {
+				return a.String()
+			}
+		} else if birth, err := time.Parse(outlived.DATE_FMT, res.BirthDate); err == nil {
+			return outlived.CalendarAgeBetween(birth, now).String()
+		}
+	}
+	return outlived.Unpadded(res.FormatAge())
+}
+
+// describeStanding describes how many of the others in the dataset lived longer or shorter lives
+func describeStanding(res outlived.Result, s outlived.Standing) string {
+	if res.Living() {
+		desc := fmt.Sprintf("has outlived %d of the %d people in %s who have died", s.Shorter, s.Total(), res.Dataset)
+		if s.Longer > 0 {
+			desc += fmt.Sprintf("; %d lived longer", s.Longer)
+		}
+		return desc
+	}
+	var parts []string
+	if s.Shorter > 0 {
+		parts = append(parts, fmt.Sprintf("longer than %d", s.Shorter))
+	}
+	if s.Longer > 0 {
+		parts = append(parts, fmt.Sprintf("shorter than %d", s.Longer))
+	}
+	if s.Same > 0 {
+		parts = append(parts, fmt.Sprintf("as long as %d", s.Same))
+	}
+	return fmt.Sprintf("lived %s of the %d others in %s", strings.Join(parts, " and "), s.Total(), res.Dataset)
+}
@@ -0,0 +1,159 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// where profiles are kept, given by the -profile-store flag
+const (
+	PROFILES_FILE  = "file"
+	PROFILES_REDIS = "redis"
+)
+
+// profileFlags holds the options which select a profile and where profiles are kept
+type profileFlags struct {
+	name  string
+	store string
+	path  string
+}
+
+// addProfileFlags adds the options locating profiles, along with -profile if selecting one
+func addProfileFlags(fs *flag.FlagSet, selecting bool) *profileFlags {
+	f := &profileFlags{}
+	if selecting {
+		fs.StringVar(&f.name, "profile", "", "Use the date of birth saved in this profile (see 'profile add')")
+	}
+	fs.StringVar(&f.store, "profile-store", orDefault(cfg.ProfileStore, PROFILES_FILE), "Where profiles are kept: 'file' or 'redis'")
+	fs.StringVar(&f.path, "profile-file", defaultProfilesPath(), "File holding profiles when using -profile-store file")
+	return f
+}
+
+// defaultProfilesPath returns the profiles file under the user's configuration directory
+func defaultProfilesPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "outlived-profiles.json"
+	}
+	return filepath.Join(dir, "outlived", "profiles.json")
+}
+
+// open the profile store, using the Redis connection options in sf if keeping them in Redis
+func (f *profileFlags) open(sf *storeFlags) (outlived.ProfileStore, func() error, error) {
+	switch f.store {
+	case PROFILES_FILE:
+		return outlived.FileProfileStore{Path: f.path}, func() error { return nil }, nil
+	case PROFILES_REDIS:
+		rs, err := outlived.NewRedisStore(sf.redisConfig())
+		if err != nil {
+			return nil, nil, err
+		}
+		return rs, rs.Close, nil
+	}
+	return nil, nil, fmt.Errorf("unknown profile store '%s'", f.store)
+}
+
+// resolveDOB returns the date of birth in the profile selected by -profile, or else dob
+func resolveDOB(dob string, pf *profileFlags, sf *storeFlags) (string, error) {
+	if pf.name == "" {
+		return dob, nil
+	}
+	ps, closeFn, err := pf.open(sf)
+	if err != nil {
+		return "", err
+	}
+	defer closeFn()
+	p, err := outlived.FindProfile(ps, pf.name)
+	return p.BirthDate, err
+}
+
+// selectProfiles returns the named profiles, or all of them given 'all'
+func selectProfiles(pf *profileFlags, sf *storeFlags, names []string) ([]outlived.Profile, error) {
+	ps, closeFn, err := pf.open(sf)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+	profiles, err := ps.Profiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 1 && names[0] == outlived.DATASETS_ALL {
+		return profiles, nil
+	}
+	byName := make(map[string]outlived.Profile, len(profiles))
+	for _, p := range profiles {
+		byName[p.Name] = p
+	}
+	selected := make([]outlived.Profile, 0, len(names))
+	for _, name := range names {
+		p, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("profile '%s': %v", name, outlived.ErrNoProfile)
+		}
+		selected = append(selected, p)
+	}
+	return selected, nil
+}
+
+var profileOpts struct {
+	store    *storeFlags
+	profiles *profileFlags
+}
+
+var profileCommand = &command{
+	name:    "profile",
+	args:    "add NAME DATE | list | remove NAME",
+	summary: "Save, list or remove named dates of birth, for use with -profile",
+	flags: func(fs *flag.FlagSet) {
+		profileOpts.store = addStoreFlags(fs)
+		profileOpts.profiles = addProfileFlags(fs, false)
+	},
+	run: runProfile,
+}
+
+func runProfile(fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("profile: a subcommand must be given")
+	}
+	ps, closeFn, err := profileOpts.profiles.open(profileOpts.store)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	switch {
+	case args[0] == "add" && len(args) == 3:
+		p := outlived.Profile{Name: args[1], BirthDate: args[2]}
+		if err := ps.SaveProfile(p); err != nil {
+			return err
+		}
+		fmt.Printf("Saved profile '%s' (born %s)\n", p.Name, p.BirthDate)
+		return nil
+	case args[0] == "list" && len(args) == 1:
+		profiles, err := ps.Profiles()
+		if err != nil {
+			return err
+		}
+		for _, p := range profiles {
+			fmt.Printf("%-20s %s\n", p.Name, p.BirthDate)
+		}
+		return nil
+	case args[0] == "remove" && len(args) == 2:
+		if err := ps.RemoveProfile(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Removed profile '%s'\n", args[1])
+		return nil
+	}
+	fs.Usage()
+	return fmt.Errorf("profile: unknown subcommand '%s' or wrong number of arguments", args[0])
+}
@@ -0,0 +1,317 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var queryOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	days     int
+	noDB     bool
+	output   string
+	format   string
+	links    bool
+	precise  bool
+	units    bool
+	filter   outlived.Filter
+
+	diedAt      string
+	batch       string
+	diedBetween string
+	nearest     int
+	minResults  int
+	maxDays     int
+	limit       int
+	offset      int
+	sort        string
+	desc        bool
+	count       bool
+	quiet       bool
+	noColor     bool
+}
+
+var queryCommand = &command{
+	name:    "query",
+	args:    "[DATE] [FILE]",
+	summary: "Show who died at an age close to that of someone born on DATE (e.g. 1990-09-25 or '25 Sep 1990'), or at an age given with -died-at",
+	flags: func(fs *flag.FlagSet) {
+		queryOpts.store = addStoreFlags(fs)
+		queryOpts.clock = addClockFlags(fs, true)
+		queryOpts.profiles = addProfileFlags(fs, true)
+		days := 365
+		if cfg.Days > 0 {
+			days = cfg.Days
+		}
+		fs.IntVar(&queryOpts.days, "days", days, "Number of days either side of target date to return results")
+		fs.IntVar(&queryOpts.days, "d", days, "Shorthand for -days")
+		fs.StringVar(&queryOpts.diedAt, "died-at", "", "Show who died at this age instead, in years, months or days, e.g. '27y', '27y6m' or '400d'; DATE is then optional")
+		fs.StringVar(&queryOpts.diedBetween, "died-between", "", "Show who died between two ages instead, e.g. '27y,28y' for those who died aged 27 or 28; DATE is then optional")
+		fs.StringVar(&queryOpts.batch, "batch", "", "CSV file of dates of birth, each optionally with a label, to summarise where each stands instead of querying one date ('-' for stdin)")
+		fs.IntVar(&queryOpts.nearest, "nearest", 0, "Show the people this many whose ages at death are closest to yours, younger or older, however far off, instead of those within -days")
+		fs.IntVar(&queryOpts.minResults, "min-results", 0, "Widen -days, doubling it up to -max-days, until at least this many people are found")
+		fs.IntVar(&queryOpts.maxDays, "max-days", outlived.DEFAULT_MAX_DAYS, "Widest window -min-results widens -days to")
+		fs.IntVar(&queryOpts.limit, "limit", 0, "Only show this many results, with a header giving the total (default all)")
+		fs.IntVar(&queryOpts.offset, "offset", 0, "Skip this many results first, for paging through them with -limit")
+		fs.StringVar(&queryOpts.sort, "sort", outlived.SORT_AGE, "Order of the results: 'age' (at death), 'name' or 'death-date'")
+		fs.BoolVar(&queryOpts.desc, "desc", false, "Reverse the order of the results, e.g. the oldest first")
+		fs.BoolVar(&queryOpts.count, "count", false, "Print only the number of people matching, counted by the store where it can be")
+		fs.BoolVar(&queryOpts.quiet, "quiet", false, "Print only the results in text output, without the header, your age or your ranking, and leave your row out of CSV output")
+		fs.BoolVar(&queryOpts.noDB, "no-db", false, "Query the CSV or JSON file supplied as FILE directly, without using a database")
+		fs.StringVar(&queryOpts.filter.Occupation, "occupation", "", "Only show people with this occupation, e.g. 'guitarist'")
+		fs.StringVar(&queryOpts.filter.Nationality, "nationality", "", "Only show people of this nationality, e.g. 'GB'")
+		fs.StringVar(&queryOpts.filter.Genre, "genre", "", "Only show people with this genre, e.g. 'rock'")
+		fs.StringVar(&queryOpts.filter.Tag, "tag", "", "Only show people with this tag, e.g. 'grammy-winner'")
+		fs.StringVar(&queryOpts.filter.Cause, "cause", "", "Only show people who died of this, e.g. 'heart attack'")
+		addYearFilterFlags(fs, &queryOpts.filter)
+		fs.StringVar(&queryOpts.output, "output", orDefault(cfg.Output, OUTPUT_TEXT), "Output format: 'text', 'json', 'csv' or 'tsv'")
+		fs.BoolVar(&queryOpts.noColor, "no-color", false, "Don't highlight your place among the results in text output, which is otherwise done on a terminal unless NO_COLOR is set")
+		fs.BoolVar(&queryOpts.links, "links", false, "Show the Wikipedia article of each person under their result in text output, where 'enrich' has found one")
+		fs.BoolVar(&queryOpts.precise, "precise", false, "Count ages on the calendar, in years, months and days, rather than in years of 365.25 days")
+		fs.BoolVar(&queryOpts.units, "units", false, "Show ages in days, weeks, months and years at once, counted on the calendar")
+		fs.StringVar(&queryOpts.format, "format", "", "Go template used to render each result, e.g. '{{.Name}} died at {{.AgeYears}}'. "+
+			"Fields: Name, Dataset, BirthDate, DeathDate, Occupation, Nationality, CauseOfDeath, Genre, URL, Summary, ImageURL, Aliases, Tags, AgeDays, AgeYears, Age, Approximate, UserAgeDays, UserAgeYears, UserAge, Outlived, Percentile")
+	},
+	run: runQuery,
+}
+
+func runQuery(fs *flag.FlagSet, args []string) error {
+	if queryOpts.batch != "" {
+		return runBatch(fs, args)
+	}
+	dob, err := resolveDOB(cfg.DOB, queryOpts.profiles, queryOpts.store)
+	if err != nil {
+		return err
+	}
+	if dob != "" && (len(args) == 0 || (queryOpts.noDB && len(args) == 1)) {
+		args = append([]string{dob}, args...) // the date of birth comes from a profile or the config file
+	}
+	byAge := queryOpts.diedAt != "" || queryOpts.diedBetween != ""
+	var ages outlived.AgeRange
+	if byAge {
+		if ages, args, err = queryAgeRange(args); err != nil {
+			return err
+		}
+	}
+	dates := len(args)
+	if queryOpts.noDB {
+		dates--
+	}
+	if dates < 0 || dates > 1 || (dates == 0 && !byAge) {
+		fs.Usage()
+		return errors.New("query: a date must be supplied unless using -died-at or -died-between, followed by a file when using -no-db")
+	}
+	dateStr := ""
+	if dates == 1 {
+		dateStr = args[0]
+	}
+	ndays := queryOpts.days
+	if ndays < 0 {
+		return errors.New("query: -days must not be negative")
+	}
+	if queryOpts.limit < 0 || queryOpts.offset < 0 || queryOpts.nearest < 0 || queryOpts.minResults < 0 {
+		return errors.New("query: -limit, -offset, -nearest and -min-results must not be negative")
+	}
+	if queryOpts.minResults > 0 && (byAge || queryOpts.nearest > 0) {
+		return errors.New("query: -min-results can't be used with -died-at, -died-between or -nearest")
+	}
+	if queryOpts.nearest > 0 && (byAge || queryOpts.count || queryOpts.limit > 0 || queryOpts.offset > 0) {
+		return errors.New("query: -nearest can't be used with -died-at, -died-between, -count, -limit or -offset")
+	}
+	if queryOpts.units && queryOpts.precise {
+		return errors.New("query: -units and -precise can't be used together")
+	}
+	if err := outlived.ValidateSort(queryOpts.sort); err != nil {
+		return fmt.Errorf("query: %v", err)
+	}
+	var tmpl *template.Template
+	if queryOpts.format != "" {
+		if tmpl, err = parseTemplate(queryOpts.format); err != nil {
+			return err
+		}
+	}
+
+	store, dataset, err := openQueryStore(args)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, dataset)
+	if err != nil {
+		return err
+	}
+	now, err := queryOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	if dateStr != "" {
+		if dateStr, err = outlived.ParseUserDate(dateStr, now); err != nil {
+			return fmt.Errorf("query: %v", err)
+		}
+	}
+	opts := outlived.QueryOptions{
+		Datasets: datasets,
+		Days:     ndays,
+		Filter:   queryOpts.filter,
+		Now:      now,
+		Offset:   queryOpts.offset,
+		Limit:    queryOpts.limit,
+		Sort:     queryOpts.sort,
+		Desc:     queryOpts.desc,
+	}
+	if opts.Offset > 0 {
+		// read the result before the page as well, to tell whether the user comes first on it
+		opts.Offset--
+		if opts.Limit > 0 {
+			opts.Limit++
+		}
+	}
+	if queryOpts.minResults > 0 {
+		if opts.Days, err = outlived.WidenDays(store, dateStr, queryOpts.minResults, queryOpts.maxDays, opts); err != nil {
+			return err
+		}
+	}
+	if queryOpts.count {
+		return printCount(store, dateStr, byAge, ages, opts)
+	}
+	var userAge, total int
+	var results []outlived.Result
+	if byAge {
+		if results, total, err = outlived.QueryByAge(store, ages, opts); err != nil {
+			return err
+		}
+		if dateStr != "" {
+			if userAge, err = outlived.AgeInDays(dateStr, now.Format(outlived.DATE_FMT)); err != nil {
+				return err
+			}
+		}
+	} else if queryOpts.nearest > 0 {
+		if userAge, results, err = outlived.QueryNearest(store, dateStr, queryOpts.nearest, opts); err != nil {
+			return err
+		}
+		total = len(results)
+	} else if userAge, results, total, err = outlived.Query(store, dateStr, opts); err != nil {
+		return err
+	}
+	prevAge := 0
+	if queryOpts.offset > 0 && len(results) > 0 {
+		prevAge, results = results[0].Days, results[1:]
+	}
+	var ranking outlived.Ranking
+	if dateStr != "" {
+		if ranking, err = outlived.Rank(store, userAge, opts); err != nil {
+			return err
+		}
+	}
+	report := queryReport{
+		BirthDate: dateStr,
+		UserAge:   userAge,
+		Results:   results,
+		Total:     total,
+		Offset:    queryOpts.offset,
+		Paged:     queryOpts.offset > 0 || queryOpts.limit > 0,
+		PrevAge:   prevAge,
+		Sort:      opts.Sort,
+		Desc:      opts.Desc,
+		Quiet:     queryOpts.quiet,
+		Color:     useColor(os.Stdout, queryOpts.noColor),
+		Ranking:   ranking,
+		Datasets:  datasets,
+		Labelled:  len(datasets) > 1,
+		Links:     queryOpts.links,
+		Precise:   queryOpts.precise,
+		Units:     queryOpts.units,
+		Now:       opts.Now,
+	}
+	if opts.Days != ndays {
+		report.Widened = opts.Days
+	}
+	if tmpl != nil {
+		err = writeTemplate(os.Stdout, tmpl, report)
+	} else {
+		err = writeReport(os.Stdout, queryOpts.output, report)
+	}
+	if err == nil && total == 0 {
+		return noMatch{}
+	}
+	return err
+}
+
+// openQueryStore opens the store to query, along with the dataset named by -dataset. With
+// -no-db this is the file given as the last of the arguments, loaded into memory.
+func openQueryStore(args []string) (outlived.Store, string, error) {
+	dataset := queryOpts.store.dataset
+	if !queryOpts.noDB {
+		store, err := queryOpts.store.open()
+		return store, dataset, err
+	}
+	// load the file straight into memory, skipping the import step
+	records, _, err := outlived.ReadFile(args[len(args)-1], outlived.ReadOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	mem := outlived.NewMemoryStore()
+	if dataset == outlived.DATASETS_ALL || strings.Contains(dataset, ",") {
+		dataset = outlived.DB_NAME
+	}
+	if err := mem.Import(dataset, records); err != nil {
+		return nil, "", err
+	}
+	return mem, dataset, nil
+}
+
+// printCount prints the number of people the query matches, which for ages given with
+// -died-at or -died-between have to be read to be counted exactly
+func printCount(store outlived.Store, dateStr string, byAge bool, ages outlived.AgeRange, opts outlived.QueryOptions) error {
+	var n int
+	var err error
+	if byAge {
+		_, n, err = outlived.QueryByAge(store, ages, opts)
+	} else {
+		n, err = outlived.CountQuery(store, dateStr, opts)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(n)
+	if n == 0 {
+		return noMatch{}
+	}
+	return nil
+}
+
+// queryAgeRange parses -died-at, or -died-between whose ages are given either as 'FROM,TO' or
+// with TO following as an argument, returning the remaining arguments
+func queryAgeRange(args []string) (outlived.AgeRange, []string, error) {
+	if queryOpts.diedAt != "" && queryOpts.diedBetween != "" {
+		return outlived.AgeRange{}, nil, errors.New("query: -died-at and -died-between cannot be used together")
+	}
+	if queryOpts.diedAt != "" {
+		r, err := outlived.ParseAgeRange(queryOpts.diedAt, "")
+		return r, args, err
+	}
+	ages := strings.FieldsFunc(queryOpts.diedBetween, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(ages) == 1 && len(args) > 0 {
+		// the second age may follow as an argument, before the date of birth if any
+		if _, err := outlived.ParseUserDate(args[0], time.Now()); err != nil {
+			ages, args = append(ages, args[0]), args[1:]
+		}
+	}
+	if len(ages) != 2 {
+		return outlived.AgeRange{}, nil, fmt.Errorf("query: -died-between needs two ages, e.g. '27y,28y', not '%s'", queryOpts.diedBetween)
+	}
+	r, err := outlived.ParseAgeRange(ages[0], ages[1])
+	return r, args, err
+}
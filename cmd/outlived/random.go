@@ -0,0 +1,192 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var randomOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	dob      string
+	spread   int
+	seed     int64
+	output   string
+}
+
+var randomCommand = &command{
+	name:    "random",
+	summary: "Show everything known about someone chosen at random, or with -dob someone who died near your age",
+	flags: func(fs *flag.FlagSet) {
+		randomOpts.store = addStoreFlags(fs)
+		randomOpts.clock = addClockFlags(fs, true)
+		randomOpts.profiles = addProfileFlags(fs, true)
+		fs.StringVar(&randomOpts.dob, "dob", "", "Date of birth (e.g. 1990-09-25 or '25 Sep 1990'), making those who died nearer your age likelier to be chosen")
+		fs.IntVar(&randomOpts.spread, "spread", outlived.DEFAULT_SPREAD_DAYS, "With -dob, how far from your age those chosen tend to be, in days")
+		fs.Int64Var(&randomOpts.seed, "seed", 0, "Seed of the random choice, so that it can be made again (default random)")
+		fs.StringVar(&randomOpts.output, "output", OUTPUT_TEXT, "Output format: 'text' or 'json'")
+	},
+	run: runRandom,
+}
+
+func runRandom(fs *flag.FlagSet, args []string) error {
+	if len(args) > 0 {
+		fs.Usage()
+		return errors.New("random: no arguments are expected")
+	}
+	if err := checkPersonOutput("random", randomOpts.output); err != nil {
+		return err
+	}
+	if randomOpts.spread <= 0 {
+		return errors.New("random: -spread must be positive")
+	}
+	dob, err := resolveDOB(randomOpts.dob, randomOpts.profiles, randomOpts.store)
+	if err != nil {
+		return err
+	}
+	now, err := randomOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	seed := randomOpts.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	store, err := randomOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, randomOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	var res outlived.Result
+	if dob == "" {
+		res, err = outlived.RandomPerson(store, datasets, rng)
+	} else {
+		if dob, err = outlived.ParseUserDate(dob, now); err != nil {
+			return fmt.Errorf("random: %v", err)
+		}
+		var age int
+		if age, err = outlived.AgeInDays(dob, now.Format(outlived.DATE_FMT)); err != nil {
+			return err
+		}
+		res, err = outlived.RandomPersonNear(store, datasets, age, randomOpts.spread, rng)
+	}
+	if errors.Is(err, outlived.ErrNoOne) {
+		return noMatch{fmt.Sprintf("random: no one in %s to choose from", strings.Join(datasets, ", "))}
+	}
+	if err != nil {
+		return err
+	}
+	return writeChosen(os.Stdout, store, res, "", randomOpts.output, now)
+}
+
+var potdOpts struct {
+	store  *storeFlags
+	clock  *clockFlags
+	output string
+}
+
+var potdCommand = &command{
+	name:    "potd",
+	summary: "Show the person of the day, the same one all day, chosen from the dataset by the date",
+	flags: func(fs *flag.FlagSet) {
+		potdOpts.store = addStoreFlags(fs)
+		potdOpts.clock = addClockFlags(fs, true)
+		fs.StringVar(&potdOpts.output, "output", OUTPUT_TEXT, "Output format: 'text' or 'json'")
+	},
+	run: runPOTD,
+}
+
+func runPOTD(fs *flag.FlagSet, args []string) error {
+	if len(args) > 0 {
+		fs.Usage()
+		return errors.New("potd: no arguments are expected")
+	}
+	if err := checkPersonOutput("potd", potdOpts.output); err != nil {
+		return err
+	}
+	now, err := potdOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	store, err := potdOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, potdOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	res, err := outlived.PersonOfTheDay(store, datasets, now)
+	if errors.Is(err, outlived.ErrNoOne) {
+		return noMatch{fmt.Sprintf("potd: no one in %s to choose from", strings.Join(datasets, ", "))}
+	}
+	if err != nil {
+		return err
+	}
+	return writeChosen(os.Stdout, store, res, now.Format(outlived.DATE_FMT), potdOpts.output, now)
+}
+
+// checkPersonOutput checks an -output format of a command showing one person
+func checkPersonOutput(cmd, output string) error {
+	if output != OUTPUT_TEXT && output != OUTPUT_JSON {
+		return fmt.Errorf("%s: unknown output format '%s': expected 'text' or 'json'", cmd, output)
+	}
+	return nil
+}
+
+// jsonChosen is the JSON output of random and potd
+type jsonChosen struct {
+	jsonResult
+	Date    string `json:"date,omitempty"` // of which they are the person of the day
+	Shorter int    `json:"shorter"`        // the others in the dataset who died younger
+	Longer  int    `json:"longer"`         // and older
+}
+
+// writeChosen writes the person chosen by random or potd, with everything known about them as
+// person shows it, or as JSON
+func writeChosen(w io.Writer, store outlived.Store, res outlived.Result, date, output string, now time.Time) error {
+	standing, err := outlived.PersonStanding(store, res)
+	if err != nil {
+		return err
+	}
+	if output == OUTPUT_TEXT {
+		if date != "" {
+			fmt.Fprintf(w, "Person of the day, %s:\n\n", date)
+		}
+		return writePerson(w, res, standing, now)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jsonChosen{
+		jsonResult: jsonResult{
+			Person:      res.Person,
+			AgeDays:     res.Days,
+			Age:         outlived.Unpadded(preciseAge(res, now)),
+			Approximate: res.Approximate(),
+			Dataset:     res.Dataset,
+		},
+		Date:    date,
+		Shorter: standing.Shorter,
+		Longer:  standing.Longer,
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// where rate limits are counted, given by -rate-limit-store
+const (
+	RATELIMIT_MEMORY = "memory"
+	RATELIMIT_REDIS  = "redis"
+)
+
+// parseRateLimit reads a rate limit given as a number of requests per second, minute or hour,
+// e.g. '60/m', returning the bucket with a burst of that number unless another is given
+func parseRateLimit(s string, burst int) (outlived.TokenBucket, error) {
+	n, unit, ok := strings.Cut(s, "/")
+	count, err := strconv.Atoi(n)
+	per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+	if !ok || err != nil || count <= 0 || per == 0 {
+		return outlived.TokenBucket{}, fmt.Errorf("invalid rate limit '%s', expected a number of requests per s, m or h, e.g. '60/m'", s)
+	}
+	if burst <= 0 {
+		burst = count
+	}
+	return outlived.TokenBucket{Rate: float64(count) / per.Seconds(), Burst: burst}, nil
+}
+
+// rateLimit rejects requests from clients which exceed the rate limit, each client being
+// identified by their credentials if the API requires them, or else by their address
+type rateLimit struct {
+	limiter    outlived.RateLimiter
+	bucket     outlived.TokenBucket
+	trustProxy bool // whether to take the address from X-Forwarded-For
+}
+
+// wrap limits the rate of requests to the handler, reporting the state of the client's limit
+// in RateLimit-* headers. A nil rateLimit allows every request.
+func (l *rateLimit) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := l.limiter.Take(l.client(r))
+		if err != nil {
+			// better to serve the request than to fail every one while Redis is unavailable
+			log.Printf("rate limit: %v", err)
+			h(w, r)
+			return
+		}
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(l.bucket.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(d.Reset)))
+		if !d.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(d.RetryAfter)))
+			writeError(w, httpError{http.StatusTooManyRequests, fmt.Sprintf("rate limit exceeded, retry in %ds", seconds(d.RetryAfter))})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// client identifies who made the request, for the purposes of the rate limit
+func (l *rateLimit) client(r *http.Request) string {
+	if client := requestClient(r); client != "" {
+		return client
+	}
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if fwd := r.Header.Get("X-Forwarded-For"); l.trustProxy && fwd != "" {
+		// the last address is that added by the proxy, where the others could be made up
+		addrs := strings.Split(fwd, ",")
+		addr = addrs[len(addrs)-1]
+	}
+	return "ip:" + strings.TrimSpace(addr)
+}
+
+// seconds rounds the duration up to a whole number of seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,68 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var recentOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	dob      string
+	count    int
+	precise  bool
+}
+
+var recentCommand = &command{
+	name:    "recent",
+	summary: "List the people someone born on -dob has most recently outlived, and the date on which they did",
+	flags: func(fs *flag.FlagSet) {
+		recentOpts.store = addStoreFlags(fs)
+		recentOpts.clock = addClockFlags(fs, true)
+		recentOpts.profiles = addProfileFlags(fs, true)
+		fs.StringVar(&recentOpts.dob, "dob", cfg.DOB, "Date of birth (YYYY-MM-DD)")
+		fs.IntVar(&recentOpts.count, "count", 10, "Number of people to list")
+		fs.BoolVar(&recentOpts.precise, "precise", false, "Count ages on the calendar, in years, months and days, rather than in years of 365.25 days")
+	},
+	run: runRecent,
+}
+
+func runRecent(fs *flag.FlagSet, args []string) error {
+	dob, err := resolveDOB(recentOpts.dob, recentOpts.profiles, recentOpts.store)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 || dob == "" {
+		fs.Usage()
+		return errors.New("recent: a date of birth must be given with -dob or -profile")
+	}
+	if recentOpts.count < 1 {
+		return errors.New("recent: -count must be at least 1")
+	}
+	store, err := recentOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, recentOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	now, err := recentOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	ms, err := outlived.Recent(store, dob, recentOpts.count, outlived.QueryOptions{Datasets: datasets, Now: now})
+	if err != nil {
+		return err
+	}
+	writeMilestones(os.Stdout, ms, now, len(datasets) > 1, recentOpts.precise)
+	return nil
+}
@@ -0,0 +1,720 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/matthewhegarty/outlived"
+)
+
+var serveOpts struct {
+	store      *storeFlags
+	clock      *clockFlags
+	listen     string
+	cacheTTL   time.Duration
+	cacheStore string
+	apiKeys    bool
+	jwtSecret  string
+	jwtIssuer  string
+	rateLimit  string
+	rateBurst  int
+	rateStore  string
+	trustProxy bool
+	drain      time.Duration
+	profiles   *profileFlags
+
+	slackSecret   string
+	slackPost     string
+	slackProfiles []string
+	slackAt       string
+}
+
+var serveCommand = &command{
+	name:    "serve",
+	summary: "Serve a web dashboard, queries over JSON and GraphQL with an OpenAPI spec, and calendar, RSS and event feeds of milestones, over HTTP",
+	flags: func(fs *flag.FlagSet) {
+		serveOpts.store = addStoreFlags(fs)
+		serveOpts.clock = addClockFlags(fs, false)
+		fs.StringVar(&serveOpts.listen, "listen", ":8080", "Address on which to listen")
+		fs.DurationVar(&serveOpts.cacheTTL, "cache-ttl", time.Hour, "How long responses are cached for, at most, as they are also refreshed by each import and at midnight")
+		fs.StringVar(&serveOpts.cacheStore, "cache-store", "", "Where responses are cached: 'memory', or also 'redis', shared by every server using the same Redis (default redis with the redis backend)")
+		fs.BoolVar(&serveOpts.apiKeys, "api-keys", false, "Require an API key created with 'apikey create' for the API and feeds")
+		// not defaulted from the environment, so that the secret is not shown in the usage text
+		fs.StringVar(&serveOpts.jwtSecret, "jwt-secret", "", "Require a JWT bearer token signed with this HS256 secret, or else an API key if -api-keys is given (env "+ENV_JWT_SECRET+")")
+		fs.StringVar(&serveOpts.jwtIssuer, "jwt-issuer", "", "Only accept JWTs whose 'iss' claim is this")
+		fs.StringVar(&serveOpts.rateLimit, "rate-limit", "", "Limit each API key, or each address if keys aren't required, to this many requests per s, m or h, e.g. '60/m'")
+		fs.IntVar(&serveOpts.rateBurst, "rate-burst", 0, "Number of requests a client may make at once, within the rate limit (default the number per period)")
+		fs.StringVar(&serveOpts.rateStore, "rate-limit-store", "", "Where requests are counted: 'redis', shared by every server using the same Redis, or 'memory' (default redis with the redis backend)")
+		fs.BoolVar(&serveOpts.trustProxy, "trust-proxy", false, "Take clients' addresses from X-Forwarded-For, when behind a reverse proxy")
+		fs.DurationVar(&serveOpts.drain, "shutdown-timeout", 30*time.Second, "How long requests in progress are given to finish on SIGTERM or SIGINT, before the server exits anyway")
+		serveOpts.profiles = addProfileFlags(fs, false)
+		fs.StringVar(&serveOpts.slackSecret, "slack-signing-secret", "", "Answer the Slack slash command at /slack/command, verifying requests with this signing secret of the Slack app (env "+ENV_SLACK_SIGNING_SECRET+")")
+		fs.StringVar(&serveOpts.slackPost, "slack-post", "", "Post the people outlived each day by saved profiles to the Slack channel of this incoming webhook URL")
+		fs.Var(listFlag{&serveOpts.slackProfiles}, "slack-profile", "Comma separated profiles whose milestones are posted with -slack-post (default all)")
+		fs.StringVar(&serveOpts.slackAt, "slack-at", "09:00", "Time of day (HH:MM, in -timezone) at which milestones are posted with -slack-post")
+	},
+	run: runServe,
+}
+
+func runServe(fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		fs.Usage()
+		return errors.New("serve: unexpected arguments")
+	}
+	loc, err := serveOpts.clock.location()
+	if err != nil {
+		return err
+	}
+	store, err := serveOpts.store.connect() // each request has its own -store-timeout
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	auth, closeAuth, err := newAuthenticator()
+	if err != nil {
+		return err
+	}
+	defer closeAuth()
+	limit, closeLimit, err := newRateLimit()
+	if err != nil {
+		return err
+	}
+	defer closeLimit()
+	slack, err := newSlackApp()
+	if err != nil {
+		return err
+	}
+
+	cache := newResponseCache(serveOpts.cacheTTL)
+	switch sharedStore(serveOpts.cacheStore) {
+	case CACHE_MEMORY:
+	case CACHE_REDIS:
+		if cache.shared, err = outlived.NewRedisStore(serveOpts.store.redisConfig()); err != nil {
+			return err
+		}
+		defer cache.shared.Close()
+	default:
+		return fmt.Errorf("unknown cache store '%s'", serveOpts.cacheStore)
+	}
+
+	srv := &server{
+		store:   store,
+		dataset: serveOpts.store.dataset,
+		cache:   cache,
+		events:  newEventHub(),
+		loc:     loc,
+		auth:    auth,
+		limit:   limit,
+		slack:   slack,
+		timeout: serveOpts.store.timeout,
+	}
+	go srv.scheduleEvents()
+	if slack != nil && slack.webhook != "" {
+		go srv.scheduleSlackPosts()
+	}
+	return srv.listen(serveOpts.listen, serveOpts.drain)
+}
+
+// listen serves HTTP on the address until a SIGTERM or SIGINT, then stops accepting
+// connections and waits up to the timeout for those open to go idle. Event streams are closed,
+// so that their clients reconnect to another server.
+func (s *server) listen(addr string, timeout time.Duration) error {
+	hs := &http.Server{Addr: addr, Handler: s.routes()}
+	hs.RegisterOnShutdown(s.events.close)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s", addr)
+		errs <- hs.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		log.Printf("%v received, shutting down", sig)
+	}
+	signal.Stop(stop) // a second signal kills the server at once
+	s.draining.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := hs.Shutdown(ctx); err != nil {
+		return fmt.Errorf("serve: shutting down: %v", err)
+	}
+	log.Printf("shut down")
+	return nil
+}
+
+// server answers HTTP requests from a store
+type server struct {
+	mu      sync.Mutex // serialises use of the store, which is not safe for concurrent use
+	store   outlived.Store
+	dataset string // the datasets queried unless a request names others
+	cache   *responseCache
+	events  *eventHub
+	loc     *time.Location // the zone in which today's date is taken
+	auth    *authenticator // nil unless the API requires credentials
+	limit   *rateLimit     // nil unless requests are rate limited
+	slack   *slackApp      // nil unless Slack is integrated
+	schema  *graphql.Schema
+	spec    []byte        // the OpenAPI spec, as JSON
+	timeout time.Duration // allowed for each request's work with the store, unless zero
+
+	draining atomic.Bool // set once the server is shutting down
+}
+
+// newAuthenticator returns the authenticator given by -api-keys and -jwt-secret, or nil if the
+// API is open to all, along with a function closing the key store
+func newAuthenticator() (*authenticator, func() error, error) {
+	secret := serveOpts.jwtSecret
+	if secret == "" {
+		secret = os.Getenv(ENV_JWT_SECRET)
+	}
+	if !serveOpts.apiKeys && secret == "" {
+		return nil, func() error { return nil }, nil
+	}
+	a := &authenticator{jwtIssuer: serveOpts.jwtIssuer}
+	if secret != "" {
+		a.jwtSecret = []byte(secret)
+	}
+	if !serveOpts.apiKeys {
+		return a, func() error { return nil }, nil
+	}
+	ks, err := outlived.NewRedisStore(serveOpts.store.redisConfig())
+	if err != nil {
+		return nil, nil, err
+	}
+	a.keys = ks
+	return a, ks.Close, nil
+}
+
+// newRateLimit returns the rate limit given by -rate-limit, or nil if there is none, along with a
+// function closing the limiter
+func newRateLimit() (*rateLimit, func() error, error) {
+	if serveOpts.rateLimit == "" {
+		return nil, func() error { return nil }, nil
+	}
+	bucket, err := parseRateLimit(serveOpts.rateLimit, serveOpts.rateBurst)
+	if err != nil {
+		return nil, nil, err
+	}
+	l := &rateLimit{bucket: bucket, trustProxy: serveOpts.trustProxy}
+	switch sharedStore(serveOpts.rateStore) {
+	case RATELIMIT_MEMORY:
+		l.limiter = outlived.NewMemoryRateLimiter(bucket)
+		return l, func() error { return nil }, nil
+	case RATELIMIT_REDIS:
+		rl, err := outlived.NewRedisRateLimiter(serveOpts.store.redisConfig(), bucket)
+		if err != nil {
+			return nil, nil, err
+		}
+		l.limiter = rl
+		return l, rl.Close, nil
+	}
+	return nil, nil, fmt.Errorf("unknown rate limit store '%s'", serveOpts.rateStore)
+}
+
+// sharedStore returns where state shared between servers is kept, given by a flag which is
+// either 'memory' or 'redis', by default 'redis' with the redis backend
+func sharedStore(flag string) string {
+	if flag != "" {
+		return flag
+	}
+	if serveOpts.store.backend == "redis" {
+		return "redis"
+	}
+	return "memory"
+}
+
+func (s *server) routes() http.Handler {
+	s.schema = s.newGraphQLSchema()
+	endpoints := s.endpoints()
+	s.spec = newOpenAPISpec(endpoints, s.auth.securitySchemes(), s.limit != nil)
+	mux := http.NewServeMux()
+	for _, e := range endpoints {
+		h := allowMethods(e.methods, e.handler)
+		if e.content != "text/event-stream" {
+			h = s.timed(h) // streams stay open, and look up milestones in their own time
+		}
+		if !e.public {
+			h = s.auth.wrap(s.limit.wrap(h))
+		}
+		mux.HandleFunc(e.path, traced(e.path, h))
+	}
+	mux.HandleFunc("/", traced("/", webHandler().ServeHTTP))
+	return mux
+}
+
+// timed wraps a handler so that its work with the store is abandoned once -store-timeout has
+// passed, answering 503 Service Unavailable
+func (s *server) timed(h http.HandlerFunc) http.HandlerFunc {
+	if s.timeout <= 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+		defer cancel()
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// endpoint is an API route, with what the OpenAPI spec says of it
+type endpoint struct {
+	path     string
+	methods  []string // those accepted, GET alone if empty
+	summary  string
+	params   []apiParam
+	body     interface{} // a value of the type of a JSON request body, if there is one
+	content  string      // the content type of the response
+	response interface{} // a value of the type of a JSON response, if it is documented
+	public   bool        // whether it can be used without credentials
+	handler  http.HandlerFunc
+}
+
+// apiParam is a query parameter of an endpoint
+type apiParam struct {
+	name     string
+	kind     string // "string", "integer" or "boolean"
+	desc     string
+	required bool
+	enum     []string
+}
+
+// Parameters shared by several endpoints
+var (
+	PARAM_DOB     = apiParam{name: "dob", kind: "string", desc: "Date of birth (YYYY-MM-DD)", required: true}
+	PARAM_DATASET = apiParam{name: "dataset", kind: "string", desc: "Datasets to query, separated by commas, or 'all'"}
+)
+
+func countParam(def int) apiParam {
+	return apiParam{name: "count", kind: "integer", desc: fmt.Sprintf("Number of people to return (default %d)", def)}
+}
+
+// endpoints lists the API, both to route requests and to document it in the OpenAPI spec
+func (s *server) endpoints() []endpoint {
+	endpoints := []endpoint{
+		{
+			path:    "/api/query",
+			summary: "Who died within 'days' of the age of someone born on 'dob'",
+			params: []apiParam{
+				PARAM_DOB, PARAM_DATASET,
+				{name: "days", kind: "integer", desc: "Number of days either side of the age (default 365)"},
+				{name: "sort", kind: "string", desc: "Order of the results, by age by default", enum: []string{outlived.SORT_AGE, outlived.SORT_NAME, outlived.SORT_DEATH_DATE}},
+				{name: "desc", kind: "boolean", desc: "Whether to reverse the order"},
+				{name: "limit", kind: "integer", desc: "Number of results to return, or all if 0"},
+				{name: "offset", kind: "integer", desc: "Number of results to skip"},
+			},
+			content:  "application/json",
+			response: jsonReport{},
+			handler:  s.cached(s.handleQuery),
+		},
+		{
+			path:     "/api/next",
+			summary:  "The next people someone born on 'dob' will outlive",
+			params:   []apiParam{PARAM_DOB, PARAM_DATASET, countParam(10)},
+			content:  "application/json",
+			response: jsonMilestones{},
+			handler:  s.cached(s.handleNext),
+		},
+		{
+			path:    "/api/stats",
+			summary: "A summary of the ages at death in the datasets",
+			params: []apiParam{
+				PARAM_DATASET,
+				{name: "bin", kind: "integer", desc: "Width of the bins of the distribution, in years (default 5)"},
+			},
+			content:  "application/json",
+			response: jsonStats{},
+			handler:  s.cached(s.handleStats),
+		},
+		{
+			path:    "/graphql",
+			methods: []string{http.MethodGet, http.MethodPost},
+			summary: "GraphQL queries over people, datasets, stats and outlived; the schema can be fetched by introspection",
+			params: []apiParam{
+				{name: "query", kind: "string", desc: "The query, if sent with GET"},
+				{name: "operationName", kind: "string", desc: "The operation to run, if the query has several"},
+				{name: "variables", kind: "string", desc: "The variables, as a JSON object"},
+			},
+			body:    graphqlRequest{},
+			content: "application/json",
+			handler: s.handleGraphQL,
+		},
+		{
+			path:    "/feed/ics",
+			summary: "A calendar of the upcoming milestones of someone born on 'dob'",
+			params:  []apiParam{PARAM_DOB, PARAM_DATASET, {name: "count", kind: "integer", desc: "Number of milestones (default all)"}},
+			content: "text/calendar",
+			handler: s.cached(s.handleICS),
+		},
+		{
+			path:    "/feed/rss",
+			summary: "An RSS feed of the people most recently outlived by someone born on 'dob'",
+			params:  []apiParam{PARAM_DOB, PARAM_DATASET, countParam(20)},
+			content: "application/rss+xml",
+			handler: s.cached(s.handleRSS),
+		},
+		{
+			path:    "/events",
+			summary: "A stream of server-sent events, an 'outlived' event being sent as someone born on 'dob' outlives each person; send Last-Event-ID to be sent those missed",
+			params:  []apiParam{PARAM_DOB, PARAM_DATASET},
+			content: "text/event-stream",
+			handler: s.handleEvents,
+		},
+		{
+			path:    "/healthz",
+			summary: "Liveness: answers ok whenever the process is running",
+			content: "text/plain",
+			public:  true,
+			handler: s.handleHealthz,
+		},
+		{
+			path:     "/readyz",
+			summary:  "Readiness: whether the store is reachable and holds the datasets served, answered with 503 when not or while shutting down",
+			content:  "application/json",
+			response: jsonReadiness{},
+			public:   true,
+			handler:  s.handleReadyz,
+		},
+		{
+			path:    "/openapi.json",
+			summary: "This specification",
+			content: "application/json",
+			public:  true,
+			handler: s.handleOpenAPI,
+		},
+	}
+	if s.slack != nil && s.slack.secret != "" {
+		endpoints = append(endpoints, endpoint{
+			path:    "/slack/command",
+			methods: []string{http.MethodPost},
+			summary: "The Slack slash command '/outlived DATE', answered in Block Kit; requests must be signed with the Slack app's signing secret",
+			content: "application/json",
+			public:  true, // verified by its signature instead
+			handler: s.handleSlackCommand,
+		})
+	}
+	return endpoints
+}
+
+// allowMethods rejects requests made with methods other than those given, or GET if none are
+func allowMethods(methods []string, h http.HandlerFunc) http.HandlerFunc {
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range methods {
+			if r.Method == m || r.Method == http.MethodHead && m == http.MethodGet {
+				h(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		writeError(w, httpError{http.StatusMethodNotAllowed, "method not allowed"})
+	}
+}
+
+// httpError is an error with the HTTP status to report it with
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e httpError) Error() string { return e.msg }
+
+func badRequest(format string, args ...interface{}) error {
+	return httpError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+// writeError reports the error to the client, logging it unless it was the client's fault
+func writeError(w http.ResponseWriter, err error) {
+	var he httpError
+	switch {
+	case errors.As(err, &he):
+	case errors.Is(err, context.DeadlineExceeded):
+		he = httpError{http.StatusServiceUnavailable, "timed out"}
+	case errors.Is(err, context.Canceled): // the client has gone, so there's no one to tell
+		he = httpError{http.StatusServiceUnavailable, "cancelled"}
+	default:
+		log.Print(err)
+		he = httpError{http.StatusInternalServerError, "internal error"}
+	}
+	http.Error(w, he.msg, he.status)
+}
+
+// queryParams holds the parameters common to the query and feed endpoints
+type queryParams struct {
+	dob   string
+	count int
+	opts  outlived.QueryOptions
+}
+
+// parseQueryParams reads 'dob', 'dataset' and 'count' from the request, resolving the
+// datasets against the store. The caller must hold s.mu.
+func (s *server) parseQueryParams(r *http.Request, defaultCount int) (queryParams, error) {
+	q := r.URL.Query()
+	p := queryParams{dob: q.Get("dob"), count: defaultCount}
+	if err := outlived.ValidateDate(p.dob); err != nil {
+		return p, badRequest("dob: %v", err)
+	}
+	if c := q.Get("count"); c != "" {
+		n, err := strconv.Atoi(c)
+		if err != nil || n <= 0 {
+			return p, badRequest("count must be a positive number")
+		}
+		p.count = n
+	}
+	datasets, err := s.requestDatasets(r)
+	if err != nil {
+		return p, err
+	}
+	p.opts = outlived.QueryOptions{Datasets: datasets, Now: time.Now().In(s.loc)}
+	return p, nil
+}
+
+// storeFor returns a view of the store making its operations within the context, so that
+// they are traced as part of the request
+func (s *server) storeFor(ctx context.Context) outlived.Store {
+	return outlived.StoreWithContext(ctx, s.store)
+}
+
+// requestDatasets resolves the datasets named by 'dataset' in the request, or those served by
+// default. The caller must hold s.mu.
+func (s *server) requestDatasets(r *http.Request) ([]string, error) {
+	dataset := s.dataset
+	if d := r.URL.Query().Get("dataset"); d != "" {
+		dataset = d
+	}
+	datasets, err := outlived.ResolveDatasets(s.storeFor(r.Context()), dataset)
+	if err != nil {
+		return nil, badRequest("dataset: %v", err)
+	}
+	return datasets, nil
+}
+
+// handleQuery answers '/api/query?dob=YYYY-MM-DD&days=365' with the JSON query output, sorted
+// by 'sort' and 'desc' and paged by 'limit' and 'offset' if given, with the total number of
+// results also in X-Total-Count
+func (s *server) handleQuery(r *http.Request) (*cachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.parseQueryParams(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	p.opts.Days = 365
+	if d := r.URL.Query().Get("days"); d != "" {
+		if p.opts.Days, err = strconv.Atoi(d); err != nil || p.opts.Days < 0 {
+			return nil, badRequest("days must be a number of days")
+		}
+	}
+	for name, n := range map[string]*int{"limit": &p.opts.Limit, "offset": &p.opts.Offset} {
+		if v := r.URL.Query().Get(name); v != "" {
+			if *n, err = strconv.Atoi(v); err != nil || *n < 0 {
+				return nil, badRequest("%s must be a number of results", name)
+			}
+		}
+	}
+	p.opts.Sort = r.URL.Query().Get("sort")
+	if err := outlived.ValidateSort(p.opts.Sort); err != nil {
+		return nil, badRequest("sort: %v", err)
+	}
+	if d := r.URL.Query().Get("desc"); d != "" {
+		if p.opts.Desc, err = strconv.ParseBool(d); err != nil {
+			return nil, badRequest("desc must be true or false")
+		}
+	}
+	userAge, results, total, err := outlived.Query(s.storeFor(r.Context()), p.dob, p.opts)
+	if err != nil {
+		return nil, err
+	}
+	ranking, err := outlived.Rank(s.storeFor(r.Context()), userAge, p.opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = writeJSON(&buf, queryReport{
+		BirthDate: p.dob,
+		UserAge:   userAge,
+		Results:   results,
+		Total:     total,
+		Offset:    p.opts.Offset,
+		Ranking:   ranking,
+		Datasets:  p.opts.Datasets,
+		Labelled:  len(p.opts.Datasets) > 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cachedResponse{
+		contentType: "application/json",
+		header:      map[string]string{"X-Total-Count": strconv.Itoa(total)},
+		body:        buf.Bytes(),
+	}, nil
+}
+
+type jsonMilestones struct {
+	BirthDate  string          `json:"birth_date"`
+	AgeDays    int             `json:"age_days"`
+	Milestones []jsonMilestone `json:"milestones"`
+}
+
+// handleNext answers '/api/next?dob=YYYY-MM-DD&count=10' with the next people to be outlived,
+// and the dates on which they are
+func (s *server) handleNext(r *http.Request) (*cachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.parseQueryParams(r, 10)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := outlived.Next(s.storeFor(r.Context()), p.dob, p.count, p.opts)
+	if err != nil {
+		return nil, err
+	}
+	doc := jsonMilestones{BirthDate: p.dob, Milestones: make([]jsonMilestone, len(ms))}
+	if doc.AgeDays, err = outlived.AgeInDays(p.dob, p.opts.Now.Format(outlived.DATE_FMT)); err != nil {
+		return nil, err
+	}
+	for i := range ms {
+		doc.Milestones[i] = *newJSONMilestone(&ms[i])
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedResponse{contentType: "application/json", body: body}, nil
+}
+
+type jsonStats struct {
+	Datasets    []string     `json:"datasets"`
+	Count       int          `json:"count"`
+	MinDays     int          `json:"min_days"`
+	MaxDays     int          `json:"max_days"`
+	MeanDays    float64      `json:"mean_days"`
+	MedianDays  float64      `json:"median_days"`
+	StdDevYears float64      `json:"std_dev_years"`
+	Buckets     []jsonBucket `json:"buckets"`
+}
+
+type jsonBucket struct {
+	From  int `json:"from"` // in years, inclusive
+	To    int `json:"to"`   // in years, exclusive
+	Count int `json:"count"`
+}
+
+// handleStats answers '/api/stats?bin=5' with a summary of the ages at death in the datasets,
+// and their distribution in bins of 'bin' years
+func (s *server) handleStats(r *http.Request) (*cachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	datasets, err := s.requestDatasets(r)
+	if err != nil {
+		return nil, err
+	}
+	bin := 5
+	if b := r.URL.Query().Get("bin"); b != "" {
+		if bin, err = strconv.Atoi(b); err != nil || bin <= 0 {
+			return nil, badRequest("bin must be a positive number of years")
+		}
+	}
+	stats, err := outlived.DatasetStats(s.storeFor(r.Context()), datasets, outlived.Filter{})
+	if err != nil {
+		return nil, err
+	}
+	doc := jsonStats{
+		Datasets:    datasets,
+		Count:       stats.Count,
+		MinDays:     stats.Min,
+		MaxDays:     stats.Max,
+		MeanDays:    stats.Mean,
+		MedianDays:  stats.Median,
+		StdDevYears: stats.StdDevYears(),
+		Buckets:     []jsonBucket{},
+	}
+	for _, b := range stats.Buckets(bin) {
+		doc.Buckets = append(doc.Buckets, jsonBucket{From: b.From, To: b.To, Count: b.Count})
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedResponse{contentType: "application/json", body: body}, nil
+}
+
+// writeJSONResponse writes the document as the JSON response
+func writeJSONResponse(w http.ResponseWriter, doc interface{}) {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// handleICS answers '/feed/ics?dob=YYYY-MM-DD' with a calendar of the upcoming milestones
+func (s *server) handleICS(r *http.Request) (*cachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.parseQueryParams(r, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := outlived.Next(s.storeFor(r.Context()), p.dob, p.count, p.opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := outlived.WriteICal(&buf, p.dob, ms, p.opts.Now); err != nil {
+		return nil, err
+	}
+	return &cachedResponse{contentType: "text/calendar; charset=utf-8", body: buf.Bytes()}, nil
+}
+
+// handleRSS answers '/feed/rss?dob=YYYY-MM-DD' with a feed of the people most recently outlived
+func (s *server) handleRSS(r *http.Request) (*cachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.parseQueryParams(r, 20)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := outlived.Recent(s.storeFor(r.Context()), p.dob, p.count, p.opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := outlived.WriteRSS(&buf, p.dob, ms, requestURL(r), p.opts.Now); err != nil {
+		return nil, err
+	}
+	return &cachedResponse{contentType: "application/rss+xml; charset=utf-8", body: buf.Bytes()}, nil
+}
+
+// requestURL reconstructs the absolute URL of the request
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
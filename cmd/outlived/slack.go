@@ -0,0 +1,174 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// ENV_SLACK_SIGNING_SECRET holds the signing secret of the Slack app, so that it need not be
+// given on the command line
+const ENV_SLACK_SIGNING_SECRET = "OUTLIVED_SLACK_SIGNING_SECRET"
+
+// SLACK_MAX_BODY is the largest slash command request read, far beyond any Slack sends
+const SLACK_MAX_BODY = 64 << 10
+
+// slackApp is the Slack integration of the server: a slash command verified with the app's
+// signing secret, and a daily post of the milestones of saved profiles to a channel
+type slackApp struct {
+	secret   string    // if empty, there is no slash command
+	webhook  string    // the incoming webhook posting to the channel, if there are posts
+	profiles []string  // whose milestones are posted, or 'all'
+	at       time.Time // the time of day at which they are posted
+}
+
+// newSlackApp returns the Slack integration given by the -slack flags, or nil if there is none
+func newSlackApp() (*slackApp, error) {
+	secret := serveOpts.slackSecret
+	if secret == "" {
+		secret = os.Getenv(ENV_SLACK_SIGNING_SECRET)
+	}
+	if secret == "" && serveOpts.slackPost == "" {
+		return nil, nil
+	}
+	at, err := time.Parse("15:04", serveOpts.slackAt)
+	if err != nil {
+		return nil, fmt.Errorf("serve: invalid -slack-at time '%s', expected HH:MM", serveOpts.slackAt)
+	}
+	profiles := serveOpts.slackProfiles
+	if len(profiles) == 0 {
+		profiles = []string{outlived.DATASETS_ALL}
+	}
+	return &slackApp{secret: secret, webhook: serveOpts.slackPost, profiles: profiles, at: at}, nil
+}
+
+// slackHelp is the reply to '/outlived' given nothing, or 'help', or what it can't read
+const slackHelp = "Give a date of birth, such as `/outlived 1990-09-25` or `/outlived 25 Sep 1990`, to see how many people it has outlived, and who is next."
+
+// handleSlackCommand answers a Slack slash command such as '/outlived 1990-09-25', posted as a
+// form signed with the app's signing secret, with the overview of that date of birth in Block
+// Kit, seen only by whoever sent it. Requests not signed by Slack are refused.
+func (s *server) handleSlackCommand(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, SLACK_MAX_BODY))
+	if err != nil {
+		writeError(w, badRequest("unreadable request"))
+		return
+	}
+	if err := outlived.VerifySlackRequest(s.slack.secret, r.Header, body, time.Now()); err != nil {
+		writeError(w, httpError{http.StatusUnauthorized, err.Error()})
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, badRequest("unreadable form"))
+		return
+	}
+	msg, err := s.slackReply(r.Context(), strings.TrimSpace(form.Get("text")))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	msg.ResponseType = outlived.SLACK_EPHEMERAL
+	writeJSONResponse(w, msg)
+}
+
+// slackReply returns the answer to the text of a slash command. Mistakes are answered in the
+// reply, as Slack shows the user nothing of a failed request but that it failed.
+func (s *server) slackReply(ctx context.Context, text string) (outlived.SlackMessage, error) {
+	now := time.Now().In(s.loc)
+	if text == "" || strings.EqualFold(text, "help") {
+		return outlived.SlackMessage{Text: slackHelp}, nil
+	}
+	dob, err := outlived.ParseUserDate(text, now)
+	if err != nil {
+		return outlived.SlackMessage{Text: fmt.Sprintf("I can't read that date of birth: %v.\n%s", err, slackHelp)}, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store := s.storeFor(ctx)
+	datasets, err := outlived.ResolveDatasets(store, s.dataset)
+	if err != nil {
+		return outlived.SlackMessage{}, err
+	}
+	o, err := outlived.OverviewOf(store, dob, outlived.QueryOptions{Datasets: datasets, Now: now})
+	if err != nil {
+		return outlived.SlackMessage{}, err
+	}
+	return o.SlackBlocks(), nil
+}
+
+// scheduleSlackPosts posts the milestones passed each day by the saved profiles to the channel,
+// at the time of day given by -slack-at. It never returns.
+func (s *server) scheduleSlackPosts() {
+	for {
+		time.Sleep(time.Until(nextCheck(time.Now().In(s.loc), s.slack.at)))
+		if err := s.postSlackMilestones(time.Now().In(s.loc)); err != nil {
+			log.Printf("slack: %v", err)
+		}
+	}
+}
+
+// postSlackMilestones posts a message for each person outlived today by each of the profiles.
+// Where servers share a store, the day's posts are claimed by one of them, which makes them all.
+func (s *server) postSlackMilestones(now time.Time) error {
+	profiles, err := selectProfiles(serveOpts.profiles, serveOpts.store, s.slack.profiles)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	notifications, err := s.milestonesToday(profiles, now)
+	s.mu.Unlock()
+	if err != nil || len(notifications) == 0 {
+		return err
+	}
+	if c, ok := s.store.(outlived.Claimer); ok {
+		claimed, err := c.Claim("slack:"+now.Format(outlived.DATE_FMT), 36*time.Hour)
+		if err != nil || !claimed {
+			return err
+		}
+	}
+	var failures []string
+	for _, n := range notifications {
+		if err := outlived.PostSlackMessage(s.slack.webhook, n.SlackBlocks()); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// milestonesToday returns the notifications of the people the profiles outlive on the day of
+// now. The caller must hold s.mu.
+func (s *server) milestonesToday(profiles []outlived.Profile, now time.Time) ([]outlived.Notification, error) {
+	datasets, err := outlived.ResolveDatasets(s.store, s.dataset)
+	if err != nil {
+		return nil, err
+	}
+	yesterday := now.AddDate(0, 0, -1).Format(outlived.DATE_FMT)
+	var notifications []outlived.Notification
+	for _, p := range profiles {
+		// those outlived after yesterday, which is to say today
+		sub := outlived.Subscription{BirthDate: p.BirthDate, Notified: yesterday}
+		due, err := outlived.DueMilestones(s.store, sub, outlived.QueryOptions{Datasets: datasets, Now: now})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range due {
+			notifications = append(notifications, outlived.Notification{Profile: p.Name, BirthDate: p.BirthDate, Milestone: m})
+		}
+	}
+	return notifications, nil
+}
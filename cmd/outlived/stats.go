@@ -0,0 +1,155 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var statsOpts struct {
+	store     *storeFlags
+	clock     *clockFlags
+	histogram bool
+	binYears  int
+	query     string
+	filter    outlived.Filter
+	by        string
+}
+
+var statsCommand = &command{
+	name:    "stats",
+	summary: "Summarise the ages at death in a dataset, with their distribution by decade",
+	flags: func(fs *flag.FlagSet) {
+		statsOpts.store = addStoreFlags(fs)
+		statsOpts.clock = addClockFlags(fs, true)
+		fs.BoolVar(&statsOpts.histogram, "histogram", false, "Draw a bar chart of the ages at death")
+		fs.IntVar(&statsOpts.binYears, "bin", 5, "Width of each histogram bar, in years")
+		fs.StringVar(&statsOpts.filter.Cause, "cause", "", "Only count people who died of this, e.g. 'heart attack'")
+		fs.StringVar(&statsOpts.filter.Tag, "tag", "", "Only count people with this tag, e.g. 'grammy-winner'")
+		addYearFilterFlags(fs, &statsOpts.filter)
+		fs.StringVar(&statsOpts.by, "by", "", "Summarise each value of this field apart: 'cause', 'occupation', 'nationality', 'genre' or 'tags'")
+		fs.StringVar(&statsOpts.query, "query", "", "Mark the age of someone born on this date (e.g. 1990-09-25, '25 Sep 1990' or '30 years ago') on the histogram")
+	},
+	run: runStats,
+}
+
+func runStats(fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		fs.Usage()
+		return errors.New("stats: unexpected arguments")
+	}
+	if statsOpts.binYears <= 0 {
+		return errors.New("stats: -bin must be a positive number of years")
+	}
+	userAge := -1
+	if statsOpts.query != "" {
+		now, err := statsOpts.clock.now()
+		if err != nil {
+			return err
+		}
+		if statsOpts.query, err = outlived.ParseUserDate(statsOpts.query, now); err != nil {
+			return fmt.Errorf("stats: %v", err)
+		}
+		if userAge, err = outlived.AgeInDays(statsOpts.query, now.Format(outlived.DATE_FMT)); err != nil {
+			return err
+		}
+	}
+	store, err := statsOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, statsOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	if statsOpts.by != "" {
+		if statsOpts.histogram {
+			return errors.New("stats: -by and -histogram can't be used together")
+		}
+		groups, err := outlived.GroupedStats(store, datasets, statsOpts.filter, statsOpts.by)
+		if err != nil {
+			return fmt.Errorf("stats: %v", err)
+		}
+		if len(groups) == 0 {
+			return fmt.Errorf("stats: no records in %s", strings.Join(datasets, ", "))
+		}
+		writeGroupedStats(os.Stdout, statsOpts.by, groups)
+		return nil
+	}
+	stats, err := outlived.DatasetStats(store, datasets, statsOpts.filter)
+	if err != nil {
+		return err
+	}
+	if stats.Count == 0 {
+		return fmt.Errorf("stats: no records in %s", strings.Join(datasets, ", "))
+	}
+	if statsOpts.histogram {
+		writeHistogram(os.Stdout, stats.Buckets(statsOpts.binYears), userAge)
+		return nil
+	}
+	writeStats(os.Stdout, strings.Join(datasets, ", "), stats)
+	return nil
+}
+
+func writeStats(w io.Writer, name string, s outlived.Stats) {
+	fmt.Fprintf(w, "Dataset:   %s\n", name)
+	fmt.Fprintf(w, "Records:   %d\n", s.Count)
+	fmt.Fprintf(w, "Youngest:  %s\n", outlived.FormatAgeInYearsAndDays(s.Min))
+	fmt.Fprintf(w, "Mean:      %s\n", outlived.FormatAgeInYearsAndDays(int(s.Mean)))
+	fmt.Fprintf(w, "Median:    %s\n", outlived.FormatAgeInYearsAndDays(int(s.Median)))
+	fmt.Fprintf(w, "Oldest:    %s\n", outlived.FormatAgeInYearsAndDays(s.Max))
+	fmt.Fprintf(w, "Std dev:   %.1f years\n", s.StdDevYears())
+
+	fmt.Fprintf(w, "\nAge at death by decade:\n")
+	for _, b := range s.Buckets(10) {
+		fmt.Fprintf(w, "    %3d-%-3d  %6d  %5.1f%%\n", b.From, b.To-1, b.Count, float64(b.Count)*100/float64(s.Count))
+	}
+}
+
+// writeGroupedStats writes a row for each group: how many are in it, their share of everyone, and
+// their mean and median ages at death
+func writeGroupedStats(w io.Writer, by string, groups []outlived.GroupStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tPEOPLE\tSHARE\tMEAN AGE\tMEDIAN AGE\n", strings.ToUpper(by))
+	for _, g := range groups {
+		value := g.Value
+		if value == "" {
+			value = "(unknown)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%s\t%s\n", value, g.Count, g.Share,
+			formatAge(int(g.Mean)), formatAge(int(g.Median)))
+	}
+	tw.Flush()
+}
+
+// HISTOGRAM_WIDTH is the length of the longest bar drawn by writeHistogram
+const HISTOGRAM_WIDTH = 50
+
+// writeHistogram draws a bar for each bucket, marking the one holding userAge (in days) with an
+// arrow unless userAge is negative
+func writeHistogram(w io.Writer, buckets []outlived.Bucket, userAge int) {
+	most := 0
+	for _, b := range buckets {
+		if b.Count > most {
+			most = b.Count
+		}
+	}
+	userYears := outlived.AgeInYears(userAge)
+	for _, b := range buckets {
+		bar := strings.Repeat("#", (b.Count*HISTOGRAM_WIDTH+most-1)/most)
+		if userAge >= 0 && userYears >= b.From && userYears < b.To {
+			bar = fmt.Sprintf("%-*s <-- you (%s)", HISTOGRAM_WIDTH, bar, formatAge(userAge))
+		}
+		fmt.Fprintf(w, "%3d-%-3d %6d | %s\n", b.From, b.To-1, b.Count, bar)
+	}
+}
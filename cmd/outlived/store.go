@@ -0,0 +1,153 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+// storeFlags holds the options which select and connect to a storage backend
+type storeFlags struct {
+	dataset       string
+	backend       string
+	dbPath        string
+	redis         outlived.RedisConfig
+	redisPassword string
+	timeout       time.Duration
+
+	ctx    context.Context // within which the store is used, once opened
+	cancel context.CancelFunc
+}
+
+func addStoreFlags(fs *flag.FlagSet) *storeFlags {
+	f := &storeFlags{redis: cfg.redisConfig()}
+	fs.StringVar(&f.dataset, "dataset", orDefault(cfg.Dataset, outlived.DB_NAME), "Name of the dataset; queries accept a comma separated list, or 'all'")
+	fs.StringVar(&f.backend, "backend", orDefault(cfg.Backend, "redis"), "Storage backend to use: 'redis' or 'sqlite'")
+	fs.StringVar(&f.dbPath, "db", orDefault(cfg.DB, "outlived.db"), "Path to the database file when using the sqlite backend")
+	fs.StringVar(&f.redis.Addr, "redis-addr", f.redis.Addr, "Address of the Redis instance (env "+outlived.ENV_REDIS_ADDR+")")
+	fs.IntVar(&f.redis.DB, "redis-db", f.redis.DB, "Redis database index (env "+outlived.ENV_REDIS_DB+")")
+	// not defaulted from the environment, so that the password is not shown in the usage text
+	fs.StringVar(&f.redisPassword, "redis-password", "", "Redis password (env "+outlived.ENV_REDIS_PASSWORD+")")
+	fs.BoolVar(&f.redis.TLS, "redis-tls", f.redis.TLS, "Connect to Redis over TLS (env "+outlived.ENV_REDIS_TLS+")")
+	fs.StringVar(&f.redis.MasterName, "redis-master", f.redis.MasterName, "Name of the master to look up through Redis Sentinel (env "+outlived.ENV_REDIS_MASTER+")")
+	fs.Var(listFlag{&f.redis.SentinelAddrs}, "redis-sentinels", "Comma separated Sentinel addresses, used with -redis-master (env "+outlived.ENV_REDIS_SENTINELS+")")
+	fs.IntVar(&f.redis.PoolSize, "redis-pool-size", f.redis.PoolSize, "Most connections to Redis held open at once; 0 for the default of "+strconv.Itoa(outlived.DEFAULT_REDIS_POOL_SIZE)+" (env "+outlived.ENV_REDIS_POOL_SIZE+")")
+	fs.IntVar(&f.redis.Retries, "redis-retries", f.redis.Retries, "Times a Redis command failing with a broken connection, or while Redis restarts, is retried; 0 for the default of "+strconv.Itoa(outlived.DEFAULT_REDIS_RETRIES)+", -1 for none (env "+outlived.ENV_REDIS_RETRIES+")")
+	fs.DurationVar(&f.redis.RetryBackoff, "redis-retry-backoff", f.redis.RetryBackoff, "Wait before the first retry of a Redis command, doubling for each after; 0 for the default of "+outlived.DEFAULT_REDIS_RETRY_BACKOFF.String()+" (env "+outlived.ENV_REDIS_RETRY_BACKOFF+")")
+	fs.DurationVar(&f.timeout, "store-timeout", 0, "Time allowed for the command's work with the store, or by serve for each request; 0 for no limit")
+	return f
+}
+
+// open the storage backend selected on the command line, to be used within f.context()
+func (f *storeFlags) open() (outlived.Store, error) {
+	store, err := f.connect()
+	if err != nil {
+		return nil, err
+	}
+	return outlived.StoreWithContext(f.context(), store), nil
+}
+
+// context returns the context within which the command uses the store, which ends once
+// -store-timeout has passed
+func (f *storeFlags) context() context.Context {
+	if f.ctx == nil {
+		f.ctx, f.cancel = context.Background(), func() {}
+		if f.timeout > 0 {
+			f.ctx, f.cancel = context.WithTimeout(f.ctx, f.timeout)
+		}
+	}
+	return f.ctx
+}
+
+// connect to the storage backend selected on the command line, with no time limit
+func (f *storeFlags) connect() (outlived.Store, error) {
+	switch f.backend {
+	case "redis":
+		return outlived.NewRedisStore(f.redisConfig())
+	case "sqlite":
+		return outlived.NewSQLiteStore(f.dbPath)
+	}
+	return nil, fmt.Errorf("unknown backend '%s'", f.backend)
+}
+
+// redisConfig returns the Redis connection details, with the password given by -redis-password
+func (f *storeFlags) redisConfig() outlived.RedisConfig {
+	rc := f.redis
+	if f.redisPassword != "" {
+		rc.Password = f.redisPassword
+	}
+	return rc
+}
+
+// listFlag is a flag.Value holding a comma separated list
+type listFlag struct {
+	list *[]string
+}
+
+func (f listFlag) String() string {
+	if f.list == nil {
+		return ""
+	}
+	return strings.Join(*f.list, ",")
+}
+
+func (f listFlag) Set(s string) error {
+	*f.list = outlived.SplitList(s)
+	return nil
+}
+
+// addYearFilterFlags adds the options selecting people by the years of their death and birth
+func addYearFilterFlags(fs *flag.FlagSet, f *outlived.Filter) {
+	fs.Var(yearFlag{&f.DiedAfter}, "died-after", "Only show people who died in this year or later, e.g. 2000")
+	fs.Var(yearFlag{&f.DiedBefore}, "died-before", "Only show people who died before this year, e.g. 2010")
+	fs.Var(decadeFlag{f}, "born-decade", "Only show people born in this decade, e.g. '1940s'")
+}
+
+// yearFlag sets a bound on years of a filter, which is otherwise left without one
+type yearFlag struct {
+	year **int
+}
+
+func (f yearFlag) String() string {
+	if f.year == nil || *f.year == nil {
+		return ""
+	}
+	return strconv.Itoa(**f.year)
+}
+
+func (f yearFlag) Set(s string) error {
+	year, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid year '%s'", s)
+	}
+	*f.year = &year
+	return nil
+}
+
+// decadeFlag sets the years of birth of a filter to those of a decade
+type decadeFlag struct {
+	filter *outlived.Filter
+}
+
+func (f decadeFlag) String() string {
+	if f.filter == nil || f.filter.BornFrom == nil {
+		return ""
+	}
+	return fmt.Sprintf("%ds", *f.filter.BornFrom)
+}
+
+func (f decadeFlag) Set(s string) error {
+	from, to, err := outlived.ParseDecade(s)
+	if err != nil {
+		return err
+	}
+	f.filter.BornFrom, f.filter.BornTo = outlived.Year(from), outlived.Year(to)
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var tagOpts struct {
+	store *storeFlags
+	born  string
+}
+
+var tagCommand = &command{
+	name:    "tag",
+	args:    "add|remove TAGS NAME",
+	summary: "Add tags, separated by ',', to one person named in full, or remove them, reindexing them at once",
+	flags: func(fs *flag.FlagSet) {
+		tagOpts.store = addStoreFlags(fs)
+		fs.StringVar(&tagOpts.born, "born", "", "Date of birth of the person to tag, choosing between namesakes")
+	},
+	run: runTag,
+}
+
+func runTag(fs *flag.FlagSet, args []string) error {
+	if len(args) < 3 || (args[0] != "add" && args[0] != "remove") {
+		fs.Usage()
+		return errors.New("tag: 'add' or 'remove' must be given, followed by the tags and a name")
+	}
+	tags := outlived.SplitList(args[1])
+	if len(tags) == 0 {
+		return errors.New("tag: no tags given")
+	}
+	name := strings.Join(args[2:], " ")
+	dataset := tagOpts.store.dataset
+	store, err := tagOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	store = outlived.StoreWithContext(tagOpts.store.context(), store)
+	editor, ok := store.(outlived.Editor)
+	if !ok {
+		return fmt.Errorf("tag: the %s backend can't edit people", tagOpts.store.backend)
+	}
+
+	old, err := namedPerson(store, "tag", dataset, name, tagOpts.born)
+	if err != nil {
+		return err
+	}
+	edited := outlived.WithTags(old, tags)
+	if args[0] == "remove" {
+		edited = outlived.WithoutTags(old, tags)
+	}
+	if edited.Tags == old.Tags {
+		fmt.Printf("Dataset '%s' already holds %s as given; nothing was changed\n", dataset, old)
+		return nil
+	}
+	if err := editor.Edit(dataset, old, edited); err != nil {
+		return fmt.Errorf("tag: %v", err)
+	}
+	verb := "Tagged"
+	if args[0] == "remove" {
+		verb = "Untagged"
+	}
+	fmt.Printf("%s %s in dataset '%s': %s\n", verb, edited, dataset, quoteField(edited.Tags))
+	return outlived.MarkEdited(store, dataset)
+}
+
+var tagsStore *storeFlags
+
+var tagsCommand = &command{
+	name:    "tags",
+	summary: "List the tags held by people in the dataset, with how many hold each",
+	flags: func(fs *flag.FlagSet) {
+		tagsStore = addStoreFlags(fs)
+	},
+	run: runTags,
+}
+
+func runTags(fs *flag.FlagSet, args []string) error {
+	if len(args) > 0 {
+		fs.Usage()
+		return errors.New("tags: no arguments are expected")
+	}
+	store, err := tagsStore.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, tagsStore.dataset)
+	if err != nil {
+		return err
+	}
+	tags, err := outlived.CountTags(store, datasets)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return noMatch{fmt.Sprintf("tags: no one in %s is tagged", strings.Join(datasets, ", "))}
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, tc := range tags {
+		fmt.Fprintf(tw, "%s\t%d\n", tc.Tag, tc.Count)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,200 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/matthewhegarty/outlived"
+	"golang.org/x/term"
+)
+
+var timelineOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	dob      string
+	days     int
+	width    int
+	birth    bool
+	noColor  bool
+}
+
+var timelineCommand = &command{
+	name:    "timeline",
+	summary: "Draw the lifespans of the people who died at an age close to that of someone born on -dob as bars, beside their own",
+	flags: func(fs *flag.FlagSet) {
+		timelineOpts.store = addStoreFlags(fs)
+		timelineOpts.clock = addClockFlags(fs, true)
+		timelineOpts.profiles = addProfileFlags(fs, true)
+		fs.StringVar(&timelineOpts.dob, "dob", cfg.DOB, "Date of birth (YYYY-MM-DD)")
+		fs.IntVar(&timelineOpts.days, "days", 365, "Number of days either side of your age to draw people who died")
+		fs.IntVar(&timelineOpts.width, "width", 0, "Width to draw to, in columns (default the width of the terminal, or 80)")
+		fs.BoolVar(&timelineOpts.birth, "from-birth", false, "Draw the bars from birth, rather than from the youngest age drawn")
+		fs.BoolVar(&timelineOpts.noColor, "no-color", false, "Don't highlight your bar, which is otherwise done on a terminal unless NO_COLOR is set")
+	},
+	run: runTimeline,
+}
+
+// TIMELINE_MIN_BAR is the fewest columns left for the bars, however narrow the terminal
+const TIMELINE_MIN_BAR = 20
+
+func runTimeline(fs *flag.FlagSet, args []string) error {
+	dob, err := resolveDOB(timelineOpts.dob, timelineOpts.profiles, timelineOpts.store)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 || dob == "" {
+		fs.Usage()
+		return errors.New("timeline: a date of birth must be given with -dob or -profile")
+	}
+	if timelineOpts.days < 0 {
+		return errors.New("timeline: -days must not be negative")
+	}
+	now, err := timelineOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	store, err := timelineOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, timelineOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	opts := outlived.QueryOptions{Datasets: datasets, Days: timelineOpts.days, Now: now}
+	userAge, results, _, err := outlived.Query(store, dob, opts)
+	if err != nil {
+		return err
+	}
+	width := timelineOpts.width
+	if width <= 0 {
+		width = terminalWidth(os.Stdout)
+	}
+	writeTimeline(os.Stdout, results, userAge, width, timelineOpts.birth, useColor(os.Stdout, timelineOpts.noColor))
+	if len(results) == 0 {
+		return noMatch{fmt.Sprintf("timeline: no one died within %d days of your age", timelineOpts.days)}
+	}
+	return nil
+}
+
+// terminalWidth returns the width of the terminal f is attached to, or else that given by
+// the COLUMNS environment variable, or 80
+func terminalWidth(f *os.File) int {
+	if w, _, err := term.GetSize(int(f.Fd())); err == nil && w > 0 {
+		return w
+	}
+	if w, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && w > 0 {
+		return w
+	}
+	return 80
+}
+
+// writeTimeline draws a bar for each person from the youngest age drawn (or if fromBirth, from
+// birth) to their age at death, with the user's own bar among them and a '|' across the others
+// at the user's age, scaled to fit within width columns
+func writeTimeline(w io.Writer, results []outlived.Result, userAge, width int, fromBirth, color bool) {
+	nameWidth := utf8.RuneCountInString(USER_MARKER)
+	ageWidth := len(shortAge(userAge))
+	from, to := userAge, userAge
+	for _, res := range results {
+		nameWidth = maxInt(nameWidth, utf8.RuneCountInString(res.Name))
+		ageWidth = maxInt(ageWidth, len(shortAge(res.Days)))
+		to = maxInt(to, res.Days)
+		from = minInt(from, res.Days)
+	}
+	from = yearsToDays(outlived.AgeInYears(from)) // a whole number of years, before the youngest
+	if fromBirth {
+		from = 0
+	}
+	nameWidth = minInt(nameWidth, maxInt(width/3, utf8.RuneCountInString(USER_MARKER)))
+	barWidth := maxInt(width-nameWidth-ageWidth-2, TIMELINE_MIN_BAR)
+	span := float64(maxInt(to-from, 1))
+	col := func(days int) int {
+		return int(float64(days-from) / span * float64(barWidth-1))
+	}
+
+	line := func(name string, days int, fill string) string {
+		bar := []rune(strings.Repeat(fill, col(days)+1) + strings.Repeat(" ", barWidth-col(days)-1))
+		if fill != "=" {
+			bar[col(userAge)] = '|'
+		}
+		return fmt.Sprintf("%-*s %s %*s", nameWidth, truncateName(name, nameWidth), string(bar), ageWidth, shortAge(days))
+	}
+	placed := false
+	for _, res := range results {
+		if !placed && res.Days >= userAge {
+			fmt.Fprintln(w, colorize(line(USER_MARKER, userAge, "="), ANSI_HIGHLIGHT, color))
+			placed = true
+		}
+		fmt.Fprintln(w, line(res.Name, res.Days, "#"))
+	}
+	if !placed {
+		fmt.Fprintln(w, colorize(line(USER_MARKER, userAge, "="), ANSI_HIGHLIGHT, color))
+	}
+	fmt.Fprintf(w, "%*s %s\n", nameWidth, "", timelineAxis(from, to, barWidth, col))
+}
+
+// timelineAxis labels the ages along the bars in years, as far apart as the labels need
+func timelineAxis(from, to, barWidth int, col func(days int) int) string {
+	axis := []rune(strings.Repeat(" ", barWidth+4))
+	for _, step := range []int{1, 2, 5, 10, 20, 25, 50, 100} {
+		if col(from+yearsToDays(step))-col(from) < 6 && step < 100 {
+			continue
+		}
+		for y := (outlived.AgeInYears(from) + step - 1) / step * step; yearsToDays(y) <= to; y += step {
+			if c := col(yearsToDays(y)); c >= 0 {
+				copy(axis[c:], []rune(strconv.Itoa(y)))
+			}
+		}
+		break
+	}
+	return strings.TrimRight(string(axis), " ")
+}
+
+// shortAge formats an age in days compactly, in years and days, e.g. "27y 44d"
+func shortAge(days int) string {
+	return fmt.Sprintf("%dy %dd", outlived.AgeInYears(days), int(math.Mod(float64(days), DAYS_IN_YEAR)))
+}
+
+// DAYS_IN_YEAR is the length of the years in which ages are given, as by outlived.AgeInYears
+const DAYS_IN_YEAR = 365.25
+
+// yearsToDays returns the number of days in a whole number of years
+func yearsToDays(years int) int {
+	return int(math.Ceil(float64(years) * DAYS_IN_YEAR))
+}
+
+// truncateName shortens the name with an ellipsis, if need be, to fit within width runes
+func truncateName(name string, width int) string {
+	runes := []rune(name)
+	if len(runes) <= width {
+		return name
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,112 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The standard OpenTelemetry environment variables giving where spans are exported to; spans
+// are only recorded if one is set
+const (
+	ENV_OTLP_ENDPOINT        = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	ENV_OTLP_TRACES_ENDPOINT = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+)
+
+// SERVICE_NAME is the name under which spans are reported, unless OTEL_SERVICE_NAME is set
+const SERVICE_NAME = "outlived"
+
+// TRACING_SHUTDOWN_TIMEOUT limits how long is spent exporting the last spans on exit
+const TRACING_SHUTDOWN_TIMEOUT = 5 * time.Second
+
+// setupTracing installs a tracer provider exporting spans by OTLP over HTTP, if an endpoint is
+// configured by the OTEL_EXPORTER_OTLP_* variables, returning a function which exports the
+// spans still held
+func setupTracing() (func(), error) {
+	if os.Getenv(ENV_OTLP_ENDPOINT) == "" && os.Getenv(ENV_OTLP_TRACES_ENDPOINT) == "" {
+		return func() {}, nil
+	}
+	ctx := context.Background()
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %v", err)
+	}
+	// later attributes win, so OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the name
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(SERVICE_NAME)),
+		resource.WithFromEnv())
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), TRACING_SHUTDOWN_TIMEOUT)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Printf("tracing: %v", err)
+		}
+	}, nil
+}
+
+// traced records a span for each request to the handler, continuing any trace whose context
+// the client sent in its headers
+func traced(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := outlived.Tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.HTTPRoute(route), semconv.URLPath(r.URL.Path)))
+		defer span.End()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	}
+}
+
+// endSpan ends the span, marking it as failed by any error
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// statusRecorder notes the status of a response, passing on flushes so that event streams
+// still work through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
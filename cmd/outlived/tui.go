@@ -0,0 +1,297 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/matthewhegarty/outlived"
+	"github.com/rivo/tview"
+)
+
+var tuiOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	dob      string
+}
+
+var tuiCommand = &command{
+	name:    "tui",
+	summary: "Browse the datasets interactively around the age of someone born on -dob, searching names as you type",
+	flags: func(fs *flag.FlagSet) {
+		tuiOpts.store = addStoreFlags(fs)
+		tuiOpts.clock = addClockFlags(fs, true)
+		tuiOpts.profiles = addProfileFlags(fs, true)
+		fs.StringVar(&tuiOpts.dob, "dob", cfg.DOB, "Date of birth (YYYY-MM-DD) to place among the people listed")
+	},
+	run: runTui,
+}
+
+// TUI_HELP lists the keys of the browser, shown at the foot of the screen
+const TUI_HELP = "[::b]/[::-] search  [::b]Tab[::-] next dataset  [::b]s[::-] stats  [::b]y[::-] you  [::b]q[::-] quit"
+
+func runTui(fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		fs.Usage()
+		return errors.New("tui: unexpected arguments")
+	}
+	dob, err := resolveDOB(tuiOpts.dob, tuiOpts.profiles, tuiOpts.store)
+	if err != nil {
+		return err
+	}
+	now, err := tuiOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	userAge := -1
+	if dob != "" {
+		if err := outlived.ValidateDate(dob); err != nil {
+			return err
+		}
+		if userAge, err = outlived.AgeInDays(dob, now.Format(outlived.DATE_FMT)); err != nil {
+			return err
+		}
+	}
+	store, err := tuiOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// every dataset can be switched to, starting with the first of those selected
+	selected, err := outlived.ResolveDatasets(store, tuiOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	datasets, err := outlived.ResolveDatasets(store, outlived.DATASETS_ALL)
+	if err != nil {
+		return err
+	}
+	b := newBrowser(store, datasets, dob, userAge, now)
+	for i, name := range datasets {
+		if name == selected[0] {
+			b.current = i
+		}
+	}
+	if err := b.load(); err != nil {
+		return err
+	}
+	return b.app.Run()
+}
+
+// browser is the state of the tui: a list of the people in the current dataset ordered by
+// age at death, with the user placed among them, beside the details of whoever is selected
+type browser struct {
+	store    outlived.Store
+	datasets []string
+	current  int // the index of the dataset shown
+	dob      string
+	userAge  int // the user's age in days, or -1 without a date of birth
+	now      time.Time
+
+	results []outlived.Result // everyone in the dataset
+	shown   []outlived.Result // those matching the search, with a zero Result for the user
+	stats   bool              // whether the dataset's statistics are shown instead of details
+
+	app    *tview.Application
+	list   *tview.List
+	detail *tview.TextView
+	status *tview.TextView
+	search *tview.InputField
+}
+
+func newBrowser(store outlived.Store, datasets []string, dob string, userAge int, now time.Time) *browser {
+	b := &browser{store: store, datasets: datasets, dob: dob, userAge: userAge, now: now}
+	b.app = tview.NewApplication()
+	b.list = tview.NewList().ShowSecondaryText(false).SetHighlightFullLine(true)
+	b.list.SetBorder(true)
+	b.list.SetChangedFunc(func(i int, _, _ string, _ rune) { b.showDetail(i) })
+	b.detail = tview.NewTextView().SetWordWrap(true)
+	b.detail.SetBorder(true)
+	b.status = tview.NewTextView().SetDynamicColors(true)
+	b.search = tview.NewInputField().SetLabel("Search: ")
+	b.search.SetChangedFunc(func(string) { b.refresh() })
+	b.search.SetDoneFunc(func(tcell.Key) { b.app.SetFocus(b.list) })
+
+	panes := tview.NewFlex().AddItem(b.list, 0, 1, true).AddItem(b.detail, 0, 1, false)
+	help := tview.NewTextView().SetDynamicColors(true).SetText(TUI_HELP)
+	root := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(b.status, 1, 0, false).
+		AddItem(panes, 0, 1, true).
+		AddItem(b.search, 1, 0, false).
+		AddItem(help, 1, 0, false)
+	b.app.SetRoot(root, true).SetInputCapture(b.keys)
+	return b
+}
+
+// keys handles the browser's keys while the list has focus, leaving typing in the search to it
+func (b *browser) keys(ev *tcell.EventKey) *tcell.EventKey {
+	if b.search.HasFocus() {
+		if ev.Key() == tcell.KeyEscape {
+			b.search.SetText("")
+		}
+		return ev
+	}
+	switch {
+	case ev.Key() == tcell.KeyTab:
+		b.switchDataset(1)
+	case ev.Key() == tcell.KeyBacktab:
+		b.switchDataset(-1)
+	case ev.Rune() == '/':
+		b.app.SetFocus(b.search)
+	case ev.Rune() == 's':
+		b.stats = !b.stats
+		b.showDetail(b.list.GetCurrentItem())
+	case ev.Rune() == 'y':
+		b.search.SetText("")
+		b.selectUser()
+	case ev.Rune() == 'q' || ev.Key() == tcell.KeyEscape:
+		b.app.Stop()
+	default:
+		return ev
+	}
+	return nil
+}
+
+// switchDataset moves by step through the datasets, wrapping at either end
+func (b *browser) switchDataset(step int) {
+	b.current = (b.current + step + len(b.datasets)) % len(b.datasets)
+	if err := b.load(); err != nil {
+		b.detail.SetText(err.Error())
+	}
+}
+
+// load reads the current dataset, and lists it with the user selected
+func (b *browser) load() error {
+	dataset := b.datasets[b.current]
+	results, err := outlived.AllRecords(b.store, dataset)
+	if err != nil {
+		return err
+	}
+	for i := range results {
+		results[i].Dataset = dataset
+	}
+	b.results = results
+	b.list.SetTitle(fmt.Sprintf(" %s (%d of %d) ", dataset, b.current+1, len(b.datasets)))
+	status := fmt.Sprintf("%s: %d records", dataset, len(results))
+	if b.userAge >= 0 {
+		r := outlived.Ranking{Total: len(results)}
+		for _, res := range results {
+			if res.Days < b.userAge {
+				r.Outlived++
+			}
+		}
+		status = fmt.Sprintf("Born %s, aged %s: you have outlived %.0f%% of %s (%d of %d), ranking %s",
+			b.dob, formatAge(b.userAge), r.Percentile(), dataset, r.Outlived, r.Total, ordinal(r.Rank()))
+	}
+	b.status.SetText(tview.Escape(status))
+	b.refresh()
+	b.selectUser()
+	return nil
+}
+
+// refresh lists the people whose names contain the search, or everyone with the user placed
+// among them if there is no search
+func (b *browser) refresh() {
+	query := outlived.FoldName(b.search.GetText())
+	b.shown = b.shown[:0]
+	placed := query != "" || b.userAge < 0
+	for _, res := range b.results {
+		if !placed && res.Days >= b.userAge {
+			b.shown, placed = append(b.shown, outlived.Result{}), true
+		}
+		if strings.Contains(outlived.FoldName(res.Name), query) {
+			b.shown = append(b.shown, res)
+		}
+	}
+	if !placed {
+		b.shown = append(b.shown, outlived.Result{})
+	}
+
+	width := utf8.RuneCountInString(USER_MARKER)
+	for _, res := range b.shown {
+		if n := utf8.RuneCountInString(res.Name); n > width {
+			width = n
+		}
+	}
+	b.list.Clear()
+	for _, res := range b.shown {
+		if res.Name == "" {
+			b.list.AddItem(fmt.Sprintf("[yellow::b]%-*s  aged %s", width, USER_MARKER, formatAge(b.userAge)), "", 0, nil)
+			continue
+		}
+		b.list.AddItem(tview.Escape(fmt.Sprintf("%-*s  died aged %s", width, res.Name, formatAge(res.Days))), "", 0, nil)
+	}
+	if len(b.shown) == 0 {
+		b.detail.SetText("No one's name contains '" + b.search.GetText() + "'")
+	}
+}
+
+// selectUser selects the user's place in the list, or the first person without a date of birth
+func (b *browser) selectUser() {
+	for i, res := range b.shown {
+		if res.Name == "" {
+			b.list.SetCurrentItem(i)
+			b.showDetail(i)
+			return
+		}
+	}
+	b.list.SetCurrentItem(0)
+	b.showDetail(0)
+}
+
+// showDetail shows the details of the person listed at i, with how the user compares with them,
+// or the statistics of the dataset
+func (b *browser) showDetail(i int) {
+	var buf bytes.Buffer
+	err := b.writeDetail(&buf, i)
+	if err != nil {
+		fmt.Fprintf(&buf, "\n%v\n", err)
+	}
+	b.detail.SetText(buf.String()).ScrollToBeginning()
+}
+
+func (b *browser) writeDetail(buf *bytes.Buffer, i int) error {
+	dataset := b.datasets[b.current]
+	if b.stats {
+		b.detail.SetTitle(" Stats ")
+		stats, err := outlived.DatasetStats(b.store, []string{dataset}, outlived.Filter{})
+		if err != nil || stats.Count == 0 {
+			return err
+		}
+		writeStats(buf, dataset, stats)
+		return nil
+	}
+	b.detail.SetTitle(" Details ")
+	if i < 0 || i >= len(b.shown) {
+		return nil
+	}
+	res := b.shown[i]
+	if res.Name == "" {
+		fmt.Fprintf(buf, "You were born on %s, and are %s old (%d days)\n", b.dob, formatAge(b.userAge), b.userAge)
+		return nil
+	}
+	standing, err := outlived.PersonStanding(b.store, res)
+	if err != nil {
+		return err
+	}
+	if err := writePerson(buf, res, standing, b.now); err != nil {
+		return err
+	}
+	if b.dob != "" {
+		s, err := comparison(res, b.dob, b.now)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, "\n%s\n", s)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var vsOpts struct {
+	store    *storeFlags
+	clock    *clockFlags
+	profiles *profileFlags
+	dob      string
+}
+
+var vsCommand = &command{
+	name:    "vs",
+	args:    "NAME NAME",
+	summary: "Compare the lifespans of two people, and when they were both alive, and optionally someone born on -dob",
+	flags: func(fs *flag.FlagSet) {
+		vsOpts.store = addStoreFlags(fs)
+		vsOpts.clock = addClockFlags(fs, true)
+		vsOpts.profiles = addProfileFlags(fs, true)
+		fs.StringVar(&vsOpts.dob, "dob", cfg.DOB, "Date of birth (YYYY-MM-DD) to compare with both people as well")
+	},
+	run: runVs,
+}
+
+func runVs(fs *flag.FlagSet, args []string) error {
+	if len(args) != 2 || strings.TrimSpace(args[0]) == "" || strings.TrimSpace(args[1]) == "" {
+		fs.Usage()
+		return errors.New("vs: two names must be given, quoting any with spaces")
+	}
+	dob, err := resolveDOB(vsOpts.dob, vsOpts.profiles, vsOpts.store)
+	if err != nil {
+		return err
+	}
+	if dob != "" {
+		if err := outlived.ValidateDate(dob); err != nil {
+			return err
+		}
+	}
+	now, err := vsOpts.clock.now()
+	if err != nil {
+		return err
+	}
+	store, err := vsOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	datasets, err := outlived.ResolveDatasets(store, vsOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	opts := outlived.QueryOptions{Datasets: datasets, Now: now}
+	var people [2]outlived.Result
+	for i, name := range args {
+		found, err := findPerson(store, "vs", name, opts)
+		if err != nil {
+			return err
+		}
+		if len(found) > 1 {
+			return fmt.Errorf("vs: '%s' could be any of %d people of that name, try -dataset", name, len(found))
+		}
+		people[i] = found[0]
+	}
+	return writeVs(os.Stdout, people[0], people[1], dob, now)
+}
+
+// writeVs writes both people's dates and ages, who lived longer and when they were both alive,
+// followed by how someone born on dob compares with each, if given
+func writeVs(w io.Writer, a, b outlived.Result, dob string, now time.Time) error {
+	if err := writeFound(w, []outlived.Result{a, b}, "", now, a.Dataset != b.Dataset); err != nil {
+		return err
+	}
+	today, _ := time.Parse(outlived.DATE_FMT, now.Format(outlived.DATE_FMT))
+	fmt.Fprintf(w, "\n%s\n", longerLived(a, b))
+	shared, err := sharedLife(a, b, "They", today)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, shared)
+	if dob == "" {
+		return nil
+	}
+
+	userAge, err := outlived.AgeInDays(dob, today.Format(outlived.DATE_FMT))
+	if err != nil {
+		return err
+	}
+	user := outlived.Result{Person: outlived.Person{Name: "You", BirthDate: dob}, Days: userAge}
+	for _, res := range []outlived.Result{a, b} {
+		s, err := comparison(res, dob, now)
+		if err != nil {
+			return err
+		}
+		if shared, err = sharedLife(res, user, "You", today); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\n%s: %s\n    %s\n", res.Name, s, shared)
+	}
+	return nil
+}
+
+// longerLived describes which of the two people lived longer, and by how much. A living person
+// who has yet to reach the other's age at death is given the date on which they will.
+func longerLived(a, b outlived.Result) string {
+	if a.Days < b.Days {
+		a, b = b, a
+	}
+	diff := a.Days - b.Days
+	by := fmt.Sprintf("%s (%d days)", formatAge(diff), diff)
+	switch {
+	case diff == 0 && a.Living() && b.Living():
+		return fmt.Sprintf("%s and %s are the same age", a.Name, b.Name)
+	case diff == 0:
+		return fmt.Sprintf("%s and %s lived exactly as long", a.Name, b.Name)
+	case a.Living() && b.Living():
+		return fmt.Sprintf("%s is %s older than %s", a.Name, by, b.Name)
+	case a.Living():
+		return fmt.Sprintf("%s has already lived %s longer than %s", a.Name, by, b.Name)
+	case b.Living():
+		s := fmt.Sprintf("%s lived %s longer than %s has so far", a.Name, by, b.Name)
+		if birth, err := time.Parse(outlived.DATE_FMT, b.BirthDate); err == nil {
+			s += fmt.Sprintf(", who will outlive them on %s", outlived.OutlivedOn(birth, a.Days).Format(outlived.DATE_FMT))
+		}
+		return s
+	}
+	return fmt.Sprintf("%s lived %s longer than %s", a.Name, by, b.Name)
+}
+
+// sharedLife describes the period during which both people were alive, or that they never were,
+// starting with who, e.g. 'They were both alive from...'
+func sharedLife(a, b outlived.Result, who string, today time.Time) (string, error) {
+	start, end, ok, err := outlived.SharedLifetime(a, b, today)
+	if err != nil {
+		return "", err
+	}
+	days := int(end.Sub(start).Hours() / 24)
+	if !ok {
+		return fmt.Sprintf("%s were never alive at the same time, being %s apart", who, formatAge(days)), nil
+	}
+	until := "to " + end.Format(outlived.DATE_FMT)
+	if end.Equal(today) {
+		until = "until today"
+	}
+	return fmt.Sprintf("%s were both alive from %s %s, for %s (%d days)", who, start.Format(outlived.DATE_FMT), until,
+		outlived.CalendarAgeBetween(start, end), days), nil
+}
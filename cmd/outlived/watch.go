@@ -0,0 +1,346 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/matthewhegarty/outlived"
+)
+
+var watchOpts struct {
+	store     *storeFlags
+	clock     *clockFlags
+	profiles  *profileFlags
+	dob       string
+	watched   []string // profile names
+	notify    []string
+	notifyCfg string
+	at        string
+	statePath string
+	once      bool
+}
+
+var watchCommand = &command{
+	name:    "watch",
+	summary: "Run in the background, sending a notification each time someone born on -dob, or in a profile, outlives someone else",
+	flags: func(fs *flag.FlagSet) {
+		watchOpts.store = addStoreFlags(fs)
+		watchOpts.clock = addClockFlags(fs, false)
+		watchOpts.profiles = addProfileFlags(fs, false)
+		fs.StringVar(&watchOpts.dob, "dob", cfg.DOB, "Date of birth (YYYY-MM-DD)")
+		fs.Var(listFlag{&watchOpts.watched}, "profile", "Comma separated profiles to watch instead of -dob, or 'all'")
+		fs.Var(stringsFlag{&watchOpts.notify}, "notify", "Where to send notifications: a 'slack://', 'smtp://', 'http(s)://' webhook URL, 'desktop' or 'stdout' (default); may be repeated")
+		fs.StringVar(&watchOpts.notifyCfg, "notify-config", "", "JSON file holding the settings of further notifiers")
+		fs.StringVar(&watchOpts.at, "at", "09:00", "Time of day (HH:MM, in -timezone) at which to check for new milestones")
+		fs.StringVar(&watchOpts.statePath, "state", defaultStatePath(), "File recording the milestones already notified")
+		fs.BoolVar(&watchOpts.once, "once", false, "Check once and exit, e.g. when run from cron")
+	},
+	run: runWatch,
+}
+
+func runWatch(fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 || (watchOpts.dob == "" && len(watchOpts.watched) == 0) {
+		fs.Usage()
+		return errors.New("watch: a date of birth must be given with -dob, or profiles with -profile")
+	}
+	if len(watchOpts.watched) == 0 {
+		if err := outlived.ValidateDate(watchOpts.dob); err != nil {
+			return err
+		}
+	}
+	at, err := time.Parse("15:04", watchOpts.at)
+	if err != nil {
+		return fmt.Errorf("watch: invalid time '%s', expected HH:MM", watchOpts.at)
+	}
+	notifiers, err := watchNotifiers()
+	if err != nil {
+		return err
+	}
+
+	loc, err := watchOpts.clock.location()
+	if err != nil {
+		return err
+	}
+	store, err := watchOpts.store.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	for {
+		if err := checkAll(store, notifiers, loc); err != nil {
+			if watchOpts.once {
+				return err
+			}
+			log.Print(err) // keep running, and try again at the next check
+		}
+		if watchOpts.once {
+			return nil
+		}
+		time.Sleep(time.Until(nextCheck(time.Now().In(loc), at)))
+	}
+}
+
+// watchNotifiers creates the notifiers given by -notify and -notify-config, or else those in
+// the config file, defaulting to stdout
+func watchNotifiers() ([]watchNotifier, error) {
+	var configs []outlived.NotifierConfig
+	for _, spec := range watchOpts.notify {
+		cfg, err := outlived.ParseNotifierURL(spec)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	if watchOpts.notifyCfg != "" {
+		more, err := outlived.ReadNotifierConfig(watchOpts.notifyCfg)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, more...)
+	}
+	if len(configs) == 0 {
+		configs = cfg.Notifiers
+	}
+	if len(configs) == 0 {
+		configs = []outlived.NotifierConfig{{Type: "stdout"}}
+	}
+	notifiers := make([]watchNotifier, 0, len(configs))
+	for _, cfg := range configs {
+		n, err := cfg.New()
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, watchNotifier{notifierID(cfg), n})
+	}
+	return notifiers, nil
+}
+
+// watchNotifier is a notifier with the ID by which the state records what it has been sent
+type watchNotifier struct {
+	id string
+	outlived.Notifier
+}
+
+// notifierID returns a hash of the notifier's settings, which stays the same from one run to
+// the next while they do. Only those which aren't secret are hashed, leaving out any password,
+// the headers and the user info of the URL, as a hash of a password would let it be guessed
+// from the state.
+func notifierID(cfg outlived.NotifierConfig) string {
+	if u, err := url.Parse(cfg.URL); err == nil && u.User != nil {
+		u.User = nil
+		cfg.URL = u.String()
+	}
+	cfg.Password, cfg.Headers = "", nil
+	data, _ := json.Marshal(cfg)
+	h := fnv.New64a()
+	h.Write(data)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// nextCheck returns the next time after now at the time of day given by at
+func nextCheck(now, at time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// watchTargets returns the profiles to watch, re-read at each check so that changes to them
+// are picked up, or else a profile without a name holding -dob
+func watchTargets() ([]outlived.Profile, error) {
+	if len(watchOpts.watched) == 0 {
+		return []outlived.Profile{{BirthDate: watchOpts.dob}}, nil
+	}
+	return selectProfiles(watchOpts.profiles, watchOpts.store, watchOpts.watched)
+}
+
+// checkAll checks the milestones of each target, carrying on past failures
+func checkAll(store outlived.Store, notifiers []watchNotifier, loc *time.Location) error {
+	targets, err := watchTargets()
+	if err != nil {
+		return err
+	}
+	var failures []string
+	for _, target := range targets {
+		if err := checkMilestones(store, notifiers, target, loc); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// watchState records, for each profile or date of birth watched, the keys of the people whose milestones
+// have been notified and the date on which they were. Delivered holds, for each milestone whose
+// notification some notifiers failed to send, the IDs of those which sent it, so that only the
+// others are retried.
+type watchState struct {
+	Notified  map[string]map[string]string   `json:"notified"`
+	Delivered map[string]map[string][]string `json:"delivered,omitempty"`
+}
+
+// checkMilestones notifies every milestone of the target which has passed but not yet been
+// notified. The first check for a target only records the milestones already passed, rather
+// than sending a notification for everyone outlived so far.
+func checkMilestones(store outlived.Store, notifiers []watchNotifier, target outlived.Profile, loc *time.Location) error {
+	state, err := loadWatchState(watchOpts.statePath)
+	if err != nil {
+		return err
+	}
+	datasets, err := outlived.ResolveDatasets(store, watchOpts.store.dataset)
+	if err != nil {
+		return err
+	}
+	now := time.Now().In(loc)
+	passed, err := outlived.Recent(store, target.BirthDate, math.MaxInt32, outlived.QueryOptions{Datasets: datasets, Now: now})
+	if err != nil {
+		return err
+	}
+	stateKey := target.BirthDate
+	if target.Name != "" {
+		stateKey = "profile:" + target.Name
+	}
+	notified, baseline := state.Notified[stateKey], false
+	if notified == nil {
+		notified, baseline = map[string]string{}, true
+		state.Notified[stateKey] = notified
+	}
+	delivered := state.Delivered[stateKey]
+	if delivered == nil {
+		delivered = map[string][]string{}
+	}
+	today := now.Format(outlived.DATE_FMT)
+	var failures []string
+	for i := len(passed) - 1; i >= 0; i-- { // in the order in which they were outlived
+		m := passed[i]
+		key := m.Dataset + "|" + m.Key()
+		if _, ok := notified[key]; ok {
+			continue
+		}
+		if !baseline {
+			sent, err := notifyAll(notifiers, outlived.Notification{Profile: target.Name, BirthDate: target.BirthDate, Milestone: m}, delivered[key])
+			if err != nil {
+				failures = append(failures, err.Error())
+				delivered[key] = sent // not notified, so that it is retried by the others at the next check
+				continue
+			}
+		}
+		notified[key] = today
+		delete(delivered, key)
+	}
+	if len(delivered) > 0 {
+		state.Delivered[stateKey] = delivered
+	} else {
+		delete(state.Delivered, stateKey)
+	}
+	if baseline {
+		log.Printf("watching %s: %d people already outlived", orDefault(target.Name, target.BirthDate), len(passed))
+	}
+	if err := saveWatchState(watchOpts.statePath, state); err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("watch: %s: %d notifications failed: %s", stateKey, len(failures), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// notifyAll sends the notification to every notifier but those whose IDs are in sent, returning
+// the IDs of those which have now sent it along with the first error
+func notifyAll(notifiers []watchNotifier, n outlived.Notification, sent []string) ([]string, error) {
+	var firstErr error
+	for _, notifier := range notifiers {
+		if slices.Contains(sent, notifier.id) {
+			continue
+		}
+		if err := notifier.Notify(n); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		sent = append(sent, notifier.id)
+	}
+	return sent, firstErr
+}
+
+// defaultStatePath returns the state file under the user's configuration directory
+func defaultStatePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "outlived-watch.json"
+	}
+	return filepath.Join(dir, "outlived", "watch.json")
+}
+
+func loadWatchState(path string) (*watchState, error) {
+	state := &watchState{Notified: map[string]map[string]string{}, Delivered: map[string]map[string][]string{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("watch: %s: %v", path, err)
+	}
+	if state.Notified == nil {
+		state.Notified = map[string]map[string]string{}
+	}
+	if state.Delivered == nil {
+		state.Delivered = map[string]map[string][]string{}
+	}
+	return state, nil
+}
+
+// saveWatchState writes the state to a temporary file which then replaces the original, so
+// that the state is not lost if the process is interrupted
+func saveWatchState(path string, state *watchState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// stringsFlag is a flag.Value collecting each use of a repeated flag
+type stringsFlag struct {
+	list *[]string
+}
+
+func (f stringsFlag) String() string {
+	if f.list == nil {
+		return ""
+	}
+	return strings.Join(*f.list, " ")
+}
+
+func (f stringsFlag) Set(s string) error {
+	*f.list = append(*f.list, s)
+	return nil
+}
@@ -0,0 +1,23 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFiles is the dashboard served at '/', a single page drawing its charts from the JSON API
+//
+//go:embed web
+var webFiles embed.FS
+
+// webHandler serves the dashboard embedded in the binary
+func webHandler() http.Handler {
+	root, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err) // the directory is embedded, so this cannot happen
+	}
+	return http.FileServer(http.FS(root))
+}
@@ -0,0 +1,70 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"sort"
+	"time"
+)
+
+// Cohort is the people of one or more datasets born in the same span of years
+type Cohort struct {
+	From, To int      // the years of birth, inclusive
+	Dead     []Result // ordered by age at death
+	Living   []Result // with their ages now, ordered by date of birth
+}
+
+// QueryCohort returns the people in the datasets born in the years from to to, read by a
+// BirthYearStore, or from everyone in each dataset otherwise. The living are given their ages
+// as of now.
+func QueryCohort(store Store, datasets []string, from, to int, now time.Time) (Cohort, error) {
+	cohort := Cohort{From: from, To: to}
+	today := now.Format(DATE_FMT)
+	for _, dataset := range datasets {
+		var people []Person
+		var err error
+		if bs, ok := store.(BirthYearStore); ok {
+			people, err = bs.QueryByBirthYear(dataset, from, to)
+		} else if people, err = AllPeople(store, dataset); err == nil {
+			people = bornIn(people, from, to)
+		}
+		if err != nil {
+			return cohort, err
+		}
+		for _, rec := range people {
+			end := rec.DeathDate
+			if rec.Living() {
+				end = today
+			}
+			age, err := rec.ageOn(end)
+			if err != nil {
+				return cohort, err
+			}
+			res := Result{Person: rec, Days: age, Dataset: dataset}
+			if rec.Living() {
+				cohort.Living = append(cohort.Living, res)
+			} else {
+				cohort.Dead = append(cohort.Dead, res)
+			}
+		}
+	}
+	sort.SliceStable(cohort.Dead, func(i, j int) bool { return cohort.Dead[i].Days < cohort.Dead[j].Days })
+	sort.SliceStable(cohort.Living, func(i, j int) bool { return birthDay(cohort.Living[i].Person) < birthDay(cohort.Living[j].Person) })
+	return cohort, nil
+}
+
+// Size returns the number of people in the cohort
+func (c Cohort) Size() int {
+	return len(c.Dead) + len(c.Living)
+}
+
+// bornIn returns the people born in the years from to to
+func bornIn(people []Person, from, to int) []Person {
+	var born []Person
+	for _, rec := range people {
+		if year, ok := yearOf(rec.BirthDate); ok && year >= from && year <= to {
+			born = append(born, rec)
+		}
+	}
+	return born
+}
@@ -0,0 +1,136 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress recognises gzip, zip and zstd data by their leading bytes and returns a reader of
+// the decompressed data, which for a zip archive is its first CSV file. Other data is returned
+// unchanged, along with its size; the size of decompressed data is not known, so is -1.
+func decompress(r io.ReadCloser, size int64) (io.ReadCloser, int64, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4) // shorter inputs cannot be compressed, and are passed through
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			r.Close()
+			return nil, 0, fmt.Errorf("gzip: %v", err)
+		}
+		return readCloser{gz, closeAll(gz.Close, r.Close)}, -1, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			r.Close()
+			return nil, 0, fmt.Errorf("zstd: %v", err)
+		}
+		return readCloser{zr, closeAll(func() error { zr.Close(); return nil }, r.Close)}, -1, nil
+	case bytes.HasPrefix(magic, zipMagic):
+		return openZipCSV(r, br, size)
+	}
+	return readCloser{br, r.Close}, size, nil
+}
+
+// openZipCSV opens the first CSV file in a zip archive, or the first file if none are named
+// '.csv'
+func openZipCSV(r io.ReadCloser, br *bufio.Reader, size int64) (io.ReadCloser, int64, error) {
+	zr, closeZip, err := openZip(r, br, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	var entry *zip.File
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if strings.EqualFold(path.Ext(f.Name), ".csv") {
+			entry = f
+			break
+		}
+		if entry == nil {
+			entry = f
+		}
+	}
+	if entry == nil {
+		closeZip()
+		return nil, 0, errors.New("zip: the archive holds no files")
+	}
+	rc, err := entry.Open()
+	if err != nil {
+		closeZip()
+		return nil, 0, fmt.Errorf("zip: %v", err)
+	}
+	return readCloser{rc, closeAll(rc.Close, closeZip)}, int64(entry.UncompressedSize64), nil
+}
+
+// openZip opens a zip archive, returning it along with the function which closes it. Zip
+// archives are read from the end, so one which is not a local file is first copied to a
+// temporary file.
+func openZip(r io.ReadCloser, br *bufio.Reader, size int64) (*zip.Reader, func() error, error) {
+	closers := []func() error{r.Close}
+	fail := func(err error) (*zip.Reader, func() error, error) {
+		closeAll(closers...)()
+		return nil, nil, fmt.Errorf("zip: %v", err)
+	}
+	var ra io.ReaderAt
+	if f, ok := r.(*os.File); ok && size > 0 {
+		ra = f
+	} else {
+		tmp, err := os.CreateTemp("", "outlived-*.zip")
+		if err != nil {
+			return fail(err)
+		}
+		closers = append(closers, tmp.Close, func() error { return os.Remove(tmp.Name()) })
+		if size, err = io.Copy(tmp, br); err != nil {
+			return fail(err)
+		}
+		ra = tmp
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return fail(err)
+	}
+	return zr, closeAll(closers...), nil
+}
+
+// readCloser pairs a reader with the function closing everything beneath it
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error {
+	return rc.close()
+}
+
+// closeAll returns a function calling each of the close functions in turn, returning the first error
+func closeAll(fns ...func() error) func() error {
+	return func() error {
+		var firstErr error
+		for _, fn := range fns {
+			if err := fn(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DEFAULT_SCAN_COUNT is the number of members asked of each ZSCAN made by ScanPeople
+const DEFAULT_SCAN_COUNT = 1000
+
+// PeopleScanner is implemented by stores able to read a dataset a page at a time, rather than
+// all at once, so that reading a large one doesn't hold up others using the store
+type PeopleScanner interface {
+	// ScanPeople calls fn with each page of the people in the dataset, dead or living, in no
+	// particular order, stopping at the first error it returns
+	ScanPeople(dataset string, fn func(page []Person) error) error
+}
+
+// ScanPeople reads the dataset's sorted sets with ZSCAN, DEFAULT_SCAN_COUNT members at a time,
+// calling fn with the people on each page. Anyone ZSCAN returns twice is passed to fn once.
+func (s *RedisStore) ScanPeople(dataset string, fn func(page []Person) error) error {
+	seen := map[string]bool{}
+	for _, key := range []string{DatasetKey(dataset), LivingKey(dataset)} {
+		for cursor := "0"; ; {
+			var (
+				next string
+				page []Person
+			)
+			err := s.do(func(c redis.Conn) error {
+				reply, err := redis.Values(c.Do("ZSCAN", key, cursor, "COUNT", DEFAULT_SCAN_COUNT))
+				if err != nil {
+					return err
+				}
+				var values []interface{}
+				if _, err := redis.Scan(reply, &next, &values); err != nil {
+					return err
+				}
+				var ids []string
+				for i := 0; i+1 < len(values); i += 2 { // member, then score
+					id, err := redis.String(values[i], nil)
+					if err != nil {
+						return err
+					}
+					if !seen[id] {
+						ids = append(ids, id)
+					}
+				}
+				people, err := hydrate(c, dataset, ids)
+				if err != nil {
+					return err
+				}
+				page = make([]Person, 0, len(ids))
+				for i, rec := range people {
+					if rec == nil {
+						// datasets imported by earlier versions held 'name,dob,dod' members
+						legacy, err := parseMember(ids[i])
+						if err != nil {
+							return fmt.Errorf("%s: no details stored for %q", key, ids[i])
+						}
+						rec = &legacy
+					}
+					page = append(page, *rec)
+				}
+				for _, id := range ids {
+					seen[id] = true
+				}
+				return nil
+			})
+			if err == nil && len(page) > 0 {
+				err = fn(page)
+			}
+			if err != nil {
+				return err
+			}
+			if cursor = next; cursor == "0" {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// ReadPeople returns everyone in the dataset, dead or living, read a page at a time if the
+// store is a PeopleScanner, in no particular order
+func ReadPeople(store Store, dataset string) ([]Person, error) {
+	ps, ok := store.(PeopleScanner)
+	if !ok {
+		return AllPeople(store, dataset)
+	}
+	var people []Person
+	err := ps.ScanPeople(dataset, func(page []Person) error {
+		people = append(people, page...)
+		return nil
+	})
+	return people, err
+}
+
+// CopyStats describes a dataset copied by CopyDataset
+type CopyStats struct {
+	People   int
+	Checksum string // see DatasetChecksum
+}
+
+// CopyDataset replaces the dataset in one store with its contents in another, then reads the
+// copy back to check that it holds the same number of people with the same checksum. The
+// provenance of the original is copied with it.
+func CopyDataset(from, to Store, dataset string) (CopyStats, error) {
+	people, err := ReadPeople(from, dataset)
+	if err != nil {
+		return CopyStats{}, err
+	}
+	people = dedupePeople(people) // as the import will
+	if len(people) == 0 {
+		return CopyStats{}, fmt.Errorf("dataset '%s' holds nobody", dataset)
+	}
+	stats := CopyStats{People: len(people), Checksum: DatasetChecksum(people)}
+	if err := to.Import(dataset, people); err != nil {
+		return stats, err
+	}
+	copied, err := ReadPeople(to, dataset)
+	if err != nil {
+		return stats, fmt.Errorf("reading the copy back: %v", err)
+	}
+	if len(copied) != stats.People {
+		return stats, fmt.Errorf("the copy of dataset '%s' holds %d people, not %d", dataset, len(copied), stats.People)
+	}
+	if sum := DatasetChecksum(copied); sum != stats.Checksum {
+		return stats, fmt.Errorf("the copy of dataset '%s' has checksum %s, not %s", dataset, sum, stats.Checksum)
+	}
+	var original *Provenance
+	if ps, ok := from.(ProvenanceStore); ok {
+		if p, err := ps.Provenance(dataset); err == nil {
+			original = &p
+		} else if !errors.Is(err, ErrNoProvenance) {
+			return stats, err
+		}
+	}
+	return stats, SaveCopiedProvenance(to, dataset, original, "copy of dataset '"+dataset+"'", people)
+}
@@ -0,0 +1,272 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ReadOptions controls how a source file is read
+type ReadOptions struct {
+	// Size is the total size of the input in bytes, if known, used to estimate progress
+	Size int64
+	// Progress, if set, is called periodically while reading and once at the end
+	Progress ProgressFunc
+	// ProgressInterval is the minimum time between calls to Progress
+	ProgressInterval time.Duration
+	// Reject, if set, is called for each invalid row, which is then skipped. Otherwise the
+	// first invalid row fails the read.
+	Reject RejectFunc
+	// Fetch controls the download of sources given as URLs
+	Fetch FetchOptions
+	// Context, if set, abandons the read, and any download, once it ends
+	Context context.Context
+	// Format is that of the file read by ReadFile, one of the FORMAT_* constants. If empty it
+	// is worked out from the file name.
+	Format string
+	// Fields, if set, says where the fields of each record are found: under which keys of a
+	// JSON object, or in which column of a CSV file or spreadsheet, by number or header
+	Fields FieldMap
+	// Delimiter separates the fields of a CSV file, ',' by default
+	Delimiter rune
+	// Quotes is how quoted fields of a CSV file are read, one of the QUOTES_* constants
+	Quotes string
+	// Header says whether a CSV file starts with a header row, one of the HEADER_* constants
+	Header string
+	// DateFormats are the formats in which dates may be written, as accepted by ParseDate,
+	// which are normalised to 'YYYY-MM-DD'. If empty, dates must be 'YYYY-MM-DD'.
+	DateFormats []string
+	// Calendar is that in which dates are written, one of the CALENDAR_* constants, by
+	// default Gregorian. Dates are converted to the Gregorian calendar.
+	Calendar string
+	// Sheet is the name of the worksheet read from an Excel workbook, by default the first
+	Sheet string
+	// Living accepts records with no date of death as those of living people, rather than
+	// rejecting them
+	Living bool
+}
+
+// How the quotes around the fields of a CSV file are read
+const (
+	QUOTES_STRICT = "strict" // a field may be quoted with '"', and quotes must be well formed
+	QUOTES_LAZY   = "lazy"   // a quote may appear in an unquoted field, or unescaped in a quoted one
+	QUOTES_NONE   = "none"   // quotes are read as any other character
+)
+
+// Whether a CSV file starts with a header row
+const (
+	HEADER_AUTO = "auto" // the first row is a header if none of its fields is a date
+	HEADER_YES  = "yes"
+	HEADER_NO   = "no"
+)
+
+// ReadCSV parses CSV data from the reader a row at a time and returns its contents as a
+// 'Person' array, along with a summary of the rows read. Rows in which every field is blank
+// are skipped, and every other row is checked with ValidatePerson, or ValidateLivingPerson
+// if opts.Living is set.
+//
+// Unless opts.Header says otherwise, the file is taken to start with a header row if none of
+// the fields of the first row is a date. The columns holding each field are found by
+// columnsFor; where the header does not name the required fields, and no opts.Fields are
+// given, the columns are taken to be in the usual order.
+func ReadCSV(r io.Reader, opts ReadOptions) ([]Person, Progress, error) {
+	tracker := newProgressTracker(opts.Context, r, opts.Size, opts.Progress, opts.ProgressInterval)
+	rows, err := newRowReader(tracker, opts)
+	if err != nil {
+		return nil, Progress{}, err
+	}
+
+	var allRecords []Person
+	var columns map[string]int
+	needed := 0 // the number of fields a row needs to hold every required field
+	for {
+		eachRow, line, err := rows.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, tracker.progress(), fmt.Errorf("file parse: %v", err)
+		}
+		if isBlankRow(eachRow) {
+			tracker.row(true)
+			continue
+		}
+		if columns == nil {
+			required := requiredFields(opts.Living)
+			header := opts.Header == HEADER_YES || (opts.Header != HEADER_NO && isHeaderRow(eachRow, opts.DateFormats))
+			if !header {
+				columns, err = columnsFor(nil, opts.Fields, required)
+			} else if columns, err = columnsFor(eachRow, opts.Fields, required); err != nil && len(opts.Fields) == 0 {
+				// a first row naming none of the fields is read as a record, unless said to be a header
+				header = opts.Header == HEADER_YES
+				columns, err = columnsFor(nil, nil, required)
+			}
+			if err != nil {
+				return nil, tracker.progress(), fmt.Errorf("file parse: %v", err)
+			}
+			for _, field := range required {
+				if columns[field] >= needed {
+					needed = columns[field] + 1
+				}
+			}
+			if header {
+				continue
+			}
+		}
+		var rec Person
+		var invalid error
+		if len(eachRow) < needed {
+			invalid = fmt.Errorf("expected %d fields, got %d", needed, len(eachRow))
+		} else {
+			rec = personFromColumns(eachRow, columns)
+			normalizeDates(&rec, opts)
+			invalid = opts.validate(rec)
+		}
+		if invalid != nil {
+			if opts.Reject == nil {
+				return nil, tracker.progress(), fmt.Errorf("file parse: line %d: %v", line, invalid)
+			}
+			opts.Reject(Rejection{Line: line, Record: append([]string(nil), eachRow...), Reason: invalid.Error()})
+			tracker.row(true)
+			continue
+		}
+		allRecords = append(allRecords, rec)
+		tracker.row(false)
+	}
+	return allRecords, tracker.finish(), nil
+}
+
+// isHeaderRow reports whether a row looks like a header, rather than a record, by having no
+// field which is a date
+func isHeaderRow(row []string, formats []string) bool {
+	for _, field := range row {
+		field = strings.TrimSpace(field)
+		if ValidateDate(field) == nil {
+			return false
+		}
+		if _, err := ParseDate(field, formats, CALENDAR_GREGORIAN); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// rowReader reads the rows of a CSV file along with their line numbers
+type rowReader interface {
+	read() ([]string, int, error)
+}
+
+func newRowReader(r io.Reader, opts ReadOptions) (rowReader, error) {
+	switch opts.Header {
+	case "", HEADER_AUTO, HEADER_YES, HEADER_NO:
+	default:
+		return nil, fmt.Errorf("unknown header setting '%s'", opts.Header)
+	}
+	delimiter := opts.Delimiter
+	if delimiter == 0 {
+		delimiter = ','
+	}
+	if delimiter == '"' || delimiter == '\r' || delimiter == '\n' || delimiter == utf8.RuneError {
+		return nil, fmt.Errorf("invalid delimiter %q", delimiter)
+	}
+	switch opts.Quotes {
+	case "", QUOTES_STRICT, QUOTES_LAZY:
+		reader := csv.NewReader(r)
+		reader.Comma = delimiter
+		reader.LazyQuotes = opts.Quotes == QUOTES_LAZY
+		reader.FieldsPerRecord = -1 // row lengths are checked by ReadCSV, with a clearer error
+		reader.ReuseRecord = true
+		return csvRowReader{reader}, nil
+	case QUOTES_NONE:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1<<20)
+		return &lineRowReader{scanner: scanner, delimiter: string(delimiter)}, nil
+	}
+	return nil, fmt.Errorf("unknown quote handling '%s'", opts.Quotes)
+}
+
+type csvRowReader struct {
+	*csv.Reader
+}
+
+func (r csvRowReader) read() ([]string, int, error) {
+	row, err := r.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+	line, _ := r.FieldPos(0)
+	return row, line, nil
+}
+
+// lineRowReader splits each line on the delimiter, with no special meaning given to quotes
+type lineRowReader struct {
+	scanner   *bufio.Scanner
+	delimiter string
+	line      int
+}
+
+func (r *lineRowReader) read() ([]string, int, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return nil, 0, err
+		}
+		return nil, 0, io.EOF
+	}
+	r.line++
+	return strings.Split(strings.TrimSuffix(r.scanner.Text(), "\r"), r.delimiter), r.line, nil
+}
+
+func isBlankRow(row []string) bool {
+	for _, field := range row {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteCSV writes the records in the same format read by ReadCSV. The optional fields are
+// only written if at least one record has them.
+func WriteCSV(w io.Writer, records []Person) error {
+	extended := false
+	for _, rec := range records {
+		extended = extended || rec.HasDetails()
+	}
+	cw := csv.NewWriter(w)
+	for _, rec := range records {
+		row := []string{rec.Name, rec.BirthDate, rec.DeathDate}
+		if extended {
+			row = append(row, rec.Occupation, rec.Nationality, rec.CauseOfDeath, rec.Genre, rec.URL, rec.Summary, rec.ImageURL, rec.Aliases, rec.Tags)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteRejects writes the rejected rows as CSV, each prefixed by its line number and the
+// reason it was rejected
+func WriteRejects(w io.Writer, rejects []Rejection) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"line", "reason", "record"}); err != nil {
+		return err
+	}
+	for _, r := range rejects {
+		row := append([]string{strconv.Itoa(r.Line), r.Reason}, r.Record...)
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
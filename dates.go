@@ -0,0 +1,403 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DATE_FORMAT_AUTO, given as a date format, recognises the common ways of writing a date: ISO
+// 8601 (with or without a time), day-first numeric dates such as '25/09/1990' and '25.09.1990',
+// and dates with the month named, such as '25 September 1990' or 'Sep 25th, 1990'
+const DATE_FORMAT_AUTO = "auto"
+
+// Calendars in which the dates of a source may be written. Dates are always stored in the
+// Gregorian calendar, extended back before its introduction, so that the days between any two
+// dates can be counted in the same way.
+const (
+	CALENDAR_GREGORIAN = "gregorian"
+	CALENDAR_JULIAN    = "julian"
+	CALENDAR_AUTO      = "auto" // Julian for dates before the Gregorian calendar was introduced, on 1582-10-15
+)
+
+// DatePrecision is how precisely a date is known
+type DatePrecision int
+
+const (
+	PRECISION_YEAR  DatePrecision = iota // 'YYYY'
+	PRECISION_MONTH                      // 'YYYY-MM'
+	PRECISION_DAY                        // 'YYYY-MM-DD'
+)
+
+// civilDate is a date in the Gregorian calendar, or in the Julian calendar before conversion.
+// Years are numbered astronomically, so that 1 BCE is year 0, and 2 BCE is year -1.
+type civilDate struct {
+	year, month, day int
+	precision        DatePrecision
+}
+
+// String formats the date as ISO 8601 does, e.g. '1942-11-27', '1942' or '-0427-05-21'
+func (d civilDate) String() string {
+	year := fmt.Sprintf("%04d", d.year)
+	if d.year < 0 {
+		year = fmt.Sprintf("-%04d", -d.year)
+	}
+	switch d.precision {
+	case PRECISION_YEAR:
+		return year
+	case PRECISION_MONTH:
+		return fmt.Sprintf("%s-%02d", year, d.month)
+	}
+	return fmt.Sprintf("%s-%02d-%02d", year, d.month, d.day)
+}
+
+// valid reports whether the month and day exist, following the leap years of the calendar
+func (d civilDate) valid(julian bool) bool {
+	if d.precision >= PRECISION_MONTH && (d.month < 1 || d.month > 12) {
+		return false
+	}
+	if d.precision < PRECISION_DAY {
+		return true
+	}
+	days := []int{31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}[d.month-1]
+	leap := d.year%4 == 0 && (julian || d.year%100 != 0 || d.year%400 == 0)
+	if d.month == 2 && leap {
+		days = 29
+	}
+	return d.day >= 1 && d.day <= days
+}
+
+// before reports whether the date falls before the given day
+func (d civilDate) before(year, month, day int) bool {
+	if d.year != year {
+		return d.year < year
+	}
+	if d.month != month {
+		return d.month < month
+	}
+	return d.day < day
+}
+
+// julianToGregorian converts a date in the Julian calendar to the Gregorian one, by way of
+// its Julian Day Number
+func (d civilDate) julianToGregorian() civilDate {
+	a := (14 - d.month) / 12
+	y := d.year + 4800 - a
+	m := d.month + 12*a - 3
+	jdn := d.day + (153*m+2)/5 + 365*y + y/4 - 32083
+	// Julian Day 0 is the 24th of November 4714 BCE in the Gregorian calendar
+	t := time.Date(-4713, 11, 24+jdn, 0, 0, 0, 0, time.UTC)
+	return civilDate{year: t.Year(), month: int(t.Month()), day: t.Day(), precision: PRECISION_DAY}
+}
+
+var isoDateRegex = regexp.MustCompile(`^(-?[0-9]{4,})(?:-([0-9]{2})(?:-([0-9]{2}))?)?$`)
+
+// parseISODate parses an ISO 8601 date at any precision, with a negative year for one BCE,
+// without checking that the month and day exist
+func parseISODate(s string) (civilDate, bool) {
+	m := isoDateRegex.FindStringSubmatch(s)
+	if m == nil {
+		return civilDate{}, false
+	}
+	var d civilDate
+	d.year, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		d.month, _ = strconv.Atoi(m[2])
+		d.precision = PRECISION_MONTH
+	}
+	if m[3] != "" {
+		d.day, _ = strconv.Atoi(m[3])
+		d.precision = PRECISION_DAY
+	}
+	return d, true
+}
+
+// ParsePartialDate parses a date whose month and day may be unknown, written 'YYYY-MM-DD',
+// 'YYYY-MM' or 'YYYY', returning its precision. Years BCE are written as negative ISO 8601
+// years, so that '-0427' is 428 BCE. A partial date is taken to be the middle of the period it
+// covers: the 2nd of July for a year, or the 15th for a month.
+func ParsePartialDate(s string) (time.Time, DatePrecision, error) {
+	d, ok := parseISODate(s)
+	if !ok || !d.valid(false) {
+		return time.Time{}, 0, fmt.Errorf("invalid date '%s': dates must be 'YYYY-MM-DD', 'YYYY-MM' or 'YYYY'", s)
+	}
+	switch d.precision {
+	case PRECISION_YEAR:
+		d.month, d.day = 7, 2
+	case PRECISION_MONTH:
+		d.day = 15
+	}
+	return time.Date(d.year, time.Month(d.month), d.day, 0, 0, 0, 0, time.UTC), d.precision, nil
+}
+
+// PartialDatePeriod returns the first and last days of the period a date whose month and day
+// may be unknown covers, as ParsePartialDate reads it: the year, the month or the day itself
+func PartialDatePeriod(s string) (time.Time, time.Time, error) {
+	d, ok := parseISODate(s)
+	if !ok || !d.valid(false) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid date '%s': dates must be 'YYYY-MM-DD', 'YYYY-MM' or 'YYYY'", s)
+	}
+	switch d.precision {
+	case PRECISION_YEAR:
+		return time.Date(d.year, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(d.year, 12, 31, 0, 0, 0, 0, time.UTC), nil
+	case PRECISION_MONTH:
+		first := time.Date(d.year, time.Month(d.month), 1, 0, 0, 0, 0, time.UTC)
+		return first, first.AddDate(0, 1, -1), nil
+	}
+	day := time.Date(d.year, time.Month(d.month), d.day, 0, 0, 0, 0, time.UTC)
+	return day, day, nil
+}
+
+// FormatYear formats a year numbered astronomically as it is commonly written, so that year 0
+// is '1 BCE' and year -427 is '428 BCE'
+func FormatYear(year int) string {
+	if year <= 0 {
+		return fmt.Sprintf("%d BCE", 1-year)
+	}
+	return strconv.Itoa(year)
+}
+
+// Precision returns how precisely the date is known, assuming it is valid
+func Precision(date string) DatePrecision {
+	if n := strings.Count(strings.TrimPrefix(date, "-"), "-"); n < int(PRECISION_DAY) {
+		return DatePrecision(n)
+	}
+	return PRECISION_DAY
+}
+
+// Approximate reports whether the person's age at death (or for a living person, their age)
+// is only known roughly, as one of their dates is partial
+func (rec Person) Approximate() bool {
+	return Precision(rec.BirthDate) < PRECISION_DAY || (!rec.Living() && Precision(rec.DeathDate) < PRECISION_DAY)
+}
+
+// FormatAge formats the result's age at death, roughly if it is approximate
+func (res Result) FormatAge() string {
+	if res.Approximate() {
+		return FormatApproximateAge(res.Days)
+	}
+	return FormatAgeInYearsAndDays(res.Days)
+}
+
+// autoDateLayouts are the layouts tried for DATE_FORMAT_AUTO, after any commas and ordinal
+// suffixes have been removed
+var autoDateLayouts = []string{
+	"2006-1-2",
+	"2006-1-2T15:04:05Z07:00",
+	"2006-1-2T15:04:05",
+	"2006-1-2T15:04Z07:00",
+	"2006-1-2T15:04",
+	"2006-1-2 15:04:05Z07:00",
+	"2006-1-2 15:04:05",
+	"2006-1-2 15:04",
+	"2006/1/2",
+	"2/1/2006",
+	"2.1.2006",
+	"2-1-2006",
+	"2 January 2006",
+	"2 Jan 2006",
+	"2-Jan-2006",
+	"January 2 2006",
+	"Jan 2 2006",
+	"2006-1",
+	"2006/1",
+	"1/2006",
+	"January 2006",
+	"Jan 2006",
+	"2006",
+}
+
+var (
+	ordinalSuffix  = regexp.MustCompile(`\b([0-9]{1,2})(st|nd|rd|th)\b`)
+	calendarMarker = regexp.MustCompile(`(?i)\s*\(?\b(O\.?S\.?|N\.?S\.?|Julian|Gregorian)\)?$`)
+	eraMarker      = regexp.MustCompile(`(?i)\s*\b(BCE|BC|B\.C\.E\.|B\.C\.|CE|AD|A\.D\.|C\.E\.)$|^(AD|A\.D\.)\s*`)
+	shortYear      = regexp.MustCompile(`(^|[^0-9])([0-9]{1,3})$`)
+	leapDay        = regexp.MustCompile(`\b29\b`)
+)
+
+// ParseDate reads a date written in any of the formats, tried in turn, and returns it as
+// 'YYYY-MM-DD', or as 'YYYY-MM' or 'YYYY' if the format lacks the day or month.
+//
+// A format is either a Go time layout, such as '02/01/2006', or is written with the
+// placeholders YYYY (the year), MM or M (the month as a number), MMMM (the month's name), MMM
+// (its abbreviated name), and DD or D (the day), such as 'DD/MM/YYYY' or 'MMMM D, YYYY'.
+// DATE_FORMAT_AUTO stands for the common formats listed with it. Any time of day is dropped,
+// without converting between time zones.
+//
+// Years may be followed by 'BC' or 'BCE' (or 'AD' or 'CE'), and are then returned as negative
+// ISO 8601 years, as are dates given as ISO 8601 dates with a negative year. Dates are read
+// as being in the calendar given, one of the CALENDAR_* constants, unless marked as Julian
+// with 'O.S.' (old style) or '(Julian)', or as Gregorian with 'N.S.' or '(Gregorian)'. Julian
+// dates known to the day are converted to the Gregorian calendar; partial ones are not.
+func ParseDate(s string, formats []string, calendar string) (string, error) {
+	s = strings.TrimSpace(s)
+	value := s
+	if m := calendarMarker.FindStringSubmatch(value); m != nil {
+		calendar = CALENDAR_GREGORIAN
+		if marker := strings.ToLower(strings.ReplaceAll(m[1], ".", "")); marker == "os" || marker == "julian" {
+			calendar = CALENDAR_JULIAN
+		}
+		value = strings.TrimSpace(value[:len(value)-len(m[0])])
+	}
+	bce := false
+	if m := eraMarker.FindStringSubmatch(value); m != nil {
+		bce = strings.HasPrefix(strings.ToUpper(m[1]), "B")
+		value = strings.TrimSpace(strings.Replace(value, m[0], "", 1))
+		// years of the era are often written without leading zeros, which layouts need
+		if m := shortYear.FindStringSubmatchIndex(value); m != nil {
+			n, _ := strconv.Atoi(value[m[4]:m[5]])
+			value = value[:m[4]] + fmt.Sprintf("%04d", n)
+		}
+	}
+
+	d, ok := parseISODate(value)
+	if !ok {
+		d, ok = parseWithFormats(value, formats)
+	}
+	if !ok {
+		return "", fmt.Errorf("unrecognised date '%s'", s)
+	}
+	if bce {
+		if d.year < 1 {
+			return "", fmt.Errorf("invalid date '%s': years BCE count from 1", s)
+		}
+		d.year = 1 - d.year
+	}
+	julian := calendar == CALENDAR_JULIAN || (calendar == CALENDAR_AUTO && d.before(1582, 10, 15))
+	if !d.valid(julian) {
+		return "", fmt.Errorf("invalid date '%s'", s)
+	}
+	if julian && d.precision == PRECISION_DAY {
+		d = d.julianToGregorian()
+	}
+	return d.String(), nil
+}
+
+// userDateFormats are the formats ParseUserDate reads dates in: the common ones, day first, and
+// then month first, which is only reached when the month would otherwise be past 12
+var userDateFormats = []string{DATE_FORMAT_AUTO, "1/2/2006"}
+
+var (
+	relativeDate = regexp.MustCompile(`^((?:[0-9]+\s*(?:years?|yrs?|y|months?|mos?|weeks?|wks?|w|days?|d)[\s,]*(?:and\s+)?)+)ago$`)
+	relativePart = regexp.MustCompile(`([0-9]+)\s*([a-z])`)
+)
+
+// ParseUserDate reads a date of birth given by the user, which unlike the dates of a dataset
+// may be written in any way ParseDate recognises with DATE_FORMAT_AUTO, such as '25 Sep 1990' or
+// '25/09/1990' (or '09/25/1990', which can only be month first), or as an age counted back from
+// now, such as '30 years ago' or '30y 6m ago'. The date returned is 'YYYY-MM-DD', as it must be
+// known to the day.
+func ParseUserDate(s string, now time.Time) (string, error) {
+	s = strings.TrimSpace(s)
+	if ValidateDate(s) == nil {
+		return s, nil
+	}
+	if m := relativeDate.FindStringSubmatch(strings.ToLower(s)); m != nil {
+		var years, months, days int
+		for _, part := range relativePart.FindAllStringSubmatch(m[1], -1) {
+			n, _ := strconv.Atoi(part[1])
+			switch part[2] {
+			case "y":
+				years += n
+			case "m":
+				months += n
+			case "w":
+				days += 7 * n
+			case "d":
+				days += n
+			}
+		}
+		return now.AddDate(-years, -months, -days).Format(DATE_FMT), nil
+	}
+	date, err := ParseDate(s, userDateFormats, CALENDAR_GREGORIAN)
+	if err != nil {
+		return "", fmt.Errorf("unrecognised date '%s': expected a date such as '1990-09-25', '25/09/1990' or '25 Sep 1990', or an age such as '30 years ago'", s)
+	}
+	if Precision(date) < PRECISION_DAY {
+		return "", fmt.Errorf("incomplete date '%s': the day of birth is needed", s)
+	}
+	return date, nil
+}
+
+// parseWithFormats reads a date written in any of the formats
+func parseWithFormats(s string, formats []string) (civilDate, bool) {
+	for _, format := range formats {
+		layouts := []string{dateLayout(format)}
+		value := s
+		if format == DATE_FORMAT_AUTO {
+			layouts = autoDateLayouts
+			value = strings.Join(strings.Fields(strings.ReplaceAll(ordinalSuffix.ReplaceAllString(s, "$1"), ",", " ")), " ")
+		}
+		// time.Parse only knows Gregorian leap years, so the 29th of February of a year which
+		// is only a leap year in the Julian calendar is read as the 28th, and put back after
+		feb28 := leapDay.ReplaceAllString(value, "28")
+		for _, layout := range layouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return civilDate{year: t.Year(), month: int(t.Month()), day: t.Day(), precision: layoutPrecision(layout)}, true
+			}
+			if t, err := time.Parse(layout, feb28); err == nil && feb28 != value && t.Month() == time.February && t.Day() == 28 {
+				return civilDate{year: t.Year(), month: 2, day: 29, precision: PRECISION_DAY}, true
+			}
+		}
+	}
+	return civilDate{}, false
+}
+
+// dateLayout converts a format written with placeholders to a Go time layout. Numbers are
+// read with or without leading zeros, whichever placeholder is used.
+func dateLayout(format string) string {
+	if strings.Contains(format, "2006") {
+		return format // already a Go layout
+	}
+	replacements := []struct{ placeholder, layout string }{
+		{"YYYY", "2006"}, {"MMMM", "January"}, {"MMM", "Jan"}, {"MM", "1"}, {"M", "1"}, {"DD", "2"}, {"D", "2"},
+	}
+	var b strings.Builder
+next:
+	for i := 0; i < len(format); {
+		for _, r := range replacements {
+			if strings.HasPrefix(format[i:], r.placeholder) {
+				b.WriteString(r.layout)
+				i += len(r.placeholder)
+				continue next
+			}
+		}
+		b.WriteByte(format[i])
+		i++
+	}
+	return b.String()
+}
+
+// layoutPrecision works out the precision of the dates written with a Go time layout, by
+// seeing whether a date formatted with it shows the day and month
+func layoutPrecision(layout string) DatePrecision {
+	s := time.Date(1987, 11, 29, 0, 0, 0, 0, time.UTC).Format(layout)
+	switch {
+	case strings.Contains(s, "29") || strings.Contains(s, "Sun"):
+		return PRECISION_DAY
+	case strings.Contains(s, "11") || strings.Contains(s, "Nov"):
+		return PRECISION_MONTH
+	}
+	return PRECISION_YEAR
+}
+
+// normalizeDates rewrites the person's dates in the form read by ParsePartialDate, where they
+// can be read with ParseDate. Dates which can't be read are left as they are, to be rejected
+// by ValidatePerson.
+func normalizeDates(rec *Person, opts ReadOptions) {
+	if len(opts.DateFormats) == 0 && (opts.Calendar == "" || opts.Calendar == CALENDAR_GREGORIAN) {
+		return
+	}
+	for _, date := range []*string{&rec.BirthDate, &rec.DeathDate} {
+		if *date == "" {
+			continue
+		}
+		if d, err := ParseDate(*date, opts.DateFormats, opts.Calendar); err == nil {
+			*date = d
+		}
+	}
+}
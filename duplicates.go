@@ -0,0 +1,139 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// What to do with a person found more than once, in the source or the dataset as well
+const (
+	ON_DUPLICATE_OVERWRITE = "overwrite" // keep the last record, a source's before the dataset's
+	ON_DUPLICATE_SKIP      = "skip"      // keep the first record, the dataset's before a source's
+	ON_DUPLICATE_ERROR     = "error"     // import nothing
+	ON_DUPLICATE_MERGE     = "merge"     // fill the gaps in the first record from the others
+)
+
+// ON_DUPLICATE_POLICIES lists the policies accepted by ResolveDuplicates
+var ON_DUPLICATE_POLICIES = []string{ON_DUPLICATE_OVERWRITE, ON_DUPLICATE_SKIP, ON_DUPLICATE_ERROR, ON_DUPLICATE_MERGE}
+
+// ErrDuplicate is returned by ResolveDuplicates, with the ON_DUPLICATE_ERROR policy, when
+// duplicates are found
+var ErrDuplicate = errors.New("duplicate people")
+
+// Duplicate is a person found more than once, as people with the same Key are the same person
+type Duplicate struct {
+	Records []Person // the person's records, in the order found: the dataset's first
+	Kept    Person   // the record imported, by a policy other than ON_DUPLICATE_ERROR
+	Dataset bool     // whether the first of the Records is the person already in the dataset
+}
+
+// Fields returns the names of the fields in which the records of the person differ, in the
+// order of PERSON_FIELDS
+func (d Duplicate) Fields() []string {
+	var fields []string
+	for _, field := range PERSON_FIELDS {
+		for _, rec := range d.Records[1:] {
+			if rec.Field(field) != d.Records[0].Field(field) {
+				fields = append(fields, field)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// ResolveDuplicates finds the people who appear more than once among the records read from a
+// source, or who differ from the same person in the existing dataset, and returns the records
+// with one for each person, chosen by the policy. A person in the dataset whose record is
+// repeated unchanged in the source is not a duplicate. With ON_DUPLICATE_SKIP or
+// ON_DUPLICATE_MERGE the dataset's record is kept in preference to the source's; with
+// ON_DUPLICATE_ERROR the duplicates are returned along with ErrDuplicate.
+func ResolveDuplicates(existing, records []Person, policy string) ([]Person, []Duplicate, error) {
+	switch policy {
+	case ON_DUPLICATE_OVERWRITE, ON_DUPLICATE_SKIP, ON_DUPLICATE_ERROR, ON_DUPLICATE_MERGE:
+	default:
+		return nil, nil, fmt.Errorf("unknown duplicate policy '%s', expected one of %s", policy, strings.Join(ON_DUPLICATE_POLICIES, ", "))
+	}
+	current := make(map[string]Person, len(existing))
+	for _, rec := range existing {
+		current[rec.Key()] = rec
+	}
+	groups := make(map[string]*Duplicate, len(records))
+	var order []string
+	for _, rec := range records {
+		key := rec.Key()
+		d, ok := groups[key]
+		if !ok {
+			d = &Duplicate{}
+			if old, ok := current[key]; ok && old != rec {
+				d.Records, d.Dataset = []Person{old}, true
+			}
+			groups[key] = d
+			order = append(order, key)
+		}
+		d.Records = append(d.Records, rec)
+	}
+
+	resolved := make([]Person, 0, len(order))
+	var duplicates []Duplicate
+	for _, key := range order {
+		d := groups[key]
+		if len(d.Records) == 1 {
+			resolved = append(resolved, d.Records[0])
+			continue
+		}
+		switch policy {
+		case ON_DUPLICATE_OVERWRITE:
+			d.Kept = d.Records[len(d.Records)-1]
+		case ON_DUPLICATE_SKIP:
+			d.Kept = d.Records[0]
+		case ON_DUPLICATE_MERGE:
+			d.Kept = mergePeople(d.Records)
+		}
+		resolved = append(resolved, d.Kept)
+		duplicates = append(duplicates, *d)
+	}
+	if policy == ON_DUPLICATE_ERROR && len(duplicates) > 0 {
+		return nil, duplicates, fmt.Errorf("%w: %d people found more than once, the first being %s", ErrDuplicate, len(duplicates), duplicates[0].Records[0])
+	}
+	return resolved, duplicates, nil
+}
+
+// mergePeople returns the first of the records of a person, with any fields it lacks taken
+// from the others, and the genres, aliases and tags of them all
+func mergePeople(records []Person) Person {
+	merged := records[0]
+	var genres, aliases, tags [][]string
+	for _, rec := range records {
+		for _, field := range PERSON_FIELDS {
+			if merged.Field(field) == "" {
+				merged.SetField(field, rec.Field(field))
+			}
+		}
+		genres = append(genres, rec.Genres())
+		aliases = append(aliases, rec.Names()[1:])
+		tags = append(tags, rec.TagList())
+	}
+	merged.Genre = joinDistinct(genres)
+	merged.Aliases = joinDistinct(aliases)
+	merged.Tags = joinDistinct(tags)
+	return merged
+}
+
+// joinDistinct joins the values of the lists with ';', leaving out any repeated, ignoring case
+func joinDistinct(lists [][]string) string {
+	seen := map[string]bool{}
+	var values []string
+	for _, list := range lists {
+		for _, v := range list {
+			if !seen[strings.ToLower(v)] {
+				seen[strings.ToLower(v)] = true
+				values = append(values, v)
+			}
+		}
+	}
+	return strings.Join(values, ";")
+}
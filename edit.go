@@ -0,0 +1,120 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ErrEditConflict is returned by Edit when the record being edited is no longer the one read,
+// as someone else has changed or removed it meanwhile
+var ErrEditConflict = errors.New("the record was changed by someone else meanwhile")
+
+// editScript replaces a person's record in the hash KEYS[1] with one in KEYS[2] (the same key
+// unless their name or date of birth changed). ARGV[1] gives the number of field and value pairs
+// which follow, which the old hash must hold, so that nothing is changed should someone else
+// have edited it meanwhile. Then follows the number of commands making the edit, each given by
+// its number of arguments, its name and those arguments: moving the person between the sorted
+// sets, writing their hash and updating their entries in the indexes (see personIndex). The
+// keys they name share KEYS[1]'s hash slot. Being a script, the whole edit is made at once.
+var editScript = redis.NewScript(2, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return redis.error_reply('NOTFOUND')
+end
+local a = 2
+for i = 1, tonumber(ARGV[1]) do
+	if (redis.call('HGET', KEYS[1], ARGV[a]) or '') ~= ARGV[a + 1] then
+		return redis.error_reply('CHANGED')
+	end
+	a = a + 2
+end
+if KEYS[2] ~= KEYS[1] and redis.call('EXISTS', KEYS[2]) == 1 then
+	return redis.error_reply('EXISTS')
+end
+local cmds = tonumber(ARGV[a])
+a = a + 1
+for i = 1, cmds do
+	local n = tonumber(ARGV[a])
+	redis.call(unpack(ARGV, a + 1, a + n))
+	a = a + n + 1
+end
+return 1
+`)
+
+// Edit replaces the record of a person in the dataset with the edited one, updating their
+// scores, hash and indexes with a single script. It fails with ErrEditConflict if the record
+// is no longer old, and with ErrDuplicate if the edit changes their name or date of birth to
+// those of someone else in the dataset.
+func (s *RedisStore) Edit(dataset string, old, edited Person) error {
+	if err := ValidateDatasetName(dataset); err != nil {
+		return err
+	}
+	score := 0
+	if edited.Living() {
+		score = birthDay(edited)
+	} else {
+		var err error
+		if score, err = edited.AgeInDays(); err != nil {
+			return err
+		}
+	}
+	oldID, newID := old.ID(), edited.ID()
+	oldKey, newKey := PersonKey(dataset, oldID), PersonKey(dataset, newID)
+	to := DatasetKey(dataset)
+	if edited.Living() {
+		to = LivingKey(dataset)
+	}
+	cmds := []redisCmd{
+		{"ZREM", []interface{}{DatasetKey(dataset), oldID}},
+		{"ZREM", []interface{}{LivingKey(dataset), oldID}},
+		{"DEL", []interface{}{oldKey}},
+	}
+	cmds = append(cmds, indexOf(dataset, old).removeCmds(oldID)...)
+	cmds = append(cmds,
+		redisCmd{"HMSET", personArgs(newKey, edited, score)},
+		redisCmd{"ZADD", []interface{}{to, score, newID}})
+	cmds = append(cmds, indexOf(dataset, edited).addCmds(newID)...)
+
+	// the fields of the person, but not those derived from them, which hashes written by
+	// earlier versions may lack
+	var guards []interface{}
+	pairs := personArgs("", old, 0)[1:]
+	for i := 0; i < len(pairs); i += 2 {
+		if pairs[i] != "name_key" && pairs[i] != "age_days" {
+			guards = append(guards, pairs[i], pairs[i+1])
+		}
+	}
+	args := []interface{}{oldKey, newKey, len(guards) / 2}
+	args = append(append(args, guards...), len(cmds))
+	for _, cmd := range cmds {
+		args = append(append(args, len(cmd.args)+1, cmd.name), cmd.args...)
+	}
+
+	return s.do(func(c redis.Conn) error {
+		if _, err := editScript.Do(c, args...); err != nil {
+			return editError(err, dataset, old, edited)
+		}
+		return recordImport(c, dataset)
+	})
+}
+
+// editError explains an error replied by editScript
+func editError(err error, dataset string, old, edited Person) error {
+	var re redis.Error
+	if !errors.As(err, &re) {
+		return err
+	}
+	switch strings.TrimPrefix(string(re), "ERR ") { // as some servers prefix script errors
+	case "NOTFOUND":
+		return fmt.Errorf("%w: dataset '%s' no longer holds %s", ErrEditConflict, dataset, old)
+	case "CHANGED":
+		return fmt.Errorf("%w: %s", ErrEditConflict, old)
+	case "EXISTS":
+		return fmt.Errorf("%w: dataset '%s' already holds a record for %s", ErrDuplicate, dataset, edited)
+	}
+	return err
+}
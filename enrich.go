@@ -0,0 +1,134 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WIKIPEDIA_SUMMARY_ENDPOINT is the Wikipedia REST API returning the summary of a page by title
+const WIKIPEDIA_SUMMARY_ENDPOINT = "https://en.wikipedia.org/api/rest_v1/page/summary/"
+
+// EnrichOptions controls the lookup of people on Wikipedia
+type EnrichOptions struct {
+	Endpoint    string // defaults to WIKIPEDIA_SUMMARY_ENDPOINT
+	Concurrency int    // the number of lookups made at once, 4 by default
+	Timeout     time.Duration
+}
+
+// EnrichResult is the outcome of looking a person up
+type EnrichResult struct {
+	Person Person // with the article's link, summary and image filled in if found
+	Found  bool
+	Err    error
+}
+
+// wikipediaSummary is the subset of the page summary used here
+type wikipediaSummary struct {
+	Type        string `json:"type"`
+	Extract     string `json:"extract"`
+	Description string `json:"description"`
+	Thumbnail   struct {
+		Source string `json:"source"`
+	} `json:"thumbnail"`
+	ContentURLs struct {
+		Desktop struct {
+			Page string `json:"page"`
+		} `json:"desktop"`
+	} `json:"content_urls"`
+}
+
+// Enrich looks each person up on Wikipedia, with up to opts.Concurrency lookups at once, and
+// calls done with each result from the calling goroutine
+func Enrich(records []Person, opts EnrichOptions, done func(EnrichResult)) {
+	if opts.Endpoint == "" {
+		opts.Endpoint = WIKIPEDIA_SUMMARY_ENDPOINT
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DEFAULT_FETCH_TIMEOUT
+	}
+	client := &http.Client{Timeout: opts.Timeout}
+
+	work := make(chan Person)
+	results := make(chan EnrichResult)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rec := range work {
+				results <- lookupWikipedia(client, opts.Endpoint, rec)
+			}
+		}()
+	}
+	go func() {
+		for _, rec := range records {
+			work <- rec
+		}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+	for res := range results {
+		done(res)
+	}
+}
+
+// lookupWikipedia fetches the summary of the page titled with the person's name. The page is
+// only accepted as being about the person if it mentions the year of their birth or death,
+// which rules out most namesakes and disambiguation pages.
+func lookupWikipedia(client *http.Client, endpoint string, rec Person) EnrichResult {
+	title := strings.ReplaceAll(strings.TrimSpace(rec.Name), " ", "_")
+	req, err := http.NewRequest("GET", endpoint+url.PathEscape(title), nil)
+	if err != nil {
+		return EnrichResult{Person: rec, Err: err}
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", USER_AGENT)
+	resp, err := client.Do(req)
+	if err != nil {
+		return EnrichResult{Person: rec, Err: fmt.Errorf("enrich: %v", err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return EnrichResult{Person: rec}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return EnrichResult{Person: rec, Err: fmt.Errorf("enrich: %s: %s", rec.Name, resp.Status)}
+	}
+	var page wikipediaSummary
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return EnrichResult{Person: rec, Err: fmt.Errorf("enrich: %s: %v", rec.Name, err)}
+	}
+	text := page.Extract + " " + page.Description
+	if page.Type != "standard" || !(mentionsYear(text, rec.BirthDate) || mentionsYear(text, rec.DeathDate)) {
+		return EnrichResult{Person: rec}
+	}
+	rec.URL = page.ContentURLs.Desktop.Page
+	rec.Summary = firstSentence(page.Extract)
+	rec.ImageURL = page.Thumbnail.Source
+	return EnrichResult{Person: rec, Found: true}
+}
+
+// mentionsYear reports whether the text contains the year of the date 'YYYY-MM-DD'
+func mentionsYear(text, date string) bool {
+	return len(date) >= 4 && strings.Contains(text, date[:4])
+}
+
+// firstSentence returns the text up to the end of its first sentence
+func firstSentence(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.Index(text, ". "); i >= 0 {
+		return text[:i+1]
+	}
+	return text
+}
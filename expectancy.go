@@ -0,0 +1,176 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sexes by which life tables are divided
+const (
+	SEX_MALE   = "m"
+	SEX_FEMALE = "f"
+)
+
+// builtinLifeTables holds the life tables built in, as read by ReadLifeTables: the period
+// tables of Great Britain (ONS), the United States (SSA) and Japan (MHLW) from around 2019,
+// rounded to a tenth of a year at every tenth year of age
+//
+//go:embed lifetables.csv
+var builtinLifeTables string
+
+// LifeTable gives the years of life still expected, on average, at each age by those of one sex
+// in one country, as a period life table does
+type LifeTable struct {
+	Country string // ISO 3166 alpha-2, e.g. 'GB'
+	Sex     string // SEX_MALE or SEX_FEMALE
+
+	ages      []float64 // in years, ascending
+	remaining []float64 // the years expected at each of ages
+}
+
+// Expectancy is what a life table expects of someone at their age
+type Expectancy struct {
+	Age       float64   // in years
+	Remaining float64   // the years still expected
+	Expected  float64   // the age expected at death, in years
+	Death     time.Time // the date on which the expected age is reached
+}
+
+// ParseSex reads a sex given as 'm', 'male', 'f' or 'female', in any case, as SEX_MALE or
+// SEX_FEMALE
+func ParseSex(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "m", "male":
+		return SEX_MALE, nil
+	case "f", "female":
+		return SEX_FEMALE, nil
+	}
+	return "", fmt.Errorf("unknown sex '%s': expected 'm' or 'f'", s)
+}
+
+// ReadLifeTables reads life tables from CSV with the header 'country,sex,age,remaining', a row
+// for each age at which the remaining years of life are given. Ages may be in any order.
+func ReadLifeTables(r io.Reader) ([]LifeTable, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || strings.Join(rows[0], ",") != "country,sex,age,remaining" {
+		return nil, fmt.Errorf("life tables must start with the header 'country,sex,age,remaining'")
+	}
+	byKey := map[string]*LifeTable{}
+	var keys []string // in the order first reached
+	for i, row := range rows[1:] {
+		sex, err := ParseSex(row[1])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", i+2, err)
+		}
+		age, err := strconv.ParseFloat(row[2], 64)
+		if err != nil || age < 0 {
+			return nil, fmt.Errorf("row %d: invalid age '%s'", i+2, row[2])
+		}
+		remaining, err := strconv.ParseFloat(row[3], 64)
+		if err != nil || remaining < 0 {
+			return nil, fmt.Errorf("row %d: invalid remaining years '%s'", i+2, row[3])
+		}
+		country := normalizeCountry(row[0])
+		key := country + "," + sex
+		t := byKey[key]
+		if t == nil {
+			t = &LifeTable{Country: country, Sex: sex}
+			byKey[key], keys = t, append(keys, key)
+		}
+		t.ages = append(t.ages, age)
+		t.remaining = append(t.remaining, remaining)
+	}
+	tables := make([]LifeTable, len(keys))
+	for i, key := range keys {
+		t := byKey[key]
+		sort.Sort(byAge{t})
+		tables[i] = *t
+	}
+	return tables, nil
+}
+
+// byAge sorts the rows of a life table by age
+type byAge struct{ t *LifeTable }
+
+func (b byAge) Len() int           { return len(b.t.ages) }
+func (b byAge) Less(i, j int) bool { return b.t.ages[i] < b.t.ages[j] }
+func (b byAge) Swap(i, j int) {
+	b.t.ages[i], b.t.ages[j] = b.t.ages[j], b.t.ages[i]
+	b.t.remaining[i], b.t.remaining[j] = b.t.remaining[j], b.t.remaining[i]
+}
+
+// BuiltinLifeTables returns the life tables built in
+func BuiltinLifeTables() []LifeTable {
+	tables, err := ReadLifeTables(strings.NewReader(builtinLifeTables))
+	if err != nil {
+		panic(err) // the tables are embedded, so this cannot happen
+	}
+	return tables
+}
+
+// FindLifeTable returns the table for the country and sex, 'UK' standing for 'GB'
+func FindLifeTable(tables []LifeTable, country, sex string) (LifeTable, error) {
+	country = normalizeCountry(country)
+	var countries []string
+	for _, t := range tables {
+		if t.Country == country && t.Sex == sex {
+			return t, nil
+		}
+		if len(countries) == 0 || countries[len(countries)-1] != t.Country {
+			countries = append(countries, t.Country)
+		}
+	}
+	return LifeTable{}, fmt.Errorf("no life table for sex '%s' in '%s': there are tables for %s", sex, country, strings.Join(countries, ", "))
+}
+
+// normalizeCountry upper-cases a country code, reading 'UK' as 'GB'
+func normalizeCountry(country string) string {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if country == "UK" {
+		return "GB"
+	}
+	return country
+}
+
+// Remaining returns the years of life still expected at an age in years, interpolated between
+// the ages of the table. Beyond its last age, that of the last is returned.
+func (t LifeTable) Remaining(age float64) float64 {
+	i := sort.SearchFloat64s(t.ages, age)
+	switch {
+	case i == len(t.ages):
+		return t.remaining[i-1]
+	case t.ages[i] == age || i == 0:
+		return t.remaining[i]
+	}
+	f := (age - t.ages[i-1]) / (t.ages[i] - t.ages[i-1])
+	return t.remaining[i-1] + f*(t.remaining[i]-t.remaining[i-1])
+}
+
+// ExpectancyOf returns what the table expects of someone born on birth, as of now
+func (t LifeTable) ExpectancyOf(birth, now time.Time) Expectancy {
+	days, _ := AgeInDays(birth.Format(DATE_FMT), now.Format(DATE_FMT))
+	age := float64(days) / daysInYear
+	remaining := t.Remaining(age)
+	return Expectancy{
+		Age:       age,
+		Remaining: remaining,
+		Expected:  age + remaining,
+		Death:     birth.AddDate(0, 0, days+int(remaining*daysInYear)),
+	}
+}
+
+// Days returns the age expected at death in days, as ages at death are held
+func (e Expectancy) Days() int {
+	return int(e.Expected * daysInYear)
+}
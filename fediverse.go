@@ -0,0 +1,113 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// FEDIVERSE_MAX_CHARS is the length of a post beyond which Mastodon, by default, refuses it
+const FEDIVERSE_MAX_CHARS = 500
+
+// FEDIVERSE_VISIBILITIES are the visibilities of a post: seen by anyone and on the public
+// timelines, by anyone but off them, by followers alone, or by those mentioned alone
+var FEDIVERSE_VISIBILITIES = []string{"public", "unlisted", "private", "direct"}
+
+// ParseFediverseAccount splits an account given as '@name@server', or 'name@server', into the
+// name and the server
+func ParseFediverseAccount(account string) (name, server string, err error) {
+	name, server, ok := strings.Cut(strings.TrimPrefix(account, "@"), "@")
+	if !ok || name == "" || server == "" || strings.ContainsAny(server, "/@") {
+		return "", "", fmt.Errorf("invalid account '%s', expected @name@server", account)
+	}
+	return name, server, nil
+}
+
+// FediverseClient posts as an account through the Mastodon client API, which Pleroma, Akkoma
+// and GoToSocial servers also implement
+type FediverseClient struct {
+	Server string // the host name of the account's server, e.g. 'mastodon.social'
+	Token  string // an access token of the account with the 'write:statuses' scope
+	Client *http.Client
+}
+
+// fediverseError is the body of a response refusing a request
+type fediverseError struct {
+	Error string `json:"error"`
+}
+
+// Account returns the name of the account whose token the client has, as used to check that
+// it posts as the account intended
+func (c FediverseClient) Account(ctx context.Context) (string, error) {
+	var account struct {
+		Username string `json:"username"`
+	}
+	err := c.call(ctx, "GET", "/api/v1/accounts/verify_credentials", nil, "", &account)
+	return account.Username, err
+}
+
+// Post posts the status with the visibility. Posts of the same key within an hour or so are
+// made only once, so that a post retried after an uncertain failure is not made twice.
+func (c FediverseClient) Post(ctx context.Context, status, visibility, key string) error {
+	return c.call(ctx, "POST", "/api/v1/statuses", map[string]string{"status": status, "visibility": visibility}, key, nil)
+}
+
+// call makes the request of the API, decoding the response into result if not nil
+func (c FediverseClient) call(ctx context.Context, method, path string, params interface{}, key string, result interface{}) error {
+	var body io.Reader
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "https://"+c.Server+path, body)
+	if err != nil {
+		return fmt.Errorf("fediverse: invalid server '%s'", c.Server)
+	}
+	if params != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("User-Agent", USER_AGENT)
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return fmt.Errorf("fediverse: %s: %v", c.Server, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("fediverse: %s: %v", c.Server, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		var refusal fediverseError
+		if json.Unmarshal(data, &refusal) == nil && refusal.Error != "" {
+			return fmt.Errorf("fediverse: %s: %s", c.Server, refusal.Error)
+		}
+		return fmt.Errorf("fediverse: %s returned %s", c.Server, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
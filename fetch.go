@@ -0,0 +1,170 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DEFAULT_FETCH_TIMEOUT is used when FetchOptions.Timeout is not set
+const DEFAULT_FETCH_TIMEOUT = 60 * time.Second
+
+// FetchOptions controls how sources given as URLs are downloaded
+type FetchOptions struct {
+	Timeout time.Duration   // for the whole download, including redirects
+	Context context.Context // if set, the download is abandoned once it ends
+	// CacheDir, if set, is where downloads are kept along with their ETag and Last-Modified
+	// headers, so that an unchanged source is not downloaded again
+	CacheDir string
+}
+
+// orBackground returns the context, or the background context if it is nil
+func orBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// IsURL reports whether the source name is an HTTP or HTTPS URL rather than a file name
+func IsURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// fetchMeta is stored alongside a cached download
+type fetchMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// OpenURL starts downloading the URL, returning its body and its size if known (otherwise -1).
+// Redirects are followed, and gzip content encoding is decoded. With a cache directory, a
+// conditional request is made and a 304 Not Modified response is answered from the cache.
+func OpenURL(url string, opts FetchOptions) (io.ReadCloser, int64, error) {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DEFAULT_FETCH_TIMEOUT
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(orBackground(opts.Context), "GET", url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetch: %v", err)
+	}
+	var bodyPath, metaPath string
+	var meta fetchMeta
+	if opts.CacheDir != "" {
+		sum := sha1.Sum([]byte(url))
+		base := filepath.Join(opts.CacheDir, hex.EncodeToString(sum[:]))
+		bodyPath, metaPath = base+".body", base+".json"
+		if data, err := os.ReadFile(metaPath); err == nil && json.Unmarshal(data, &meta) == nil && meta.URL == url {
+			if meta.ETag != "" {
+				req.Header.Set("If-None-Match", meta.ETag)
+			}
+			if meta.LastModified != "" {
+				req.Header.Set("If-Modified-Since", meta.LastModified)
+			}
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetch: %v", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && bodyPath != "":
+		resp.Body.Close()
+		f, err := os.Open(bodyPath)
+		if err != nil {
+			return nil, 0, fmt.Errorf("fetch: cached copy of %s: %v", url, err)
+		}
+		size := int64(-1)
+		if fi, err := f.Stat(); err == nil {
+			size = fi.Size()
+		}
+		return f, size, nil
+	case resp.StatusCode != http.StatusOK:
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("fetch: %s: %s", url, resp.Status)
+	}
+	if bodyPath == "" || (resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "") {
+		return resp.Body, resp.ContentLength, nil
+	}
+	meta = fetchMeta{URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	body, err := newCachingReader(resp.Body, bodyPath, metaPath, meta)
+	if err != nil { // caching is an optimisation, so carry on without it
+		return resp.Body, resp.ContentLength, nil
+	}
+	return body, resp.ContentLength, nil
+}
+
+// cachingReader copies a download into the cache as it is read. The copy is only kept if the
+// whole body was read.
+type cachingReader struct {
+	body     io.ReadCloser
+	tmp      *os.File
+	bodyPath string
+	metaPath string
+	meta     fetchMeta
+	complete bool
+}
+
+func newCachingReader(body io.ReadCloser, bodyPath, metaPath string, meta fetchMeta) (*cachingReader, error) {
+	if err := os.MkdirAll(filepath.Dir(bodyPath), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(bodyPath), "download-*")
+	if err != nil {
+		return nil, err
+	}
+	return &cachingReader{body: body, tmp: tmp, bodyPath: bodyPath, metaPath: metaPath, meta: meta}, nil
+}
+
+func (c *cachingReader) Read(p []byte) (int, error) {
+	n, err := c.body.Read(p)
+	if n > 0 {
+		if _, werr := c.tmp.Write(p[:n]); werr != nil {
+			return n, werr
+		}
+	}
+	if err == io.EOF {
+		c.complete = true
+	}
+	return n, err
+}
+
+func (c *cachingReader) Close() error {
+	err := c.body.Close()
+	tmpErr := c.tmp.Close()
+	if !c.complete || tmpErr != nil {
+		os.Remove(c.tmp.Name())
+		return err
+	}
+	if os.Rename(c.tmp.Name(), c.bodyPath) != nil {
+		os.Remove(c.tmp.Name())
+		return err
+	}
+	if data, jerr := json.Marshal(c.meta); jerr == nil {
+		os.WriteFile(c.metaPath, data, 0644)
+	}
+	return err
+}
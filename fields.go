@@ -0,0 +1,256 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Names of the fields of a Person, as used in a FieldMap
+const (
+	FIELD_NAME           = "name"
+	FIELD_BIRTH          = "birth"
+	FIELD_DEATH          = "death"
+	FIELD_OCCUPATION     = "occupation"
+	FIELD_NATIONALITY    = "nationality"
+	FIELD_CAUSE_OF_DEATH = "cause_of_death"
+	FIELD_GENRE          = "genre"
+	FIELD_URL            = "url"
+	FIELD_SUMMARY        = "summary"
+	FIELD_IMAGE_URL      = "image_url"
+	FIELD_ALIASES        = "aliases"
+	FIELD_TAGS           = "tags"
+)
+
+// PERSON_FIELDS lists the fields of a Person in the order of the columns of a CSV file
+var PERSON_FIELDS = []string{
+	FIELD_NAME, FIELD_BIRTH, FIELD_DEATH, FIELD_OCCUPATION, FIELD_NATIONALITY,
+	FIELD_CAUSE_OF_DEATH, FIELD_GENRE, FIELD_URL, FIELD_SUMMARY, FIELD_IMAGE_URL, FIELD_ALIASES,
+	FIELD_TAGS,
+}
+
+// fieldAliases are alternative names accepted for fields, including the JSON keys of a Person
+var fieldAliases = map[string]string{
+	"birth_date":    FIELD_BIRTH,
+	"date_of_birth": FIELD_BIRTH,
+	"born":          FIELD_BIRTH,
+	"death_date":    FIELD_DEATH,
+	"date_of_death": FIELD_DEATH,
+	"died":          FIELD_DEATH,
+	"cause":         FIELD_CAUSE_OF_DEATH,
+	"genres":        FIELD_GENRE,
+	"alias":         FIELD_ALIASES,
+	"aka":           FIELD_ALIASES,
+	"also_known_as": FIELD_ALIASES,
+	"tag":           FIELD_TAGS,
+}
+
+// FieldMap says where in a source each field of a Person is found, keyed by field name, e.g.
+// {"name": "full_name", "birth": "born"}. A key is that of a JSON object, or the header or
+// number (counting from 1) of a column. Fields which are not mapped are looked for under
+// their usual names.
+type FieldMap map[string]string
+
+// ParseFieldMap parses a comma separated list of FIELD=KEY pairs, such as
+// 'name=full_name,birth=born,death=died' or 'name=2,birth=5,death=6'
+func ParseFieldMap(s string) (FieldMap, error) {
+	m := FieldMap{}
+	for _, pair := range SplitList(s) {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid field mapping '%s': expected FIELD=KEY", pair)
+		}
+		field, key := fieldName(strings.TrimSpace(pair[:i])), strings.TrimSpace(pair[i+1:])
+		if field == "" {
+			return nil, fmt.Errorf("unknown field '%s' in mapping, expected one of %s", pair[:i], strings.Join(PERSON_FIELDS, ", "))
+		}
+		if key == "" {
+			return nil, fmt.Errorf("invalid field mapping '%s': missing key", pair)
+		}
+		m[field] = key
+	}
+	return m, nil
+}
+
+// String formats the mapping as accepted by ParseFieldMap
+func (m FieldMap) String() string {
+	pairs := make([]string, 0, len(m))
+	for field, key := range m {
+		pairs = append(pairs, field+"="+key)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// fieldName returns the field of a Person with the given name or alias, or "" if there is none
+func fieldName(name string) string {
+	name = strings.ToLower(name)
+	if alias, ok := fieldAliases[name]; ok {
+		return alias
+	}
+	for _, f := range PERSON_FIELDS {
+		if f == name {
+			return f
+		}
+	}
+	return ""
+}
+
+// requiredFields returns the fields which every record must have, which for living people
+// leaves out the date of death
+func requiredFields(living bool) []string {
+	if living {
+		return []string{FIELD_NAME, FIELD_BIRTH}
+	}
+	return []string{FIELD_NAME, FIELD_BIRTH, FIELD_DEATH}
+}
+
+// columnsFor works out which column of a table holds each field, given its header row, or nil
+// if it has none. Fields in the map are found by column number, or else by header. Other
+// fields are found in the column headed with their name or an alias (ignoring case, and
+// reading spaces as '_', so that 'Birth Date' holds the date of birth). In a table without a
+// header, the columns are in the order of PERSON_FIELDS, unless a map is given, in which case
+// only the fields it names are read. It is an error for any of the required fields to be
+// missing.
+func columnsFor(header []string, fields FieldMap, required []string) (map[string]int, error) {
+	columns := map[string]int{}
+	for field, key := range fields {
+		if n, err := strconv.Atoi(key); err == nil {
+			if n < 1 {
+				return nil, fmt.Errorf("invalid column number %d for the field '%s', columns count from 1", n, field)
+			}
+			columns[field] = n - 1
+			continue
+		}
+		if header == nil {
+			return nil, fmt.Errorf("the field '%s' is mapped to the header '%s', but there is no header row", field, key)
+		}
+		for i, h := range header {
+			if normalizeHeader(h) == normalizeHeader(key) {
+				columns[field] = i
+				break
+			}
+		}
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("no column headed '%s' for the field '%s'", key, field)
+		}
+	}
+	switch {
+	case header != nil:
+		for i, h := range header {
+			field := fieldName(normalizeHeader(h))
+			if _, mapped := fields[field]; field == "" || mapped {
+				continue
+			}
+			if _, ok := columns[field]; !ok {
+				columns[field] = i
+			}
+		}
+	case len(fields) == 0:
+		for i, field := range PERSON_FIELDS {
+			columns[field] = i
+		}
+	}
+	for _, field := range required {
+		if _, ok := columns[field]; !ok {
+			if header == nil {
+				return nil, fmt.Errorf("no column given for the field '%s'", field)
+			}
+			return nil, fmt.Errorf("no column found for the field '%s' among the headers %s; name it with a field mapping",
+				field, strings.Join(header, ", "))
+		}
+	}
+	return columns, nil
+}
+
+// normalizeHeader lower-cases a column header and joins its words with '_'
+func normalizeHeader(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.ReplaceAll(s, "_", " "))), "_")
+}
+
+// personFromColumns maps a row onto a Person using the columns found by columnsFor
+func personFromColumns(row []string, columns map[string]int) Person {
+	var rec Person
+	for field, i := range columns {
+		if i < len(row) {
+			rec.SetField(field, strings.TrimSpace(row[i]))
+		}
+	}
+	return rec
+}
+
+// aliasesOf returns the alternative names of a field, in alphabetical order
+func aliasesOf(field string) []string {
+	var aliases []string
+	for alias, f := range fieldAliases {
+		if f == field {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// SetField sets the named field of the person, one of PERSON_FIELDS
+func (rec *Person) SetField(field, value string) {
+	switch field {
+	case FIELD_NAME:
+		rec.Name = value
+	case FIELD_BIRTH:
+		rec.BirthDate = value
+	case FIELD_DEATH:
+		rec.DeathDate = value
+	case FIELD_OCCUPATION:
+		rec.Occupation = value
+	case FIELD_NATIONALITY:
+		rec.Nationality = value
+	case FIELD_CAUSE_OF_DEATH:
+		rec.CauseOfDeath = value
+	case FIELD_GENRE:
+		rec.Genre = value
+	case FIELD_URL:
+		rec.URL = value
+	case FIELD_SUMMARY:
+		rec.Summary = value
+	case FIELD_IMAGE_URL:
+		rec.ImageURL = value
+	case FIELD_ALIASES:
+		rec.Aliases = value
+	case FIELD_TAGS:
+		rec.Tags = value
+	}
+}
+
+// Field returns the named field of the person, or "" for an unknown field
+func (rec Person) Field(field string) string {
+	switch field {
+	case FIELD_NAME:
+		return rec.Name
+	case FIELD_BIRTH:
+		return rec.BirthDate
+	case FIELD_DEATH:
+		return rec.DeathDate
+	case FIELD_OCCUPATION:
+		return rec.Occupation
+	case FIELD_NATIONALITY:
+		return rec.Nationality
+	case FIELD_CAUSE_OF_DEATH:
+		return rec.CauseOfDeath
+	case FIELD_GENRE:
+		return rec.Genre
+	case FIELD_URL:
+		return rec.URL
+	case FIELD_SUMMARY:
+		return rec.Summary
+	case FIELD_IMAGE_URL:
+		return rec.ImageURL
+	case FIELD_ALIASES:
+		return rec.Aliases
+	case FIELD_TAGS:
+		return rec.Tags
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Filter selects records by their optional fields, and by the years of their death and birth.
+// Empty fields match every record. Each field of a record may hold several values separated by
+// ';', any of which can match, and comparisons ignore case.
+type Filter struct {
+	Occupation  string
+	Nationality string
+	Genre       string
+	Cause       string // of death
+	Tag         string
+
+	// The bounds on years, numbered astronomically so that 1 BCE is year 0, apply if not nil
+	DiedAfter  *int // only those who died in this year or later
+	DiedBefore *int // only those who died before this year
+	BornFrom   *int // only those born in this year or later
+	BornTo     *int // only those born in this year or earlier
+}
+
+// Year returns a pointer to the year, as a bound of a Filter
+func Year(year int) *int {
+	return &year
+}
+
+// ParseDecade reads a decade written as '1940s' or '1940', returning its first and last years
+func ParseDecade(s string) (int, int, error) {
+	year, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(s), "s"))
+	if err != nil || year%10 != 0 {
+		return 0, 0, fmt.Errorf("invalid decade '%s': expected one such as '1940s'", s)
+	}
+	return year, year + 9, nil
+}
+
+// hasYears reports whether the filter selects by the years of death or birth
+func (f Filter) hasYears() bool {
+	return f.DiedAfter != nil || f.DiedBefore != nil || f.BornFrom != nil || f.BornTo != nil
+}
+
+// searchable reports whether the filter selects only by the fields a search index holds as tags
+// (see tagQuery)
+func (f Filter) searchable() bool {
+	return f.Cause == "" && f.Tag == "" && !f.hasYears()
+}
+
+// IsEmpty reports whether the filter matches every record
+func (f Filter) IsEmpty() bool {
+	return f == Filter{}
+}
+
+// Match reports whether the record satisfies every field of the filter
+func (f Filter) Match(rec Person) bool {
+	return matchesAny(rec.Occupation, f.Occupation) &&
+		matchesAny(rec.Nationality, f.Nationality) &&
+		matchesAny(rec.Genre, f.Genre) &&
+		matchesAny(rec.CauseOfDeath, f.Cause) &&
+		matchesAny(rec.Tags, f.Tag) &&
+		inYears(rec.DeathDate, f.DiedAfter, f.DiedBefore, true) &&
+		inYears(rec.BirthDate, f.BornFrom, f.BornTo, false)
+}
+
+// inYears reports whether the year of the date lies from from up to to, including to unless
+// toExcluded, either bound being ignored if nil, or whether no bound is given at all. A date
+// without a year lies within no bounds.
+func inYears(date string, from, to *int, toExcluded bool) bool {
+	if from == nil && to == nil {
+		return true
+	}
+	year, ok := yearOf(date)
+	if !ok || from != nil && year < *from {
+		return false
+	}
+	return to == nil || year < *to || year == *to && !toExcluded
+}
+
+// FilterResults returns the results matching the filter
+func FilterResults(results []Result, f Filter) []Result {
+	if f.IsEmpty() {
+		return results
+	}
+	filtered := results[:0]
+	for _, res := range results {
+		if f.Match(res.Person) {
+			filtered = append(filtered, res)
+		}
+	}
+	return filtered
+}
+
+func matchesAny(values, want string) bool {
+	if want == "" {
+		return true
+	}
+	for _, v := range strings.Split(values, ";") {
+		if strings.EqualFold(strings.TrimSpace(v), want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteICal writes the milestones of someone born on birthDate as an iCalendar (RFC 5545)
+// calendar, with an all-day event on the date each person is outlived
+func WriteICal(w io.Writer, birthDate string, ms []Milestone, now time.Time) error {
+	bw := bufio.NewWriter(w)
+	line := func(s string) { writeICalLine(bw, s) }
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//outlived//outlived//EN")
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:" + icalEscape("Outlived milestones for "+birthDate))
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, m := range ms {
+		n := Notification{BirthDate: birthDate, Milestone: m}
+		line("BEGIN:VEVENT")
+		line(fmt.Sprintf("UID:%s-%s-%s@outlived", birthDate, m.Dataset, m.ID()))
+		line("DTSTAMP:" + stamp)
+		line("DTSTART;VALUE=DATE:" + m.Date.Format("20060102"))
+		line("DTEND;VALUE=DATE:" + m.Date.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY:" + icalEscape("Outlive "+m.Name))
+		line("DESCRIPTION:" + icalEscape(fmt.Sprintf("%s. %s was born on %s and died on %s.", n.Text(), m.Name, m.BirthDate, m.DeathDate)))
+		line("TRANSP:TRANSPARENT")
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return bw.Flush()
+}
+
+// writeICalLine writes a content line, folding it so that no line exceeds 75 octets
+func writeICalLine(w *bufio.Writer, s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8Start(s[cut]) { // do not split a multi-byte character
+			cut--
+		}
+		w.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = 74 // continuation lines begin with a space
+	}
+	w.WriteString(s + "\r\n")
+}
+
+func utf8Start(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// icalEscape escapes a TEXT property value
+func icalEscape(s string) string {
+	return icalEscaper.Replace(s)
+}
@@ -0,0 +1,283 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// INDEXED_SCHEMA_VERSION is the first layout with the sorted set by year of death and the sets
+// by occupation, which datasets imported in an earlier one lack until they are migrated
+const INDEXED_SCHEMA_VERSION = 3
+
+// BIRTH_YEARS_SCHEMA_VERSION is the first layout with the sorted set by year of birth
+const BIRTH_YEARS_SCHEMA_VERSION = 4
+
+// DayKey returns the key of the set indexing the people in the dataset who died, or were born,
+// on a day of the year, e.g. 'outlived:{actors}:died:09-18'
+func DayKey(dataset, which, day string) string {
+	return DatasetKey(dataset) + ":" + which + ":" + day
+}
+
+// dayKeys returns the keys of every set indexing the dataset by day
+func dayKeys(dataset string) []interface{} {
+	var keys []interface{}
+	for _, which := range []string{DAY_DIED, DAY_BORN} {
+		for _, day := range allDays() {
+			keys = append(keys, DayKey(dataset, which, day))
+		}
+	}
+	return keys
+}
+
+// NamesKey returns the key of the hash mapping the ID of each person in the dataset to their
+// name and aliases folded by NameKey, e.g. 'outlived:{actors}:names'
+func NamesKey(dataset string) string {
+	return DatasetKey(dataset) + ":names"
+}
+
+// DeathYearsKey returns the key of the sorted set holding the IDs of those in the dataset who
+// have died, scored by the year of their death, e.g. 'outlived:{actors}:death-years'
+func DeathYearsKey(dataset string) string {
+	return DatasetKey(dataset) + ":death-years"
+}
+
+// BirthYearsKey returns the key of the sorted set holding the IDs of everyone in the dataset,
+// living or dead, scored by the year of their birth, e.g. 'outlived:{actors}:birth-years'
+func BirthYearsKey(dataset string) string {
+	return DatasetKey(dataset) + ":birth-years"
+}
+
+// OccupationKey returns the key of the set indexing the people in the dataset with an
+// occupation, folded by foldValue, e.g. 'outlived:{actors}:occupation:singer'
+func OccupationKey(dataset, occupation string) string {
+	return occupationIndex.key(dataset, occupation)
+}
+
+// OccupationsKey returns the key of the set of the folded occupations for which the dataset has
+// a set, e.g. 'outlived:{actors}:occupations'. An occupation stays in it once its last holder is
+// removed, until the dataset is next imported.
+func OccupationsKey(dataset string) string {
+	return occupationIndex.registry(dataset)
+}
+
+// TagKey returns the key of the set indexing the people in the dataset with a tag, folded by
+// foldValue, e.g. 'outlived:{actors}:tag:grammy-winner'
+func TagKey(dataset, tag string) string {
+	return tagIndex.key(dataset, tag)
+}
+
+// TagsKey returns the key of the set of the folded tags for which the dataset has a set, e.g.
+// 'outlived:{actors}:tags', which like the set of occupations keeps a tag until the dataset is
+// next imported
+func TagsKey(dataset string) string {
+	return tagIndex.registry(dataset)
+}
+
+// valueIndex is an index of a dataset by the values of a field: a set of the IDs of those with
+// each value, and a set of the values for which there is one, so that the sets can be found
+type valueIndex struct {
+	name  string // forming the keys of the sets, as 'occupation' does 'occupation:singer'
+	field string // one of PERSON_FIELDS, holding values separated by ';'
+}
+
+var (
+	occupationIndex = valueIndex{"occupation", FIELD_OCCUPATION}
+	tagIndex        = valueIndex{"tag", FIELD_TAGS}
+	valueIndexes    = []valueIndex{occupationIndex, tagIndex}
+)
+
+// key returns the key of the set of those with the value
+func (vi valueIndex) key(dataset, value string) string {
+	return DatasetKey(dataset) + ":" + vi.name + ":" + foldValue(value)
+}
+
+// registry returns the key of the set of the values for which there is a set
+func (vi valueIndex) registry(dataset string) string {
+	return DatasetKey(dataset) + ":" + vi.name + "s"
+}
+
+// valuesOf returns the person's folded values of the field, of which it may hold several
+// separated by ';'
+func (vi valueIndex) valuesOf(rec Person) []string {
+	var values []string
+	for _, v := range strings.Split(rec.Field(vi.field), ";") {
+		if v = foldValue(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// foldValue folds a value as its index holds it, ignoring case as Filter does
+func foldValue(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// yearOf returns the year of the date, which may be partial, reporting false if it has none
+func yearOf(date string) (int, bool) {
+	t, _, err := ParsePartialDate(date)
+	if err != nil {
+		return 0, false
+	}
+	return t.Year(), true
+}
+
+// personIndex holds the entries a person has in the indexes RedisStore keeps of their dataset
+// beside its sorted sets: the hash of names, the sets by day of death and of birth, by
+// occupation and by tag, and the sorted sets by year of death and of birth. Only those who have
+// died are indexed by day and year of death. Every write of the dataset updates them through
+// it, in the same transaction or script as the person's hash.
+type personIndex struct {
+	dataset   string
+	nameKey   string              // held under their ID in the hash of names
+	sets      []string            // the keys of the sets holding their ID
+	deathYear int                 // their score in the sorted set by year of death, if hasDeathYear
+	birthYear int                 // their score in the sorted set by year of birth, if hasBirthYear
+	values    map[string][]string // their folded values, keyed by the registry of each valueIndex
+
+	hasDeathYear, hasBirthYear bool
+}
+
+// indexOf returns the person's entries in the dataset's indexes
+func indexOf(dataset string, rec Person) personIndex {
+	ix := personIndex{dataset: dataset, nameKey: NameKey(rec), values: map[string][]string{}}
+	ix.birthYear, ix.hasBirthYear = yearOf(rec.BirthDate)
+	if !rec.Living() {
+		for _, which := range []string{DAY_DIED, DAY_BORN} {
+			if day := DayOf(dateOf(rec, which)); day != "" {
+				ix.sets = append(ix.sets, DayKey(dataset, which, day))
+			}
+		}
+		ix.deathYear, ix.hasDeathYear = yearOf(rec.DeathDate)
+	}
+	for _, vi := range valueIndexes {
+		values := vi.valuesOf(rec)
+		for _, v := range values {
+			ix.sets = append(ix.sets, vi.key(dataset, v))
+		}
+		ix.values[vi.registry(dataset)] = values
+	}
+	return ix
+}
+
+// addCmds returns the commands adding the person, with the ID given, to the indexes
+func (ix personIndex) addCmds(id string) []redisCmd {
+	cmds := []redisCmd{{"HSET", []interface{}{NamesKey(ix.dataset), id, ix.nameKey}}}
+	for _, key := range ix.sets {
+		cmds = append(cmds, redisCmd{"SADD", []interface{}{key, id}})
+	}
+	if ix.hasDeathYear {
+		cmds = append(cmds, redisCmd{"ZADD", []interface{}{DeathYearsKey(ix.dataset), ix.deathYear, id}})
+	}
+	if ix.hasBirthYear {
+		cmds = append(cmds, redisCmd{"ZADD", []interface{}{BirthYearsKey(ix.dataset), ix.birthYear, id}})
+	}
+	for _, vi := range valueIndexes {
+		registry := vi.registry(ix.dataset)
+		if values := ix.values[registry]; len(values) > 0 {
+			args := []interface{}{registry}
+			for _, v := range values {
+				args = append(args, v)
+			}
+			cmds = append(cmds, redisCmd{"SADD", args})
+		}
+	}
+	return cmds
+}
+
+// removeCmds returns the commands removing the person, with the ID given, from the indexes.
+// Their values stay in the sets of values, such as that of occupations, as others may share them.
+func (ix personIndex) removeCmds(id string) []redisCmd {
+	cmds := []redisCmd{{"HDEL", []interface{}{NamesKey(ix.dataset), id}}}
+	for _, key := range ix.sets {
+		cmds = append(cmds, redisCmd{"SREM", []interface{}{key, id}})
+	}
+	if ix.hasDeathYear {
+		cmds = append(cmds, redisCmd{"ZREM", []interface{}{DeathYearsKey(ix.dataset), id}})
+	}
+	if ix.hasBirthYear {
+		cmds = append(cmds, redisCmd{"ZREM", []interface{}{BirthYearsKey(ix.dataset), id}})
+	}
+	return cmds
+}
+
+// stagedIndexCmds returns the commands adding a batch of people, whose IDs are given, to the
+// indexes an import writes beside the dataset's, with one command adding all of the batch to
+// each index
+func stagedIndexCmds(dataset string, people []Person, ids []string) []redisCmd {
+	names := []interface{}{stagingKey(NamesKey(dataset))}
+	years := []interface{}{stagingKey(DeathYearsKey(dataset))}
+	births := []interface{}{stagingKey(BirthYearsKey(dataset))}
+	registries := map[string][]interface{}{}
+	for _, vi := range valueIndexes {
+		k := stagingKey(vi.registry(dataset))
+		registries[k] = []interface{}{k}
+	}
+	sets := map[string][]interface{}{}
+	var keys []string // of sets, in the order first reached
+	for i, rec := range people {
+		ix := indexOf(dataset, rec)
+		names = append(names, ids[i], ix.nameKey)
+		for _, key := range ix.sets {
+			k := stagingKey(key)
+			if sets[k] == nil {
+				keys = append(keys, k)
+			}
+			sets[k] = append(sets[k], ids[i])
+		}
+		if ix.hasDeathYear {
+			years = append(years, ix.deathYear, ids[i])
+		}
+		if ix.hasBirthYear {
+			births = append(births, ix.birthYear, ids[i])
+		}
+		for registry, values := range ix.values {
+			k := stagingKey(registry)
+			for _, v := range values {
+				registries[k] = append(registries[k], v)
+			}
+		}
+	}
+	var cmds []redisCmd
+	if len(names) > 1 {
+		cmds = append(cmds, redisCmd{"HMSET", names})
+	}
+	if len(years) > 1 {
+		cmds = append(cmds, redisCmd{"ZADD", years})
+	}
+	if len(births) > 1 {
+		cmds = append(cmds, redisCmd{"ZADD", births})
+	}
+	for _, vi := range valueIndexes {
+		if args := registries[stagingKey(vi.registry(dataset))]; len(args) > 1 {
+			cmds = append(cmds, redisCmd{"SADD", args})
+		}
+	}
+	for _, k := range keys {
+		cmds = append(cmds, redisCmd{"SADD", append([]interface{}{k}, sets[k]...)})
+	}
+	return cmds
+}
+
+// valueSets returns the keys of the sets indexing the dataset by each value held in the sets of
+// values of every valueIndex, either the dataset's or, if staged, those staged beside it
+func valueSets(c redis.Conn, dataset string, staged bool) ([]string, error) {
+	var keys []string
+	for _, vi := range valueIndexes {
+		registry := vi.registry(dataset)
+		if staged {
+			registry = stagingKey(registry)
+		}
+		values, err := redis.Strings(c.Do("SMEMBERS", registry))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			keys = append(keys, vi.key(dataset, v))
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,197 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteJSON writes the records as an indented JSON array of objects with 'name',
+// 'birth_date' and 'death_date' keys, plus any of the optional fields which are set
+func WriteJSON(w io.Writer, records []Person) error {
+	if records == nil {
+		records = []Person{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// ReadJSON parses either a JSON array of objects, or a stream of objects such as a file with
+// one object per line (JSONL), and returns its contents as a 'Person' array. Each field is
+// read from the key given for it in opts.Fields, or else from its usual name, so that the
+// output of WriteJSON can be read back. A key may be a path into nested objects, such as
+// 'born.date', and values which are arrays, such as a list of genres, are joined with ';'.
+//
+// The Line of a Rejection is the position of the object in the input, counting from 1.
+func ReadJSON(r io.Reader, opts ReadOptions) ([]Person, Progress, error) {
+	tracker := newProgressTracker(opts.Context, r, opts.Size, opts.Progress, opts.ProgressInterval)
+	br := bufio.NewReader(tracker)
+	array, err := isJSONArray(br)
+	if err != nil {
+		return nil, tracker.progress(), fmt.Errorf("file parse: %v", err)
+	}
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return nil, tracker.progress(), fmt.Errorf("file parse: %v", err)
+		}
+	}
+
+	var allRecords []Person
+	for n := 1; ; n++ {
+		if array && !dec.More() {
+			break
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF && !array {
+			break
+		} else if err != nil {
+			return nil, tracker.progress(), fmt.Errorf("file parse: record %d: %v", n, err)
+		}
+		var obj map[string]interface{}
+		var invalid error
+		rec := Person{}
+		d := json.NewDecoder(bytes.NewReader(raw))
+		d.UseNumber()
+		if err := d.Decode(&obj); err != nil || obj == nil {
+			invalid = fmt.Errorf("expected an object, got %s", jsonType(raw))
+		} else {
+			rec = personFromJSON(obj, opts.Fields)
+			normalizeDates(&rec, opts)
+			invalid = opts.validate(rec)
+		}
+		if invalid != nil {
+			if opts.Reject == nil {
+				return nil, tracker.progress(), fmt.Errorf("file parse: record %d: %v", n, invalid)
+			}
+			var compact bytes.Buffer
+			json.Compact(&compact, raw)
+			opts.Reject(Rejection{Line: n, Record: []string{compact.String()}, Reason: invalid.Error()})
+			tracker.row(true)
+			continue
+		}
+		allRecords = append(allRecords, rec)
+		tracker.row(false)
+	}
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return nil, tracker.progress(), fmt.Errorf("file parse: %v", err)
+		}
+	}
+	return allRecords, tracker.finish(), nil
+}
+
+// isJSONArray reports whether the first value in the input is an array, skipping any leading
+// whitespace and byte order mark
+func isJSONArray(br *bufio.Reader) (bool, error) {
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+			continue
+		case 0xef: // a UTF-8 byte order mark
+			if bom, _ := br.Peek(3); bytes.Equal(bom, []byte{0xef, 0xbb, 0xbf}) {
+				br.Discard(3)
+				continue
+			}
+		}
+		return b[0] == '[', nil
+	}
+}
+
+// personFromJSON maps a JSON object onto a Person
+func personFromJSON(obj map[string]interface{}, fields FieldMap) Person {
+	var rec Person
+	for _, field := range PERSON_FIELDS {
+		keys := []string{field}
+		if key, ok := fields[field]; ok {
+			keys = []string{key}
+		} else {
+			keys = append(keys, aliasesOf(field)...)
+		}
+		for _, key := range keys {
+			if v, ok := lookupJSON(obj, key); ok {
+				rec.SetField(field, jsonString(v))
+				break
+			}
+		}
+	}
+	return rec
+}
+
+// lookupJSON finds the value with the key, which may be a dotted path into nested objects
+func lookupJSON(obj map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := obj[key]; ok {
+		return v, true
+	}
+	for {
+		i := strings.Index(key, ".")
+		if i < 0 {
+			return nil, false
+		}
+		child, ok := obj[key[:i]].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		obj, key = child, key[i+1:]
+		if v, ok := obj[key]; ok {
+			return v, true
+		}
+	}
+}
+
+// jsonString converts a JSON value to the string held in a field of a Person
+func jsonString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		var parts []string
+		for _, e := range v {
+			if s := jsonString(e); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ";")
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// jsonType describes the kind of a JSON value, for error messages
+func jsonType(raw json.RawMessage) string {
+	switch s := bytes.TrimSpace(raw); {
+	case len(s) == 0:
+		return "nothing"
+	case s[0] == '[':
+		return "an array"
+	case s[0] == '"':
+		return "a string"
+	case s[0] == 'n':
+		return "null"
+	case s[0] == 't' || s[0] == 'f':
+		return "a boolean"
+	}
+	return "a number"
+}
@@ -0,0 +1,204 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MemoryStore holds datasets in memory, with records sorted by age at death in days.
+// It is intended for small datasets loaded directly from a file at query time.
+type MemoryStore struct {
+	datasets map[string][]Result
+	living   map[string][]Person // ordered by date of birth
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{datasets: make(map[string][]Result), living: make(map[string][]Person)}
+}
+
+// Import replaces the records held in memory for the dataset with the given records
+func (s *MemoryStore) Import(dataset string, records []Person) error {
+	if err := ValidateDatasetName(dataset); err != nil {
+		return err
+	}
+	dead, living := SplitLiving(dedupePeople(records))
+	results, err := NewResults(dead)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Days < results[j].Days })
+	sortByBirth(living)
+	s.datasets[dataset] = results
+	s.living[dataset] = living
+	return nil
+}
+
+// Upsert merges the records into the dataset held in memory
+func (s *MemoryStore) Upsert(dataset string, records []Person) (UpsertStats, error) {
+	var stats UpsertStats
+	if err := ValidateDatasetName(dataset); err != nil {
+		return stats, err
+	}
+	var merged []Person
+	for _, res := range s.datasets[dataset] {
+		merged = append(merged, res.Person)
+	}
+	merged = append(merged, s.living[dataset]...)
+	index := make(map[string]int, len(merged))
+	for i, rec := range merged {
+		index[rec.Key()] = i
+	}
+	for _, rec := range dedupePeople(records) {
+		i, ok := index[rec.Key()]
+		switch {
+		case !ok:
+			index[rec.Key()] = len(merged)
+			merged = append(merged, rec)
+			stats.Inserted++
+		case merged[i] != rec:
+			merged[i] = rec // which moves anyone who has died since from the living
+			stats.Updated++
+		default:
+			stats.Unchanged++
+		}
+	}
+	return stats, s.Import(dataset, merged)
+}
+
+// Remove deletes the records of the same people as those given from the dataset held in memory
+func (s *MemoryStore) Remove(dataset string, people []Person) (int, error) {
+	if err := ValidateDatasetName(dataset); err != nil {
+		return 0, err
+	}
+	gone := make(map[string]bool, len(people))
+	for _, rec := range people {
+		gone[rec.Key()] = true
+	}
+	var kept []Person
+	removed := 0
+	for _, res := range s.datasets[dataset] {
+		if gone[res.Key()] {
+			removed++
+		} else {
+			kept = append(kept, res.Person)
+		}
+	}
+	for _, rec := range s.living[dataset] {
+		if gone[rec.Key()] {
+			removed++
+		} else {
+			kept = append(kept, rec)
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.Import(dataset, kept)
+}
+
+// Edit replaces the record of a person in the dataset held in memory with the edited one
+func (s *MemoryStore) Edit(dataset string, old, edited Person) error {
+	if err := ValidateDatasetName(dataset); err != nil {
+		return err
+	}
+	var people []Person
+	for _, res := range s.datasets[dataset] {
+		people = append(people, res.Person)
+	}
+	people = append(people, s.living[dataset]...)
+	found := -1
+	for i, rec := range people {
+		switch rec.Key() {
+		case old.Key():
+			found = i
+		case edited.Key():
+			return fmt.Errorf("%w: dataset '%s' already holds a record for %s", ErrDuplicate, dataset, edited)
+		}
+	}
+	if found < 0 || people[found] != old {
+		return fmt.Errorf("%w: %s", ErrEditConflict, old)
+	}
+	people[found] = edited
+	return s.Import(dataset, people)
+}
+
+// QueryByAgeRange returns all records in the dataset whose age at death in days lies within
+// [min, max], ordered by age
+func (s *MemoryStore) QueryByAgeRange(dataset string, min, max int) ([]Result, error) {
+	results := s.datasets[dataset]
+	start, end := s.bounds(dataset, min, max)
+	return append([]Result(nil), results[start:end]...), nil
+}
+
+// QueryPage returns up to limit of the records in the dataset whose age at death lies within
+// [min, max], after skipping the first offset, ordered by age
+func (s *MemoryStore) QueryPage(dataset string, min, max, offset, limit int) ([]Result, error) {
+	start, end := s.bounds(dataset, min, max)
+	return append([]Result(nil), paginate(s.datasets[dataset][start:end], offset, limit)...), nil
+}
+
+// Count returns the number of records in the dataset whose age at death lies within [min, max]
+func (s *MemoryStore) Count(dataset string, min, max int) (int, error) {
+	start, end := s.bounds(dataset, min, max)
+	return end - start, nil
+}
+
+// bounds returns the slice indices of the dataset's results whose age lies within [min, max]
+func (s *MemoryStore) bounds(dataset string, min, max int) (int, int) {
+	results := s.datasets[dataset]
+	start := sort.Search(len(results), func(i int) bool { return results[i].Days >= min })
+	end := sort.Search(len(results), func(i int) bool { return results[i].Days > max })
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+// QueryByDay returns the records in the dataset whose date of death, or of birth, falls on
+// the day given as 'MM-DD'
+func (s *MemoryStore) QueryByDay(dataset, day, which string) ([]Result, error) {
+	var found []Result
+	for _, res := range s.datasets[dataset] {
+		if DayOf(dateOf(res.Person, which)) == day {
+			found = append(found, res)
+		}
+	}
+	return found, nil
+}
+
+// FindByName returns the people in the dataset whose folded names, or aliases, match
+func (s *MemoryStore) FindByName(dataset string, match func(folded string) bool) ([]Person, error) {
+	var found []Person
+	for _, res := range s.datasets[dataset] {
+		if matchesNameKey(NameKey(res.Person), match) {
+			found = append(found, res.Person)
+		}
+	}
+	for _, rec := range s.living[dataset] {
+		if matchesNameKey(NameKey(rec), match) {
+			found = append(found, rec)
+		}
+	}
+	return found, nil
+}
+
+// Living returns the living people in the dataset, ordered by date of birth
+func (s *MemoryStore) Living(dataset string) ([]Person, error) {
+	return append([]Person(nil), s.living[dataset]...), nil
+}
+
+// Datasets lists the datasets held in memory, with their sizes
+func (s *MemoryStore) Datasets() ([]DatasetInfo, error) {
+	datasets := make([]DatasetInfo, 0, len(s.datasets))
+	for name, results := range s.datasets {
+		datasets = append(datasets, DatasetInfo{Name: name, Count: len(results), Living: len(s.living[name])})
+	}
+	sort.Slice(datasets, func(i, j int) bool { return datasets[i].Name < datasets[j].Name })
+	return datasets, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"math"
+	"time"
+)
+
+// Milestone is a person the user outlives, with the date on which they do so
+type Milestone struct {
+	Result
+	Date time.Time // the first day on which the user is older than the person was at death
+}
+
+// OutlivedOn returns the date on which someone born on birth becomes older than a person who
+// died aged days
+func OutlivedOn(birth time.Time, days int) time.Time {
+	return birth.AddDate(0, 0, days+1)
+}
+
+// Next returns the next n people from the datasets that someone born on dateStr will outlive,
+// as of opts.Now, in the order in which they will be outlived, or none if n is not positive
+func Next(store Store, dateStr string, n int, opts QueryOptions) ([]Milestone, error) {
+	birth, userAge, err := userBirthAndAge(dateStr, opts.Now)
+	if err != nil || n <= 0 {
+		return nil, err
+	}
+	results, err := queryDatasets(store, opts, userAge, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) > n {
+		results = results[:n]
+	}
+	return milestones(birth, results), nil
+}
+
+// Recent returns the last n people from the datasets that someone born on dateStr has outlived,
+// as of opts.Now, most recently outlived first, or none if n is not positive
+func Recent(store Store, dateStr string, n int, opts QueryOptions) ([]Milestone, error) {
+	birth, userAge, err := userBirthAndAge(dateStr, opts.Now)
+	if err != nil || n <= 0 {
+		return nil, err
+	}
+	results, err := queryDatasets(store, opts, math.MinInt32, userAge-1)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) > n {
+		results = results[len(results)-n:]
+	}
+	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+		results[i], results[j] = results[j], results[i]
+	}
+	return milestones(birth, results), nil
+}
+
+// userBirthAndAge parses the user's date of birth and returns it with their age in days as of now
+func userBirthAndAge(dateStr string, now time.Time) (time.Time, int, error) {
+	if err := ValidateDate(dateStr); err != nil {
+		return time.Time{}, 0, err
+	}
+	birth, err := time.Parse(DATE_FMT, dateStr)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidDate
+	}
+	userAge, err := AgeInDays(dateStr, now.Format(DATE_FMT))
+	return birth, userAge, err
+}
+
+func milestones(birth time.Time, results []Result) []Milestone {
+	ms := make([]Milestone, len(results))
+	for i, res := range results {
+		ms[i] = Milestone{Result: res, Date: OutlivedOn(birth, res.Days)}
+	}
+	return ms
+}
@@ -0,0 +1,227 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// MUSICBRAINZ_ENDPOINT is the artist search of the MusicBrainz web service
+	MUSICBRAINZ_ENDPOINT = "https://musicbrainz.org/ws/2/artist"
+	// MUSICBRAINZ_QUERY selects artists who are people no longer living
+	MUSICBRAINZ_QUERY = "type:person AND ended:true"
+	// MUSICBRAINZ_PAGE_SIZE is the largest page the search allows
+	MUSICBRAINZ_PAGE_SIZE = 100
+)
+
+// MusicBrainzOptions selects the artists read from MusicBrainz
+type MusicBrainzOptions struct {
+	Query    string        // a search query, defaulting to MUSICBRAINZ_QUERY
+	Endpoint string        // defaults to MUSICBRAINZ_ENDPOINT
+	Interval time.Duration // the least time between requests, one second by default as the service asks
+	Limit    int           // the maximum number of people to read, or 0 for all
+	// Checkpoint, if set, is a file to which each page is appended as it is read, so that an
+	// interrupted read can carry on from where it stopped when run again
+	Checkpoint string
+}
+
+type mbArtist struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Country  string `json:"country"`
+	LifeSpan struct {
+		Begin string `json:"begin"`
+		End   string `json:"end"`
+	} `json:"life-span"`
+	Tags []struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	} `json:"tags"`
+}
+
+type mbSearch struct {
+	Count   int        `json:"count"`
+	Offset  int        `json:"offset"`
+	Artists []mbArtist `json:"artists"`
+}
+
+// mbCheckpoint is a line of a checkpoint file, recording a page of people and where the
+// following page starts
+type mbCheckpoint struct {
+	Query  string   `json:"query"`
+	Next   int      `json:"next"`
+	Total  int      `json:"total"`
+	People []Person `json:"people"`
+}
+
+// ReadMusicBrainz pages through the artists matching the query, returning those whose dates
+// of birth and death are both known to the day. Requests are spaced opts.Interval apart.
+func ReadMusicBrainz(mb MusicBrainzOptions, opts ReadOptions) ([]Person, Progress, error) {
+	if mb.Query == "" {
+		mb.Query = MUSICBRAINZ_QUERY
+	}
+	if mb.Endpoint == "" {
+		mb.Endpoint = MUSICBRAINZ_ENDPOINT
+	}
+	if mb.Interval <= 0 {
+		mb.Interval = time.Second
+	}
+	client := &http.Client{Timeout: opts.Fetch.Timeout}
+	if client.Timeout == 0 {
+		client.Timeout = DEFAULT_FETCH_TIMEOUT
+	}
+	tracker := newProgressTracker(opts.Context, nil, 0, opts.Progress, opts.ProgressInterval)
+
+	people, offset, total, err := readMBCheckpoint(mb.Checkpoint, mb.Query)
+	if err != nil {
+		return nil, tracker.progress(), err
+	}
+	tracker.p.Rows = len(people)
+	var checkpoint *os.File
+	if mb.Checkpoint != "" {
+		if checkpoint, err = os.OpenFile(mb.Checkpoint, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+			return nil, tracker.progress(), fmt.Errorf("musicbrainz: %v", err)
+		}
+		defer checkpoint.Close()
+	}
+
+	var last time.Time
+	for first := offset == 0; first || offset < total; first = false {
+		if wait := mb.Interval - time.Since(last); wait > 0 {
+			time.Sleep(wait)
+		}
+		last = time.Now()
+		var page mbSearch
+		if err := mbGet(client, mb, offset, tracker, &page); err != nil {
+			return nil, tracker.progress(), err
+		}
+		total = page.Count
+		var accepted []Person
+		for i, a := range page.Artists {
+			rec := a.person()
+			if err := ValidatePerson(rec); err != nil {
+				if opts.Reject == nil {
+					return nil, tracker.progress(), fmt.Errorf("musicbrainz: %s: %v", a.ID, err)
+				}
+				opts.Reject(Rejection{Line: offset + i + 1, Record: []string{a.ID, rec.Name, rec.BirthDate, rec.DeathDate}, Reason: err.Error()})
+				tracker.row(true)
+				continue
+			}
+			accepted = append(accepted, rec)
+			tracker.row(false)
+		}
+		offset += len(page.Artists)
+		if len(page.Artists) == 0 {
+			offset = total // the results ran out early
+		}
+		people = append(people, accepted...)
+		if checkpoint != nil {
+			line, _ := json.Marshal(mbCheckpoint{Query: mb.Query, Next: offset, Total: total, People: accepted})
+			if _, err := checkpoint.Write(append(line, '\n')); err != nil {
+				return nil, tracker.progress(), fmt.Errorf("musicbrainz: checkpoint: %v", err)
+			}
+		}
+		if mb.Limit > 0 && len(people) >= mb.Limit {
+			tracker.p.Rows -= len(people) - mb.Limit
+			return people[:mb.Limit], tracker.finish(), nil
+		}
+	}
+	return people, tracker.finish(), nil
+}
+
+// readMBCheckpoint returns the people read so far by an earlier run with the same query, with
+// the offset at which to carry on and the total expected. A line left incomplete by an
+// interruption is ignored.
+func readMBCheckpoint(path, query string) ([]Person, int, int, error) {
+	if path == "" {
+		return nil, 0, 0, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, 0, nil
+	}
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("musicbrainz: %v", err)
+	}
+	defer f.Close()
+	var people []Person
+	next, total := 0, 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var cp mbCheckpoint
+		if json.Unmarshal(scanner.Bytes(), &cp) != nil {
+			break
+		}
+		if cp.Query != query {
+			return nil, 0, 0, fmt.Errorf("musicbrainz: checkpoint %s is for another query, '%s'", path, cp.Query)
+		}
+		people = append(people, cp.People...)
+		next, total = cp.Next, cp.Total
+	}
+	return people, next, total, nil
+}
+
+func (a mbArtist) person() Person {
+	tags := a.Tags
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].Count > tags[j].Count })
+	var genres []string
+	for i := 0; i < len(tags) && i < 3; i++ {
+		genres = append(genres, tags[i].Name)
+	}
+	return Person{
+		Name:        a.Name,
+		BirthDate:   a.LifeSpan.Begin,
+		DeathDate:   a.LifeSpan.End,
+		Occupation:  "musician",
+		Nationality: a.Country,
+		Genre:       strings.Join(genres, ";"),
+	}
+}
+
+// mbGet fetches a page of search results, retrying when the service asks for requests to slow down
+func mbGet(client *http.Client, mb MusicBrainzOptions, offset int, tracker *progressTracker, v interface{}) error {
+	q := url.Values{
+		"query":  {mb.Query},
+		"fmt":    {"json"},
+		"limit":  {fmt.Sprint(MUSICBRAINZ_PAGE_SIZE)},
+		"offset": {fmt.Sprint(offset)},
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(tracker.ctx, "GET", mb.Endpoint+"?"+q.Encode(), nil)
+		if err != nil {
+			return fmt.Errorf("musicbrainz: %v", err)
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("User-Agent", USER_AGENT)
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("musicbrainz: %v", err)
+		}
+		if (resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests) && attempt < 5 {
+			resp.Body.Close()
+			time.Sleep(retryAfter(resp, time.Duration(attempt+1)*mb.Interval*2))
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("musicbrainz: search failed: %s", resp.Status)
+		}
+		tracker.r = resp.Body
+		err = json.NewDecoder(tracker).Decode(v)
+		tracker.r = nil
+		if err != nil {
+			return fmt.Errorf("musicbrainz: %v", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// letters which do not decompose into a base letter and an accent
+var foldReplacer = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "ł", "l", "đ", "d", "ð", "d", "þ", "th", "ı", "i",
+)
+
+// FoldName normalises a name for searching, ignoring case, accents and extra whitespace, so
+// that 'Björk Guðmundsdóttir' is found as 'bjork gudmundsdottir'
+func FoldName(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(strings.ToLower(name)) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(foldReplacer.Replace(b.String())), " ")
+}
+
+// NAME_KEY_SEPARATOR separates the folded names held in a name key, see NameKey
+const NAME_KEY_SEPARATOR = " | "
+
+// NameKey returns the folded name of the person followed by those of their aliases, as stored
+// for searching, e.g. 'prince rogers nelson | prince'
+func NameKey(rec Person) string {
+	names := rec.Names()
+	for i, name := range names {
+		names[i] = FoldName(name)
+	}
+	return strings.Join(names, NAME_KEY_SEPARATOR)
+}
+
+// matchesNameKey reports whether match accepts any of the folded names held in the name key
+func matchesNameKey(key string, match func(folded string) bool) bool {
+	for _, name := range strings.Split(key, NAME_KEY_SEPARATOR) {
+		if match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// How closely a name must match a search
+const (
+	MATCH_EXACT    = "exact"    // the name contains the search
+	MATCH_FUZZY    = "fuzzy"    // or each word of the search is a word of the name, give or take a few typos
+	MATCH_PHONETIC = "phonetic" // or each word of the search sounds like a word of the name (by Soundex)
+)
+
+// Match is a person found by name, with how closely their name matched the search
+type Match struct {
+	Result
+	Exact    bool   // whether the name contains the search exactly
+	Phonetic bool   // whether the name only sounds like the search
+	Typos    int    // the number of letters mistyped in the search, or for a phonetic match misspelt
+	Alias    string // the alias which matched, if closer than the person's name
+}
+
+// closer reports whether the match is closer than another: exact matches are closest, then
+// those with the fewest typos, then those which only sound alike
+func (m Match) closer(o Match) bool {
+	switch {
+	case m.Exact != o.Exact:
+		return m.Exact
+	case m.Phonetic != o.Phonetic:
+		return !m.Phonetic
+	}
+	return m.Typos < o.Typos
+}
+
+// better reports whether the match comes before another, the closest first and then by name
+func (m Match) better(o Match) bool {
+	if m.closer(o) || o.closer(m) {
+		return m.closer(o)
+	}
+	return FoldName(m.Name) < FoldName(o.Name)
+}
+
+// matchName works out how the folded name matches the folded search, if at all, allowing for
+// typos if mode is MATCH_FUZZY, or also names which sound alike if it is MATCH_PHONETIC
+func matchName(name, query, mode string) (Match, bool) {
+	if strings.Contains(name, query) {
+		return Match{Exact: true}, true
+	}
+	if mode != MATCH_FUZZY && mode != MATCH_PHONETIC {
+		return Match{}, false
+	}
+	words := strings.Fields(name)
+	m := Match{}
+	for _, q := range strings.Fields(query) {
+		best, sounds := -1, false
+		for _, w := range words {
+			d := levenshtein(q, w)
+			if strings.HasPrefix(w, q) {
+				d = 0
+			}
+			if d <= maxTypos(q) && (best < 0 || d < best) {
+				best = d
+			}
+			if mode == MATCH_PHONETIC && soundex(q) != "" && soundex(q) == soundex(w) {
+				sounds = true
+			}
+		}
+		switch {
+		case best >= 0:
+			m.Typos += best
+		case sounds:
+			m.Phonetic = true
+			m.Typos += len(q) // ranked below any typo
+		default:
+			return Match{}, false
+		}
+	}
+	return m, true
+}
+
+// matchPerson matches the folded search against the person's name and each of their aliases,
+// returning the closest match
+func matchPerson(rec Person, query, mode string) (Match, bool) {
+	var best Match
+	found := false
+	for i, name := range rec.Names() {
+		m, ok := matchName(FoldName(name), query, mode)
+		if !ok || (found && !m.closer(best)) {
+			continue
+		}
+		if i > 0 {
+			m.Alias = name
+		}
+		best, found = m, true
+	}
+	return best, found
+}
+
+// maxTypos returns the number of typos tolerated in a word of a search: none for one or two
+// letters, then one more for every three
+func maxTypos(word string) int {
+	if n := len([]rune(word)); n > 2 {
+		return (n + 1) / 3
+	}
+	return 0
+}
+
+// levenshtein returns the number of single letter insertions, deletions or substitutions
+// which turn one word into another
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// soundex returns the American Soundex code of a word, e.g. "C150" for both "cobain" and
+// "cobane", or "" if it has no letters
+func soundex(word string) string {
+	const codes = "01230120022455012623010202" // the digit of each letter a to z
+	var b strings.Builder
+	last := byte(0)
+	for _, r := range word {
+		if r < 'a' || r > 'z' {
+			continue
+		}
+		code := codes[r-'a']
+		if b.Len() == 0 {
+			b.WriteRune(unicode.ToUpper(r))
+		} else if code != '0' && code != last {
+			b.WriteByte(code)
+		}
+		if r != 'h' && r != 'w' { // which don't separate letters with the same digit
+			last = code
+		}
+		if b.Len() == 4 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return (b.String() + "000")[:4]
+}
+
+// Find returns the people from the datasets whose names match the search, ignoring case and
+// accents, as mode allows (one of the MATCH_* constants), and who match the filter. The
+// closest matches come first. For living people Days is their age as of opts.Now rather than
+// at death.
+func Find(store Store, query, mode string, opts QueryOptions) ([]Match, error) {
+	folded := FoldName(query)
+	if folded == "" {
+		return nil, nil
+	}
+	today := opts.Now.Format(DATE_FMT)
+	var matches []Match
+	for _, dataset := range opts.Datasets {
+		var people []Person
+		var err error
+		if ns, ok := store.(NameSearcher); ok {
+			people, err = ns.SearchNames(dataset, folded, mode)
+		} else {
+			people, err = store.FindByName(dataset, func(name string) bool {
+				_, ok := matchName(name, folded, mode)
+				return ok
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		found := map[string]Match{} // by Key
+		results := make([]Result, 0, len(people))
+		for _, rec := range people {
+			m, ok := matchPerson(rec, folded, mode)
+			if !ok {
+				continue
+			}
+			found[rec.Key()] = m
+			death := rec.DeathDate
+			if rec.Living() {
+				death = today
+			}
+			age, err := rec.ageOn(death)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, Result{Person: rec, Days: age, Dataset: dataset})
+		}
+		for _, res := range FilterResults(results, opts.Filter) {
+			m := found[res.Key()]
+			m.Result = res
+			matches = append(matches, m)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].better(matches[j]) })
+	return matches, nil
+}
+
+// FindPerson returns the person a search most likely names: anyone whose whole name, or one of
+// whose aliases, is the search, or otherwise the closest match. If several match as closely as
+// each other they are all returned, as are namesakes.
+func FindPerson(store Store, query string, opts QueryOptions) ([]Match, error) {
+	matches, err := Find(store, query, MATCH_FUZZY, opts)
+	if err != nil || len(matches) == 0 {
+		return nil, err
+	}
+	folded := FoldName(query)
+	var named []Match
+	for _, m := range matches {
+		for _, name := range m.Names() {
+			if FoldName(name) == folded {
+				named = append(named, m)
+				break
+			}
+		}
+	}
+	if len(named) > 0 {
+		return named, nil
+	}
+	n := 1
+	for n < len(matches) && !matches[0].closer(matches[n]) {
+		n++
+	}
+	return matches[:n], nil
+}
@@ -0,0 +1,35 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// DesktopNotifier shows each notification on the local desktop, using notify-send on Linux
+// and osascript on macOS
+type DesktopNotifier struct{}
+
+func newDesktopNotifier(cfg NotifierConfig) (Notifier, error) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		return nil, fmt.Errorf("notifier: desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	return DesktopNotifier{}, nil
+}
+
+func (DesktopNotifier) Notify(n Notification) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		script := fmt.Sprintf("display notification %s with title \"outlived\"", strconv.Quote(n.Text()))
+		cmd = exec.Command("osascript", "-e", script)
+	} else {
+		cmd = exec.Command("notify-send", "outlived", n.Text())
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("notifier: desktop: %v: %s", err, out)
+	}
+	return nil
+}
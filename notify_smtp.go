@@ -0,0 +1,53 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// SMTPNotifier sends each notification as an email. The connection is upgraded with STARTTLS
+// when the server offers it.
+type SMTPNotifier struct {
+	Addr string // host:port
+	Auth smtp.Auth
+	From string
+	To   []string
+}
+
+func newSMTPNotifier(cfg NotifierConfig) (Notifier, error) {
+	if cfg.Host == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("notifier: smtp needs a host, a from address and at least one to address")
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = 587
+	}
+	n := SMTPNotifier{Addr: net.JoinHostPort(cfg.Host, strconv.Itoa(port)), From: cfg.From, To: cfg.To}
+	if cfg.Username != "" {
+		n.Auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	return n, nil
+}
+
+func (s SMTPNotifier) Notify(n Notification) error {
+	msg := strings.Join([]string{
+		"From: " + s.From,
+		"To: " + strings.Join(s.To, ", "),
+		"Subject: " + n.Text(),
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		n.Text() + ".",
+		"",
+		fmt.Sprintf("%s was born on %s and died on %s.", n.Name, n.BirthDate, n.DeathDate),
+		"",
+	}, "\r\n")
+	if err := smtp.SendMail(s.Addr, s.Auth, s.From, s.To, []byte(msg)); err != nil {
+		return fmt.Errorf("notifier: smtp: %v", err)
+	}
+	return nil
+}
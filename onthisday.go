@@ -0,0 +1,79 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// The dates by which records are indexed for QueryByDay
+const (
+	DAY_DIED = "died"
+	DAY_BORN = "born"
+)
+
+// DayOf returns the month and day of a date as 'MM-DD', or "" if the date is not known to
+// the day
+func DayOf(date string) string {
+	if date == "" || Precision(date) < PRECISION_DAY {
+		return ""
+	}
+	return date[len(date)-5:]
+}
+
+// ParseDay checks a month and day given as 'MM-DD', or as a date 'YYYY-MM-DD', returning it
+// as 'MM-DD'. The 29th of February is a valid day.
+func ParseDay(s string) (string, error) {
+	if len(s) == len(DATE_FMT) {
+		if _, err := time.Parse(DATE_FMT, s); err != nil {
+			return "", fmt.Errorf("invalid date '%s', expected MM-DD or YYYY-MM-DD", s)
+		}
+		return s[5:], nil
+	}
+	if _, err := time.Parse(DATE_FMT, "2000-"+s); err != nil || len(s) != 5 {
+		return "", fmt.Errorf("invalid day '%s', expected MM-DD", s)
+	}
+	return s, nil
+}
+
+// allDays returns every day of the year as 'MM-DD', the 29th of February included
+func allDays() []string {
+	days := make([]string, 0, 366)
+	for t := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC); t.Year() == 2000; t = t.AddDate(0, 0, 1) {
+		days = append(days, t.Format("01-02"))
+	}
+	return days
+}
+
+// OnThisDay returns the people from the datasets who died on the day of the year given as
+// 'MM-DD', or who were born on it if which is DAY_BORN, and who match the filter. They are
+// ordered by the date, earliest first. Living people are not included.
+func OnThisDay(store Store, day, which string, opts QueryOptions) ([]Result, error) {
+	var results []Result
+	for _, dataset := range opts.Datasets {
+		found, err := store.QueryByDay(dataset, day, which)
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			found[i].Dataset = dataset
+		}
+		results = append(results, FilterResults(found, opts.Filter)...)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		di, _, _ := ParsePartialDate(dateOf(results[i].Person, which))
+		dj, _, _ := ParsePartialDate(dateOf(results[j].Person, which))
+		return di.Before(dj)
+	})
+	return results, nil
+}
+
+// dateOf returns the record's date of death, or of birth if which is DAY_BORN
+func dateOf(rec Person, which string) string {
+	if which == DAY_BORN {
+		return rec.BirthDate
+	}
+	return rec.DeathDate
+}
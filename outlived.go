@@ -1,37 +1,36 @@
 // Copyright © 2016 Matthew R Hegarty
 
-// Imports data from a source text file into a Redis Sorted Set, and allows querying of the data.
+// Package outlived imports data from a source text file into a Redis Sorted Set, and allows
+// querying of the data.
 // The source data is a csv containing a list of deceased musicians in the format:
 //
 // FIELD 1: Name (unquoted)
 // FIELD 2: Date of Birth (YYYY-MM-DD)
 // FIELD 3: Date of Death (YYYY-MM-DD)
+// FIELD 4: Occupation (optional)
+// FIELD 5: Nationality (optional)
+// FIELD 6: Cause of Death (optional)
+// FIELD 7: Genres, separated by ';' (optional)
+// FIELD 8: Link to an article about the person (optional)
+// FIELD 9: Summary (optional)
+// FIELD 10: Image URL (optional)
+// FIELD 11: Other names the person is known by, separated by ';' (optional)
+// FIELD 12: Tags, such as 'grammy-winner', separated by ';' (optional)
 //
-// The data can be imported and then queried using this script.
-// A date can be passed in (for example, your own date of birth) in order to establish which
-// musicians you've outlived.
-// Use the '-d' flag to widen the search query.
+// Each record is scored by its age at death in days, so that a date can be passed in (for
+// example, your own date of birth) in order to establish which musicians you've outlived.
 //
-// Usage:
-//   ./outlived [OPTIONS] [FILE]
-//
-// Examples:
-//
-//     Import:  ./outlived -import musicians.csv
-//      Query:  ./outlived -query 1990-09-25 -d 365
-package main
+// The command line tool wrapping this package lives in cmd/outlived.
+package outlived
 
 import (
-	"encoding/csv"
-	"flag"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"github.com/garyburd/redigo/redis"
-	"log"
 	"math"
-	"os"
 	"regexp"
 	"strings"
-	"time"
 )
 
 const (
@@ -40,162 +39,153 @@ const (
 	DATE_FMT = "2006-01-02"
 )
 
+// ErrInvalidDate is returned when a date is not in the format 'YYYY-MM-DD'
+var ErrInvalidDate = errors.New("invalid date format: Dates must be in the format 'YYYY-MM-DD'")
+
 type Person struct {
-	Name      string
-	BirthDate string
-	DeathDate string
+	Name      string `json:"name"`
+	BirthDate string `json:"birth_date"`
+	DeathDate string `json:"death_date"`
+
+	Occupation   string `json:"occupation,omitempty"`
+	Nationality  string `json:"nationality,omitempty"`
+	CauseOfDeath string `json:"cause_of_death,omitempty"`
+	Genre        string `json:"genre,omitempty"` // one or more genres or tags, separated by ';'
+
+	// added by the enrich command
+	URL      string `json:"url,omitempty"` // of an article about the person
+	Summary  string `json:"summary,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+
+	Aliases string `json:"aliases,omitempty"` // other names the person is known by, separated by ';'
+	Tags    string `json:"tags,omitempty"`    // such as 'grammy-winner', separated by ';'
 }
 
 func (rec Person) String() string {
 	return fmt.Sprintf("%s,%s,%s", rec.Name, rec.BirthDate, rec.DeathDate)
 }
 
-var dateFmtRegex = regexp.MustCompile("[0-9]{4}-[0-9]{2}-[0-9]{2}")
-
-var importFile = flag.String("import", "", "Imports files into Redis database using CSV file supplied as arg")
-var query = flag.String("query", "", "Query the database using a date supplied in format 'YYYY-MM-DD'")
-var dayRange = flag.Int("d", 365, "Number of days either side of target date to return results")
-
-var Usage = func() {
-	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-	flag.PrintDefaults()
-}
-
-func main() {
-
-	flag.Parse()
-	if *importFile == "" && *query == "" {
-		Usage()
-		os.Exit(0)
+// Genres returns the list of genres or tags held in Genre
+func (rec Person) Genres() []string {
+	var genres []string
+	for _, g := range strings.Split(rec.Genre, ";") {
+		if g = strings.TrimSpace(g); g != "" {
+			genres = append(genres, g)
+		}
 	}
+	return genres
+}
 
-	if *importFile != "" {
-		doFileImport(*importFile)
-	}
-	if *query != "" {
-		if *dayRange >= 0 {
-			doQuery(*query, *dayRange)
-		} else {
-			doQuery(*query, 365)
+// TagList returns the tags held in Tags
+func (rec Person) TagList() []string {
+	var tags []string
+	for _, t := range strings.Split(rec.Tags, ";") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
 		}
 	}
+	return tags
 }
 
-// import data from the given file and import into Redis instance
-func doFileImport(importFile string) {
-	fmt.Printf("Importing records from '%s'\n", importFile)
-	records := readCSVFileContents(importFile)
-	fmt.Printf("Parsed %d records from file\n", len(records))
-	storeRecordsInRedis(records)
-	fmt.Println("Successfully completed import into Redis")
+// HasDetails reports whether any of the optional fields are set
+func (rec Person) HasDetails() bool {
+	return rec.Occupation != "" || rec.Nationality != "" || rec.CauseOfDeath != "" || rec.Genre != "" ||
+		rec.URL != "" || rec.Summary != "" || rec.ImageURL != "" || rec.Aliases != "" || rec.Tags != ""
 }
 
-func doQuery(dateStr string, ndays int) {
-	if !dateFmtRegex.MatchString(dateStr) {
-		log.Fatalf("invalid query date format: Dates must be in the format 'YYYY-MM-DD'\n")
+// Names returns the person's name followed by each of the aliases held in Aliases
+func (rec Person) Names() []string {
+	names := []string{rec.Name}
+	for _, a := range strings.Split(rec.Aliases, ";") {
+		if a = strings.TrimSpace(a); a != "" {
+			names = append(names, a)
+		}
 	}
-	now := time.Now().Format(DATE_FMT)
-	userAge := getAgeInDays(dateStr, now)
+	return names
+}
 
-	c, err := redis.Dial("tcp", DB_ADDR)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer c.Close()
+// Key returns a stable identifier for the person made from their normalized name and their
+// date of birth, used to recognise the same person across imports
+func (rec Person) Key() string {
+	return NormalizeName(rec.Name) + "|" + rec.BirthDate
+}
 
-	results, err := redis.Strings(c.Do("ZRANGEBYSCORE", DB_NAME, userAge-ndays, userAge+ndays))
-	if err != nil {
-		log.Fatal(err)
-	}
-	lastAge := 0
-	for _, row := range results {
-		fields := strings.Split(row, ",")
+// ID returns a short identifier derived from Key, suitable for use in storage keys
+func (rec Person) ID() string {
+	sum := sha1.Sum([]byte(rec.Key()))
+	return hex.EncodeToString(sum[:8])
+}
 
-		name := fields[0]
-		bday := fields[1]
-		dday := fields[2]
+// NormalizeName lower-cases a name and collapses its whitespace, for use in comparisons
+func NormalizeName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
 
-		age := getAgeInDays(bday, dday)
-		if userAge >= lastAge && userAge < age {
-			printUserAge(userAge)
-		}
-		fmt.Printf("%-30s (died aged %s)\n", name, formatAgeInYearsAndDays(age))
-		lastAge = age
-	}
-	if userAge >= lastAge { // case where user is older than everyone in return set
-		printUserAge(userAge)
-	}
+// Living reports whether the person is still alive, having no date of death
+func (rec Person) Living() bool {
+	return rec.DeathDate == ""
 }
 
-func printUserAge(userAge int) {
-	s := ">>> YOU ARE HERE"
-	fmt.Printf("%-30s (     aged %s)\n", s, formatAgeInYearsAndDays(userAge))
+// AgeInDays returns the age of the person at death in days
+func (rec Person) AgeInDays() (int, error) {
+	return rec.ageOn(rec.DeathDate)
 }
 
-// Format the age in years and days.
-// The calculation is to divide days by 365.25 - this is the simplest method but not 100% accurate
-func formatAgeInYearsAndDays(days int) string {
-	var daysInYear float64 = 365.25
-	ageInYears := int(float64(days) / daysInYear)
-	ageInDays := int(math.Mod(float64(days), daysInYear))
-	return fmt.Sprintf("%3d years and %3d days", ageInYears, ageInDays)
+// ageOn returns the age of the person in days on the date, which is 0 rather than negative
+// where partial dates of birth and death overlap, e.g. '1990' and '1990-03', and the middle of
+// the one falls after that of the other, as validatePerson accepts them
+func (rec Person) ageOn(date string) (int, error) {
+	age, err := AgeInDays(rec.BirthDate, date)
+	return max(age, 0), err
 }
 
-// Read and parse the CSV file and return contents as a 'Person' array
-func readCSVFileContents(filename string) []Person {
+var dateFmtRegex = regexp.MustCompile("^[0-9]{4}-[0-9]{2}-[0-9]{2}$")
 
-	csvFile, err := os.Open(filename)
-	if err != nil {
-		log.Fatalf("import: %v\n", err)
+// ValidateDate checks that the given string is a date in the format 'YYYY-MM-DD'
+func ValidateDate(dateStr string) error {
+	if !dateFmtRegex.MatchString(dateStr) {
+		return ErrInvalidDate
 	}
-	defer csvFile.Close()
+	return nil
+}
 
-	reader := csv.NewReader(csvFile)
-	var allRecords []Person
-	csvData, err := reader.ReadAll()
+// AgeInDays takes dates as strings in format YYYY-MM-DD and returns the number of days
+// between the two dates. Either date may be partial (see ParsePartialDate), in which case the
+// middle of the period it covers is used.
+func AgeInDays(d1, d2 string) (int, error) {
+	bd, _, err := ParsePartialDate(d1)
 	if err != nil {
-		log.Fatalf("file parse: %v\n", err)
+		return 0, fmt.Errorf("unparseable birth date: %v", err)
 	}
-
-	var tmpRecord Person
-	for _, eachRow := range csvData {
-		tmpRecord.Name = eachRow[0]
-		tmpRecord.BirthDate = eachRow[1]
-		tmpRecord.DeathDate = eachRow[2]
-		allRecords = append(allRecords, tmpRecord)
+	dd, _, err := ParsePartialDate(d2)
+	if err != nil {
+		return 0, fmt.Errorf("unparseable death date: %v", err)
 	}
-	return allRecords
+	return int(dd.Sub(bd).Hours() / 24), nil
 }
 
-func storeRecordsInRedis(records []Person) {
-	c, err := redis.Dial("tcp", DB_ADDR)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer c.Close()
+const daysInYear float64 = 365.25
 
-	c.Send("MULTI")        // send following commands in a transaction
-	c.Send("DEL", DB_NAME) // Remove existing data
+// AgeInYears returns the number of whole years in an age given in days
+func AgeInYears(days int) int {
+	return int(float64(days) / daysInYear)
+}
 
-	for _, eachRec := range records {
-		ageInDays := getAgeInDays(eachRec.BirthDate, eachRec.DeathDate)
-		c.Send("ZADD", DB_NAME, ageInDays, eachRec.String())
-	}
-	if _, err := c.Do("EXEC"); err != nil { // COMMIT data
-		log.Fatal(err)
-	}
+// FormatApproximateAge formats an age known only roughly, as it comes from partial dates, in
+// whole years, e.g. "~ 63 years"
+func FormatApproximateAge(days int) string {
+	return fmt.Sprintf("~%3d years", AgeInYears(days))
 }
 
-// Takes dates as strings in format YYYY-MM-DD and returns the number of days
-// between the two dates
-func getAgeInDays(d1, d2 string) int {
-	bd, err := time.Parse(DATE_FMT, d1)
-	if err != nil {
-		log.Fatalf("unparseable birth date: %v\n", err)
-	}
-	dd, err := time.Parse(DATE_FMT, d2)
-	if err != nil {
-		log.Fatalf("unparseable death date: %v\n", err)
-	}
-	return int(dd.Sub(bd).Hours() / 24)
+// FormatAgeInYearsAndDays formats the age in years and days.
+// The calculation is to divide days by 365.25 - this is the simplest method but not 100% accurate
+func FormatAgeInYearsAndDays(days int) string {
+	ageInDays := int(math.Mod(float64(days), daysInYear))
+	return fmt.Sprintf("%3d years and %3d days", AgeInYears(days), ageInDays)
+}
+
+// Unpadded removes the padding used to align a formatted age in text output, for use within a
+// sentence
+func Unpadded(age string) string {
+	return strings.Join(strings.Fields(age), " ")
 }
@@ -0,0 +1,125 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Overlap is a person whose lifetime overlapped the user's
+type Overlap struct {
+	Result       // Days is the age at death, or for a living person their age now
+	Shared  int  // the number of days for which both were alive
+	AtBirth bool // whether the person was alive on the day the user was born
+}
+
+// Overlaps returns the user's age in days as of opts.Now, along with the people from the
+// datasets who have been alive at some time since the user was born on dateStr, living people
+// included, and who match the filter. Those who shared the most of the user's life come first.
+func Overlaps(store Store, dateStr string, opts QueryOptions) (int, []Overlap, error) {
+	birth, userAge, err := userBirthAndAge(dateStr, opts.Now)
+	if err != nil {
+		return 0, nil, err
+	}
+	today := birth.AddDate(0, 0, userAge)
+	var overlaps []Overlap
+	for _, dataset := range opts.Datasets {
+		results, err := AllRecords(store, dataset)
+		if err != nil {
+			return 0, nil, err
+		}
+		living, err := store.Living(dataset)
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, rec := range living {
+			age, err := AgeInDays(rec.BirthDate, today.Format(DATE_FMT))
+			if err != nil {
+				return 0, nil, err
+			}
+			results = append(results, Result{Person: rec, Days: age})
+		}
+		for _, res := range FilterResults(results, opts.Filter) {
+			res.Dataset = dataset
+			if ov, ok := overlap(res, birth, today); ok {
+				overlaps = append(overlaps, ov)
+			}
+		}
+	}
+	sort.SliceStable(overlaps, func(i, j int) bool {
+		if overlaps[i].Shared != overlaps[j].Shared {
+			return overlaps[i].Shared > overlaps[j].Shared
+		}
+		return overlaps[i].Name < overlaps[j].Name
+	})
+	return userAge, overlaps, nil
+}
+
+// overlap works out how much of the user's life, from birth until today, the person shared
+func overlap(res Result, birth, today time.Time) (Overlap, bool) {
+	born, _, err := ParsePartialDate(res.BirthDate)
+	if err != nil || born.After(today) {
+		return Overlap{}, false
+	}
+	died := today
+	if !res.Living() {
+		if died, _, err = ParsePartialDate(res.DeathDate); err != nil || died.Before(birth) {
+			return Overlap{}, false
+		}
+	}
+	start, end := born, died
+	if start.Before(birth) {
+		start = birth
+	}
+	if end.After(today) {
+		end = today
+	}
+	return Overlap{
+		Result:  res,
+		Shared:  int(end.Sub(start).Hours() / 24),
+		AtBirth: !born.After(birth),
+	}, true
+}
+
+// SharedLifetime returns the period during which both people were alive, counting the living
+// as alive until today. If they never were it returns false, and the gap between the death of
+// one and the birth of the other.
+func SharedLifetime(a, b Result, today time.Time) (time.Time, time.Time, bool, error) {
+	aBorn, aDied, err := lifetime(a, today)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	bBorn, bDied, err := lifetime(b, today)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	start, end := aBorn, aDied
+	if bBorn.After(start) {
+		start = bBorn
+	}
+	if bDied.Before(end) {
+		end = bDied
+	}
+	if end.Before(start) {
+		return end, start, false, nil
+	}
+	return start, end, true, nil
+}
+
+// lifetime returns the dates of the person's birth and death, or today if they are living
+func lifetime(res Result, today time.Time) (time.Time, time.Time, error) {
+	born, _, err := ParsePartialDate(res.BirthDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%s: unparseable birth date: %v", res.Name, err)
+	}
+	if res.Living() {
+		return born, today, nil
+	}
+	died, _, err := ParsePartialDate(res.DeathDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%s: unparseable death date: %v", res.Name, err)
+	}
+	return born, died, nil
+}
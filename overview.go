@@ -0,0 +1,70 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Overview is how someone compares with the datasets: their age, how many they have outlived,
+// and who they outlived last and will outlive next, as a bot answers a date of birth
+type Overview struct {
+	BirthDate string
+	Age       int // in days
+	Ranking
+	Last *Milestone // nil if they have outlived no one yet
+	Next *Milestone // nil if there is no one left to outlive
+}
+
+// OverviewOf returns the overview of someone born on dateStr, as of opts.Now
+func OverviewOf(store Store, dateStr string, opts QueryOptions) (Overview, error) {
+	_, age, err := userBirthAndAge(dateStr, opts.Now)
+	if err != nil {
+		return Overview{}, err
+	}
+	o := Overview{BirthDate: dateStr, Age: age}
+	if o.Ranking, err = Rank(store, age, opts); err != nil {
+		return o, err
+	}
+	last, err := Recent(store, dateStr, 1, opts)
+	if err != nil {
+		return o, err
+	}
+	next, err := Next(store, dateStr, 1, opts)
+	if err != nil {
+		return o, err
+	}
+	if len(last) > 0 {
+		o.Last = &last[0]
+	}
+	if len(next) > 0 {
+		o.Next = &next[0]
+	}
+	return o, nil
+}
+
+// Text returns the overview as a few lines of plain text, e.g. "You are 35 years and 19 days
+// old, and have outlived 123 of 456 people (27%)"
+func (o Overview) Text() string {
+	var b strings.Builder
+	b.WriteString(o.Headline())
+	if o.Last != nil {
+		fmt.Fprintf(&b, "\nLast outlived: %s", o.Last.describe(o.Last.Name))
+	}
+	if o.Next != nil {
+		fmt.Fprintf(&b, "\nNext to outlive: %s", o.Next.describe(o.Next.Name))
+	}
+	return b.String()
+}
+
+// Headline returns the first line of the overview's text, its age and the share outlived
+func (o Overview) Headline() string {
+	return fmt.Sprintf("You are %s old, and have outlived %d of %d people (%.0f%%)",
+		Unpadded(FormatAgeInYearsAndDays(o.Age)), o.Outlived, o.Total, o.Percentile())
+}
+
+// describe returns the milestone as an overview gives it, with the person's name as given
+func (m Milestone) describe(name string) string {
+	return fmt.Sprintf("%s, on %s, who died aged %s", name, m.Date.Format(DATE_FMT), Unpadded(m.FormatAge()))
+}
@@ -0,0 +1,158 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Defaults for the RedisConfig fields controlling the pool and retries, used when they are zero
+const (
+	DEFAULT_REDIS_POOL_SIZE     = 10
+	DEFAULT_REDIS_RETRIES       = 3
+	DEFAULT_REDIS_RETRY_BACKOFF = 100 * time.Millisecond
+)
+
+// REDIS_MAX_BACKOFF caps the wait between retries, which doubles after each one
+const REDIS_MAX_BACKOFF = 5 * time.Second
+
+// REDIS_IDLE_TIMEOUT is how long a pooled connection may sit idle before it is closed, less
+// than the timeouts servers and load balancers tend to apply
+const REDIS_IDLE_TIMEOUT = 4 * time.Minute
+
+// REDIS_IDLE_CHECK is how long a pooled connection may sit idle before it is checked with a
+// PING when next taken from the pool
+const REDIS_IDLE_CHECK = time.Minute
+
+// transientPrefixes begin the errors with which Redis refuses commands it will accept again
+// shortly: while it loads its data on restarting, after a failover, or while a cluster's slots
+// are moving
+var transientPrefixes = []string{"LOADING", "READONLY", "MASTERDOWN", "TRYAGAIN", "CLUSTERDOWN"}
+
+// NewPool returns a pool of connections made by Dial, holding up to PoolSize of them. Connections
+// which break, or whose server is no longer the master, are discarded rather than reused, so
+// that the pool reconnects once Redis is back.
+func (c RedisConfig) NewPool() *redis.Pool {
+	size := c.PoolSize
+	if size <= 0 {
+		size = DEFAULT_REDIS_POOL_SIZE
+	}
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			conn, err := c.Dial()
+			if err != nil {
+				return nil, err
+			}
+			return &pooledConn{Conn: conn}, nil
+		},
+		TestOnBorrow: func(conn redis.Conn, idle time.Time) error {
+			if time.Since(idle) < REDIS_IDLE_CHECK {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+		MaxIdle:     size,
+		MaxActive:   size,
+		IdleTimeout: REDIS_IDLE_TIMEOUT,
+		Wait:        true,
+	}
+}
+
+// retries returns how many times a failing command is retried
+func (c RedisConfig) retries() int {
+	switch {
+	case c.Retries < 0:
+		return 0
+	case c.Retries == 0:
+		return DEFAULT_REDIS_RETRIES
+	}
+	return c.Retries
+}
+
+// backoff returns how long to wait before the given retry, counting from zero: RetryBackoff
+// doubled for each retry before it, up to REDIS_MAX_BACKOFF
+func (c RedisConfig) backoff(retry int) time.Duration {
+	d := c.RetryBackoff
+	if d <= 0 {
+		d = DEFAULT_REDIS_RETRY_BACKOFF
+	}
+	for i := 0; i < retry && d < REDIS_MAX_BACKOFF; i++ {
+		d *= 2
+	}
+	if d > REDIS_MAX_BACKOFF {
+		d = REDIS_MAX_BACKOFF
+	}
+	return d
+}
+
+// pooledConn is a connection held by the pool. It takes itself to be broken once the server
+// refuses a write as a replica, as a master does after failing over, so that the pool
+// discards it and dials the new master.
+type pooledConn struct {
+	redis.Conn
+	err error
+}
+
+func (c *pooledConn) Err() error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.Conn.Err()
+}
+
+func (c *pooledConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(cmd, args...)
+	return reply, c.check(err)
+}
+
+func (c *pooledConn) Receive() (interface{}, error) {
+	reply, err := c.Conn.Receive()
+	return reply, c.check(err)
+}
+
+// check notes an error showing that the server is no longer the master
+func (c *pooledConn) check(err error) error {
+	var re redis.Error
+	if errors.As(err, &re) && strings.HasPrefix(string(re), "READONLY") {
+		c.err = err
+	}
+	return err
+}
+
+// isTransient reports whether the error may pass if the commands are tried again on another
+// connection: the connection broke or couldn't be made, or Redis refused them for now
+func isTransient(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var re redis.Error
+	if errors.As(err, &re) {
+		for _, prefix := range transientPrefixes {
+			if strings.HasPrefix(string(re), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sleepContext waits for the duration, or until the context ends, returning its error if it does
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNoProfile is returned when a profile does not exist
+var ErrNoProfile = errors.New("no such profile")
+
+// Profile is a named date of birth, so that queries can be made for someone by name
+type Profile struct {
+	Name      string `json:"name"`
+	BirthDate string `json:"birth_date"`
+}
+
+// ProfileStore holds profiles
+type ProfileStore interface {
+	// Profiles returns all of the profiles, ordered by name
+	Profiles() ([]Profile, error)
+	// SaveProfile adds the profile, replacing any with the same name
+	SaveProfile(p Profile) error
+	// RemoveProfile removes the named profile, returning ErrNoProfile if there is none
+	RemoveProfile(name string) error
+}
+
+// ValidateProfile checks that the profile has a usable name and a valid date of birth
+func ValidateProfile(p Profile) error {
+	if p.Name == "" || strings.ContainsAny(p.Name, ", \t\r\n") {
+		return fmt.Errorf("invalid profile name '%s': names must not be empty or contain commas or whitespace", p.Name)
+	}
+	return ValidateDate(p.BirthDate)
+}
+
+// FindProfile returns the named profile
+func FindProfile(ps ProfileStore, name string) (Profile, error) {
+	profiles, err := ps.Profiles()
+	if err != nil {
+		return Profile{}, err
+	}
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("profile '%s': %v", name, ErrNoProfile)
+}
+
+// FileProfileStore keeps profiles in a JSON file
+type FileProfileStore struct {
+	Path string
+}
+
+func (fp FileProfileStore) Profiles() ([]Profile, error) {
+	data, err := os.ReadFile(fp.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var profiles []Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("profiles: %s: %v", fp.Path, err)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles, nil
+}
+
+func (fp FileProfileStore) SaveProfile(p Profile) error {
+	if err := ValidateProfile(p); err != nil {
+		return err
+	}
+	profiles, err := fp.Profiles()
+	if err != nil {
+		return err
+	}
+	kept := profiles[:0]
+	for _, old := range profiles {
+		if old.Name != p.Name {
+			kept = append(kept, old)
+		}
+	}
+	return fp.write(append(kept, p))
+}
+
+func (fp FileProfileStore) RemoveProfile(name string) error {
+	profiles, err := fp.Profiles()
+	if err != nil {
+		return err
+	}
+	kept := profiles[:0]
+	for _, p := range profiles {
+		if p.Name != name {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == len(profiles) {
+		return fmt.Errorf("profile '%s': %v", name, ErrNoProfile)
+	}
+	return fp.write(kept)
+}
+
+// write replaces the file with the profiles, via a temporary file
+func (fp FileProfileStore) write(profiles []Profile) error {
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fp.Path), 0755); err != nil {
+		return err
+	}
+	tmp := fp.Path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fp.Path)
+}
@@ -0,0 +1,134 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"time"
+)
+
+// DEFAULT_PROGRESS_INTERVAL is the default minimum time between progress reports
+const DEFAULT_PROGRESS_INTERVAL = 200 * time.Millisecond
+
+// Progress reports how far the reading of a source file has got. The final value returned
+// once reading is complete doubles as a summary of the import.
+type Progress struct {
+	Rows    int   // rows accepted so far
+	Skipped int   // rows skipped so far, i.e. blank or rejected rows
+	Bytes   int64 // bytes of input consumed so far
+	Total   int64 // total size of the input in bytes, or 0 if unknown
+	Elapsed time.Duration
+
+	// SourceHash is the SHA-256 of the data read, once decompressed, e.g. 'sha256:9f86d081...',
+	// set once reading is complete. It is empty for sources such as Wikidata read by query.
+	SourceHash string
+}
+
+// ProgressFunc is called periodically while a source file is read
+type ProgressFunc func(Progress)
+
+// RowsPerSecond returns the throughput so far
+func (p Progress) RowsPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Rows+p.Skipped) / p.Elapsed.Seconds()
+}
+
+// Fraction returns the proportion of the input consumed so far, or -1 if the size of the
+// input is unknown
+func (p Progress) Fraction() float64 {
+	if p.Total <= 0 {
+		return -1
+	}
+	if p.Bytes >= p.Total {
+		return 1
+	}
+	return float64(p.Bytes) / float64(p.Total)
+}
+
+// ETA estimates the time remaining from the rate at which the input has been consumed, or
+// returns -1 if it cannot be estimated
+func (p Progress) ETA() time.Duration {
+	f := p.Fraction()
+	if f <= 0 {
+		return -1
+	}
+	return time.Duration(float64(p.Elapsed) * (1 - f) / f)
+}
+
+// progressTracker counts bytes read through it and calls a ProgressFunc no more often than
+// the given interval. Reads fail once its context has ended.
+type progressTracker struct {
+	ctx      context.Context
+	r        io.Reader
+	h        hash.Hash // of what has been read, if there is a reader
+	fn       ProgressFunc
+	interval time.Duration
+	start    time.Time
+	last     time.Time
+	p        Progress
+}
+
+func newProgressTracker(ctx context.Context, r io.Reader, total int64, fn ProgressFunc, interval time.Duration) *progressTracker {
+	if interval <= 0 {
+		interval = DEFAULT_PROGRESS_INTERVAL
+	}
+	now := time.Now()
+	t := &progressTracker{ctx: orBackground(ctx), r: r, fn: fn, interval: interval, start: now, last: now, p: Progress{Total: total}}
+	if r != nil {
+		t.h = sha256.New()
+	}
+	return t
+}
+
+func (t *progressTracker) Read(b []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := t.r.Read(b)
+	t.p.Bytes += int64(n)
+	t.h.Write(b[:n])
+	return n, err
+}
+
+// row records a row as accepted or skipped, reporting progress if due
+func (t *progressTracker) row(skipped bool) {
+	if skipped {
+		t.p.Skipped++
+	} else {
+		t.p.Rows++
+	}
+	if t.fn == nil {
+		return
+	}
+	if now := time.Now(); now.Sub(t.last) >= t.interval {
+		t.last = now
+		t.fn(t.progress())
+	}
+}
+
+func (t *progressTracker) progress() Progress {
+	p := t.p
+	p.Elapsed = time.Since(t.start)
+	return p
+}
+
+// finish returns the final progress, reporting it if a ProgressFunc was given
+func (t *progressTracker) finish() Progress {
+	var sum string
+	if t.h != nil {
+		io.Copy(io.Discard, t) // anything after the last record, so that the whole input is hashed
+		sum = "sha256:" + hex.EncodeToString(t.h.Sum(nil))
+	}
+	p := t.progress()
+	p.SourceHash = sum
+	if t.fn != nil {
+		t.fn(p)
+	}
+	return p
+}
@@ -0,0 +1,92 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoProvenance is returned when no provenance has been recorded for a dataset, as those
+// imported by earlier versions have none
+var ErrNoProvenance = errors.New("no provenance recorded")
+
+// Provenance records where the last import to a dataset came from and what it did, so that the
+// data in a store can be traced back to the version of the file it was read from
+type Provenance struct {
+	Source   string    `json:"source"` // the file or URL, or the source such as 'wikidata'
+	Imported time.Time `json:"imported"`
+	Upsert   bool      `json:"upsert"` // whether the records were merged into the dataset
+
+	Records   int `json:"records"` // the records imported, after any duplicates were dropped
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Removed   int `json:"removed"` // by a full import, as they were absent from the source
+	Rejected  int `json:"rejected"`
+	Skipped   int `json:"skipped"` // blank rows and the like
+
+	SourceHash string `json:"source_hash,omitempty"` // of the data read, see Progress.SourceHash
+	Checksum   string `json:"checksum"`              // DatasetChecksum of the records imported
+
+	// when people were last added or removed one at a time since, after which the dataset no
+	// longer holds just the records imported
+	Edited *time.Time `json:"edited,omitempty"`
+}
+
+// ProvenanceStore is implemented by stores able to record the provenance of each dataset
+type ProvenanceStore interface {
+	// SaveProvenance records the provenance of the dataset's latest import
+	SaveProvenance(dataset string, p Provenance) error
+	// Provenance returns the provenance of the dataset's latest import, or ErrNoProvenance
+	Provenance(dataset string) (Provenance, error)
+}
+
+// NewProvenance returns the provenance of an import of the records read from the source, with
+// the summary of the reading, leaving the counts of the changes made to be filled in
+func NewProvenance(source string, records []Person, summary Progress) Provenance {
+	records = dedupePeople(records)
+	return Provenance{
+		Source:     source,
+		Imported:   time.Now().UTC(),
+		Records:    len(records),
+		Skipped:    summary.Skipped,
+		SourceHash: summary.SourceHash,
+		Checksum:   DatasetChecksum(records),
+	}
+}
+
+// SaveCopiedProvenance records the provenance of a dataset replaced by a copy of the people, from
+// a snapshot or another store: that of the original, if given, so that the copy can be traced
+// back to the same source, or else one naming where it was copied from. Stores which record no
+// provenance are left as they are.
+func SaveCopiedProvenance(store Store, dataset string, original *Provenance, source string, people []Person) error {
+	ps, ok := store.(ProvenanceStore)
+	if !ok {
+		return nil
+	}
+	p := NewProvenance(source, people, Progress{})
+	if original != nil {
+		p = *original
+	}
+	return ps.SaveProvenance(dataset, p)
+}
+
+// MarkEdited records in the provenance of the dataset's latest import, if there is one, that
+// people have since been added or removed one at a time. Stores which record no provenance are
+// left as they are.
+func MarkEdited(store Store, dataset string) error {
+	ps, ok := store.(ProvenanceStore)
+	if !ok {
+		return nil
+	}
+	p, err := ps.Provenance(dataset)
+	if errors.Is(err, ErrNoProvenance) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	p.Edited = &now
+	return ps.SaveProvenance(dataset, p)
+}
@@ -0,0 +1,288 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Orders in which query results can be sorted
+const (
+	SORT_AGE        = "age" // by age at death, the default
+	SORT_NAME       = "name"
+	SORT_DEATH_DATE = "death-date"
+)
+
+// QueryOptions selects the records returned by Query
+type QueryOptions struct {
+	Datasets []string
+	Days     int // the window either side of the user's age, in days
+	Filter   Filter
+	Now      time.Time // the date on which the user's age is calculated
+
+	// Offset and Limit page the results of Query and QueryByAge: Limit results are returned
+	// after skipping the first Offset, or with a Limit of zero all the rest
+	Offset int
+	Limit  int
+
+	// Sort orders the results of Query and QueryByAge before they are paged, by age if empty
+	Sort string
+	Desc bool // whether the order is reversed, e.g. the oldest first
+}
+
+// Query returns the user's age in days as of opts.Now, along with the records from the
+// datasets whose age at death lies within opts.Days either side of it and which match the
+// filter. Results from several datasets are merged and ordered as opts.Sort says, then paged
+// by opts.Offset and opts.Limit; the total number of results before paging is also returned.
+func Query(store Store, dateStr string, opts QueryOptions) (int, []Result, int, error) {
+	if err := ValidateDate(dateStr); err != nil {
+		return 0, nil, 0, err
+	}
+	userAge, err := AgeInDays(dateStr, opts.Now.Format(DATE_FMT))
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	results, total, err := queryPage(store, opts, userAge-opts.Days, userAge+opts.Days)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	return userAge, results, total, nil
+}
+
+// DEFAULT_MAX_DAYS is the widest window WidenDays widens to unless told otherwise, a century
+// either side of the user's age
+const DEFAULT_MAX_DAYS = 36525
+
+// WidenDays returns the window, opts.Days doubled as many times as it takes, within which at
+// least minResults records match the query, or maxDays (DEFAULT_MAX_DAYS if it is zero) if
+// fewer match within that. The matches are counted by CountQuery, so that none is read.
+func WidenDays(store Store, dateStr string, minResults, maxDays int, opts QueryOptions) (int, error) {
+	if maxDays <= 0 {
+		maxDays = DEFAULT_MAX_DAYS
+	}
+	days := min(opts.Days, maxDays)
+	for {
+		opts.Days = days
+		n, err := CountQuery(store, dateStr, opts)
+		if err != nil {
+			return 0, err
+		}
+		if n >= minResults || days >= maxDays {
+			return days, nil
+		}
+		days = min(max(2*days, 1), maxDays)
+	}
+}
+
+// QueryNearest returns the user's age in days as of opts.Now, along with the n records from
+// the datasets, younger or older, whose ages at death are closest to it and which match the
+// filter, ordered as opts.Sort says; of two as close, the younger comes first. Unless a filter
+// is given, only the n records either side of the age are read from each dataset of a store
+// which is a NearestStore; otherwise every record is read.
+func QueryNearest(store Store, dateStr string, n int, opts QueryOptions) (int, []Result, error) {
+	if err := ValidateDate(dateStr); err != nil {
+		return 0, nil, err
+	}
+	userAge, err := AgeInDays(dateStr, opts.Now.Format(DATE_FMT))
+	if err != nil {
+		return 0, nil, err
+	}
+	var results []Result
+	if ns, ok := store.(NearestStore); ok && opts.Filter.IsEmpty() {
+		for _, dataset := range opts.Datasets {
+			found, err := ns.QueryNearest(dataset, userAge, n)
+			if err != nil {
+				return 0, nil, err
+			}
+			for i := range found {
+				found[i].Dataset = dataset
+			}
+			results = append(results, found...)
+		}
+	} else if results, err = queryDatasets(store, opts, math.MinInt32, math.MaxInt32); err != nil {
+		return 0, nil, err
+	}
+	results = nearest(results, userAge, n)
+	SortResults(results, opts.Sort, opts.Desc)
+	return userAge, results, nil
+}
+
+// nearest returns the n results whose ages at death are closest to age, ordered by age
+func nearest(results []Result, age, n int) []Result {
+	distance := func(res Result) int {
+		if res.Days < age {
+			return age - res.Days
+		}
+		return res.Days - age
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if di, dj := distance(results[i]), distance(results[j]); di != dj {
+			return di < dj
+		}
+		return results[i].Days < results[j].Days
+	})
+	if len(results) > n {
+		results = results[:n]
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Days < results[j].Days })
+	return results
+}
+
+// CountQuery returns the number of records Query would return before paging, counted by the
+// store where it can be, without the records being read
+func CountQuery(store Store, dateStr string, opts QueryOptions) (int, error) {
+	if err := ValidateDate(dateStr); err != nil {
+		return 0, err
+	}
+	userAge, err := AgeInDays(dateStr, opts.Now.Format(DATE_FMT))
+	if err != nil {
+		return 0, err
+	}
+	min, max := userAge-opts.Days, userAge+opts.Days
+	total := 0
+	for _, dataset := range opts.Datasets {
+		var n int
+		fs, ok := store.(FilteredStore)
+		switch {
+		case opts.Filter.IsEmpty():
+			n, err = store.Count(dataset, min, max)
+		case ok:
+			n, err = fs.CountFiltered(dataset, min, max, opts.Filter)
+		default:
+			var found []Result
+			found, err = store.QueryByAgeRange(dataset, min, max)
+			n = len(FilterResults(found, opts.Filter))
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// queryPage returns the page of the filtered records from the datasets whose age at death
+// lies within [min, max], along with the total number of such records. A single unfiltered
+// dataset ordered by age is paged by the store if it is a PagedStore; otherwise every record
+// in the range is read and sorted here.
+func queryPage(store Store, opts QueryOptions, min, max int) ([]Result, int, error) {
+	ps, ok := store.(PagedStore)
+	if ok && len(opts.Datasets) == 1 && opts.Filter.IsEmpty() && (opts.Sort == "" || opts.Sort == SORT_AGE) {
+		dataset := opts.Datasets[0]
+		total, err := store.Count(dataset, min, max)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset, limit := opts.Offset, opts.Limit
+		if opts.Desc {
+			// the same page counted back from the oldest
+			end := total - opts.Offset
+			if offset = 0; limit > 0 && end > limit {
+				offset = end - limit
+			}
+			if limit = end - offset; limit <= 0 {
+				return nil, total, nil
+			}
+		}
+		results, err := ps.QueryPage(dataset, min, max, offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := range results {
+			results[i].Dataset = dataset
+		}
+		if opts.Desc {
+			SortResults(results, SORT_AGE, true)
+		}
+		return results, total, nil
+	}
+	results, err := queryDatasets(store, opts, min, max)
+	if err != nil {
+		return nil, 0, err
+	}
+	SortResults(results, opts.Sort, opts.Desc)
+	return paginate(results, opts.Offset, opts.Limit), len(results), nil
+}
+
+// ValidateSort checks that the results can be sorted by the given order
+func ValidateSort(by string) error {
+	switch by {
+	case "", SORT_AGE, SORT_NAME, SORT_DEATH_DATE:
+		return nil
+	}
+	return fmt.Errorf("unknown sort order '%s', expected '%s', '%s' or '%s'", by, SORT_AGE, SORT_NAME, SORT_DEATH_DATE)
+}
+
+// SortResults sorts the results by age at death, by name ignoring case and accents, or by date
+// of death, or if desc is true in the reverse order. Ties are ordered by age.
+func SortResults(results []Result, by string, desc bool) {
+	compare := func(a, b Result) int { return 0 }
+	switch by {
+	case SORT_NAME:
+		compare = func(a, b Result) int { return strings.Compare(FoldName(a.Name), FoldName(b.Name)) }
+	case SORT_DEATH_DATE:
+		compare = func(a, b Result) int { return deathTime(a).Compare(deathTime(b)) }
+	}
+	less := func(a, b Result) bool {
+		if c := compare(a, b); c != 0 {
+			return c < 0
+		}
+		return a.Days < b.Days
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if desc {
+			return less(results[j], results[i])
+		}
+		return less(results[i], results[j])
+	})
+}
+
+// deathTime returns the date of death, which for a partial date is its first day
+func deathTime(res Result) time.Time {
+	t, _, _ := ParsePartialDate(res.DeathDate)
+	return t
+}
+
+// paginate returns up to limit of the results after skipping the first offset, or with a
+// limit of zero all the rest
+func paginate(results []Result, offset, limit int) []Result {
+	if offset > len(results) {
+		offset = len(results)
+	}
+	results = results[offset:]
+	if limit > 0 && limit < len(results) {
+		results = results[:limit]
+	}
+	return results
+}
+
+// queryDatasets returns the filtered records from all of the datasets whose age at death lies
+// within [min, max], ordered by age
+func queryDatasets(store Store, opts QueryOptions, min, max int) ([]Result, error) {
+	var results []Result
+	for _, dataset := range opts.Datasets {
+		var found []Result
+		var err error
+		if fs, ok := store.(FilteredStore); ok && !opts.Filter.IsEmpty() {
+			found, err = fs.QueryFiltered(dataset, min, max, opts.Filter)
+		} else {
+			found, err = store.QueryByAgeRange(dataset, min, max)
+			found = FilterResults(found, opts.Filter)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			found[i].Dataset = dataset
+		}
+		results = append(results, found...)
+	}
+	if len(opts.Datasets) > 1 {
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Days < results[j].Days })
+	}
+	return results, nil
+}
@@ -0,0 +1,129 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoOne is returned when a person is to be chosen from datasets in which no one has died
+var ErrNoOne = errors.New("no one to choose from")
+
+// DEFAULT_SPREAD_DAYS is the standard deviation, in days, of the ages at death by which
+// RandomPersonNear weights people, about five years
+const DEFAULT_SPREAD_DAYS = 1826
+
+// RandomPerson returns someone chosen at random from those in the datasets who have died, each
+// as likely as any other. Where the store is a PagedStore only the one chosen is read.
+func RandomPerson(store Store, datasets []string, rng *rand.Rand) (Result, error) {
+	counts := make([]int, len(datasets))
+	total := 0
+	for i, dataset := range datasets {
+		n, err := store.Count(dataset, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return Result{}, err
+		}
+		counts[i] = n
+		total += n
+	}
+	if total == 0 {
+		return Result{}, ErrNoOne
+	}
+	n := rng.Intn(total)
+	for i, dataset := range datasets {
+		if n >= counts[i] {
+			n -= counts[i]
+			continue
+		}
+		var found []Result
+		var err error
+		if ps, ok := store.(PagedStore); ok {
+			found, err = ps.QueryPage(dataset, math.MinInt32, math.MaxInt32, n, 1)
+		} else if found, err = store.QueryByAgeRange(dataset, math.MinInt32, math.MaxInt32); err == nil && n < len(found) {
+			found = found[n:]
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		if len(found) == 0 {
+			return Result{}, ErrNoOne // removed by an import since they were counted
+		}
+		res := found[0]
+		res.Dataset = dataset
+		return res, nil
+	}
+	return Result{}, ErrNoOne
+}
+
+// RandomPersonNear returns someone chosen at random from those in the datasets who died within
+// three spreads of age, both in days, the likelier the closer their age at death is to it, as
+// weighted by a normal distribution with a standard deviation of spread
+func RandomPersonNear(store Store, datasets []string, age, spread int, rng *rand.Rand) (Result, error) {
+	if spread <= 0 {
+		spread = DEFAULT_SPREAD_DAYS
+	}
+	var candidates []Result
+	var weights []float64
+	total := 0.0
+	for _, dataset := range datasets {
+		found, err := store.QueryByAgeRange(dataset, age-3*spread, age+3*spread)
+		if err != nil {
+			return Result{}, err
+		}
+		for _, res := range found {
+			d := float64(res.Days-age) / float64(spread)
+			w := math.Exp(-d * d / 2)
+			res.Dataset = dataset
+			candidates = append(candidates, res)
+			weights = append(weights, w)
+			total += w
+		}
+	}
+	if len(candidates) == 0 {
+		return Result{}, ErrNoOne
+	}
+	x := rng.Float64() * total
+	for i, w := range weights {
+		if x < w {
+			return candidates[i], nil
+		}
+		x -= w
+	}
+	return candidates[len(candidates)-1], nil
+}
+
+// PersonOfTheDay returns the person of the day on which now falls, chosen as RandomPerson
+// chooses but by a source seeded by the date and the datasets, so that it is the same all day
+// for anyone asking of the same datasets, unless they are imported again
+func PersonOfTheDay(store Store, datasets []string, now time.Time) (Result, error) {
+	h := fnv.New64a()
+	h.Write([]byte(now.Format(DATE_FMT) + "," + strings.Join(datasets, ",")))
+	return RandomPerson(store, datasets, rand.New(rand.NewSource(int64(h.Sum64()))))
+}
+
+// ChooseOfTheDay returns n of the results, chosen at random by a source seeded by the date on
+// which now falls, so that the same are chosen all day, in the order in which they were given.
+// It returns none if n is 0 or less.
+func ChooseOfTheDay(results []Result, n int, now time.Time) []Result {
+	if n <= 0 {
+		return nil
+	}
+	if n >= len(results) {
+		return results
+	}
+	h := fnv.New64a()
+	h.Write([]byte(now.Format(DATE_FMT)))
+	chosen := rand.New(rand.NewSource(int64(h.Sum64()))).Perm(len(results))[:n]
+	sort.Ints(chosen)
+	picked := make([]Result, n)
+	for i, j := range chosen {
+		picked[i] = results[j]
+	}
+	return picked
+}
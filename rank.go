@@ -0,0 +1,103 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import "math"
+
+// Ranking places the user within the whole of one or more datasets
+type Ranking struct {
+	Outlived int // the number of people who died younger than the user is now
+	Total    int
+}
+
+// Percentile returns the percentage of people the user has outlived
+func (r Ranking) Percentile() float64 {
+	if r.Total == 0 {
+		return 0
+	}
+	return float64(r.Outlived) * 100 / float64(r.Total)
+}
+
+// Rank returns the user's position among the people in the datasets when ordered from the
+// longest lived, the user included
+func (r Ranking) Rank() int {
+	return r.Total - r.Outlived + 1
+}
+
+// Rank counts how many people across the datasets the user, aged userAge days, has outlived.
+// Without a filter this is answered by counts from the store; with one it is counted by a
+// FilteredStore, or otherwise every record has to be read and matched.
+func Rank(store Store, userAge int, opts QueryOptions) (Ranking, error) {
+	var r Ranking
+	for _, dataset := range opts.Datasets {
+		if opts.Filter.IsEmpty() {
+			outlived, err := store.Count(dataset, math.MinInt32, userAge-1)
+			if err != nil {
+				return r, err
+			}
+			total, err := store.Count(dataset, math.MinInt32, math.MaxInt32)
+			if err != nil {
+				return r, err
+			}
+			r.Outlived += outlived
+			r.Total += total
+			continue
+		}
+		if fs, ok := store.(FilteredStore); ok {
+			outlived, err := fs.CountFiltered(dataset, math.MinInt32, userAge-1, opts.Filter)
+			if err != nil {
+				return r, err
+			}
+			total, err := fs.CountFiltered(dataset, math.MinInt32, math.MaxInt32, opts.Filter)
+			if err != nil {
+				return r, err
+			}
+			r.Outlived += outlived
+			r.Total += total
+			continue
+		}
+		all, err := AllRecords(store, dataset)
+		if err != nil {
+			return r, err
+		}
+		for _, res := range FilterResults(all, opts.Filter) {
+			if res.Days < userAge {
+				r.Outlived++
+			}
+			r.Total++
+		}
+	}
+	return r, nil
+}
+
+// Standing places a person among the others in their dataset by age at death. For a living
+// person their age now is compared instead.
+type Standing struct {
+	Shorter int // the number of others who died younger
+	Same    int // the number of others who died at the same age
+	Longer  int // the number of others who died older
+}
+
+// Total returns the number of others in the dataset who have died
+func (s Standing) Total() int {
+	return s.Shorter + s.Same + s.Longer
+}
+
+// PersonStanding counts how many people in the result's dataset lived longer and shorter lives
+func PersonStanding(store Store, res Result) (Standing, error) {
+	var s Standing
+	var err error
+	if s.Shorter, err = store.Count(res.Dataset, math.MinInt32, res.Days-1); err != nil {
+		return s, err
+	}
+	if s.Same, err = store.Count(res.Dataset, res.Days, res.Days); err != nil {
+		return s, err
+	}
+	if s.Longer, err = store.Count(res.Dataset, res.Days+1, math.MaxInt32); err != nil {
+		return s, err
+	}
+	if !res.Living() && s.Same > 0 {
+		s.Same-- // not counting the person themselves
+	}
+	return s, nil
+}
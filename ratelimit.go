@@ -0,0 +1,164 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RATELIMIT_KEY_PREFIX begins the keys of the Redis Hashes holding each client's token bucket,
+// e.g. 'outlived:ratelimit:key:1f2e3d4c5b6a7988'
+const RATELIMIT_KEY_PREFIX = "outlived:ratelimit:"
+
+// TokenBucket describes a rate limit: each client has a bucket of up to Burst tokens, refilled
+// at Rate tokens a second, and each request takes a token
+type TokenBucket struct {
+	Rate  float64
+	Burst int
+}
+
+// RateDecision is whether a request is allowed by the rate limit, and the state of the
+// client's bucket after it
+type RateDecision struct {
+	Allowed    bool
+	Remaining  int           // the whole tokens left
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until a token will be available, if the request is not allowed
+}
+
+// decision describes the bucket once a request has (if it was allowed) taken from it
+func (b TokenBucket) decision(tokens float64, allowed bool) RateDecision {
+	d := RateDecision{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(b.Burst) - tokens) / b.Rate * float64(time.Second)),
+	}
+	if !allowed {
+		d.RetryAfter = time.Duration((1 - tokens) / b.Rate * float64(time.Second))
+	}
+	return d
+}
+
+// RateLimiter limits the rate of each client's requests, identified by any string
+type RateLimiter interface {
+	// Take takes a token from the client's bucket, returning whether there was one
+	Take(client string) (RateDecision, error)
+}
+
+// MemoryRateLimiter keeps the buckets in memory, so each server has its own limit
+type MemoryRateLimiter struct {
+	bucket TokenBucket
+	mu     sync.Mutex
+	state  map[string]bucketState
+}
+
+type bucketState struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MEMORY_RATELIMIT_CLIENTS is the number of buckets a MemoryRateLimiter holds before those which
+// have refilled, and so are no different to a new bucket, are discarded
+const MEMORY_RATELIMIT_CLIENTS = 10000
+
+func NewMemoryRateLimiter(b TokenBucket) *MemoryRateLimiter {
+	return &MemoryRateLimiter{bucket: b, state: map[string]bucketState{}}
+}
+
+func (l *MemoryRateLimiter) Take(client string) (RateDecision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if len(l.state) >= MEMORY_RATELIMIT_CLIENTS {
+		for c, s := range l.state {
+			if l.refill(s, now) >= float64(l.bucket.Burst) {
+				delete(l.state, c)
+			}
+		}
+	}
+	tokens := float64(l.bucket.Burst)
+	if s, ok := l.state[client]; ok {
+		tokens = l.refill(s, now)
+	}
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	l.state[client] = bucketState{tokens, now}
+	return l.bucket.decision(tokens, allowed), nil
+}
+
+// refill returns the tokens in the bucket as of now
+func (l *MemoryRateLimiter) refill(s bucketState, now time.Time) float64 {
+	return math.Min(float64(l.bucket.Burst), s.tokens+now.Sub(s.updated).Seconds()*l.bucket.Rate)
+}
+
+// takeScript refills the bucket held in KEYS[1] at ARGV[1] tokens a second up to ARGV[2], by
+// the server's clock so that every client of the server agrees, and takes a token if there is
+// one. It returns whether a token was taken, and the tokens left as a string, as Lua numbers
+// are truncated to integers in replies.
+var takeScript = redis.NewScript(1, `
+local rate, burst = tonumber(ARGV[1]), tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisRateLimiter keeps the buckets in Redis, so that several servers share one limit. Each
+// bucket expires once it has refilled.
+type RedisRateLimiter struct {
+	bucket TokenBucket
+	store  *RedisStore // whose pool lets clients be counted at once
+}
+
+// NewRedisRateLimiter connects to Redis with its own pool of connections
+func NewRedisRateLimiter(cfg RedisConfig, b TokenBucket) (*RedisRateLimiter, error) {
+	store, err := NewRedisStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisRateLimiter{bucket: b, store: store}, nil
+}
+
+func (l *RedisRateLimiter) Take(client string) (RateDecision, error) {
+	var allowed bool
+	var tokens float64
+	err := l.store.do(func(c redis.Conn) error {
+		reply, err := redis.Values(takeScript.Do(c, RATELIMIT_KEY_PREFIX+client, l.bucket.Rate, l.bucket.Burst))
+		if err != nil {
+			return err
+		}
+		var n int
+		var s string
+		if _, err := redis.Scan(reply, &n, &s); err != nil {
+			return err
+		}
+		allowed = n == 1
+		tokens, err = strconv.ParseFloat(s, 64)
+		return err
+	})
+	if err != nil {
+		return RateDecision{}, err
+	}
+	return l.bucket.decision(tokens, allowed), nil
+}
+
+func (l *RedisRateLimiter) Close() error {
+	return l.store.Close()
+}
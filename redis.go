@@ -0,0 +1,896 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DATASETS_KEY is the Redis Set holding the names of all imported datasets
+const DATASETS_KEY = "outlived:datasets"
+
+// PROFILES_KEY is the Redis Hash mapping profile names to dates of birth
+const PROFILES_KEY = "outlived:profiles"
+
+// GENERATION_KEY is the Redis String counting the imports and upserts made to any dataset
+const GENERATION_KEY = "outlived:generation"
+
+// CACHE_KEY_PREFIX begins the keys of the Redis Strings holding cached responses, shared by
+// every server using the instance
+const CACHE_KEY_PREFIX = "outlived:cache:"
+
+// APIKEYS_KEY is the Redis Hash mapping the IDs of API keys to the keys, encoded as JSON
+const APIKEYS_KEY = "outlived:apikeys"
+
+// CLAIMS_KEY_PREFIX begins the keys set by Claim
+const CLAIMS_KEY_PREFIX = "outlived:claims:"
+
+// SubscriptionsKey returns the key of the Redis Hash mapping the IDs of the chats subscribed to
+// a bot service to their subscriptions, encoded as JSON, e.g. 'outlived:subscriptions:telegram'
+func SubscriptionsKey(service string) string {
+	return "outlived:subscriptions:" + service
+}
+
+// RedisStore stores each dataset in a Redis Sorted Set of person IDs (see Person.ID) scored
+// by age at death in days, with each person's details held in a Redis Hash. Living people are
+// held in a second sorted set, scored by date of birth. The dataset is indexed beside them by
+// name, day, year of death and occupation (see personIndex). When the RediSearch module is
+// loaded, the person hashes are also indexed for searching by name and filtering (see
+// SearchIndex).
+type RedisStore struct {
+	cfg     RedisConfig
+	pool    *redis.Pool     // shared with the views made by WithContext
+	ctx     context.Context // within which commands are made and traced
+	search  bool            // whether the RediSearch module is loaded
+	indexed map[string]bool // whether each dataset has a search index, once known
+}
+
+// NewRedisStore connects to the Redis instance described by the configuration, and detects
+// whether the RediSearch module is loaded. Further connections are made by a pool as needed.
+func NewRedisStore(cfg RedisConfig) (*RedisStore, error) {
+	c, err := cfg.Dial()
+	if err != nil {
+		return nil, err
+	}
+	search := detectSearch(c)
+	c.Close()
+	return &RedisStore{cfg: cfg, pool: cfg.NewPool(), ctx: context.Background(), search: search, indexed: map[string]bool{}}, nil
+}
+
+// WithContext returns a view of the store which records a span for each command it sends to
+// Redis, as a child of any span in ctx
+func (s *RedisStore) WithContext(ctx context.Context) Store {
+	view := *s
+	view.ctx = ctx
+	return &view
+}
+
+// DatasetKey returns the key of the sorted set holding the dataset, e.g. 'outlived:{actors}'.
+// The name is a Redis Cluster hash tag, so that the other keys belonging to the dataset are
+// stored in the same slot and can be updated in the same transaction.
+func DatasetKey(dataset string) string {
+	return "outlived:{" + dataset + "}"
+}
+
+// LivingKey returns the key of the sorted set holding the dataset's living people, scored by
+// date of birth in days since 1970-01-01, e.g. 'outlived:{actors}:living'
+func LivingKey(dataset string) string {
+	return DatasetKey(dataset) + ":living"
+}
+
+// PersonKey returns the key of the hash holding a person's details,
+// e.g. 'outlived:{actors}:person:9f86d081884c7d65'
+func PersonKey(dataset, id string) string {
+	return DatasetKey(dataset) + ":person:" + id
+}
+
+// ProvenanceKey returns the key of the string holding the provenance of the dataset's latest
+// import, encoded as JSON, e.g. 'outlived:{actors}:provenance'
+func ProvenanceKey(dataset string) string {
+	return DatasetKey(dataset) + ":provenance"
+}
+
+// do runs the function against a connection from the pool. If it fails for a reason which may
+// pass (the connection broke as Redis restarted, say, or the master failed over) it is retried
+// on another connection after a backoff, as configured, so the function must be safe to repeat.
+// Once the store's context has ended no more commands are sent, and any transaction the
+// function had begun is discarded.
+func (s *RedisStore) do(fn func(c redis.Conn) error) error {
+	for retry := 0; ; retry++ {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+		broken, err := s.try(fn)
+		if ctxErr := s.ctx.Err(); err != nil && ctxErr != nil {
+			return ctxErr
+		}
+		if err == nil || !(broken || isTransient(err)) || retry >= s.cfg.retries() {
+			return err
+		}
+		if err := sleepContext(s.ctx, s.cfg.backoff(retry)); err != nil {
+			return err
+		}
+	}
+}
+
+// try runs the function once against a connection from the pool, reporting whether the
+// connection broke, or couldn't be made. The connection is returned to the pool afterwards,
+// unless it broke, with any transaction left open discarded.
+func (s *RedisStore) try(fn func(c redis.Conn) error) (broken bool, err error) {
+	c, err := s.pool.GetContext(s.ctx)
+	if err != nil {
+		return true, err // no connection could be made
+	}
+	defer c.Close()
+	err = fn(&tracedConn{Conn: c, ctx: s.ctx})
+	return c.Err() != nil, err
+}
+
+// watchedTransaction watches the keys, then calls fn to read whatever it needs and return
+// the commands to run in a MULTI/EXEC transaction. If a watched key changes before EXEC
+// the whole process is repeated.
+func watchedTransaction(c redis.Conn, keys []interface{}, fn func() ([]redisCmd, error)) error {
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := c.Do("WATCH", keys...); err != nil {
+			return err
+		}
+		cmds, err := fn()
+		if err != nil {
+			c.Do("UNWATCH")
+			return err
+		}
+		c.Send("MULTI") // send following commands in a transaction
+		for _, cmd := range cmds {
+			c.Send(cmd.name, cmd.args...)
+		}
+		reply, err := c.Do("EXEC") // COMMIT data
+		if err != nil {
+			return err
+		}
+		if reply != nil { // a nil reply means a watched key changed
+			return nil
+		}
+	}
+	return fmt.Errorf("transaction aborted: %v kept changing", keys)
+}
+
+// recordImport lists the dataset and counts the import once its transaction has committed. The
+// commands are sent even if the store's context has ended meanwhile, since the import has
+// been made and must not be left out of the list.
+func recordImport(c redis.Conn, dataset string) error {
+	c = uncancelled(c)
+	if _, err := c.Do("SADD", DATASETS_KEY, dataset); err != nil {
+		return err
+	}
+	_, err := c.Do("INCR", GENERATION_KEY)
+	return err
+}
+
+// personArgs returns the HMSET arguments storing the person's details, along with their folded
+// name and for those who have died their age at death in days, which are indexed for searching
+func personArgs(key string, rec Person, days int) []interface{} {
+	args := []interface{}{key,
+		"name", rec.Name,
+		"birth_date", rec.BirthDate,
+		"death_date", rec.DeathDate,
+		"occupation", rec.Occupation,
+		"nationality", rec.Nationality,
+		"cause_of_death", rec.CauseOfDeath,
+		"genre", rec.Genre,
+		"url", rec.URL,
+		"summary", rec.Summary,
+		"image_url", rec.ImageURL,
+		"aliases", rec.Aliases,
+		"tags", rec.Tags,
+		"name_key", NameKey(rec),
+	}
+	if !rec.Living() {
+		args = append(args, "age_days", days)
+	}
+	return args
+}
+
+func personFromHash(fields map[string]string) Person {
+	return Person{
+		Name:      fields["name"],
+		BirthDate: fields["birth_date"],
+		DeathDate: fields["death_date"],
+
+		Occupation:   fields["occupation"],
+		Nationality:  fields["nationality"],
+		CauseOfDeath: fields["cause_of_death"],
+		Genre:        fields["genre"],
+
+		URL:      fields["url"],
+		Summary:  fields["summary"],
+		ImageURL: fields["image_url"],
+
+		Aliases: fields["aliases"],
+		Tags:    fields["tags"],
+	}
+}
+
+// Upsert merges the records into the dataset. The dataset is watched while the merge is
+// prepared, so that if another import changes it meanwhile the merge is retried.
+func (s *RedisStore) Upsert(dataset string, records []Person) (UpsertStats, error) {
+	if err := ValidateDatasetName(dataset); err != nil {
+		return UpsertStats{}, err
+	}
+	records = dedupePeople(records)
+	var err error
+	scores := make([]int, len(records)) // age at death, or for the living date of birth
+	for i, rec := range records {
+		if rec.Living() {
+			scores[i] = birthDay(rec)
+		} else if scores[i], err = rec.AgeInDays(); err != nil {
+			return UpsertStats{}, err
+		}
+	}
+	key, livingKey := DatasetKey(dataset), LivingKey(dataset)
+
+	var stats UpsertStats
+	err = s.do(func(c redis.Conn) error {
+		err := watchedTransaction(c, []interface{}{key, livingKey}, func() ([]redisCmd, error) {
+			stats = UpsertStats{}
+			ids := make([]string, len(records))
+			for i, rec := range records {
+				ids[i] = rec.ID()
+			}
+			existing, err := hydrate(c, dataset, ids)
+			if err != nil {
+				return nil, err
+			}
+			var cmds []redisCmd
+			// a dataset begun by an upsert is held in the current layout, but one imported by an
+			// earlier version is left to be migrated
+			if created, err := isNewDataset(c, dataset); err != nil {
+				return nil, err
+			} else if created {
+				cmds = append(cmds, redisCmd{"SET", []interface{}{SchemaKey(dataset), SCHEMA_VERSION}})
+			}
+			for i, rec := range records {
+				switch {
+				case existing[i] == nil:
+					stats.Inserted++
+				case *existing[i] != rec:
+					stats.Updated++
+				default:
+					stats.Unchanged++
+					continue
+				}
+				// anyone who has died since the last import moves from the living to the dead
+				to, from := key, livingKey
+				if rec.Living() {
+					to, from = livingKey, key
+				}
+				cmds = append(cmds,
+					redisCmd{"ZREM", []interface{}{from, ids[i]}},
+					redisCmd{"ZADD", []interface{}{to, scores[i], ids[i]}},
+					redisCmd{"HMSET", personArgs(PersonKey(dataset, ids[i]), rec, scores[i])})
+				if existing[i] != nil {
+					cmds = append(cmds, indexOf(dataset, *existing[i]).removeCmds(ids[i])...)
+					if !existing[i].Living() && rec.Living() {
+						cmds = append(cmds, redisCmd{"HDEL", []interface{}{PersonKey(dataset, ids[i]), "age_days"}})
+					}
+				}
+				cmds = append(cmds, indexOf(dataset, rec).addCmds(ids[i])...)
+			}
+			return cmds, nil
+		})
+		if err != nil {
+			return err
+		}
+		return recordImport(c, dataset)
+	})
+	return stats, err
+}
+
+// hydrate fetches the details of each person with a pipelined HGETALL, returning nil for
+// any person who is not stored
+func hydrate(c redis.Conn, dataset string, ids []string) ([]*Person, error) {
+	for _, id := range ids {
+		c.Send("HGETALL", PersonKey(dataset, id))
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	people := make([]*Person, len(ids))
+	var firstErr error
+	for i := range ids {
+		fields, err := redis.StringMap(c.Receive())
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if len(fields) > 0 {
+			rec := personFromHash(fields)
+			people[i] = &rec
+		}
+	}
+	return people, firstErr
+}
+
+// Remove deletes the records of the same people as those given from the dataset, along with
+// their entries in its sets, indexes and hash of names, in a transaction watching the dataset
+// as Upsert does
+func (s *RedisStore) Remove(dataset string, people []Person) (int, error) {
+	if err := ValidateDatasetName(dataset); err != nil {
+		return 0, err
+	}
+	people = dedupePeople(people)
+	ids := make([]string, len(people))
+	for i, rec := range people {
+		ids[i] = rec.ID()
+	}
+	key, livingKey := DatasetKey(dataset), LivingKey(dataset)
+
+	var removed int
+	err := s.do(func(c redis.Conn) error {
+		err := watchedTransaction(c, []interface{}{key, livingKey}, func() ([]redisCmd, error) {
+			removed = 0
+			existing, err := hydrate(c, dataset, ids)
+			if err != nil {
+				return nil, err
+			}
+			var cmds []redisCmd
+			for i, rec := range existing {
+				if rec == nil {
+					continue
+				}
+				removed++
+				cmds = append(cmds,
+					redisCmd{"ZREM", []interface{}{key, ids[i]}},
+					redisCmd{"ZREM", []interface{}{livingKey, ids[i]}},
+					redisCmd{"DEL", []interface{}{PersonKey(dataset, ids[i])}})
+				cmds = append(cmds, indexOf(dataset, *rec).removeCmds(ids[i])...)
+			}
+			return cmds, nil
+		})
+		if err != nil || removed == 0 {
+			return err
+		}
+		return recordImport(c, dataset)
+	})
+	return removed, err
+}
+
+// QueryByAgeRange returns all records in the dataset whose age at death in days lies within
+// [min, max], ordered by age
+func (s *RedisStore) QueryByAgeRange(dataset string, min, max int) ([]Result, error) {
+	return s.QueryPage(dataset, min, max, 0, 0)
+}
+
+// queryScript reads the members of the sorted set KEYS[1] scored within [ARGV[1], ARGV[2]],
+// skipping the first ARGV[3] and returning up to ARGV[4] (all of them if it is -1), along with
+// each one's hash, whose key is ARGV[5] followed by their ID. Its reply holds the ID, score and
+// hash fields of each member in turn, an empty hash for those stored by earlier versions, so
+// that a query takes one round trip however many people it finds. If ARGV[9] is '1' the members
+// are read from the highest score down, as by ZREVRANGEBYSCORE. Unless ARGV[6] is empty, only
+// members of the set it names are read, provided the dataset's layout, recorded under ARGV[7],
+// is at least ARGV[8] and so holds the set; otherwise every member is. The other keys share
+// KEYS[1]'s hash slot, so a Redis Cluster runs the script on the node serving it.
+var queryScript = redis.NewScript(1, `
+local within = ARGV[6]
+if within ~= '' and (tonumber(redis.call('GET', ARGV[7])) or 0) < tonumber(ARGV[8]) then
+	within = ''
+end
+local members
+if ARGV[9] == '1' then
+	members = redis.call('ZREVRANGEBYSCORE', KEYS[1], ARGV[2], ARGV[1], 'WITHSCORES', 'LIMIT', ARGV[3], ARGV[4])
+else
+	members = redis.call('ZRANGEBYSCORE', KEYS[1], ARGV[1], ARGV[2], 'WITHSCORES', 'LIMIT', ARGV[3], ARGV[4])
+end
+local reply = {}
+for i = 1, #members, 2 do
+	if within == '' or redis.call('SISMEMBER', within, members[i]) == 1 then
+		reply[#reply + 1] = members[i]
+		reply[#reply + 1] = members[i + 1]
+		reply[#reply + 1] = redis.call('HGETALL', ARGV[5] .. members[i])
+	end
+end
+return reply
+`)
+
+// QueryPage returns up to limit of the records in the dataset whose age at death lies within
+// [min, max], after skipping the first offset, ordered by age. The page is read, and each
+// person's details with it, by queryScript in one round trip.
+func (s *RedisStore) QueryPage(dataset string, min, max, offset, limit int) ([]Result, error) {
+	return s.queryWithin(dataset, min, max, offset, limit, "", false)
+}
+
+// QueryNearest reads the n records either side of the age with two reads of the sorted set
+// bounded by LIMIT, one down from the age and one up from it
+func (s *RedisStore) QueryNearest(dataset string, age, n int) ([]Result, error) {
+	below, err := s.queryWithin(dataset, math.MinInt32, age-1, 0, n, "", true)
+	if err != nil {
+		return nil, err
+	}
+	above, err := s.queryWithin(dataset, age, math.MaxInt32, 0, n, "", false)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(below)
+	return append(below, above...), nil
+}
+
+// queryWithin reads a page as QueryPage does, of only those in the set under the key within if
+// it is not empty and the dataset has it, and if rev is set from the oldest down (see
+// queryScript)
+func (s *RedisStore) queryWithin(dataset string, min, max, offset, limit int, within string, rev bool) ([]Result, error) {
+	if limit <= 0 {
+		limit = -1 // the rest of the range
+	}
+	order := "0"
+	if rev {
+		order = "1"
+	}
+	var results []Result
+	err := s.do(func(c redis.Conn) error {
+		values, err := redis.Values(queryScript.Do(c, DatasetKey(dataset), min, max, offset, limit, PersonKey(dataset, ""),
+			within, SchemaKey(dataset), INDEXED_SCHEMA_VERSION, order))
+		if err != nil {
+			return err
+		}
+		results = make([]Result, 0, len(values)/3)
+		for len(values) > 0 {
+			var id string
+			var score int
+			var fields []interface{}
+			if values, err = redis.Scan(values, &id, &score, &fields); err != nil {
+				return err
+			}
+			var rec Person
+			if len(fields) > 0 {
+				hash, err := redis.StringMap(fields, nil)
+				if err != nil {
+					return err
+				}
+				rec = personFromHash(hash)
+			} else if rec, err = parseMember(id); err != nil {
+				// datasets imported by earlier versions held 'name,dob,dod' members
+				return fmt.Errorf("%s: no details stored for %q", DatasetKey(dataset), id)
+			}
+			results = append(results, Result{Person: rec, Days: score})
+		}
+		return nil
+	})
+	return results, err
+}
+
+// QueryByDay returns the records in the dataset whose date of death, or of birth, falls on
+// the day given as 'MM-DD', from the set indexing them by that day. Datasets imported by
+// earlier versions have no such index until they are imported again.
+func (s *RedisStore) QueryByDay(dataset, day, which string) ([]Result, error) {
+	var results []Result
+	err := s.do(func(c redis.Conn) error {
+		ids, err := redis.Strings(c.Do("SMEMBERS", DayKey(dataset, which, day)))
+		if err != nil {
+			return err
+		}
+		people, err := hydrate(c, dataset, ids)
+		if err != nil {
+			return err
+		}
+		results = make([]Result, 0, len(ids))
+		for _, rec := range people {
+			if rec == nil {
+				continue // removed by an import since
+			}
+			age, err := rec.AgeInDays()
+			if err != nil {
+				return err
+			}
+			results = append(results, Result{Person: *rec, Days: age})
+		}
+		return nil
+	})
+	return results, err
+}
+
+// QueryByBirthYear returns the people in the dataset born in the years from to to, from the
+// sorted set indexing them by year of birth. Datasets held in an earlier layout, which lack it,
+// are read in full instead.
+func (s *RedisStore) QueryByBirthYear(dataset string, from, to int) ([]Person, error) {
+	version, err := s.SchemaVersion(dataset)
+	if err != nil {
+		return nil, err
+	}
+	if version < BIRTH_YEARS_SCHEMA_VERSION {
+		all, err := AllPeople(s, dataset)
+		return bornIn(all, from, to), err
+	}
+	var people []Person
+	err = s.do(func(c redis.Conn) error {
+		ids, err := redis.Strings(c.Do("ZRANGEBYSCORE", BirthYearsKey(dataset), from, to))
+		if err != nil {
+			return err
+		}
+		found, err := hydrate(c, dataset, ids)
+		if err != nil {
+			return err
+		}
+		people = make([]Person, 0, len(ids))
+		for _, rec := range found {
+			if rec != nil { // removed by an import since
+				people = append(people, *rec)
+			}
+		}
+		return nil
+	})
+	return people, err
+}
+
+// TagCounts returns the tags held by the people in the dataset, counted by the size of the set
+// indexing each tag in the set of tags. Tags whose last holder has been removed are left out.
+func (s *RedisStore) TagCounts(dataset string) ([]TagCount, error) {
+	counts := map[string]int{}
+	err := s.do(func(c redis.Conn) error {
+		tags, err := redis.Strings(c.Do("SMEMBERS", TagsKey(dataset)))
+		if err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			c.Send("SCARD", TagKey(dataset, tag))
+		}
+		if err := c.Flush(); err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			n, err := redis.Int(c.Receive())
+			if err != nil {
+				return err
+			}
+			counts[tag] = n
+		}
+		return nil
+	})
+	return sortTagCounts(counts), err
+}
+
+// FindByName returns the people in the dataset whose folded names, or aliases, match, read from
+// the hash of names. Datasets imported by earlier versions have no such hash, and are searched by reading
+// every record instead.
+func (s *RedisStore) FindByName(dataset string, match func(folded string) bool) ([]Person, error) {
+	var names map[string]string
+	err := s.do(func(c redis.Conn) error {
+		var err error
+		names, err = redis.StringMap(c.Do("HGETALL", NamesKey(dataset)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		all, err := AllPeople(s, dataset)
+		if err != nil {
+			return nil, err
+		}
+		var found []Person
+		for _, rec := range all {
+			if matchesNameKey(NameKey(rec), match) {
+				found = append(found, rec)
+			}
+		}
+		return found, nil
+	}
+	var ids []string
+	for id, key := range names {
+		if matchesNameKey(key, match) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	var found []Person
+	err = s.do(func(c redis.Conn) error {
+		people, err := hydrate(c, dataset, ids)
+		if err != nil {
+			return err
+		}
+		found = make([]Person, 0, len(ids))
+		for _, rec := range people {
+			if rec != nil {
+				found = append(found, *rec)
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// Living returns the living people in the dataset, ordered by date of birth
+func (s *RedisStore) Living(dataset string) ([]Person, error) {
+	var living []Person
+	err := s.do(func(c redis.Conn) error {
+		ids, err := redis.Strings(c.Do("ZRANGE", LivingKey(dataset), 0, -1))
+		if err != nil {
+			return err
+		}
+		people, err := hydrate(c, dataset, ids)
+		if err != nil {
+			return err
+		}
+		living = make([]Person, 0, len(ids))
+		for i, rec := range people {
+			if rec == nil {
+				return fmt.Errorf("%s: no details stored for %q", LivingKey(dataset), ids[i])
+			}
+			living = append(living, *rec)
+		}
+		return nil
+	})
+	return living, err
+}
+
+// Count returns the number of records in the dataset whose age at death lies within [min, max]
+func (s *RedisStore) Count(dataset string, min, max int) (int, error) {
+	var n int
+	err := s.do(func(c redis.Conn) error {
+		var err error
+		n, err = redis.Int(c.Do("ZCOUNT", DatasetKey(dataset), min, max))
+		return err
+	})
+	return n, err
+}
+
+// parseMember parses a sorted set member of the form 'name,dob,dod'
+func parseMember(member string) (Person, error) {
+	fields := strings.Split(member, ",")
+	if len(fields) < 3 {
+		return Person{}, fmt.Errorf("malformed record %q", member)
+	}
+	return Person{Name: fields[0], BirthDate: fields[1], DeathDate: fields[2]}, nil
+}
+
+// Datasets lists the datasets recorded in the 'outlived:datasets' set, with their sizes
+func (s *RedisStore) Datasets() ([]DatasetInfo, error) {
+	var datasets []DatasetInfo
+	err := s.do(func(c redis.Conn) error {
+		names, err := redis.Strings(c.Do("SMEMBERS", DATASETS_KEY))
+		if err != nil {
+			return err
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c.Send("ZCARD", DatasetKey(name))
+			c.Send("ZCARD", LivingKey(name))
+		}
+		if err := c.Flush(); err != nil {
+			return err
+		}
+		datasets = make([]DatasetInfo, 0, len(names))
+		for _, name := range names {
+			n, err := redis.Int(c.Receive())
+			if err != nil {
+				return err
+			}
+			living, err := redis.Int(c.Receive())
+			if err != nil {
+				return err
+			}
+			datasets = append(datasets, DatasetInfo{Name: name, Count: n, Living: living})
+		}
+		return nil
+	})
+	return datasets, err
+}
+
+// Profiles returns the profiles held in the 'outlived:profiles' hash
+func (s *RedisStore) Profiles() ([]Profile, error) {
+	var profiles []Profile
+	err := s.do(func(c redis.Conn) error {
+		m, err := redis.StringMap(c.Do("HGETALL", PROFILES_KEY))
+		if err != nil {
+			return err
+		}
+		profiles = make([]Profile, 0, len(m))
+		for name, dob := range m {
+			profiles = append(profiles, Profile{Name: name, BirthDate: dob})
+		}
+		return nil
+	})
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles, err
+}
+
+func (s *RedisStore) SaveProfile(p Profile) error {
+	if err := ValidateProfile(p); err != nil {
+		return err
+	}
+	return s.do(func(c redis.Conn) error {
+		_, err := c.Do("HSET", PROFILES_KEY, p.Name, p.BirthDate)
+		return err
+	})
+}
+
+func (s *RedisStore) RemoveProfile(name string) error {
+	return s.do(func(c redis.Conn) error {
+		n, err := redis.Int(c.Do("HDEL", PROFILES_KEY, name))
+		if err == nil && n == 0 {
+			err = fmt.Errorf("profile '%s': %v", name, ErrNoProfile)
+		}
+		return err
+	})
+}
+
+// APIKeys returns the API keys held in the 'outlived:apikeys' hash
+func (s *RedisStore) APIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	err := s.do(func(c redis.Conn) error {
+		m, err := redis.StringMap(c.Do("HGETALL", APIKEYS_KEY))
+		if err != nil {
+			return err
+		}
+		keys = make([]APIKey, 0, len(m))
+		for id, v := range m {
+			var k APIKey
+			if err := json.Unmarshal([]byte(v), &k); err != nil {
+				return fmt.Errorf("API key '%s': %v", id, err)
+			}
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	return keys, err
+}
+
+func (s *RedisStore) APIKey(id string) (APIKey, error) {
+	var k APIKey
+	err := s.do(func(c redis.Conn) error {
+		v, err := redis.Bytes(c.Do("HGET", APIKEYS_KEY, id))
+		if err == redis.ErrNil {
+			return fmt.Errorf("API key '%s': %w", id, ErrNoAPIKey)
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(v, &k)
+	})
+	return k, err
+}
+
+func (s *RedisStore) SaveAPIKey(k APIKey) error {
+	v, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return s.do(func(c redis.Conn) error {
+		_, err := c.Do("HSET", APIKEYS_KEY, k.ID, v)
+		return err
+	})
+}
+
+// Subscriptions returns the subscriptions held in the service's hash of them
+func (s *RedisStore) Subscriptions(service string) ([]Subscription, error) {
+	var subs []Subscription
+	err := s.do(func(c redis.Conn) error {
+		m, err := redis.StringMap(c.Do("HGETALL", SubscriptionsKey(service)))
+		if err != nil {
+			return err
+		}
+		subs = make([]Subscription, 0, len(m))
+		for chat, v := range m {
+			var sub Subscription
+			if err := json.Unmarshal([]byte(v), &sub); err != nil {
+				return fmt.Errorf("subscription of chat '%s': %v", chat, err)
+			}
+			subs = append(subs, sub)
+		}
+		return nil
+	})
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Created.Before(subs[j].Created) })
+	return subs, err
+}
+
+func (s *RedisStore) SaveSubscription(service string, sub Subscription) error {
+	v, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	return s.do(func(c redis.Conn) error {
+		_, err := c.Do("HSET", SubscriptionsKey(service), sub.Chat, v)
+		return err
+	})
+}
+
+func (s *RedisStore) RemoveSubscription(service, chat string) error {
+	return s.do(func(c redis.Conn) error {
+		n, err := redis.Int(c.Do("HDEL", SubscriptionsKey(service), chat))
+		if err == nil && n == 0 {
+			err = fmt.Errorf("chat '%s': %w", chat, ErrNoSubscription)
+		}
+		return err
+	})
+}
+
+func (s *RedisStore) SaveProvenance(dataset string, p Provenance) error {
+	v, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.do(func(c redis.Conn) error {
+		_, err := c.Do("SET", ProvenanceKey(dataset), v)
+		return err
+	})
+}
+
+func (s *RedisStore) Provenance(dataset string) (Provenance, error) {
+	var p Provenance
+	err := s.do(func(c redis.Conn) error {
+		v, err := redis.Bytes(c.Do("GET", ProvenanceKey(dataset)))
+		if err == redis.ErrNil {
+			return fmt.Errorf("dataset '%s': %w", dataset, ErrNoProvenance)
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(v, &p)
+	})
+	return p, err
+}
+
+// Generation returns the count held in 'outlived:generation'
+func (s *RedisStore) Generation() (int64, error) {
+	var n int64
+	err := s.do(func(c redis.Conn) error {
+		var err error
+		n, err = redis.Int64(c.Do("GET", GENERATION_KEY))
+		if err == redis.ErrNil {
+			err = nil
+		}
+		return err
+	})
+	return n, err
+}
+
+// CachedResponse returns the value cached under the key by CacheResponse, or nil if there is
+// none or it has expired
+func (s *RedisStore) CachedResponse(key string) ([]byte, error) {
+	var v []byte
+	err := s.do(func(c redis.Conn) error {
+		var err error
+		v, err = redis.Bytes(c.Do("GET", CACHE_KEY_PREFIX+key))
+		if err == redis.ErrNil {
+			err = nil
+		}
+		return err
+	})
+	return v, err
+}
+
+// CacheResponse caches the value under the key for the given time
+func (s *RedisStore) CacheResponse(key string, value []byte, ttl time.Duration) error {
+	return s.do(func(c redis.Conn) error {
+		_, err := c.Do("SET", CACHE_KEY_PREFIX+key, value, "PX", ttl.Milliseconds())
+		return err
+	})
+}
+
+// Claim sets the key under 'outlived:claims:' unless it is already set, to expire after ttl
+func (s *RedisStore) Claim(key string, ttl time.Duration) (bool, error) {
+	var claimed bool
+	err := s.do(func(c redis.Conn) error {
+		_, err := redis.String(c.Do("SET", CLAIMS_KEY_PREFIX+key, 1, "NX", "PX", ttl.Milliseconds()))
+		if err == redis.ErrNil {
+			return nil // claimed already
+		}
+		claimed = err == nil
+		return err
+	})
+	return claimed, err
+}
+
+func (s *RedisStore) Close() error {
+	return s.pool.Close()
+}
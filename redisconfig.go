@@ -0,0 +1,224 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Environment variables which can be used in place of the equivalent command line flags
+const (
+	ENV_REDIS_ADDR      = "OUTLIVED_REDIS_ADDR"
+	ENV_REDIS_DB        = "OUTLIVED_REDIS_DB"
+	ENV_REDIS_PASSWORD  = "OUTLIVED_REDIS_PASSWORD"
+	ENV_REDIS_TLS       = "OUTLIVED_REDIS_TLS"
+	ENV_REDIS_MASTER    = "OUTLIVED_REDIS_MASTER"
+	ENV_REDIS_SENTINELS = "OUTLIVED_REDIS_SENTINELS"
+
+	ENV_REDIS_POOL_SIZE     = "OUTLIVED_REDIS_POOL_SIZE"
+	ENV_REDIS_RETRIES       = "OUTLIVED_REDIS_RETRIES"
+	ENV_REDIS_RETRY_BACKOFF = "OUTLIVED_REDIS_RETRY_BACKOFF"
+)
+
+// RedisConfig holds the details needed to connect to a Redis instance.
+//
+// If MasterName is set, the address of the master is looked up from the Sentinels in
+// SentinelAddrs each time a connection is made, and Addr is ignored. Otherwise Addr is
+// dialled directly, and if it turns out to be a member of a Redis Cluster the connection
+// routes each command to the node serving its key.
+//
+// Stores keep a pool of up to PoolSize connections. Commands which fail for a reason that may
+// pass, such as Redis restarting, are retried up to Retries times on a new connection, waiting
+// RetryBackoff before the first retry and twice as long before each one after. The zero values
+// select the DEFAULT_REDIS_* defaults, and a negative Retries turns retrying off.
+type RedisConfig struct {
+	Addr          string
+	DB            int
+	Password      string
+	TLS           bool
+	MasterName    string
+	SentinelAddrs []string
+
+	PoolSize     int
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// RedisConfigFromEnv returns the default configuration, overridden by any of the
+// OUTLIVED_REDIS_* environment variables which are set
+func RedisConfigFromEnv() RedisConfig {
+	return RedisConfig{Addr: DB_ADDR}.WithEnv()
+}
+
+// WithEnv returns a copy of the configuration overridden by any of the OUTLIVED_REDIS_*
+// environment variables which are set
+func (c RedisConfig) WithEnv() RedisConfig {
+	if v := os.Getenv(ENV_REDIS_ADDR); v != "" {
+		c.Addr = v
+	}
+	if v, err := strconv.Atoi(os.Getenv(ENV_REDIS_DB)); err == nil {
+		c.DB = v
+	}
+	if v := os.Getenv(ENV_REDIS_PASSWORD); v != "" {
+		c.Password = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv(ENV_REDIS_TLS)); err == nil {
+		c.TLS = v
+	}
+	if v := os.Getenv(ENV_REDIS_MASTER); v != "" {
+		c.MasterName = v
+	}
+	if v := SplitList(os.Getenv(ENV_REDIS_SENTINELS)); len(v) > 0 {
+		c.SentinelAddrs = v
+	}
+	if v, err := strconv.Atoi(os.Getenv(ENV_REDIS_POOL_SIZE)); err == nil {
+		c.PoolSize = v
+	}
+	if v, err := strconv.Atoi(os.Getenv(ENV_REDIS_RETRIES)); err == nil {
+		c.Retries = v
+	}
+	if v, err := time.ParseDuration(os.Getenv(ENV_REDIS_RETRY_BACKOFF)); err == nil {
+		c.RetryBackoff = v
+	}
+	return c
+}
+
+// WithURL returns a copy of the configuration connecting to the instance given by a URL of the
+// form 'redis://[:PASSWORD@]HOST[:PORT][/DB]', or 'rediss://...' to connect over TLS, keeping
+// its other settings
+func (c RedisConfig) WithURL(raw string) (RedisConfig, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return c, err
+	}
+	switch u.Scheme {
+	case "redis":
+		c.TLS = false
+	case "rediss":
+		c.TLS = true
+	default:
+		return c, fmt.Errorf("'%s' is not a redis:// or rediss:// URL", raw)
+	}
+	if u.Host == "" {
+		return c, fmt.Errorf("'%s' names no host", raw)
+	}
+	c.Addr, c.MasterName, c.SentinelAddrs = u.Host, "", nil
+	if u.Port() == "" {
+		c.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.DB, err = strconv.Atoi(db); err != nil {
+			return c, fmt.Errorf("'%s' names an invalid database '%s'", raw, db)
+		}
+	}
+	if password, ok := u.User.Password(); ok {
+		c.Password = password
+	}
+	return c, nil
+}
+
+// SplitList splits a comma separated list, dropping any empty entries
+func SplitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Dial opens a new connection to Redis using the configuration, resolving the master
+// through Sentinel or detecting a Redis Cluster as required
+func (c RedisConfig) Dial() (redis.Conn, error) {
+	if c.MasterName != "" {
+		return c.dialSentinelMaster()
+	}
+	conn, err := c.dialAddr(c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if isClusterNode(conn) {
+		return newClusterConn(c, conn)
+	}
+	return conn, nil
+}
+
+// dial a single Redis server, with no topology handling
+func (c RedisConfig) dialAddr(addr string) (redis.Conn, error) {
+	opts := []redis.DialOption{
+		redis.DialDatabase(c.DB),
+		redis.DialUseTLS(c.TLS),
+	}
+	if c.Password != "" {
+		opts = append(opts, redis.DialPassword(c.Password))
+	}
+	return redis.Dial("tcp", addr, opts...)
+}
+
+// ask each Sentinel in turn for the current master, then check that it really is the master,
+// as it may not be during a failover
+func (c RedisConfig) dialSentinelMaster() (redis.Conn, error) {
+	if len(c.SentinelAddrs) == 0 {
+		return nil, errors.New("sentinel: no sentinel addresses configured")
+	}
+	var lastErr error
+	for _, sentinel := range c.SentinelAddrs {
+		addr, err := c.sentinelMasterAddr(sentinel)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		conn, err := c.dialAddr(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		role, err := redis.Values(conn.Do("ROLE"))
+		if err == nil && len(role) > 0 {
+			if r, _ := redis.String(role[0], nil); r == "master" {
+				return conn, nil
+			}
+			err = fmt.Errorf("%s is not a master", addr)
+		}
+		conn.Close()
+		lastErr = err
+	}
+	return nil, fmt.Errorf("sentinel: unable to connect to master '%s': %v", c.MasterName, lastErr)
+}
+
+func (c RedisConfig) sentinelMasterAddr(sentinel string) (string, error) {
+	conn, err := redis.Dial("tcp", sentinel, redis.DialUseTLS(c.TLS))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	res, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", c.MasterName))
+	if err == redis.ErrNil {
+		return "", fmt.Errorf("%s does not know master '%s'", sentinel, c.MasterName)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(res) != 2 {
+		return "", fmt.Errorf("%s returned an unexpected master address: %v", sentinel, res)
+	}
+	return net.JoinHostPort(res[0], res[1]), nil
+}
+
+// isClusterNode reports whether the server has cluster mode enabled. Servers which
+// disallow the INFO command are assumed to be standalone.
+func isClusterNode(conn redis.Conn) bool {
+	info, err := redis.String(conn.Do("INFO", "cluster"))
+	return err == nil && strings.Contains(info, "cluster_enabled:1")
+}
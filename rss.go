@@ -0,0 +1,66 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+type rssDoc struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	LastBuild   string    `xml:"lastBuildDate"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Description string  `xml:"description"`
+	PubDate     string  `xml:"pubDate"`
+	GUID        rssGUID `xml:"guid"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// WriteRSS writes the milestones of someone born on birthDate as an RSS 2.0 feed, with an item
+// for each person outlived, published on the date they were. link is the URL of the feed.
+func WriteRSS(w io.Writer, birthDate string, ms []Milestone, link string, now time.Time) error {
+	doc := rssDoc{Version: "2.0", Channel: rssChannel{
+		Title:       "Outlived milestones for " + birthDate,
+		Link:        link,
+		Description: "The people someone born on " + birthDate + " has outlived",
+		LastBuild:   now.UTC().Format(time.RFC1123Z),
+	}}
+	for _, m := range ms {
+		n := Notification{BirthDate: birthDate, Milestone: m}
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       "Outlived " + m.Name,
+			Description: fmt.Sprintf("%s. %s was born on %s and died on %s.", n.Text(), m.Name, m.BirthDate, m.DeathDate),
+			PubDate:     m.Date.Format(time.RFC1123Z),
+			GUID:        rssGUID{Value: fmt.Sprintf("outlived:%s:%s:%s", birthDate, m.Dataset, m.ID())},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,107 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// SCHEMA_VERSION is the version of the layout in which RedisStore holds a dataset, recorded
+// under its SchemaKey as it is imported: a hash of details for each person, the sorted sets of
+// the dead and the living, and the indexes by name, day, year of death and of birth, and
+// occupation
+const SCHEMA_VERSION = 4
+
+// SCHEMA_LEGACY is the version taken by datasets with no version recorded, imported by earlier
+// versions. Their sorted sets may hold 'name,dob,dod' members with no hash of details, and they
+// may lack the sets added since.
+const SCHEMA_LEGACY = 1
+
+// SchemaKey returns the key of the string holding the version of the layout in which the
+// dataset is held, e.g. 'outlived:{actors}:schema'
+func SchemaKey(dataset string) string {
+	return DatasetKey(dataset) + ":schema"
+}
+
+// SchemaVersion returns the version of the layout in which the dataset is held, SCHEMA_LEGACY
+// if none is recorded, or 0 if the dataset holds nobody
+func (s *RedisStore) SchemaVersion(dataset string) (int, error) {
+	var version int
+	err := s.do(func(c redis.Conn) error {
+		var err error
+		version, err = redis.Int(c.Do("GET", SchemaKey(dataset)))
+		if err != redis.ErrNil {
+			return err
+		}
+		n, err := datasetSize(c, dataset)
+		if version = 0; n > 0 {
+			version = SCHEMA_LEGACY
+		}
+		return err
+	})
+	return version, err
+}
+
+// datasetSize returns the number of people in the dataset, dead or living
+func datasetSize(c redis.Conn, dataset string) (int, error) {
+	c.Send("ZCARD", DatasetKey(dataset))
+	c.Send("ZCARD", LivingKey(dataset))
+	counts, err := redis.Ints(c.Do(""))
+	if err != nil {
+		return 0, err
+	}
+	return counts[0] + counts[1], nil
+}
+
+// isNewDataset reports whether the dataset holds nobody and has no version recorded, so that
+// what is written to it will be in the current layout
+func isNewDataset(c redis.Conn, dataset string) (bool, error) {
+	exists, err := redis.Bool(c.Do("EXISTS", SchemaKey(dataset)))
+	if err != nil || exists {
+		return false, err
+	}
+	n, err := datasetSize(c, dataset)
+	return n == 0, err
+}
+
+// Migrate upgrades the dataset to SCHEMA_VERSION by reading everyone in it and importing them
+// again, which replaces the old sets only once the new are written, so that should it fail
+// the dataset is left as it was. Progress, if not nil, is called as batches are written. It
+// must not run alongside imports to the dataset, whose changes it may undo.
+func (s *RedisStore) Migrate(dataset string, progress func(written, total int)) (MigrateStats, error) {
+	version, err := s.SchemaVersion(dataset)
+	if err != nil {
+		return MigrateStats{}, err
+	}
+	stats := MigrateStats{From: version}
+	switch {
+	case version == 0:
+		return stats, fmt.Errorf("dataset '%s' holds nobody", dataset)
+	case version > SCHEMA_VERSION:
+		return stats, fmt.Errorf("dataset '%s' is held in layout version %d, newer than this version of outlived knows (%d)", dataset, version, SCHEMA_VERSION)
+	case version == SCHEMA_VERSION:
+		return stats, nil
+	}
+	err = s.do(func(c redis.Conn) error {
+		ids, err := datasetIDs(c, dataset)
+		stats.Legacy = 0
+		for id := range ids {
+			if strings.Contains(id, ",") {
+				stats.Legacy++
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return stats, err
+	}
+	people, err := AllPeople(s, dataset)
+	if err != nil {
+		return stats, err
+	}
+	stats.People = len(people)
+	return stats, s.ImportBatched(dataset, people, BatchOptions{Progress: progress})
+}
@@ -0,0 +1,354 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// SEARCH_PAGE is the number of documents fetched by each FT.SEARCH
+const SEARCH_PAGE = 1000
+
+// SearchIndex returns the name of the RediSearch index over the person hashes of the dataset,
+// e.g. 'outlived:{actors}:idx'
+func SearchIndex(dataset string) string {
+	return DatasetKey(dataset) + ":idx"
+}
+
+// detectSearch reports whether the RediSearch module is loaded, by trying one of its commands.
+// Search is not used with Redis Cluster, where each node would index only its own slots.
+func detectSearch(c redis.Conn) bool {
+	if _, ok := c.(*clusterConn); ok {
+		return false
+	}
+	_, err := c.Do("FT._LIST")
+	return err == nil
+}
+
+// createIndex creates the dataset's search index unless it already exists. It is created
+// before the dataset is written, so that every hash is indexed as it is stored rather than
+// by a background scan.
+func (s *RedisStore) createIndex(c redis.Conn, dataset string) error {
+	if !s.search {
+		return nil
+	}
+	if _, err := c.Do("FT.INFO", SearchIndex(dataset)); err == nil {
+		s.indexed[dataset] = true
+		return nil
+	}
+	_, err := c.Do("FT.CREATE", SearchIndex(dataset), "ON", "HASH",
+		"PREFIX", 1, PersonKey(dataset, ""), "STOPWORDS", 0, "SCHEMA",
+		"name_key", "TEXT", "NOSTEM",
+		"age_days", "NUMERIC", "SORTABLE",
+		"occupation", "TAG", "SEPARATOR", ";",
+		"nationality", "TAG", "SEPARATOR", ";",
+		"genre", "TAG", "SEPARATOR", ";")
+	if err != nil {
+		return fmt.Errorf("%s: %v", SearchIndex(dataset), err)
+	}
+	s.indexed[dataset] = true
+	return nil
+}
+
+// hasIndex reports whether the dataset can be searched. Datasets imported by earlier
+// versions, or before the module was loaded, have no index until they are imported again,
+// since their hashes lack the indexed fields.
+func (s *RedisStore) hasIndex(dataset string) bool {
+	if !s.search {
+		return false
+	}
+	if indexed, ok := s.indexed[dataset]; ok {
+		return indexed
+	}
+	err := s.do(func(c redis.Conn) error {
+		_, err := c.Do("FT.INFO", SearchIndex(dataset))
+		return err
+	})
+	if err != nil && s.ctx.Err() != nil {
+		return false // not known, so asked again next time
+	}
+	s.indexed[dataset] = err == nil
+	return err == nil
+}
+
+// QueryFiltered returns the records in the dataset whose age at death lies within [min, max]
+// and which match the filter, ordered by age. With an index the fields of the filter are
+// applied by FT.SEARCH, and otherwise to every record in the range as it is read, of which
+// only those in the set indexing the tag or the occupation, if the filter has one, are read, or
+// failing that only those in the years the filter selects (see queryByYears). As the index
+// holds no tags, a filter with one is always read from its set. The years and the cause of
+// death are applied as records are read in either case.
+func (s *RedisStore) QueryFiltered(dataset string, min, max int, f Filter) ([]Result, error) {
+	if !s.hasIndex(dataset) || f.Tag != "" {
+		if f.Occupation == "" && f.Tag == "" && f.hasYears() {
+			if found, ok, err := s.queryByYears(dataset, min, max, f); ok || err != nil {
+				return FilterResults(found, f), err
+			}
+		}
+		within := ""
+		switch {
+		case f.Tag != "":
+			within = TagKey(dataset, f.Tag)
+		case f.Occupation != "":
+			within = OccupationKey(dataset, f.Occupation)
+		}
+		found, err := s.queryWithin(dataset, min, max, 0, 0, within, false)
+		return FilterResults(found, f), err
+	}
+	var results []Result
+	err := s.searchAll(dataset, ageQuery(min, max)+tagQuery(f), func(rec Person) error {
+		age, err := rec.AgeInDays()
+		if err != nil {
+			return err
+		}
+		results = append(results, Result{Person: rec, Days: age})
+		return nil
+	}, "SORTBY", "age_days", "ASC")
+	return FilterResults(results, f), err
+}
+
+// queryByYears reads the records in the dataset whose age at death lies within [min, max],
+// ordered by age, of only those whose years of death and birth the filter selects, read from
+// the sorted sets by year of death and of birth. It reports false if the dataset is held in a
+// layout lacking the sets it needs.
+func (s *RedisStore) queryByYears(dataset string, min, max int, f Filter) ([]Result, bool, error) {
+	version, err := s.SchemaVersion(dataset)
+	if err != nil {
+		return nil, false, err
+	}
+	died, born := f.DiedAfter != nil || f.DiedBefore != nil, f.BornFrom != nil || f.BornTo != nil
+	if (died && version < INDEXED_SCHEMA_VERSION) || (born && version < BIRTH_YEARS_SCHEMA_VERSION) {
+		return nil, false, nil
+	}
+	var results []Result
+	err = s.do(func(c redis.Conn) error {
+		var ids []string
+		if died {
+			// the years of death are bounded by DiedBefore exclusively
+			lo, hi := yearBound(f.DiedAfter, "-inf"), yearBound(f.DiedBefore, "+inf")
+			if f.DiedBefore != nil {
+				hi = "(" + hi
+			}
+			if ids, err = redis.Strings(c.Do("ZRANGEBYSCORE", DeathYearsKey(dataset), lo, hi)); err != nil {
+				return err
+			}
+		}
+		if born {
+			bornIDs, err := redis.Strings(c.Do("ZRANGEBYSCORE", BirthYearsKey(dataset), yearBound(f.BornFrom, "-inf"), yearBound(f.BornTo, "+inf")))
+			if err != nil {
+				return err
+			}
+			if died {
+				bornIDs = intersect(ids, bornIDs)
+			}
+			ids = bornIDs
+		}
+		people, err := hydrate(c, dataset, ids)
+		if err != nil {
+			return err
+		}
+		results = make([]Result, 0, len(ids))
+		for _, rec := range people {
+			if rec == nil || rec.Living() {
+				continue // removed by an import since, or living and so unscored
+			}
+			age, err := rec.AgeInDays()
+			if err != nil {
+				return err
+			}
+			if age >= min && age <= max {
+				results = append(results, Result{Person: *rec, Days: age})
+			}
+		}
+		return nil
+	})
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Days < results[j].Days })
+	return results, true, err
+}
+
+// yearBound formats a year as a bound of ZRANGEBYSCORE, or gives none if it is nil
+func yearBound(year *int, none string) string {
+	if year == nil {
+		return none
+	}
+	return strconv.Itoa(*year)
+}
+
+// intersect returns the IDs of a which are also in b, in the order of b
+func intersect(a, b []string) []string {
+	in := make(map[string]bool, len(a))
+	for _, id := range a {
+		in[id] = true
+	}
+	both := b[:0]
+	for _, id := range b {
+		if in[id] {
+			both = append(both, id)
+		}
+	}
+	return both
+}
+
+// CountFiltered returns the number of records in the dataset whose age at death lies within
+// [min, max] and which match the filter
+func (s *RedisStore) CountFiltered(dataset string, min, max int, f Filter) (int, error) {
+	if !s.hasIndex(dataset) || !f.searchable() {
+		found, err := s.QueryFiltered(dataset, min, max, f)
+		return len(found), err
+	}
+	var n int
+	err := s.do(func(c redis.Conn) error {
+		values, err := redis.Values(c.Do("FT.SEARCH", SearchIndex(dataset), ageQuery(min, max)+tagQuery(f), "LIMIT", 0, 0))
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("%s: empty search reply", SearchIndex(dataset))
+		}
+		n, err = redis.Int(values[0], nil)
+		return err
+	})
+	return n, err
+}
+
+// SearchNames returns the people in the dataset, living people included, whose folded names
+// may match the folded query as the mode allows. Each word of the query is searched for
+// within the words of the names, and for fuzzy matching also as a term within a few typos, so
+// that more may be returned than match but never fewer. Phonetic matching, and queries
+// RediSearch cannot express, fall back to FindByName.
+func (s *RedisStore) SearchNames(dataset, query, mode string) ([]Person, error) {
+	q, ok := nameQuery(query, mode)
+	if !ok || !s.hasIndex(dataset) {
+		return s.FindByName(dataset, func(name string) bool {
+			_, ok := matchName(name, query, mode)
+			return ok
+		})
+	}
+	var found []Person
+	err := s.searchAll(dataset, q, func(rec Person) error {
+		found = append(found, rec)
+		return nil
+	})
+	return found, err
+}
+
+// searchAll runs the search a page at a time, calling fn with each person found
+func (s *RedisStore) searchAll(dataset, query string, fn func(rec Person) error, args ...interface{}) error {
+	index := SearchIndex(dataset)
+	for offset := 0; ; offset += SEARCH_PAGE {
+		var total int
+		var docs []map[string]string
+		err := s.do(func(c redis.Conn) error {
+			cmd := append([]interface{}{index, query}, args...)
+			values, err := redis.Values(c.Do("FT.SEARCH", append(cmd, "LIMIT", offset, SEARCH_PAGE)...))
+			if err != nil {
+				return err
+			}
+			total, docs, err = parseSearch(values)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", index, err)
+		}
+		for _, fields := range docs {
+			if err := fn(personFromHash(fields)); err != nil {
+				return err
+			}
+		}
+		if len(docs) == 0 || offset+SEARCH_PAGE >= total {
+			return nil
+		}
+	}
+}
+
+// parseSearch parses an FT.SEARCH reply, the total number of matches followed by the key and
+// the fields of each document in the page
+func parseSearch(values []interface{}) (int, []map[string]string, error) {
+	if len(values) == 0 {
+		return 0, nil, fmt.Errorf("empty search reply")
+	}
+	total, err := redis.Int(values[0], nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	var docs []map[string]string
+	for rest := values[1:]; len(rest) >= 2; rest = rest[2:] {
+		fields, err := redis.StringMap(rest[1], nil)
+		if err != nil {
+			return 0, nil, err
+		}
+		docs = append(docs, fields)
+	}
+	return total, docs, nil
+}
+
+// ageQuery returns the search query for ages at death within [min, max]
+func ageQuery(min, max int) string {
+	return fmt.Sprintf("@age_days:[%d %d]", min, max)
+}
+
+// tagQuery returns the search query clauses for the filter
+func tagQuery(f Filter) string {
+	var b strings.Builder
+	for _, t := range []struct{ field, value string }{
+		{"occupation", f.Occupation},
+		{"nationality", f.Nationality},
+		{"genre", f.Genre},
+	} {
+		if t.value != "" {
+			fmt.Fprintf(&b, " @%s:{%s}", t.field, escapeTag(strings.TrimSpace(t.value)))
+		}
+	}
+	return b.String()
+}
+
+// escapeTag escapes the punctuation and spaces which separate tags in a search query
+func escapeTag(s string) string {
+	var b strings.Builder
+	for _, ch := range s {
+		if !isQueryChar(ch) && ch < 0x80 {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+func isQueryChar(ch rune) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_'
+}
+
+// nameQuery returns the search query for names which may match the folded query, or false
+// if it cannot be expressed as one: for phonetic matching, or for words which are too short
+// to search within or which hold punctuation.
+func nameQuery(query, mode string) (string, bool) {
+	if mode == MATCH_PHONETIC {
+		return "", false
+	}
+	var terms []string
+	for _, w := range strings.Fields(query) {
+		for _, ch := range w {
+			if !isQueryChar(ch) {
+				return "", false
+			}
+		}
+		if len(w) < 2 || maxTypos(w) > 3 { // fuzzy terms allow at most three typos
+			return "", false
+		}
+		term := "*" + w + "*"
+		if n := maxTypos(w); mode == MATCH_FUZZY && n > 0 {
+			pct := strings.Repeat("%", n)
+			term = "(" + term + "|" + pct + w + pct + ")"
+		}
+		terms = append(terms, term)
+	}
+	if len(terms) == 0 {
+		return "", false
+	}
+	return "@name_key:(" + strings.Join(terms, " ") + ")", true
+}
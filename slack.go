@@ -0,0 +1,138 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSlackSignature is returned for a request which was not signed by Slack with the app's
+// signing secret, or was signed too long ago to be trusted
+var ErrSlackSignature = errors.New("invalid Slack request signature")
+
+// SLACK_MAX_AGE is how old the timestamp of a signed request may be, beyond which it may be a
+// replay, as Slack's guidance suggests
+const SLACK_MAX_AGE = 5 * time.Minute
+
+// Response types of a slash command, seen either by the one who sent it or by the channel
+const (
+	SLACK_EPHEMERAL  = "ephemeral"
+	SLACK_IN_CHANNEL = "in_channel"
+)
+
+// VerifySlackRequest checks the signature of a request from Slack, made over its timestamp and
+// raw body with the app's signing secret (the 'v0' scheme), and that it was signed within
+// SLACK_MAX_AGE of now
+func VerifySlackRequest(secret string, header http.Header, body []byte, now time.Time) error {
+	ts := header.Get("X-Slack-Request-Timestamp")
+	sent, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrSlackSignature
+	}
+	if age := now.Sub(time.Unix(sent, 0)); age > SLACK_MAX_AGE || age < -SLACK_MAX_AGE {
+		return ErrSlackSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", ts)
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(want), []byte(header.Get("X-Slack-Signature"))) {
+		return ErrSlackSignature
+	}
+	return nil
+}
+
+// SlackMessage is a message in Slack's Block Kit, whose text is shown where the blocks cannot
+// be, such as in notifications
+type SlackMessage struct {
+	ResponseType string       `json:"response_type,omitempty"` // SLACK_EPHEMERAL or SLACK_IN_CHANNEL
+	Text         string       `json:"text"`
+	Blocks       []SlackBlock `json:"blocks,omitempty"`
+}
+
+// SlackBlock is a block of a message: a 'section', 'context' or 'divider'
+type SlackBlock struct {
+	Type      string        `json:"type"`
+	Text      *SlackText    `json:"text,omitempty"`
+	Fields    []SlackText   `json:"fields,omitempty"`   // of a section, shown in two columns
+	Elements  []SlackText   `json:"elements,omitempty"` // of a context
+	Accessory *SlackElement `json:"accessory,omitempty"`
+}
+
+// SlackText is a text object, of type 'mrkdwn' or 'plain_text'
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// SlackElement is an image shown beside the text of a section
+type SlackElement struct {
+	Type     string `json:"type"` // 'image'
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+// mrkdwn returns a text object of Slack's markup
+func mrkdwn(format string, args ...interface{}) SlackText {
+	return SlackText{Type: "mrkdwn", Text: fmt.Sprintf(format, args...)}
+}
+
+// slackEscaper escapes the characters which Slack's markup reserves for links and mentions
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackName returns the person's name escaped for Slack's markup, linked to their article if
+// they have one
+func slackName(rec Person) string {
+	name := slackEscaper.Replace(rec.Name)
+	if rec.URL != "" {
+		return "<" + rec.URL + "|" + name + ">"
+	}
+	return name
+}
+
+// SlackBlocks returns the overview as a message in Block Kit
+func (o Overview) SlackBlocks() SlackMessage {
+	msg := SlackMessage{Text: o.Text()}
+	head := mrkdwn("%s", o.Headline())
+	msg.Blocks = append(msg.Blocks, SlackBlock{Type: "section", Text: &head})
+	var fields []SlackText
+	if o.Last != nil {
+		fields = append(fields, mrkdwn("*Last outlived*\n%s", o.Last.describe(slackName(o.Last.Person))))
+	}
+	if o.Next != nil {
+		fields = append(fields, mrkdwn("*Next to outlive*\n%s", o.Next.describe(slackName(o.Next.Person))))
+	}
+	if len(fields) > 0 {
+		msg.Blocks = append(msg.Blocks, SlackBlock{Type: "section", Fields: fields})
+	}
+	msg.Blocks = append(msg.Blocks, SlackBlock{Type: "context", Elements: []SlackText{mrkdwn("Born %s", o.BirthDate)}})
+	return msg
+}
+
+// SlackBlocks returns the notification as a message in Block Kit, with the person's image
+// beside it if they have one
+func (n Notification) SlackBlocks() SlackMessage {
+	who := "You have"
+	if n.Profile != "" {
+		who = "*" + slackEscaper.Replace(n.Profile) + "* has"
+	}
+	text := mrkdwn("%s outlived *%s*, who died aged %s", who, slackName(n.Person), Unpadded(n.FormatAge()))
+	section := SlackBlock{Type: "section", Text: &text}
+	if n.ImageURL != "" {
+		section.Accessory = &SlackElement{Type: "image", ImageURL: n.ImageURL, AltText: n.Name}
+	}
+	return SlackMessage{Text: n.Text(), Blocks: []SlackBlock{section}}
+}
+
+// PostSlackMessage posts the message to a Slack incoming webhook
+func PostSlackMessage(webhookURL string, msg SlackMessage) error {
+	return postJSON(webhookURL, nil, msg)
+}
@@ -0,0 +1,133 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// SNAPSHOT_FORMAT names the format of the files written by WriteSnapshot, so that other files
+// are not mistaken for them
+const SNAPSHOT_FORMAT = "outlived-snapshot"
+
+// SNAPSHOT_VERSION is the version of the snapshot format written, which ReadSnapshot reads
+// along with any earlier one
+const SNAPSHOT_VERSION = 1
+
+// Snapshot is a copy of whole datasets, independent of the store which held them, written to a
+// file by WriteSnapshot as gzipped JSON
+type Snapshot struct {
+	Format   string            `json:"format"`
+	Version  int               `json:"version"`
+	Created  time.Time         `json:"created"`
+	Datasets []DatasetSnapshot `json:"datasets"`
+}
+
+// DatasetSnapshot is everyone in a dataset, those who have died followed by the living, with
+// the counts and checksum by which a restored copy is checked
+type DatasetSnapshot struct {
+	Name     string   `json:"name"`
+	Count    int      `json:"count"` // the number of people who have died
+	Living   int      `json:"living"`
+	Checksum string   `json:"checksum"` // see DatasetChecksum
+	People   []Person `json:"people"`
+
+	Provenance *Provenance `json:"provenance,omitempty"` // of its latest import, if recorded
+}
+
+// TakeSnapshot copies the datasets out of the store, along with their provenance
+func TakeSnapshot(store Store, datasets []string) (Snapshot, error) {
+	snap := Snapshot{Format: SNAPSHOT_FORMAT, Version: SNAPSHOT_VERSION, Created: time.Now().UTC()}
+	for _, name := range datasets {
+		people, err := AllPeople(store, name)
+		if err != nil {
+			return Snapshot{}, fmt.Errorf("dataset '%s': %v", name, err)
+		}
+		if len(people) == 0 {
+			return Snapshot{}, fmt.Errorf("dataset '%s' holds nobody", name)
+		}
+		_, living := SplitLiving(people)
+		ds := DatasetSnapshot{
+			Name:     name,
+			Count:    len(people) - len(living),
+			Living:   len(living),
+			Checksum: DatasetChecksum(people),
+			People:   people,
+		}
+		if ps, ok := store.(ProvenanceStore); ok {
+			p, err := ps.Provenance(name)
+			if err != nil && !errors.Is(err, ErrNoProvenance) {
+				return Snapshot{}, err
+			}
+			if err == nil {
+				ds.Provenance = &p
+			}
+		}
+		snap.Datasets = append(snap.Datasets, ds)
+	}
+	return snap, nil
+}
+
+// DatasetChecksum returns a digest of everyone's details, whatever their order, by which two
+// copies of a dataset can be compared, e.g. 'sha256:9f86d081...'
+func DatasetChecksum(people []Person) string {
+	sorted := make([]Person, len(people))
+	copy(sorted, people)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key() < sorted[j].Key() })
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	for _, rec := range sorted {
+		enc.Encode(rec)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// WriteSnapshot writes the snapshot as gzipped JSON
+func WriteSnapshot(w io.Writer, snap Snapshot) error {
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(snap); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot, checking each dataset against its
+// counts and checksum so that a damaged file is refused
+func ReadSnapshot(r io.Reader) (Snapshot, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("not a snapshot: %v", err)
+	}
+	defer gz.Close()
+	var snap Snapshot
+	if err := json.NewDecoder(gz).Decode(&snap); err != nil {
+		return Snapshot{}, fmt.Errorf("snapshot: %v", err)
+	}
+	if snap.Format != SNAPSHOT_FORMAT {
+		return Snapshot{}, fmt.Errorf("not a snapshot: format is '%s'", snap.Format)
+	}
+	if snap.Version > SNAPSHOT_VERSION {
+		return Snapshot{}, fmt.Errorf("snapshot: version %d is newer than this version of outlived reads (%d)", snap.Version, SNAPSHOT_VERSION)
+	}
+	for _, ds := range snap.Datasets {
+		if err := ValidateDatasetName(ds.Name); err != nil {
+			return Snapshot{}, fmt.Errorf("snapshot: %v", err)
+		}
+		_, living := SplitLiving(ds.People)
+		if len(ds.People)-len(living) != ds.Count || len(living) != ds.Living {
+			return Snapshot{}, fmt.Errorf("snapshot: dataset '%s' holds %d people, not the %d recorded", ds.Name, len(ds.People), ds.Count+ds.Living)
+		}
+		if sum := DatasetChecksum(ds.People); sum != ds.Checksum {
+			return Snapshot{}, fmt.Errorf("snapshot: dataset '%s' does not match its checksum", ds.Name)
+		}
+	}
+	return snap, nil
+}
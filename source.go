@@ -0,0 +1,114 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// Formats of the source files accepted by ReadFile
+const (
+	FORMAT_CSV   = "csv"
+	FORMAT_JSON  = "json"  // an array of objects
+	FORMAT_JSONL = "jsonl" // one object per line
+	FORMAT_XLSX  = "xlsx"  // an Excel workbook
+)
+
+// ReadFile reads and parses the source file, which may also be given as an HTTP or HTTPS URL,
+// in the format given by opts.Format, and returns its contents as a 'Person' array
+func ReadFile(filename string, opts ReadOptions) ([]Person, Progress, error) {
+	format := opts.Format
+	if format == "" {
+		format = DetectFormat(filename)
+	}
+	var read func(io.Reader, ReadOptions) ([]Person, Progress, error)
+	switch format {
+	case FORMAT_CSV:
+		read = ReadCSV
+	case FORMAT_JSON, FORMAT_JSONL:
+		read = ReadJSON // which accepts either
+	case FORMAT_XLSX:
+		return ReadXLSXFile(filename, opts)
+	default:
+		return nil, Progress{}, fmt.Errorf("import: unknown input format '%s'", format)
+	}
+
+	r, size, err := OpenSource(filename, opts.fetchOptions())
+	if err != nil {
+		return nil, Progress{}, fmt.Errorf("import: %v", err)
+	}
+	defer r.Close()
+
+	if opts.Size == 0 && size > 0 {
+		opts.Size = size
+	}
+	return read(r, opts)
+}
+
+// ReadCSVFile reads and parses the CSV file, which may also be given as an HTTP or HTTPS URL,
+// and returns its contents as a 'Person' array
+func ReadCSVFile(filename string, opts ReadOptions) ([]Person, Progress, error) {
+	opts.Format = FORMAT_CSV
+	return ReadFile(filename, opts)
+}
+
+// DetectFormat works out the format of a file or URL from its extension, ignoring that of any
+// compression, and defaults to CSV
+func DetectFormat(name string) string {
+	if u, err := url.Parse(name); err == nil && IsURL(name) {
+		name = u.Path
+	}
+	name = strings.ToLower(path.Base(name))
+	for _, ext := range []string{".gz", ".zst", ".zip"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	switch path.Ext(name) {
+	case ".json":
+		return FORMAT_JSON
+	case ".jsonl", ".ndjson":
+		return FORMAT_JSONL
+	case ".xlsx", ".xlsm":
+		return FORMAT_XLSX
+	}
+	return FORMAT_CSV
+}
+
+// OpenSource opens a file, or starts downloading it if given as a URL, returning its size if
+// known. Files compressed with gzip or zstd, or in a zip archive, are decompressed.
+func OpenSource(name string, fetch FetchOptions) (io.ReadCloser, int64, error) {
+	r, size, err := openRaw(name, fetch)
+	if err != nil {
+		return nil, 0, err
+	}
+	return decompress(r, size)
+}
+
+// fetchOptions returns the options for downloading a source, abandoned with the read
+func (opts ReadOptions) fetchOptions() FetchOptions {
+	fetch := opts.Fetch
+	if fetch.Context == nil {
+		fetch.Context = opts.Context
+	}
+	return fetch
+}
+
+// openRaw opens a file, or starts downloading it if given as a URL, returning its size if known
+func openRaw(name string, fetch FetchOptions) (io.ReadCloser, int64, error) {
+	if IsURL(name) {
+		return OpenURL(name, fetch)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := int64(-1)
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		size = fi.Size()
+	}
+	return f, size, nil
+}
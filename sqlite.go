@@ -0,0 +1,626 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS people (
+	name       TEXT NOT NULL,
+	birth_date TEXT NOT NULL,
+	death_date TEXT NOT NULL,
+	age_days   INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS generation (
+	n INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS provenance (
+	dataset TEXT PRIMARY KEY,
+	data    TEXT NOT NULL
+);
+`
+
+// counts an import into the generation table, which holds a single row once there has been one
+const sqliteBumpGeneration = `
+INSERT INTO generation (n) SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM generation);
+UPDATE generation SET n = n + 1;
+`
+
+// columns added since the table was first created, which older databases gain on opening
+var sqliteAddedColumns = []struct{ name, definition string }{
+	{"dataset", "TEXT NOT NULL DEFAULT 'musicians'"},
+	{"occupation", "TEXT NOT NULL DEFAULT ''"},
+	{"nationality", "TEXT NOT NULL DEFAULT ''"},
+	{"cause_of_death", "TEXT NOT NULL DEFAULT ''"},
+	{"genre", "TEXT NOT NULL DEFAULT ''"},
+	{"url", "TEXT NOT NULL DEFAULT ''"},
+	{"summary", "TEXT NOT NULL DEFAULT ''"},
+	{"image_url", "TEXT NOT NULL DEFAULT ''"},
+	{"living", "INTEGER NOT NULL DEFAULT 0"}, // 1 for a living person, whose age_days is 0
+	{"name_key", "TEXT NOT NULL DEFAULT ''"}, // the name and aliases folded by NameKey, for searching
+	{"aliases", "TEXT NOT NULL DEFAULT ''"},
+	{"tags", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// the columns holding a Person, in the order used by every query
+const sqlitePersonColumns = "name, birth_date, death_date, occupation, nationality, cause_of_death, genre, url, summary, image_url, aliases, tags"
+
+// applied after any added columns, as databases created before datasets were introduced
+// have to gain the dataset column first
+const sqliteIndexes = `
+DROP INDEX IF EXISTS people_age_days;
+CREATE INDEX IF NOT EXISTS people_dataset_age_days ON people (dataset, age_days);
+CREATE INDEX IF NOT EXISTS people_dataset_death_day ON people (dataset, substr(death_date, -5));
+CREATE INDEX IF NOT EXISTS people_dataset_birth_day ON people (dataset, substr(birth_date, -5));
+`
+
+// SQLiteStore stores records in a SQLite database file, indexed by dataset and age at death
+// in days. Living people are marked as such, and left out of queries by age.
+type SQLiteStore struct {
+	db  *sql.DB
+	ctx context.Context // within which statements are run
+}
+
+// NewSQLiteStore opens (creating if necessary) the SQLite database at the given path
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db, ctx: context.Background()}, nil
+}
+
+// WithContext returns a view of the store which runs its statements within ctx. Should ctx end
+// during an import or upsert, its transaction is rolled back, leaving the dataset as it was.
+func (s *SQLiteStore) WithContext(ctx context.Context) Store {
+	view := *s
+	view.ctx = ctx
+	return &view
+}
+
+func migrateSQLite(db *sql.DB) error {
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	for _, col := range sqliteAddedColumns {
+		exists, err := sqliteHasColumn(db, "people", col.name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if _, err := db.Exec("ALTER TABLE people ADD COLUMN " + col.name + " " + col.definition); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := db.Exec(sqliteIndexes); err != nil {
+		return err
+	}
+	return backfillNameKeys(db)
+}
+
+// backfillNameKeys folds the names of rows stored before names were, which can't be done in SQL
+func backfillNameKeys(db *sql.DB) error {
+	rows, err := db.Query("SELECT rowid, name FROM people WHERE name_key = ''")
+	if err != nil {
+		return err
+	}
+	keys := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		keys[id] = FoldName(name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(keys) == 0 {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+	for id, key := range keys {
+		if _, err := tx.Exec("UPDATE people SET name_key = ? WHERE rowid = ?", key, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func sqliteHasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+const sqliteInsert = "INSERT INTO people (dataset, " + sqlitePersonColumns + ", age_days, living, name_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+func sqliteInsertArgs(dataset string, res Result) []interface{} {
+	return append(append([]interface{}{dataset}, sqlitePersonArgs(res.Person)...), res.Days, res.Living(), NameKey(res.Person))
+}
+
+const sqliteUpdate = `UPDATE people SET name = ?, birth_date = ?, death_date = ?, occupation = ?,
+	nationality = ?, cause_of_death = ?, genre = ?, url = ?, summary = ?, image_url = ?, aliases = ?, tags = ?, age_days = ?,
+	living = ?, name_key = ? WHERE rowid = ?`
+
+// sqliteUpdateArgs returns the arguments of sqliteUpdate replacing the row with the result
+func sqliteUpdateArgs(res Result, id int64) []interface{} {
+	return append(sqlitePersonArgs(res.Person), res.Days, res.Living(), NameKey(res.Person), id)
+}
+
+// sqliteResults computes the age at death of each record, returning living people as results
+// with an age of 0
+func sqliteResults(records []Person) ([]Result, error) {
+	dead, living := SplitLiving(dedupePeople(records))
+	results, err := NewResults(dead)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range living {
+		results = append(results, Result{Person: rec})
+	}
+	return results, nil
+}
+
+// sqlitePersonArgs returns the values of a Person in the order of sqlitePersonColumns
+func sqlitePersonArgs(rec Person) []interface{} {
+	return []interface{}{rec.Name, rec.BirthDate, rec.DeathDate, rec.Occupation, rec.Nationality, rec.CauseOfDeath, rec.Genre,
+		rec.URL, rec.Summary, rec.ImageURL, rec.Aliases, rec.Tags}
+}
+
+// sqlitePersonDest returns scan destinations for a Person in the order of sqlitePersonColumns
+func sqlitePersonDest(rec *Person) []interface{} {
+	return []interface{}{&rec.Name, &rec.BirthDate, &rec.DeathDate, &rec.Occupation, &rec.Nationality, &rec.CauseOfDeath, &rec.Genre,
+		&rec.URL, &rec.Summary, &rec.ImageURL, &rec.Aliases, &rec.Tags}
+}
+
+// Import replaces the dataset's rows with the given records
+func (s *SQLiteStore) Import(dataset string, records []Person) error {
+	if err := ValidateDatasetName(dataset); err != nil {
+		return err
+	}
+	results, err := sqliteResults(records)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	if _, err := tx.ExecContext(s.ctx, "DELETE FROM people WHERE dataset = ?", dataset); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(s.ctx, sqliteInsert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, res := range results {
+		if _, err := stmt.ExecContext(s.ctx, sqliteInsertArgs(dataset, res)...); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(s.ctx, sqliteBumpGeneration); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Upsert merges the records into the dataset, updating the row of any person already present
+func (s *SQLiteStore) Upsert(dataset string, records []Person) (UpsertStats, error) {
+	var stats UpsertStats
+	if err := ValidateDatasetName(dataset); err != nil {
+		return stats, err
+	}
+	results, err := sqliteResults(records)
+	if err != nil {
+		return stats, err
+	}
+
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	// people are matched on Person.Key, which can't be expressed in SQL, so the existing
+	// rows are read back and matched here
+	rows, err := tx.QueryContext(s.ctx, "SELECT rowid, "+sqlitePersonColumns+" FROM people WHERE dataset = ?", dataset)
+	if err != nil {
+		return stats, err
+	}
+	type existingRow struct {
+		id  int64
+		rec Person
+	}
+	existing := make(map[string]existingRow)
+	for rows.Next() {
+		var row existingRow
+		if err := rows.Scan(append([]interface{}{&row.id}, sqlitePersonDest(&row.rec)...)...); err != nil {
+			rows.Close()
+			return stats, err
+		}
+		existing[row.rec.Key()] = row
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	insert, err := tx.PrepareContext(s.ctx, sqliteInsert)
+	if err != nil {
+		return stats, err
+	}
+	defer insert.Close()
+	update, err := tx.PrepareContext(s.ctx, sqliteUpdate)
+	if err != nil {
+		return stats, err
+	}
+	defer update.Close()
+
+	for _, res := range results {
+		row, ok := existing[res.Key()]
+		switch {
+		case !ok:
+			_, err = insert.ExecContext(s.ctx, sqliteInsertArgs(dataset, res)...)
+			stats.Inserted++
+		case row.rec != res.Person:
+			_, err = update.ExecContext(s.ctx, sqliteUpdateArgs(res, row.id)...)
+			stats.Updated++
+		default:
+			stats.Unchanged++
+		}
+		if err != nil {
+			return UpsertStats{}, err
+		}
+	}
+	if _, err := tx.ExecContext(s.ctx, sqliteBumpGeneration); err != nil {
+		return UpsertStats{}, err
+	}
+	return stats, tx.Commit()
+}
+
+// Edit replaces the row of a person in the dataset with the edited one, in a transaction
+func (s *SQLiteStore) Edit(dataset string, old, edited Person) error {
+	if err := ValidateDatasetName(dataset); err != nil {
+		return err
+	}
+	results, err := sqliteResults([]Person{edited})
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	id, current, err := s.findPerson(tx, dataset, old)
+	if err != nil {
+		return err
+	}
+	if id == 0 || current != old {
+		return fmt.Errorf("%w: %s", ErrEditConflict, old)
+	}
+	if edited.Key() != old.Key() {
+		if other, _, err := s.findPerson(tx, dataset, edited); err != nil {
+			return err
+		} else if other != 0 {
+			return fmt.Errorf("%w: dataset '%s' already holds a record for %s", ErrDuplicate, dataset, edited)
+		}
+	}
+	if _, err := tx.ExecContext(s.ctx, sqliteUpdate, sqliteUpdateArgs(results[0], id)...); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(s.ctx, sqliteBumpGeneration); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// findPerson returns the rowid and record of the same person (see Person.Key) as the one
+// given, or a rowid of 0 if the dataset doesn't hold them
+func (s *SQLiteStore) findPerson(tx *sql.Tx, dataset string, rec Person) (int64, Person, error) {
+	rows, err := tx.QueryContext(s.ctx, "SELECT rowid, "+sqlitePersonColumns+" FROM people WHERE dataset = ? AND birth_date = ?", dataset, rec.BirthDate)
+	if err != nil {
+		return 0, Person{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var found Person
+		if err := rows.Scan(append([]interface{}{&id}, sqlitePersonDest(&found)...)...); err != nil {
+			return 0, Person{}, err
+		}
+		if found.Key() == rec.Key() {
+			return id, found, nil
+		}
+	}
+	return 0, Person{}, rows.Err()
+}
+
+// Remove deletes the rows of the same people as those given from the dataset
+func (s *SQLiteStore) Remove(dataset string, people []Person) (int, error) {
+	if err := ValidateDatasetName(dataset); err != nil {
+		return 0, err
+	}
+	gone := make(map[string]bool, len(people))
+	births := make(map[string]bool, len(people))
+	for _, rec := range people {
+		gone[rec.Key()], births[rec.BirthDate] = true, true
+	}
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	// as in Upsert, people are matched on Person.Key here, among those born on the same dates
+	var ids []int64
+	for birth := range births {
+		rows, err := tx.QueryContext(s.ctx, "SELECT rowid, name, birth_date FROM people WHERE dataset = ? AND birth_date = ?", dataset, birth)
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			var id int64
+			var rec Person
+			if err := rows.Scan(&id, &rec.Name, &rec.BirthDate); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			if gone[rec.Key()] {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	for _, id := range ids {
+		if _, err := tx.ExecContext(s.ctx, "DELETE FROM people WHERE rowid = ?", id); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.ExecContext(s.ctx, sqliteBumpGeneration); err != nil {
+		return 0, err
+	}
+	return len(ids), tx.Commit()
+}
+
+// QueryByAgeRange returns all records in the dataset whose age at death in days lies within
+// [min, max], ordered by age
+func (s *SQLiteStore) QueryByAgeRange(dataset string, min, max int) ([]Result, error) {
+	return s.QueryPage(dataset, min, max, 0, 0)
+}
+
+// QueryPage returns up to limit of the records in the dataset whose age at death lies within
+// [min, max], after skipping the first offset, ordered by age
+func (s *SQLiteStore) QueryPage(dataset string, min, max, offset, limit int) ([]Result, error) {
+	if limit <= 0 {
+		limit = -1 // no limit
+	}
+	return s.queryResults(`SELECT `+sqlitePersonColumns+`, age_days FROM people
+		WHERE dataset = ? AND living = 0 AND age_days BETWEEN ? AND ? ORDER BY age_days, rowid
+		LIMIT ? OFFSET ?`, dataset, min, max, limit, offset)
+}
+
+// queryResults runs a query selecting the person columns and age at death of each row
+func (s *SQLiteStore) queryResults(query string, args ...interface{}) ([]Result, error) {
+	rows, err := s.db.QueryContext(s.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []Result
+	for rows.Next() {
+		var res Result
+		if err := rows.Scan(append(sqlitePersonDest(&res.Person), &res.Days)...); err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// QueryNearest reads the n records either side of the age with two queries bounded by LIMIT,
+// one down from the age and one up from it
+func (s *SQLiteStore) QueryNearest(dataset string, age, n int) ([]Result, error) {
+	below, err := s.queryResults(`SELECT `+sqlitePersonColumns+`, age_days FROM people
+		WHERE dataset = ? AND living = 0 AND age_days < ? ORDER BY age_days DESC, rowid DESC LIMIT ?`, dataset, age, n)
+	if err != nil {
+		return nil, err
+	}
+	above, err := s.queryResults(`SELECT `+sqlitePersonColumns+`, age_days FROM people
+		WHERE dataset = ? AND living = 0 AND age_days >= ? ORDER BY age_days, rowid LIMIT ?`, dataset, age, n)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(below)
+	return append(below, above...), nil
+}
+
+// Count returns the number of records in the dataset whose age at death lies within [min, max]
+func (s *SQLiteStore) Count(dataset string, min, max int) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(s.ctx, "SELECT COUNT(*) FROM people WHERE dataset = ? AND living = 0 AND age_days BETWEEN ? AND ?",
+		dataset, min, max).Scan(&n)
+	return n, err
+}
+
+// QueryByDay returns the records in the dataset whose date of death, or of birth, falls on
+// the day given as 'MM-DD', using the index on the last five characters of the date. Only
+// dates known to the day are ten characters long, less any leading '-'.
+func (s *SQLiteStore) QueryByDay(dataset, day, which string) ([]Result, error) {
+	column := "death_date"
+	if which == DAY_BORN {
+		column = "birth_date"
+	}
+	rows, err := s.db.QueryContext(s.ctx, `SELECT `+sqlitePersonColumns+`, age_days FROM people
+		WHERE dataset = ? AND living = 0 AND substr(`+column+`, -5) = ? AND length(ltrim(`+column+`, '-')) = 10
+		ORDER BY age_days`, dataset, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []Result
+	for rows.Next() {
+		var res Result
+		if err := rows.Scan(append(sqlitePersonDest(&res.Person), &res.Days)...); err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// FindByName returns the people in the dataset whose folded names, or aliases, match. Only the
+// name keys are read to be matched, and then the rows of those which do.
+func (s *SQLiteStore) FindByName(dataset string, match func(folded string) bool) ([]Person, error) {
+	rows, err := s.db.QueryContext(s.ctx, "SELECT rowid, name_key FROM people WHERE dataset = ?", dataset)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if matchesNameKey(name, match) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	people := make([]Person, 0, len(ids))
+	for _, id := range ids {
+		var rec Person
+		if err := s.db.QueryRowContext(s.ctx, "SELECT "+sqlitePersonColumns+" FROM people WHERE rowid = ?", id).Scan(sqlitePersonDest(&rec)...); err != nil {
+			return nil, err
+		}
+		people = append(people, rec)
+	}
+	return people, nil
+}
+
+// Living returns the living people in the dataset, ordered by date of birth
+func (s *SQLiteStore) Living(dataset string) ([]Person, error) {
+	rows, err := s.db.QueryContext(s.ctx, "SELECT "+sqlitePersonColumns+" FROM people WHERE dataset = ? AND living = 1", dataset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var people []Person
+	for rows.Next() {
+		var rec Person
+		if err := rows.Scan(sqlitePersonDest(&rec)...); err != nil {
+			return nil, err
+		}
+		people = append(people, rec)
+	}
+	sortByBirth(people) // partial and BCE dates don't sort as text
+	return people, rows.Err()
+}
+
+// Datasets lists the datasets held in the database, with their sizes
+func (s *SQLiteStore) Datasets() ([]DatasetInfo, error) {
+	rows, err := s.db.QueryContext(s.ctx, "SELECT dataset, COUNT(*) - SUM(living), SUM(living) FROM people GROUP BY dataset ORDER BY dataset")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var datasets []DatasetInfo
+	for rows.Next() {
+		var info DatasetInfo
+		if err := rows.Scan(&info.Name, &info.Count, &info.Living); err != nil {
+			return nil, err
+		}
+		datasets = append(datasets, info)
+	}
+	return datasets, rows.Err()
+}
+
+// Generation returns the count held in the generation table
+func (s *SQLiteStore) Generation() (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(s.ctx, "SELECT n FROM generation").Scan(&n)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return n, err
+}
+
+// SaveProvenance records the provenance in the provenance table, as JSON
+func (s *SQLiteStore) SaveProvenance(dataset string, p Provenance) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(s.ctx, "INSERT OR REPLACE INTO provenance (dataset, data) VALUES (?, ?)", dataset, string(data))
+	return err
+}
+
+// Provenance returns the provenance held in the provenance table
+func (s *SQLiteStore) Provenance(dataset string) (Provenance, error) {
+	var data string
+	err := s.db.QueryRowContext(s.ctx, "SELECT data FROM provenance WHERE dataset = ?", dataset).Scan(&data)
+	if err == sql.ErrNoRows {
+		return Provenance{}, fmt.Errorf("dataset '%s': %w", dataset, ErrNoProvenance)
+	}
+	if err != nil {
+		return Provenance{}, err
+	}
+	var p Provenance
+	return p, json.Unmarshal([]byte(data), &p)
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
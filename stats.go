@@ -0,0 +1,184 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Stats summarises the distribution of ages at death, in days
+type Stats struct {
+	Count  int
+	Min    int
+	Max    int
+	Mean   float64
+	Median float64
+	StdDev float64 // population standard deviation
+
+	ages []int // sorted, retained so that the distribution can be bucketed
+}
+
+// Bucket counts the ages at death within [From, To) years
+type Bucket struct {
+	From  int
+	To    int
+	Count int
+}
+
+// ComputeStats summarises the ages, given in days
+func ComputeStats(ages []int) Stats {
+	s := Stats{Count: len(ages), ages: append([]int(nil), ages...)}
+	if s.Count == 0 {
+		return s
+	}
+	sort.Ints(s.ages)
+	s.Min, s.Max = s.ages[0], s.ages[s.Count-1]
+
+	var sum float64
+	for _, a := range s.ages {
+		sum += float64(a)
+	}
+	s.Mean = sum / float64(s.Count)
+	var variance float64
+	for _, a := range s.ages {
+		d := float64(a) - s.Mean
+		variance += d * d
+	}
+	s.StdDev = math.Sqrt(variance / float64(s.Count))
+
+	mid := s.Count / 2
+	if s.Count%2 == 1 {
+		s.Median = float64(s.ages[mid])
+	} else {
+		s.Median = float64(s.ages[mid-1]+s.ages[mid]) / 2
+	}
+	return s
+}
+
+// StdDevYears returns the standard deviation in years
+func (s Stats) StdDevYears() float64 {
+	return s.StdDev / daysInYear
+}
+
+// Buckets groups the ages into bins of the given width in years, from the bin holding the
+// youngest age to the one holding the oldest. Empty bins in between are included.
+func (s Stats) Buckets(years int) []Bucket {
+	if s.Count == 0 || years <= 0 {
+		return nil
+	}
+	first := AgeInYears(s.Min) / years
+	last := AgeInYears(s.Max) / years
+	buckets := make([]Bucket, last-first+1)
+	for i := range buckets {
+		buckets[i].From = (first + i) * years
+		buckets[i].To = buckets[i].From + years
+	}
+	for _, a := range s.ages {
+		buckets[AgeInYears(a)/years-first].Count++
+	}
+	return buckets
+}
+
+// DatasetStats summarises the ages at death of everyone in the datasets matching the filter
+func DatasetStats(store Store, datasets []string, filter Filter) (Stats, error) {
+	results, err := filteredRecords(store, datasets, filter)
+	if err != nil {
+		return Stats{}, err
+	}
+	ages := make([]int, len(results))
+	for i, res := range results {
+		ages[i] = res.Days
+	}
+	return ComputeStats(ages), nil
+}
+
+// GroupStats summarises the ages at death of those sharing one value of a field
+type GroupStats struct {
+	Value string  // as first written, or empty for those with none
+	Share float64 // the percentage of everyone summarised who are in the group
+	Stats
+}
+
+// GROUP_FIELDS are the fields by which GroupedStats can group people
+var GROUP_FIELDS = []string{FIELD_CAUSE_OF_DEATH, FIELD_OCCUPATION, FIELD_NATIONALITY, FIELD_GENRE, FIELD_TAGS}
+
+// GroupedStats summarises the ages at death of everyone in the datasets matching the filter in
+// groups sharing a value of the field, one of GROUP_FIELDS or an alias such as 'cause', the
+// largest group first. Values are grouped ignoring case, and someone with several, separated
+// by ';', is counted in each of their groups.
+func GroupedStats(store Store, datasets []string, filter Filter, field string) ([]GroupStats, error) {
+	name := fieldName(field)
+	if !slices.Contains(GROUP_FIELDS, name) {
+		return nil, fmt.Errorf("can't group by '%s': expected one of %s", field, strings.Join(GROUP_FIELDS, ", "))
+	}
+	results, err := filteredRecords(store, datasets, filter)
+	if err != nil {
+		return nil, err
+	}
+	ages := map[string][]int{}
+	values := map[string]string{} // the first spelling of each folded value
+	var keys []string             // in the order first reached
+	for _, res := range results {
+		counted := map[string]bool{}
+		for _, v := range groupValues(res.Field(name)) {
+			key := strings.ToLower(v)
+			if counted[key] {
+				continue
+			}
+			if _, ok := values[key]; !ok {
+				values[key], keys = v, append(keys, key)
+			}
+			counted[key], ages[key] = true, append(ages[key], res.Days)
+		}
+	}
+	groups := make([]GroupStats, len(keys))
+	for i, key := range keys {
+		groups[i] = GroupStats{Value: values[key], Share: float64(len(ages[key])) * 100 / float64(len(results)), Stats: ComputeStats(ages[key])}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return strings.ToLower(groups[i].Value) < strings.ToLower(groups[j].Value)
+	})
+	return groups, nil
+}
+
+// groupValues returns the values of a field separated by ';', or a single empty one if it has
+// none
+func groupValues(field string) []string {
+	var values []string
+	for _, v := range strings.Split(field, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return []string{""}
+	}
+	return values
+}
+
+// filteredRecords returns the records in the datasets matching the filter, which a
+// FilteredStore applies itself
+func filteredRecords(store Store, datasets []string, filter Filter) ([]Result, error) {
+	var results []Result
+	for _, dataset := range datasets {
+		var all []Result
+		var err error
+		if fs, ok := store.(FilteredStore); ok && !filter.IsEmpty() {
+			all, err = fs.QueryFiltered(dataset, math.MinInt32, math.MaxInt32, filter)
+		} else {
+			all, err = AllRecords(store, dataset)
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, FilterResults(all, filter)...)
+	}
+	return results, nil
+}
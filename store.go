@@ -0,0 +1,273 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Store is a storage backend holding named datasets of records, each scored by age at
+// death in days. Redis is the default implementation, but any backend able to answer
+// range queries over the age can satisfy it. Living people, who have no date of death, are
+// held apart from the others, and are left out of queries by age.
+type Store interface {
+	// Import replaces any existing data in the dataset with the given records
+	Import(dataset string, records []Person) error
+	// Upsert merges the records into the dataset, replacing any existing record for the
+	// same person (see Person.Key) and leaving all other records in place
+	Upsert(dataset string, records []Person) (UpsertStats, error)
+	// QueryByAgeRange returns the records in the dataset whose age at death in days lies
+	// within [min, max], ordered by age
+	QueryByAgeRange(dataset string, min, max int) ([]Result, error)
+	// Count returns the number of records in the dataset whose age at death in days lies
+	// within [min, max]
+	Count(dataset string, min, max int) (int, error)
+	// QueryByDay returns the records in the dataset whose date of death, or of birth if which
+	// is DAY_BORN, falls on the day of the year given as 'MM-DD'
+	QueryByDay(dataset, day, which string) ([]Result, error)
+	// FindByName returns the people in the dataset, living people included, for whose names
+	// folded by FoldName match returns true
+	FindByName(dataset string, match func(folded string) bool) ([]Person, error)
+	// Living returns the living people in the dataset, ordered by date of birth
+	Living(dataset string) ([]Person, error)
+	// Datasets lists the datasets which have been imported
+	Datasets() ([]DatasetInfo, error)
+	Close() error
+}
+
+// FilteredStore is implemented by stores able to apply a Filter themselves, rather than every
+// record in the age range being read so that it can be filtered
+type FilteredStore interface {
+	// QueryFiltered returns the records in the dataset whose age at death in days lies within
+	// [min, max] and which match the filter, ordered by age
+	QueryFiltered(dataset string, min, max int, f Filter) ([]Result, error)
+	// CountFiltered returns the number of records QueryFiltered would return
+	CountFiltered(dataset string, min, max int, f Filter) (int, error)
+}
+
+// PagedStore is implemented by stores able to read a page of the records in an age range,
+// rather than every record in the range being read
+type PagedStore interface {
+	// QueryPage returns up to limit of the records QueryByAgeRange would return, after
+	// skipping the first offset; with a limit of zero, all the rest
+	QueryPage(dataset string, min, max, offset, limit int) ([]Result, error)
+}
+
+// NearestStore is implemented by stores able to read the records closest to an age at death,
+// rather than every record in the dataset being read
+type NearestStore interface {
+	// QueryNearest returns up to n of the records in the dataset who died younger than age in
+	// days, the oldest of them, and up to n of those who died at that age or older, the
+	// youngest of them, ordered by age
+	QueryNearest(dataset string, age, n int) ([]Result, error)
+}
+
+// BirthYearStore is implemented by stores able to read the people born in a span of years,
+// rather than everyone in the dataset being read
+type BirthYearStore interface {
+	// QueryByBirthYear returns the people in the dataset, living people included, born in the
+	// years from to to inclusive
+	QueryByBirthYear(dataset string, from, to int) ([]Person, error)
+}
+
+// TagStore is implemented by stores able to count the people holding each tag, rather than
+// everyone in the dataset being read
+type TagStore interface {
+	// TagCounts returns the tags held by the people in the dataset, folded as by foldValue, with
+	// how many hold each, the most held first
+	TagCounts(dataset string) ([]TagCount, error)
+}
+
+// NameSearcher is implemented by stores able to search names themselves. SearchNames returns
+// the people in the dataset whose names may match the query folded by FoldName, as the mode
+// (see Find) allows. It may return people who do not match, as Find matches them again.
+type NameSearcher interface {
+	SearchNames(dataset, query, mode string) ([]Person, error)
+}
+
+// VersionedStore is implemented by stores which count the imports made to them, so that what
+// is derived from their contents, such as cached query results, can tell when it is stale
+type VersionedStore interface {
+	// Generation returns a number which changes whenever any dataset is imported or upserted
+	Generation() (int64, error)
+}
+
+// Claimer is implemented by stores shared by several processes, through which one of them can
+// claim a piece of work, such as the day's post to a channel, so that no other does it too
+type Claimer interface {
+	// Claim reports whether the key was claimed by this call, rather than already held by an
+	// earlier claim made within ttl
+	Claim(key string, ttl time.Duration) (bool, error)
+}
+
+// BatchImporter is implemented by stores able to import a dataset in batches written at once
+// by several workers, rather than one record at a time
+type BatchImporter interface {
+	// ImportBatched replaces any existing data in the dataset with the given records, as
+	// Import does
+	ImportBatched(dataset string, records []Person, opts BatchOptions) error
+}
+
+// Remover is implemented by stores able to remove people from a dataset without importing the
+// rest of it again
+type Remover interface {
+	// Remove deletes the records of the same people (see Person.Key) as those given from the
+	// dataset, returning how many of them it held
+	Remove(dataset string, people []Person) (int, error)
+}
+
+// Editor is implemented by stores able to change one person's record in place, without the
+// rest of the dataset being imported again
+type Editor interface {
+	// Edit replaces the record of a person in the dataset, which must still be old, with the
+	// edited one, failing with ErrEditConflict if it is not, and with ErrDuplicate if the edit
+	// makes them the same person as another (see Person.Key)
+	Edit(dataset string, old, edited Person) error
+}
+
+// Defaults for the BatchOptions fields, used when they are zero
+const (
+	DEFAULT_BATCH_SIZE    = 1000
+	DEFAULT_BATCH_WORKERS = 4
+)
+
+// BatchOptions controls how an import is written by a BatchImporter
+type BatchOptions struct {
+	Size    int // records written in each batch
+	Workers int // batches written at once
+
+	// Progress, if not nil, is called after each batch is written with the number of records
+	// written so far, out of the total. Calls are made one at a time, from the workers.
+	Progress func(written, total int)
+}
+
+// Migrator is implemented by stores whose datasets may be held in a layout written by an
+// earlier version, which can upgrade them to the current one
+type Migrator interface {
+	// SchemaVersion returns the version of the layout in which the dataset is held
+	SchemaVersion(dataset string) (int, error)
+	// Migrate upgrades the dataset to the current layout, leaving it as it was should it
+	// fail, and calling progress, if not nil, as it goes
+	Migrate(dataset string, progress func(written, total int)) (MigrateStats, error)
+}
+
+// MigrateStats describes the migration of a dataset
+type MigrateStats struct {
+	From   int // the version of the layout in which the dataset was held
+	People int // the people rewritten, or none if the dataset was already current
+	Legacy int // those of them who were held as 'name,dob,dod' members
+}
+
+// UpsertStats counts the outcome of merging records into a dataset
+type UpsertStats struct {
+	Inserted  int
+	Updated   int
+	Unchanged int
+}
+
+// DatasetInfo describes a dataset held in a store
+type DatasetInfo struct {
+	Name   string
+	Count  int // the number of people who have died, who are scored by age at death
+	Living int
+}
+
+// ValidateDatasetName checks that a dataset name is usable as part of a storage key
+func ValidateDatasetName(name string) error {
+	if name == "" || strings.ContainsAny(name, ",{}: \t\n") {
+		return fmt.Errorf("invalid dataset name '%s'", name)
+	}
+	return nil
+}
+
+// DATASETS_ALL may be given in place of a list of datasets to select every dataset
+const DATASETS_ALL = "all"
+
+// ResolveDatasets expands a comma separated list of dataset names, or 'all', into the
+// names of the datasets to query
+func ResolveDatasets(store Store, spec string) ([]string, error) {
+	if spec != DATASETS_ALL {
+		names := SplitList(spec)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no dataset given")
+		}
+		return names, nil
+	}
+	datasets, err := store.Datasets()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(datasets))
+	for _, ds := range datasets {
+		names = append(names, ds.Name)
+	}
+	return names, nil
+}
+
+// Result is a record returned from a query, along with its age at death in days and the
+// dataset it was found in
+type Result struct {
+	Person
+	Days    int
+	Dataset string
+}
+
+// SplitLiving separates the records of living people from those of people who have died
+func SplitLiving(records []Person) (dead, living []Person) {
+	for _, rec := range records {
+		if rec.Living() {
+			living = append(living, rec)
+		} else {
+			dead = append(dead, rec)
+		}
+	}
+	return dead, living
+}
+
+// NewResults computes the age at death of each record, returning them as results
+func NewResults(records []Person) ([]Result, error) {
+	results := make([]Result, 0, len(records))
+	for _, rec := range records {
+		age, err := rec.AgeInDays()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, Result{Person: rec, Days: age})
+	}
+	return results, nil
+}
+
+// dedupePeople drops all but the last record for each person, so that a source file which
+// lists someone twice leaves a single record behind
+func dedupePeople(records []Person) []Person {
+	last := make(map[string]int, len(records))
+	for i, rec := range records {
+		last[rec.Key()] = i
+	}
+	deduped := make([]Person, 0, len(last))
+	for i, rec := range records {
+		if last[rec.Key()] == i {
+			deduped = append(deduped, rec)
+		}
+	}
+	return deduped
+}
+
+// sortByBirth orders living people by date of birth, the eldest first
+func sortByBirth(people []Person) {
+	sort.SliceStable(people, func(i, j int) bool { return birthDay(people[i]) < birthDay(people[j]) })
+}
+
+// birthDay returns the person's date of birth as a number of days since 1970-01-01, by which
+// living people are scored
+func birthDay(rec Person) int {
+	t, _, err := ParsePartialDate(rec.BirthDate)
+	if err != nil {
+		return 0
+	}
+	return int(math.Floor(float64(t.Unix()) / 86400))
+}
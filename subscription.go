@@ -0,0 +1,63 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoSubscription is returned when a chat is not subscribed
+var ErrNoSubscription = errors.New("no such subscription")
+
+// Subscription is a chat to which a bot sends a message each time the person it was given
+// the date of birth of outlives someone
+type Subscription struct {
+	Chat      string `json:"chat"` // the chat's ID on the bot's service
+	BirthDate string `json:"birth_date"`
+	// Notified is the last day, YYYY-MM-DD, whose milestones have been sent, so that those
+	// passed while the bot was not running are sent at its next check
+	Notified string    `json:"notified"`
+	Created  time.Time `json:"created"`
+}
+
+// SubscriptionStore holds the subscriptions of the chats of each bot service, such as
+// 'telegram'
+type SubscriptionStore interface {
+	// Subscriptions returns the subscriptions of the service, the oldest first
+	Subscriptions(service string) ([]Subscription, error)
+	// SaveSubscription adds the subscription, replacing any of the same chat
+	SaveSubscription(service string, sub Subscription) error
+	// RemoveSubscription removes the chat's subscription, returning ErrNoSubscription if
+	// there is none
+	RemoveSubscription(service, chat string) error
+}
+
+// NewSubscription returns the subscription of the chat to the milestones of someone born on
+// dateStr, from the day after now
+func NewSubscription(chat, dateStr string, now time.Time) (Subscription, error) {
+	if err := ValidateDate(dateStr); err != nil {
+		return Subscription{}, err
+	}
+	return Subscription{Chat: chat, BirthDate: dateStr, Notified: now.Format(DATE_FMT), Created: now.UTC()}, nil
+}
+
+// DueMilestones returns the milestones of the subscriber passed after the day they were last
+// notified of, up to and including opts.Now, in the order in which they were passed. Only those
+// who died at the ages passed since are read.
+func DueMilestones(store Store, sub Subscription, opts QueryOptions) ([]Milestone, error) {
+	birth, userAge, err := userBirthAndAge(sub.BirthDate, opts.Now)
+	if err != nil {
+		return nil, err
+	}
+	from, err := AgeInDays(sub.BirthDate, sub.Notified)
+	if err != nil {
+		return nil, err
+	}
+	// someone who died aged days is outlived the day after birth+days
+	results, err := queryDatasets(store, opts, from, userAge-1)
+	if err != nil {
+		return nil, err
+	}
+	return milestones(birth, results), nil
+}
@@ -0,0 +1,116 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// SOURCE_SYNTHETIC is the source recorded in the provenance of datasets of synthetic people
+const SOURCE_SYNTHETIC = "synthetic"
+
+// Bounds of the synthetic people's lives, in years, and the mean and standard deviation of
+// their ages at death
+const (
+	syntheticMinAge   = 15
+	syntheticMaxAge   = 100
+	syntheticMaxBirth = 120 // years before now
+	syntheticMeanAge  = 68
+	syntheticAgeSD    = 16
+)
+
+// The attempts made at a name and date of birth no one else synthetic has, before the name is
+// numbered to make it so
+const syntheticAttempts = 10
+
+var (
+	syntheticFirstNames = []string{
+		"James", "John", "Robert", "Michael", "William", "David", "Richard", "Joseph", "Charles", "Thomas",
+		"George", "Frank", "Edward", "Henry", "Walter", "Arthur", "Harold", "Albert", "Louis", "Ray",
+		"Otis", "Marvin", "Curtis", "Jimi", "Kurt", "Layne", "Ian", "Keith", "Brian", "Roy",
+		"Mary", "Patricia", "Linda", "Barbara", "Elizabeth", "Jennifer", "Susan", "Margaret", "Dorothy", "Nancy",
+		"Helen", "Ruth", "Betty", "Judy", "Ella", "Billie", "Nina", "Aretha", "Etta", "Janis",
+		"Amy", "Whitney", "Karen", "Donna", "Tina", "Dolores", "Sinead", "Cass", "Dusty", "Sandy",
+	}
+	syntheticSurnames = []string{
+		"Smith", "Johnson", "Williams", "Brown", "Jones", "Miller", "Davis", "Wilson", "Taylor", "Clark",
+		"Lewis", "Walker", "Hall", "Allen", "Young", "King", "Wright", "Scott", "Green", "Baker",
+		"Adams", "Nelson", "Hill", "Campbell", "Mitchell", "Roberts", "Carter", "Phillips", "Evans", "Turner",
+		"Parker", "Collins", "Edwards", "Stewart", "Morris", "Murphy", "Cook", "Rogers", "Morgan", "Cooper",
+		"Reed", "Bailey", "Bell", "Kelly", "Howard", "Ward", "Cox", "Richardson", "Wood", "Watson",
+		"Brooks", "Bennett", "Gray", "James", "Hughes", "Price", "Sanders", "Myers", "Long", "Foster",
+	}
+	syntheticOccupations   = []string{"singer", "guitarist", "drummer", "bassist", "pianist", "composer", "songwriter", "rapper", "producer", "saxophonist", "violinist", "conductor"}
+	syntheticNationalities = []string{"American", "British", "Canadian", "Irish", "Australian", "French", "German", "Jamaican", "Brazilian", "Japanese", "Nigerian", "Swedish"}
+	syntheticGenres        = []string{"rock", "pop", "jazz", "blues", "soul", "country", "folk", "hip hop", "punk", "metal", "classical", "reggae", "funk", "electronic"}
+	syntheticCausesOfDeath = []string{"heart attack", "cancer", "stroke", "drug overdose", "car accident", "plane crash", "pneumonia", "suicide", "liver failure", "natural causes"}
+)
+
+// SyntheticPeople returns n made-up people for load testing and demos, all of whom have died
+// by now, drawn from rng so that the same seed and date give the same people. Their names are
+// made of common first names and surnames, their births spread over the last 120 years with
+// more of them recent, and their ages at death normally distributed about 68, between 15 and
+// 100. Each has an occupation, nationality and genres, and most a cause of death. No two are
+// the same person (see Person.Key).
+func SyntheticPeople(n int, rng *rand.Rand, now time.Time) []Person {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	earliest := today.AddDate(-syntheticMaxBirth, 0, 0)
+	latest := today.AddDate(-syntheticMinAge, 0, 0)
+	span := latest.Sub(earliest).Hours() / 24
+	seen := make(map[string]bool, n)
+	people := make([]Person, n)
+	for i := range people {
+		var rec Person
+		for attempt := 0; ; attempt++ {
+			// the square root of a uniform draw favours later births
+			birth := earliest.AddDate(0, 0, int(math.Sqrt(rng.Float64())*span))
+			rec = Person{
+				Name:      pick(rng, syntheticFirstNames) + " " + pick(rng, syntheticSurnames),
+				BirthDate: birth.Format(DATE_FMT),
+				DeathDate: syntheticDeath(rng, birth, today).Format(DATE_FMT),
+			}
+			if attempt >= syntheticAttempts {
+				rec.Name = fmt.Sprintf("%s %d", rec.Name, i+1)
+			}
+			if !seen[rec.Key()] {
+				break
+			}
+		}
+		seen[rec.Key()] = true
+		rec.Occupation = pick(rng, syntheticOccupations)
+		rec.Nationality = pick(rng, syntheticNationalities)
+		rec.Genre = pick(rng, syntheticGenres)
+		if other := pick(rng, syntheticGenres); other != rec.Genre && rng.Intn(3) == 0 {
+			rec.Genre += ";" + other
+		}
+		if rng.Intn(5) > 0 {
+			rec.CauseOfDeath = pick(rng, syntheticCausesOfDeath)
+		}
+		people[i] = rec
+	}
+	return people
+}
+
+// syntheticDeath draws the date of death of someone born on birth, at an age normally distributed
+// within the bounds, which they have reached by today
+func syntheticDeath(rng *rand.Rand, birth, today time.Time) time.Time {
+	lived := today.Sub(birth).Hours() / 24 / 365.25
+	age := math.Max(syntheticMinAge, math.Min(syntheticMaxAge, rng.NormFloat64()*syntheticAgeSD+syntheticMeanAge))
+	if age > lived {
+		// those born recently who would have lived longer died young
+		age = syntheticMinAge + rng.Float64()*(lived-syntheticMinAge)
+	}
+	death := birth.AddDate(0, 0, int(age*365.25))
+	if death.After(today) {
+		return today
+	}
+	return death
+}
+
+// pick returns one of the values at random
+func pick(rng *rand.Rand, values []string) string {
+	return values[rng.Intn(len(values))]
+}
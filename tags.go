@@ -0,0 +1,103 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"sort"
+	"strings"
+)
+
+// TagCount is a tag, folded as its index holds it, and the number of people holding it
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// CountTags returns the tags held by the people in the datasets, with how many hold each, the
+// most held first. They are read by a TagStore, or from everyone in each dataset otherwise.
+func CountTags(store Store, datasets []string) ([]TagCount, error) {
+	counts := map[string]int{}
+	for _, dataset := range datasets {
+		var found []TagCount
+		var err error
+		if ts, ok := store.(TagStore); ok {
+			found, err = ts.TagCounts(dataset)
+		} else {
+			found, err = countTagsOf(store, dataset)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, tc := range found {
+			counts[tc.Tag] += tc.Count
+		}
+	}
+	return sortTagCounts(counts), nil
+}
+
+// countTagsOf counts the tags of everyone in the dataset
+func countTagsOf(store Store, dataset string) ([]TagCount, error) {
+	people, err := AllPeople(store, dataset)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, rec := range people {
+		for _, tag := range tagIndex.valuesOf(rec) {
+			counts[tag]++
+		}
+	}
+	return sortTagCounts(counts), nil
+}
+
+// sortTagCounts returns the counts of the tags, the most held first and then by tag, leaving
+// out those no one holds
+func sortTagCounts(counts map[string]int) []TagCount {
+	tags := make([]TagCount, 0, len(counts))
+	for tag, n := range counts {
+		if n > 0 {
+			tags = append(tags, TagCount{Tag: tag, Count: n})
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags
+}
+
+// WithTags returns the record with the tags added to those it holds, other than any it holds
+// already, ignoring case
+func WithTags(rec Person, tags []string) Person {
+	rec.Tags = joinDistinct([][]string{rec.TagList(), trimTags(tags)})
+	return rec
+}
+
+// WithoutTags returns the record with the tags removed from those it holds, ignoring case
+func WithoutTags(rec Person, tags []string) Person {
+	removed := map[string]bool{}
+	for _, tag := range tags {
+		removed[foldValue(tag)] = true
+	}
+	var kept []string
+	for _, tag := range rec.TagList() {
+		if !removed[foldValue(tag)] {
+			kept = append(kept, tag)
+		}
+	}
+	rec.Tags = strings.Join(kept, ";")
+	return rec
+}
+
+// trimTags returns the tags trimmed of spaces, leaving out any empty
+func trimTags(tags []string) []string {
+	var trimmed []string
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			trimmed = append(trimmed, tag)
+		}
+	}
+	return trimmed
+}
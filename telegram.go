@@ -0,0 +1,111 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TELEGRAM_ENDPOINT is the Telegram Bot API, under which each bot's methods are found at
+// '/bot<token>/<method>'
+const TELEGRAM_ENDPOINT = "https://api.telegram.org"
+
+// TelegramBot calls the methods of the Telegram Bot API as a bot
+type TelegramBot struct {
+	Token    string
+	Endpoint string // defaults to TELEGRAM_ENDPOINT
+	Client   *http.Client
+}
+
+// TelegramUpdate is an update received by a bot, of which only new messages are read
+type TelegramUpdate struct {
+	ID      int64            `json:"update_id"`
+	Message *TelegramMessage `json:"message"`
+}
+
+// TelegramMessage is a message sent in a chat with a bot
+type TelegramMessage struct {
+	Chat struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+// telegramReply is the envelope of every response of the Bot API
+type telegramReply struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// Updates waits up to wait for the updates from offset on, which are then confirmed as read
+// by the next call with an offset beyond them
+func (b TelegramBot) Updates(ctx context.Context, offset int64, wait time.Duration) ([]TelegramUpdate, error) {
+	var updates []TelegramUpdate
+	err := b.call(ctx, "getUpdates", map[string]interface{}{
+		"offset":          offset,
+		"timeout":         int(wait / time.Second),
+		"allowed_updates": []string{"message"},
+	}, &updates)
+	return updates, err
+}
+
+// Send sends a message of plain text to the chat
+func (b TelegramBot) Send(ctx context.Context, chat int64, text string) error {
+	return b.call(ctx, "sendMessage", map[string]interface{}{"chat_id": chat, "text": text}, nil)
+}
+
+// call posts the parameters to the method, decoding its result into result if not nil. The
+// token is left out of any error, as it would be of the URL.
+func (b TelegramBot) call(ctx context.Context, method string, params, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	endpoint := b.Endpoint
+	if endpoint == "" {
+		endpoint = TELEGRAM_ENDPOINT
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint+"/bot"+b.Token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("telegram: %s: invalid endpoint", method)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", USER_AGENT)
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return fmt.Errorf("telegram: %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("telegram: %s: %v", method, err)
+	}
+	var reply telegramReply
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return fmt.Errorf("telegram: %s returned %s", method, resp.Status)
+	}
+	if !reply.OK {
+		return fmt.Errorf("telegram: %s: %s", method, reply.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
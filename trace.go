@@ -0,0 +1,128 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"context"
+
+	"github.com/garyburd/redigo/redis"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TRACER_NAME names the OpenTelemetry tracer recording the library's spans. They go nowhere
+// unless the program installs a tracer provider.
+const TRACER_NAME = "github.com/matthewhegarty/outlived"
+
+// Tracer returns the tracer recording the library's spans, from the global tracer provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(TRACER_NAME)
+}
+
+// ContextStore is implemented by stores able to make their operations within a context, so
+// that they are traced as part of whatever the caller is doing
+type ContextStore interface {
+	// WithContext returns a view of the store, sharing its connection, which makes its
+	// operations within ctx
+	WithContext(ctx context.Context) Store
+}
+
+// StoreWithContext returns a view of the store making its operations within ctx, or the store
+// itself if it can't
+func StoreWithContext(ctx context.Context, store Store) Store {
+	if cs, ok := store.(ContextStore); ok {
+		return cs.WithContext(ctx)
+	}
+	return store
+}
+
+// tracedConn records a span for each command sent to Redis, as a child of any span in ctx.
+// Commands queued with Send are covered by the span of the Do which sends them or, when they
+// are pipelined with Flush, by a span ending once their last reply is received. Once ctx has
+// ended, Send, Do and Flush fail with its error rather than sending anything.
+type tracedConn struct {
+	redis.Conn
+	ctx      context.Context
+	queued   int // commands sent since the last Flush or Do
+	awaiting int // replies to flushed commands not yet received
+	pipeline trace.Span
+}
+
+func (c *tracedConn) Send(cmd string, args ...interface{}) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	c.queued++
+	return c.Conn.Send(cmd, args...)
+}
+
+func (c *tracedConn) Flush() error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	if c.queued > 0 && c.pipeline == nil {
+		_, c.pipeline = c.start("pipeline", semconv.DBOperationBatchSize(c.queued))
+	}
+	c.awaiting += c.queued
+	c.queued = 0
+	err := c.Conn.Flush()
+	if err != nil && c.pipeline != nil {
+		c.end(c.pipeline, err)
+		c.pipeline = nil
+	}
+	return err
+}
+
+func (c *tracedConn) Receive() (interface{}, error) {
+	reply, err := c.Conn.Receive()
+	if c.awaiting--; c.awaiting <= 0 && c.pipeline != nil {
+		c.end(c.pipeline, err)
+		c.pipeline = nil
+	}
+	return reply, err
+}
+
+func (c *tracedConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	name := cmd
+	if name == "" {
+		name = "pipeline"
+	}
+	var attrs []attribute.KeyValue
+	if c.queued > 0 {
+		attrs = append(attrs, semconv.DBOperationBatchSize(c.queued+1))
+	}
+	_, span := c.start(name, attrs...)
+	c.queued = 0
+	reply, err := c.Conn.Do(cmd, args...)
+	c.end(span, err)
+	return reply, err
+}
+
+// uncancelled returns the connection, or if it is a tracedConn a copy which goes on sending
+// commands after its context has ended, still tracing them as part of it
+func uncancelled(c redis.Conn) redis.Conn {
+	if tc, ok := c.(*tracedConn); ok {
+		return &tracedConn{Conn: tc.Conn, ctx: context.WithoutCancel(tc.ctx)}
+	}
+	return c
+}
+
+func (c *tracedConn) start(op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, semconv.DBSystemNameRedis, semconv.DBOperationName(op))
+	return Tracer().Start(c.ctx, "redis "+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// end ends the span, marking it as failed by any error
+func (c *tracedConn) end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
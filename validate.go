@@ -0,0 +1,162 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Rejection describes a row of a source file which could not be imported
+type Rejection struct {
+	Line   int
+	Record []string // the raw fields of the row
+	Reason string
+}
+
+// RejectFunc is called for each row rejected while reading a source file
+type RejectFunc func(Rejection)
+
+// ValidatePerson checks that a record has a name, that both of its dates are valid (though
+// they may be partial), and that the date of death does not precede the date of birth
+func ValidatePerson(rec Person) error {
+	return validatePerson(rec, false)
+}
+
+// ValidateLivingPerson checks a record as ValidatePerson does, except that a record with no
+// date of death is accepted as that of a living person
+func ValidateLivingPerson(rec Person) error {
+	return validatePerson(rec, true)
+}
+
+// validate checks a record read from a source, allowing living people if opts.Living is set
+func (opts ReadOptions) validate(rec Person) error {
+	return validatePerson(rec, opts.Living)
+}
+
+func validatePerson(rec Person, living bool) error {
+	if strings.TrimSpace(rec.Name) == "" {
+		return errors.New("missing name")
+	}
+	if rec.BirthDate == "" {
+		return errors.New("missing date of birth")
+	}
+	if _, _, err := ParsePartialDate(rec.BirthDate); err != nil {
+		return fmt.Errorf("invalid date of birth '%s'", rec.BirthDate)
+	}
+	if rec.DeathDate == "" {
+		if living {
+			return nil
+		}
+		return errors.New("missing date of death")
+	}
+	if _, _, err := ParsePartialDate(rec.DeathDate); err != nil {
+		return fmt.Errorf("invalid date of death '%s'", rec.DeathDate)
+	}
+	// partial dates are compared by the periods they cover, as those of a year and a month in
+	// it may be either way round; the age of such a record is then 0 (see Person.ageOn)
+	earliestBirth, _, err := PartialDatePeriod(rec.BirthDate)
+	if err != nil {
+		return err
+	}
+	_, latestDeath, err := PartialDatePeriod(rec.DeathDate)
+	if err != nil {
+		return err
+	}
+	if latestDeath.Before(earliestBirth) {
+		return errors.New("date of death is before date of birth")
+	}
+	return nil
+}
+
+// AllRecords returns every record in the dataset, ordered by age, leaving out living people
+func AllRecords(store Store, dataset string) ([]Result, error) {
+	return store.QueryByAgeRange(dataset, math.MinInt32, math.MaxInt32)
+}
+
+// AllPeople returns every record in the dataset, those of people who have died ordered by age
+// and followed by the living
+func AllPeople(store Store, dataset string) ([]Person, error) {
+	results, err := AllRecords(store, dataset)
+	if err != nil {
+		return nil, err
+	}
+	living, err := store.Living(dataset)
+	if err != nil {
+		return nil, err
+	}
+	people := make([]Person, 0, len(results)+len(living))
+	for _, res := range results {
+		people = append(people, res.Person)
+	}
+	return append(people, living...), nil
+}
+
+// RecordChange is an existing record which an import would replace
+type RecordChange struct {
+	Old Person
+	New Person
+}
+
+// Fields returns the names of the fields which the change alters, in the order of PERSON_FIELDS
+func (c RecordChange) Fields() []string {
+	var fields []string
+	for _, field := range PERSON_FIELDS {
+		if c.Old.Field(field) != c.New.Field(field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// ImportPlan describes what importing records into a dataset would do
+type ImportPlan struct {
+	Insert     []Person
+	Update     []RecordChange
+	Remove     []Person // records which a full import would drop, as they are absent from the source
+	Unchanged  int
+	Duplicates []Person // records superseded by a later record for the same person in the source
+}
+
+// PlanImport compares the records read from a source with those already in the dataset.
+// If replace is true the plan is for a full import, which drops records absent from the
+// source, otherwise it is for an upsert.
+func PlanImport(existing []Person, records []Person, replace bool) ImportPlan {
+	var plan ImportPlan
+
+	last := make(map[string]int, len(records))
+	for i, rec := range records {
+		last[rec.Key()] = i
+	}
+	current := make(map[string]Person, len(existing))
+	for _, rec := range existing {
+		current[rec.Key()] = rec
+	}
+
+	for i, rec := range records {
+		key := rec.Key()
+		if last[key] != i {
+			plan.Duplicates = append(plan.Duplicates, rec)
+			continue
+		}
+		old, ok := current[key]
+		switch {
+		case !ok:
+			plan.Insert = append(plan.Insert, rec)
+		case old != rec:
+			plan.Update = append(plan.Update, RecordChange{Old: old, New: rec})
+		default:
+			plan.Unchanged++
+		}
+	}
+	if replace {
+		for _, rec := range existing {
+			if _, ok := last[rec.Key()]; !ok {
+				plan.Remove = append(plan.Remove, rec)
+			}
+		}
+	}
+	return plan
+}
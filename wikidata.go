@@ -0,0 +1,188 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// WIKIDATA_ENDPOINT is the Wikidata Query Service SPARQL endpoint
+	WIKIDATA_ENDPOINT = "https://query.wikidata.org/sparql"
+	// WIKIDATA_PAGE_SIZE is the number of people fetched by each SPARQL query
+	WIKIDATA_PAGE_SIZE = 5000
+)
+
+// USER_AGENT identifies the tool to the public APIs it calls, as their usage policies require
+const USER_AGENT = "outlived/1.0 (https://github.com/matthewhegarty/outlived)"
+
+// WikidataOptions selects the people read from Wikidata
+type WikidataOptions struct {
+	// Occupation is an English occupation label, e.g. 'musician', or a Wikidata item ID such
+	// as 'Q639669'
+	Occupation string
+	Endpoint   string // defaults to WIKIDATA_ENDPOINT
+	PageSize   int    // defaults to WIKIDATA_PAGE_SIZE
+	Limit      int    // the maximum number of people to read, or 0 for all
+}
+
+var wikidataItemRegex = regexp.MustCompile("^Q[0-9]+$")
+
+// sparqlResults is the subset of the SPARQL 1.1 JSON results format used here
+type sparqlResults struct {
+	Results struct {
+		Bindings []map[string]struct {
+			Value string `json:"value"`
+		} `json:"bindings"`
+	} `json:"results"`
+}
+
+// ReadWikidata pages through the deceased people with the occupation on Wikidata, returning
+// those whose dates of birth and death are both known to the day. People whose records fail
+// ValidatePerson are passed to opts.Reject if set, and otherwise fail the read.
+func ReadWikidata(wd WikidataOptions, opts ReadOptions) ([]Person, Progress, error) {
+	if wd.Endpoint == "" {
+		wd.Endpoint = WIKIDATA_ENDPOINT
+	}
+	if wd.PageSize <= 0 {
+		wd.PageSize = WIKIDATA_PAGE_SIZE
+	}
+	client := &http.Client{Timeout: opts.Fetch.Timeout}
+	if client.Timeout == 0 {
+		client.Timeout = DEFAULT_FETCH_TIMEOUT
+	}
+	tracker := newProgressTracker(opts.Context, nil, 0, opts.Progress, opts.ProgressInterval)
+
+	occupation := wd.Occupation
+	if !wikidataItemRegex.MatchString(occupation) {
+		item, err := wikidataOccupation(tracker.ctx, client, wd.Endpoint, occupation)
+		if err != nil {
+			return nil, tracker.progress(), err
+		}
+		occupation = item
+	}
+
+	var people []Person
+	seen := map[string]bool{} // people with several recorded dates are returned once
+	for offset := 0; ; offset += wd.PageSize {
+		query := fmt.Sprintf(`SELECT ?person ?personLabel ?birth ?death WHERE {
+  ?person wdt:P106 wd:%s;
+          p:P569/psv:P569 [wikibase:timeValue ?birth; wikibase:timePrecision ?bp];
+          p:P570/psv:P570 [wikibase:timeValue ?death; wikibase:timePrecision ?dp].
+  FILTER(?bp >= 11 && ?dp >= 11)
+  SERVICE wikibase:label { bd:serviceParam wikibase:language "en". }
+}
+ORDER BY ?person
+LIMIT %d OFFSET %d`, occupation, wd.PageSize, offset)
+		var res sparqlResults
+		if err := sparqlQuery(tracker.ctx, client, wd.Endpoint, query, tracker, &res); err != nil {
+			return nil, tracker.progress(), err
+		}
+		for i, b := range res.Results.Bindings {
+			id := b["person"].Value
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			rec := Person{
+				Name:       b["personLabel"].Value,
+				BirthDate:  wikidataDate(b["birth"].Value),
+				DeathDate:  wikidataDate(b["death"].Value),
+				Occupation: wd.Occupation,
+			}
+			if err := ValidatePerson(rec); err != nil {
+				if opts.Reject == nil {
+					return nil, tracker.progress(), fmt.Errorf("wikidata: %s: %v", id, err)
+				}
+				opts.Reject(Rejection{Line: offset + i + 1, Record: []string{id, rec.Name, rec.BirthDate, rec.DeathDate}, Reason: err.Error()})
+				tracker.row(true)
+				continue
+			}
+			people = append(people, rec)
+			tracker.row(false)
+			if wd.Limit > 0 && len(people) >= wd.Limit {
+				return people, tracker.finish(), nil
+			}
+		}
+		if len(res.Results.Bindings) < wd.PageSize {
+			return people, tracker.finish(), nil
+		}
+	}
+}
+
+// wikidataDate converts a time value such as '1942-11-27T00:00:00Z' to 'YYYY-MM-DD'
+func wikidataDate(v string) string {
+	if i := strings.IndexByte(v, 'T'); i >= 0 {
+		return v[:i]
+	}
+	return v
+}
+
+// wikidataOccupation looks up the item ID of an occupation from its English label
+func wikidataOccupation(ctx context.Context, client *http.Client, endpoint, label string) (string, error) {
+	query := fmt.Sprintf(`SELECT ?item WHERE {
+  ?item rdfs:label %q@en.
+  ?person wdt:P106 ?item.
+} LIMIT 1`, label)
+	var res sparqlResults
+	if err := sparqlQuery(ctx, client, endpoint, query, nil, &res); err != nil {
+		return "", err
+	}
+	if len(res.Results.Bindings) == 0 {
+		return "", fmt.Errorf("wikidata: no occupation is labelled '%s'", label)
+	}
+	item := res.Results.Bindings[0]["item"].Value
+	return item[strings.LastIndexByte(item, '/')+1:], nil
+}
+
+// sparqlQuery runs the query and decodes the JSON results, counting the bytes read with the
+// tracker if given. Requests refused for exceeding the rate limit are retried.
+func sparqlQuery(ctx context.Context, client *http.Client, endpoint, query string, tracker *progressTracker, v interface{}) error {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint+"?"+url.Values{"query": {query}}.Encode(), nil)
+		if err != nil {
+			return fmt.Errorf("wikidata: %v", err)
+		}
+		req.Header.Set("Accept", "application/sparql-results+json")
+		req.Header.Set("User-Agent", USER_AGENT)
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("wikidata: %v", err)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < 3 {
+			resp.Body.Close()
+			time.Sleep(retryAfter(resp, time.Duration(attempt+1)*10*time.Second))
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("wikidata: query failed: %s", resp.Status)
+		}
+		if tracker == nil {
+			return json.NewDecoder(resp.Body).Decode(v)
+		}
+		tracker.r = resp.Body
+		err = json.NewDecoder(tracker).Decode(v)
+		tracker.r = nil
+		if err != nil {
+			return fmt.Errorf("wikidata: %v", err)
+		}
+		return nil
+	}
+}
+
+// retryAfter returns the delay asked for by a Retry-After header given in seconds, or def
+func retryAfter(resp *http.Response, def time.Duration) time.Duration {
+	var secs int
+	if _, err := fmt.Sscanf(resp.Header.Get("Retry-After"), "%d", &secs); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return def
+}
@@ -0,0 +1,380 @@
+// Copyright © 2016 Matthew R Hegarty
+
+package outlived
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadXLSXFile reads a worksheet of an Excel workbook, which may also be given as an HTTP or
+// HTTPS URL, and returns its contents as a 'Person' array. The sheet is chosen by name with
+// opts.Sheet, or is otherwise the first.
+//
+// The first row which is not blank holds the column headers, from which columnsFor finds the
+// column holding each field. Cells formatted as dates are read as dates, rather than as the serial numbers
+// Excel stores them as.
+func ReadXLSXFile(filename string, opts ReadOptions) ([]Person, Progress, error) {
+	r, size, err := openRaw(filename, opts.fetchOptions())
+	if err != nil {
+		return nil, Progress{}, fmt.Errorf("import: %v", err)
+	}
+	zr, closeZip, err := openZip(r, bufio.NewReader(r), size)
+	if err != nil {
+		return nil, Progress{}, fmt.Errorf("xlsx: %v", err)
+	}
+	defer closeZip()
+	return readXLSX(zr, opts)
+}
+
+func readXLSX(zr *zip.Reader, opts ReadOptions) ([]Person, Progress, error) {
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+	wb, err := readWorkbook(files)
+	if err != nil {
+		return nil, Progress{}, fmt.Errorf("xlsx: %v", err)
+	}
+	sheet, err := wb.sheetFile(files, opts.Sheet)
+	if err != nil {
+		return nil, Progress{}, fmt.Errorf("xlsx: %v", err)
+	}
+	if wb.strings, err = readSharedStrings(files["xl/sharedStrings.xml"]); err != nil {
+		return nil, Progress{}, fmt.Errorf("xlsx: shared strings: %v", err)
+	}
+	if wb.dateStyles, err = readDateStyles(files["xl/styles.xml"]); err != nil {
+		return nil, Progress{}, fmt.Errorf("xlsx: styles: %v", err)
+	}
+
+	rc, err := sheet.Open()
+	if err != nil {
+		return nil, Progress{}, fmt.Errorf("xlsx: %v", err)
+	}
+	defer rc.Close()
+	tracker := newProgressTracker(opts.Context, rc, int64(sheet.UncompressedSize64), opts.Progress, opts.ProgressInterval)
+
+	var allRecords []Person
+	var columns map[string]int // the column holding each field, once the header row is read
+	err = wb.readRows(tracker, func(line int, row []string) error {
+		if isBlankRow(row) {
+			if columns != nil {
+				tracker.row(true)
+			}
+			return nil
+		}
+		if columns == nil {
+			var err error
+			columns, err = columnsFor(row, opts.Fields, requiredFields(opts.Living))
+			return err
+		}
+		rec := personFromColumns(row, columns)
+		normalizeDates(&rec, opts)
+		if invalid := opts.validate(rec); invalid != nil {
+			if opts.Reject == nil {
+				return fmt.Errorf("row %d: %v", line, invalid)
+			}
+			opts.Reject(Rejection{Line: line, Record: row, Reason: invalid.Error()})
+			tracker.row(true)
+			return nil
+		}
+		allRecords = append(allRecords, rec)
+		tracker.row(false)
+		return nil
+	})
+	if err == nil && columns == nil {
+		err = errors.New("the sheet is empty")
+	}
+	if err != nil {
+		return nil, tracker.progress(), fmt.Errorf("xlsx: %v", err)
+	}
+	return allRecords, tracker.finish(), nil
+}
+
+// workbook holds what is needed from a workbook to read the cells of one of its sheets
+type workbook struct {
+	sheets     []xlsxSheet
+	rels       map[string]string // the part holding each sheet, by relationship ID
+	date1904   bool              // whether dates count from 1904 rather than 1900
+	strings    []string
+	dateStyles map[int]bool // the styles which format numbers as dates
+}
+
+type xlsxSheet struct {
+	Name string `xml:"name,attr"`
+	RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+}
+
+func readWorkbook(files map[string]*zip.File) (*workbook, error) {
+	var doc struct {
+		WorkbookPr struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []xlsxSheet `xml:"sheets>sheet"`
+	}
+	if err := decodeXMLPart(files["xl/workbook.xml"], &doc); err != nil {
+		return nil, fmt.Errorf("not an Excel workbook: %v", err)
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeXMLPart(files["xl/_rels/workbook.xml.rels"], &rels); err != nil {
+		return nil, fmt.Errorf("workbook relationships: %v", err)
+	}
+	wb := &workbook{sheets: doc.Sheets, rels: map[string]string{}}
+	wb.date1904 = doc.WorkbookPr.Date1904 == "1" || doc.WorkbookPr.Date1904 == "true"
+	for _, rel := range rels.Relationships {
+		target := rel.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		wb.rels[rel.ID] = target
+	}
+	return wb, nil
+}
+
+// sheetFile returns the part holding the named sheet, or the first sheet if name is empty
+func (wb *workbook) sheetFile(files map[string]*zip.File, name string) (*zip.File, error) {
+	if len(wb.sheets) == 0 {
+		return nil, errors.New("the workbook has no sheets")
+	}
+	sheet := wb.sheets[0]
+	if name != "" {
+		found := false
+		var names []string
+		for _, s := range wb.sheets {
+			names = append(names, s.Name)
+			if strings.EqualFold(s.Name, name) {
+				sheet, found = s, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no sheet named '%s', expected one of: %s", name, strings.Join(names, ", "))
+		}
+	}
+	f := files[wb.rels[sheet.RID]]
+	if f == nil {
+		return nil, fmt.Errorf("the sheet '%s' is missing from the workbook", sheet.Name)
+	}
+	return f, nil
+}
+
+// readRows calls fn with each row of the sheet in turn, along with its row number. Cells are
+// placed by their column, so that the cells missing from a row are read as blank.
+func (wb *workbook) readRows(r io.Reader, fn func(line int, row []string) error) error {
+	dec := xml.NewDecoder(r)
+	var row []string
+	line, col := 0, 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				line++
+				if n, err := strconv.Atoi(xmlAttr(t, "r")); err == nil {
+					line = n
+				}
+				row, col = row[:0], 0
+			case "c":
+				var c struct {
+					Ref    string `xml:"r,attr"`
+					Type   string `xml:"t,attr"`
+					Style  int    `xml:"s,attr"`
+					Value  string `xml:"v"`
+					Inline struct {
+						Text string   `xml:"t"`
+						Runs []string `xml:"r>t"`
+					} `xml:"is"`
+				}
+				if err := dec.DecodeElement(&c, &t); err != nil {
+					return err
+				}
+				if i := cellColumn(c.Ref); i >= 0 {
+					col = i
+				}
+				for len(row) < col {
+					row = append(row, "")
+				}
+				text := c.Value
+				switch c.Type {
+				case "s":
+					if i, err := strconv.Atoi(c.Value); err == nil && i >= 0 && i < len(wb.strings) {
+						text = wb.strings[i]
+					}
+				case "inlineStr":
+					text = c.Inline.Text + strings.Join(c.Inline.Runs, "")
+				case "", "n":
+					if wb.dateStyles[c.Style] {
+						if serial, err := strconv.ParseFloat(c.Value, 64); err == nil {
+							text = excelDate(serial, wb.date1904)
+						}
+					}
+				case "d": // an ISO 8601 date and time
+					if len(text) > 10 {
+						text = text[:10]
+					}
+				}
+				row = append(row, text)
+				col++
+			}
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				if err := fn(line, append([]string(nil), row...)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// cellColumn returns the zero-based column of a cell reference such as 'C5', or -1 if the
+// reference is missing
+func cellColumn(ref string) int {
+	col := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A'+1)
+	}
+	return col - 1
+}
+
+// excelDate converts a serial date to 'YYYY-MM-DD'. In the 1900 date system, day 1 is the
+// 1st of January 1900, and Excel counts the 29th of February 1900 which never was.
+func excelDate(serial float64, date1904 bool) string {
+	days := int(math.Floor(serial))
+	if date1904 {
+		return time.Date(1904, 1, 1+days, 0, 0, 0, 0, time.UTC).Format(DATE_FMT)
+	}
+	if days < 60 {
+		days++
+	}
+	return time.Date(1899, 12, 30+days, 0, 0, 0, 0, time.UTC).Format(DATE_FMT)
+}
+
+// readSharedStrings reads the table of strings which cells refer to by index
+func readSharedStrings(f *zip.File) ([]string, error) {
+	if f == nil {
+		return nil, nil // a workbook with no text cells need not have one
+	}
+	var doc struct {
+		Items []struct {
+			Text string   `xml:"t"`
+			Runs []string `xml:"r>t"` // rich text, which is formatted in runs
+		} `xml:"si"`
+	}
+	if err := decodeXMLPart(f, &doc); err != nil {
+		return nil, err
+	}
+	table := make([]string, len(doc.Items))
+	for i, si := range doc.Items {
+		table[i] = si.Text + strings.Join(si.Runs, "")
+	}
+	return table, nil
+}
+
+// readDateStyles finds the cell styles whose number format shows a date
+func readDateStyles(f *zip.File) (map[int]bool, error) {
+	styles := map[int]bool{}
+	if f == nil {
+		return styles, nil
+	}
+	var doc struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := decodeXMLPart(f, &doc); err != nil {
+		return nil, err
+	}
+	custom := map[int]string{}
+	for _, nf := range doc.NumFmts {
+		custom[nf.ID] = nf.Code
+	}
+	for i, xf := range doc.CellXfs {
+		if code, ok := custom[xf.NumFmtID]; ok {
+			styles[i] = isDateFormat(code)
+		} else {
+			styles[i] = isBuiltinDateFormat(xf.NumFmtID)
+		}
+	}
+	return styles, nil
+}
+
+// isBuiltinDateFormat reports whether one of Excel's built in number formats shows a date
+func isBuiltinDateFormat(id int) bool {
+	return (id >= 14 && id <= 17) || id == 22 || (id >= 27 && id <= 36) || (id >= 50 && id <= 58)
+}
+
+// isDateFormat reports whether a custom number format code shows a date, ignoring its
+// quoted text, escaped characters and bracketed colours and conditions
+func isDateFormat(code string) bool {
+	var b strings.Builder
+	quoted, bracketed := false, false
+	for i := 0; i < len(code); i++ {
+		switch ch := code[i]; {
+		case quoted:
+			quoted = ch != '"'
+		case bracketed:
+			bracketed = ch != ']'
+		case ch == '"':
+			quoted = true
+		case ch == '[':
+			bracketed = true
+		case ch == '\\' || ch == '_' || ch == '*':
+			i++ // the next character is literal, or padding
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	s := strings.ToLower(b.String())
+	return strings.ContainsAny(s, "dy")
+}
+
+func decodeXMLPart(f *zip.File, v interface{}) error {
+	if f == nil {
+		return errors.New("missing part")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+func xmlAttr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}